@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+// aiConfigCache holds every device's AI configuration in memory and is
+// synchronized with the database.
+var (
+	aiConfigCache = make(map[string]models.AIConfig)
+	aiConfigMutex sync.RWMutex
+)
+
+func aiConfigKey(userID uint, deviceID string) string {
+	return fmt.Sprintf("%d/%s", userID, deviceID)
+}
+
+// InitAIConfigState loads all AI configurations from the database.
+// This should be called on application startup.
+func InitAIConfigState(db *gorm.DB) error {
+	var configs []models.AIConfig
+	if err := db.Find(&configs).Error; err != nil {
+		return err
+	}
+
+	aiConfigMutex.Lock()
+	defer aiConfigMutex.Unlock()
+
+	aiConfigCache = make(map[string]models.AIConfig, len(configs))
+	for _, cfg := range configs {
+		aiConfigCache[aiConfigKey(cfg.UserID, cfg.DeviceID)] = cfg
+	}
+	return nil
+}
+
+// GetAIConfig returns the cached AI configuration for a user's device.
+// The second return value is false when the device has never been configured.
+func GetAIConfig(userID uint, deviceID string) (models.AIConfig, bool) {
+	aiConfigMutex.RLock()
+	defer aiConfigMutex.RUnlock()
+	cfg, ok := aiConfigCache[aiConfigKey(userID, deviceID)]
+	return cfg, ok
+}
+
+// ListAIConfigs returns the cached AI configurations owned by a user.
+func ListAIConfigs(userID uint) []models.AIConfig {
+	aiConfigMutex.RLock()
+	defer aiConfigMutex.RUnlock()
+
+	configs := []models.AIConfig{}
+	for _, cfg := range aiConfigCache {
+		if cfg.UserID == userID {
+			configs = append(configs, cfg)
+		}
+	}
+	return configs
+}
+
+// SetAIConfig creates or updates a device's AI configuration, writes an audit
+// entry for the change and refreshes the cache.
+func SetAIConfig(db *gorm.DB, cfg models.AIConfig, changedBy uint) (models.AIConfig, error) {
+	aiConfigMutex.Lock()
+	defer aiConfigMutex.Unlock()
+
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var existing models.AIConfig
+		result := tx.Where("user_id = ? AND device_id = ?", cfg.UserID, cfg.DeviceID).First(&existing)
+		if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
+			return result.Error
+		}
+		if result.Error == nil {
+			cfg.ID = existing.ID
+		}
+
+		cfg.UpdatedBy = changedBy
+		cfg.UpdatedAt = now
+		if err := tx.Save(&cfg).Error; err != nil {
+			return err
+		}
+
+		audit := models.AIConfigAudit{
+			AIConfigID:   cfg.ID,
+			UserID:       cfg.UserID,
+			DeviceID:     cfg.DeviceID,
+			ChangedBy:    changedBy,
+			Enabled:      cfg.Enabled,
+			PlantName:    cfg.PlantName,
+			ModelVersion: cfg.ModelVersion,
+			ChangedAt:    now,
+		}
+		return tx.Create(&audit).Error
+	})
+	if err != nil {
+		return cfg, err
+	}
+
+	aiConfigCache[aiConfigKey(cfg.UserID, cfg.DeviceID)] = cfg
+	return cfg, nil
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+	minSigningSecretLength = 32
+)
+
+// SigningKey is a secret tokens are signed with, named by the "kid" header.
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+// AuthSettings holds the token signing keys and lifetimes. New tokens are
+// signed with the active key; every listed key is accepted, so a key can be
+// rotated by adding the new one, making it active and removing the old one
+// once the tokens signed with it have expired.
+type AuthSettings struct {
+	Keys            map[string]SigningKey
+	ActiveKeyID     string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+var (
+	authSettings AuthSettings
+	authMu       sync.RWMutex
+)
+
+// InitAuthSettings loads the signing keys from JWT_SIGNING_KEYS, a comma
+// separated list of kid:secret pairs with JWT_ACTIVE_KEY_ID naming the one to
+// sign with (the first by default), or a single key from JWT_SECRET. Token
+// lifetimes come from ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL as Go durations.
+func InitAuthSettings() error {
+	settings := AuthSettings{
+		Keys:            make(map[string]SigningKey),
+		AccessTokenTTL:  defaultAccessTokenTTL,
+		RefreshTokenTTL: defaultRefreshTokenTTL,
+	}
+
+	if list := os.Getenv("JWT_SIGNING_KEYS"); list != "" {
+		for _, entry := range strings.Split(list, ",") {
+			kid, secret, found := strings.Cut(strings.TrimSpace(entry), ":")
+			if !found || kid == "" {
+				return fmt.Errorf("JWT_SIGNING_KEYS entry %q is not kid:secret", entry)
+			}
+			if _, dup := settings.Keys[kid]; dup {
+				return fmt.Errorf("JWT_SIGNING_KEYS lists key %q twice", kid)
+			}
+			settings.Keys[kid] = SigningKey{ID: kid, Secret: []byte(secret)}
+			if settings.ActiveKeyID == "" {
+				settings.ActiveKeyID = kid
+			}
+		}
+		if active := os.Getenv("JWT_ACTIVE_KEY_ID"); active != "" {
+			settings.ActiveKeyID = active
+		}
+	} else if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		settings.Keys["default"] = SigningKey{ID: "default", Secret: []byte(secret)}
+		settings.ActiveKeyID = "default"
+	} else {
+		return errors.New("no token signing key configured, set JWT_SIGNING_KEYS or JWT_SECRET")
+	}
+
+	if _, ok := settings.Keys[settings.ActiveKeyID]; !ok {
+		return fmt.Errorf("active signing key %q is not in JWT_SIGNING_KEYS", settings.ActiveKeyID)
+	}
+	for _, key := range settings.Keys {
+		if len(key.Secret) < minSigningSecretLength {
+			return fmt.Errorf("signing key %q must be at least %d bytes", key.ID, minSigningSecretLength)
+		}
+	}
+
+	for name, ttl := range map[string]*time.Duration{
+		"ACCESS_TOKEN_TTL":  &settings.AccessTokenTTL,
+		"REFRESH_TOKEN_TTL": &settings.RefreshTokenTTL,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return fmt.Errorf("%s must be a positive duration such as 15m", name)
+		}
+		*ttl = parsed
+	}
+
+	SetAuthSettings(settings)
+	return nil
+}
+
+// SetAuthSettings replaces the token settings.
+func SetAuthSettings(settings AuthSettings) {
+	authMu.Lock()
+	defer authMu.Unlock()
+	authSettings = settings
+}
+
+// GetAuthSettings returns the token settings.
+func GetAuthSettings() AuthSettings {
+	authMu.RLock()
+	defer authMu.RUnlock()
+	return authSettings
+}
@@ -64,18 +64,34 @@ func GetDeveloperModeState() (isEnabled bool, startTime time.Time) {
 }
 
 // SetDeveloperModeState updates the developer mode state in both the database and the cache.
-func SetDeveloperModeState(db *gorm.DB, isEnabled bool, startTime time.Time) error {
+// If record is not nil it runs in the same transaction with the previous
+// state, so the change can be audited; the change is rolled back if it fails.
+func SetDeveloperModeState(db *gorm.DB, isEnabled bool, startTime time.Time, record func(tx *gorm.DB, previous models.DeveloperModeSetting) error) error {
 	devModeMutex.Lock()
 	defer devModeMutex.Unlock()
 
+	previous := models.DeveloperModeSetting{
+		ID:        developerModeSettingID,
+		IsEnabled: currentDevModeState.IsEnabled,
+		StartTime: currentDevModeState.StartTime,
+	}
 	setting := models.DeveloperModeSetting{
 		ID:        developerModeSettingID,
 		IsEnabled: isEnabled,
 		StartTime: startTime,
 	}
 
-	// Use Save to update or create if somehow missing (though Init should prevent this)
-	if err := db.Save(&setting).Error; err != nil {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Use Save to update or create if somehow missing (though Init should prevent this)
+		if err := tx.Save(&setting).Error; err != nil {
+			return err
+		}
+		if record != nil {
+			return record(tx, previous)
+		}
+		return nil
+	})
+	if err != nil {
 		return err
 	}
 
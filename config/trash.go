@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+var (
+	trashRetention = defaultTrashRetention
+	trashMu        sync.RWMutex
+)
+
+// InitTrashRetention reads how long deleted sensor data and users stay
+// restorable from TRASH_RETENTION, a Go duration such as 720h.
+func InitTrashRetention() error {
+	value := os.Getenv("TRASH_RETENTION")
+	if value == "" {
+		return nil
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention <= 0 {
+		return fmt.Errorf("TRASH_RETENTION must be a positive duration such as 720h")
+	}
+	SetTrashRetention(retention)
+	return nil
+}
+
+// SetTrashRetention sets how long trashed rows are kept before they are purged.
+func SetTrashRetention(retention time.Duration) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	trashRetention = retention
+}
+
+// GetTrashRetention returns how long trashed rows are kept before they are purged.
+func GetTrashRetention() time.Duration {
+	trashMu.RLock()
+	defer trashMu.RUnlock()
+	return trashRetention
+}
@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// VerifyEmail marks a user's email verified with the token mailed to them.
+func VerifyEmail(c *gin.Context) {
+	var req models.TokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+
+	token, err := utils.ConsumeUserToken(config.DB, req.Token, models.TokenPurposeVerifyEmail)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+	// A token verifies the address it was sent to, not one changed to since
+	result := config.DB.Model(&models.User{}).Where("id = ? AND email = ?", token.UserID, token.Email).
+		Update("email_verified", true)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// ResendVerification mails a new verification link. It answers the same
+// whether or not the address is registered.
+func ResendVerification(c *gin.Context) {
+	var req models.EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A valid email is required"})
+		return
+	}
+
+	var user models.User
+	if err := config.DB.Where("LOWER(email) = ?", normaliseEmail(req.Email)).First(&user).Error; err == nil && !user.EmailVerified {
+		if err := utils.SendVerificationEmail(config.DB, user); err != nil {
+			fmt.Println("❌ Failed to send verification email:", err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "If the address needs verifying, a new link has been sent"})
+}
+
+// ForgotPassword mails a password reset link. It answers the same whether or
+// not the address is registered.
+func ForgotPassword(c *gin.Context) {
+	var req models.EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A valid email is required"})
+		return
+	}
+
+	var user models.User
+	if err := config.DB.Where("LOWER(email) = ?", normaliseEmail(req.Email)).First(&user).Error; err == nil {
+		if err := utils.SendPasswordResetEmail(config.DB, user); err != nil {
+			fmt.Println("❌ Failed to send password reset email:", err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "If the address is registered, a reset link has been sent"})
+}
+
+// ResetPassword sets a new password with a reset token and ends every
+// session of the user.
+func ResetPassword(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token and new_password are required"})
+		return
+	}
+
+	// Check the password before spending the token on it
+	var user models.User
+	var pending models.UserToken
+	if err := config.DB.Where("token_hash = ? AND purpose = ?", utils.HashToken(req.Token), models.TokenPurposeResetPassword).
+		First(&pending).Error; err != nil || config.DB.First(&user, pending.UserID).Error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+	if err := utils.ValidatePassword(req.NewPassword, user.Username, user.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := utils.ConsumeUserToken(config.DB, req.Token, models.TokenPurposeResetPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+
+	if !setPassword(c, user, req.NewPassword) {
+		return
+	}
+	// The reset link proves the user controls the address
+	config.DB.Model(&user).Update("email_verified", true)
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset, please log in again"})
+}
+
+// ChangePassword sets a new password for the caller after checking the
+// current one under the login throttle, and ends their sessions.
+func ChangePassword(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "current_password and new_password are required"})
+		return
+	}
+	if !checkCallerSecret(c, user, models.LoginFailedBadPassword, passwordCheck(user, req.CurrentPassword)) {
+		return
+	}
+	if err := utils.ValidatePassword(req.NewPassword, user.Username, user.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !setPassword(c, user, req.NewPassword) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, please log in again"})
+}
+
+// setPassword stores a new password hash and revokes the user's refresh
+// tokens. It writes the error response and returns false on failure.
+func setPassword(c *gin.Context, user models.User, password string) bool {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error hashing password"})
+		return false
+	}
+	if err := config.DB.Model(&user).Update("password", string(hashed)).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return false
+	}
+	if err := utils.RevokeUserSessions(config.DB, user.ID); err != nil {
+		fmt.Println("❌ Failed to revoke sessions:", err)
+	}
+	return true
+}
+
+// UpdateProfile changes the caller's username, or starts changing their
+// email. The current password is required for an email change, and the old
+// address stays in use until the new one is confirmed with ConfirmEmailChange.
+func UpdateProfile(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	var req models.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if username == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "username cannot be empty"})
+			return
+		}
+		if username != user.Username {
+			updates["username"] = username
+		}
+	}
+	newEmail := ""
+	if req.Email != nil && normaliseEmail(*req.Email) != normaliseEmail(user.Email) {
+		newEmail = normaliseEmail(*req.Email)
+		if req.CurrentPassword == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "current_password is required to change your email"})
+			return
+		}
+		if !checkCallerSecret(c, user, models.LoginFailedBadPassword, passwordCheck(user, req.CurrentPassword)) {
+			return
+		}
+		if emailTaken(newEmail, user.ID) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username or email is already taken"})
+			return
+		}
+	}
+	if len(updates) == 0 && newEmail == "" {
+		c.JSON(http.StatusOK, gin.H{"message": "Nothing to update", "user": user})
+		return
+	}
+
+	if len(updates) > 0 {
+		if err := config.DB.Model(&models.User{}).Where("id = ?", user.ID).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username or email is already taken"})
+			return
+		}
+		if username, ok := updates["username"].(string); ok {
+			user.Username = username
+		}
+	}
+	if newEmail == "" {
+		c.JSON(http.StatusOK, gin.H{"message": "Profile updated", "user": user})
+		return
+	}
+	if err := utils.SendEmailChangeConfirmation(config.DB, user, newEmail); err != nil {
+		fmt.Println("❌ Failed to send email change confirmation:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send the confirmation email"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Check " + newEmail + " for a link to confirm your new email address",
+		"user":          user,
+		"pending_email": newEmail,
+	})
+}
+
+// ConfirmEmailChange switches a user to the new email address the token was
+// mailed to.
+func ConfirmEmailChange(c *gin.Context) {
+	var req models.TokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+
+	token, err := utils.ConsumeUserToken(config.DB, req.Token, models.TokenPurposeChangeEmail)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+	// Someone may have registered the address since the link was sent
+	if emailTaken(token.Email, token.UserID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This email address is already in use"})
+		return
+	}
+	if err := config.DB.Model(&models.User{}).Where("id = ?", token.UserID).
+		Updates(map[string]interface{}{"email": token.Email, "email_verified": true}).Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "This email address is already in use"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email address changed", "email": token.Email})
+}
+
+// emailTaken reports whether another account, including one in the trash,
+// uses an email address.
+func emailTaken(email string, userID uint) bool {
+	var count int64
+	config.DB.Unscoped().Model(&models.User{}).Where("LOWER(email) = ? AND id <> ?", normaliseEmail(email), userID).Count(&count)
+	return count > 0
+}
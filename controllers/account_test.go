@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+)
+
+var mailedToken = regexp.MustCompile(`\?token=(\S+)`)
+
+// lastToken returns the token linked in the last message sent to an address.
+func lastToken(t *testing.T, mailer *utils.CaptureMailer, to string) string {
+	t.Helper()
+	messages := mailer.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].To != to {
+			continue
+		}
+		match := mailedToken.FindStringSubmatch(messages[i].Body)
+		if match == nil {
+			continue
+		}
+		token, err := url.QueryUnescape(match[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	t.Fatalf("no link mailed to %s in %+v", to, messages)
+	return ""
+}
+
+// login signs in with a password and returns the response status.
+func login(username, password string) int {
+	return serve(Login, http.MethodPost, "/login", map[string]string{"username": username, "password": password}, nil).Code
+}
+
+func TestSignupThenVerifyEmail(t *testing.T) {
+	testDB(t)
+	mailer := captureMail(t)
+
+	recorder := serve(Signup, http.MethodPost, "/signup",
+		map[string]string{"username": "grower", "email": "Grower@Example.com", "password": "Seedling-password-1"}, nil)
+	expectStatus(t, recorder, http.StatusCreated)
+	if code := login("grower", "Seedling-password-1"); code != http.StatusForbidden {
+		t.Fatalf("login before verifying = %d, want %d", code, http.StatusForbidden)
+	}
+
+	token := lastToken(t, mailer, "grower@example.com")
+	expectStatus(t, serve(VerifyEmail, http.MethodPost, "/verify-email", map[string]string{"token": token}, nil), http.StatusOK)
+	if code := login("grower", "Seedling-password-1"); code != http.StatusOK {
+		t.Fatalf("login after verifying = %d, want %d", code, http.StatusOK)
+	}
+	expectStatus(t, serve(VerifyEmail, http.MethodPost, "/verify-email", map[string]string{"token": token}, nil), http.StatusBadRequest)
+}
+
+func TestForgotThenResetPassword(t *testing.T) {
+	testDB(t)
+	mailer := captureMail(t)
+	createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+
+	expectStatus(t, serve(ForgotPassword, http.MethodPost, "/password/forgot", map[string]string{"email": "grower@example.com"}, nil), http.StatusOK)
+	token := lastToken(t, mailer, "grower@example.com")
+
+	// A rejected password leaves the token usable
+	expectStatus(t, serve(ResetPassword, http.MethodPost, "/password/reset",
+		map[string]string{"token": token, "new_password": "short"}, nil), http.StatusBadRequest)
+	expectStatus(t, serve(ResetPassword, http.MethodPost, "/password/reset",
+		map[string]string{"token": token, "new_password": "Another-password-2"}, nil), http.StatusOK)
+	if code := login("grower", "Another-password-2"); code != http.StatusOK {
+		t.Fatalf("login with the new password = %d, want %d", code, http.StatusOK)
+	}
+	if code := login("grower", "Grower-password-1"); code != http.StatusUnauthorized {
+		t.Fatalf("login with the old password = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// The link works once
+	expectStatus(t, serve(ResetPassword, http.MethodPost, "/password/reset",
+		map[string]string{"token": token, "new_password": "Third-password-3"}, nil), http.StatusBadRequest)
+	if code := login("grower", "Third-password-3"); code != http.StatusUnauthorized {
+		t.Fatalf("replayed reset token changed the password")
+	}
+}
+
+func TestForgotPasswordForUnknownEmailSendsNothing(t *testing.T) {
+	testDB(t)
+	mailer := captureMail(t)
+
+	expectStatus(t, serve(ForgotPassword, http.MethodPost, "/password/forgot", map[string]string{"email": "nobody@example.com"}, nil), http.StatusOK)
+	if messages := mailer.Messages(); len(messages) != 0 {
+		t.Fatalf("mail sent for an unknown address: %+v", messages)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+
+	expectStatus(t, serve(ChangePassword, http.MethodPost, "/password/change",
+		map[string]string{"current_password": "Wrong-password-9", "new_password": "Another-password-2"}, &user), http.StatusUnauthorized)
+	expectStatus(t, serve(ChangePassword, http.MethodPost, "/password/change",
+		map[string]string{"current_password": "Grower-password-1", "new_password": "Another-password-2"}, &user), http.StatusOK)
+
+	if code := login("grower", "Another-password-2"); code != http.StatusOK {
+		t.Fatalf("login with the new password = %d, want %d", code, http.StatusOK)
+	}
+	if code := login("grower", "Grower-password-1"); code != http.StatusUnauthorized {
+		t.Fatalf("login with the old password = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestEmailChangeWaitsForConfirmation(t *testing.T) {
+	testDB(t)
+	mailer := captureMail(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	update := func(body map[string]interface{}) int {
+		return serve(UpdateProfile, http.MethodPut, "/profile", body, &user).Code
+	}
+
+	if code := update(map[string]interface{}{"email": "new@example.com"}); code != http.StatusBadRequest {
+		t.Fatalf("email change without current_password = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := update(map[string]interface{}{"email": "new@example.com", "current_password": "Wrong-password-9"}); code != http.StatusUnauthorized {
+		t.Fatalf("email change with a wrong password = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if messages := mailer.Messages(); len(messages) != 0 {
+		t.Fatalf("mail sent for a refused change: %+v", messages)
+	}
+
+	if code := update(map[string]interface{}{"email": "New@Example.com", "current_password": "Grower-password-1"}); code != http.StatusOK {
+		t.Fatalf("email change = %d, want %d", code, http.StatusOK)
+	}
+	var stored models.User
+	config.DB.First(&stored, user.ID)
+	if stored.Email != "grower@example.com" || !stored.EmailVerified {
+		t.Fatalf("email changed before confirmation: %+v", stored)
+	}
+	token := lastToken(t, mailer, "new@example.com")
+	// The old address is told, but never gets the link
+	told := false
+	for _, message := range mailer.Messages() {
+		if message.To != "grower@example.com" {
+			continue
+		}
+		if mailedToken.MatchString(message.Body) {
+			t.Fatalf("confirmation link sent to the old address: %s", message.Body)
+		}
+		told = true
+	}
+	if !told {
+		t.Fatal("the old address was not told about the change")
+	}
+
+	expectStatus(t, serve(ConfirmEmailChange, http.MethodPost, "/email/confirm", map[string]string{"token": token}, nil), http.StatusOK)
+	config.DB.First(&stored, user.ID)
+	if stored.Email != "new@example.com" || !stored.EmailVerified {
+		t.Fatalf("email after confirmation = %q (verified %v), want new@example.com", stored.Email, stored.EmailVerified)
+	}
+	expectStatus(t, serve(ConfirmEmailChange, http.MethodPost, "/email/confirm", map[string]string{"token": token}, nil), http.StatusBadRequest)
+}
+
+func TestEmailChangeToTakenAddress(t *testing.T) {
+	testDB(t)
+	mailer := captureMail(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	body := map[string]interface{}{"email": "later@example.com", "current_password": "Grower-password-1"}
+	expectStatus(t, serve(UpdateProfile, http.MethodPut, "/profile", body, &user), http.StatusOK)
+	token := lastToken(t, mailer, "later@example.com")
+
+	// Registered after the link was sent
+	createTestUser(t, "later", "later@example.com", "Later-password-1")
+	expectStatus(t, serve(ConfirmEmailChange, http.MethodPost, "/email/confirm", map[string]string{"token": token}, nil), http.StatusConflict)
+	expectStatus(t, serve(UpdateProfile, http.MethodPut, "/profile", body, &user), http.StatusConflict)
+
+	var stored models.User
+	config.DB.First(&stored, user.ID)
+	if stored.Email != "grower@example.com" {
+		t.Fatalf("email = %q, want it unchanged", stored.Email)
+	}
+}
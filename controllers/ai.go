@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ToggleAI enables or disables AI prediction for one of the caller's devices.
+// Admins may configure another user's device by passing user_id.
+func ToggleAI(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var body models.ToggleAIRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	var currentUser models.User
+	if err := config.DB.First(&currentUser, userID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ownerID := userID
+	if body.UserID != 0 && body.UserID != userID {
+		if !utils.CanManageAny(currentUser, models.ResourceAIConfig) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only configure your own devices"})
+			return
+		}
+		ownerID = body.UserID
+	}
+
+	deviceID := body.DeviceID
+	if deviceID == "" {
+		deviceID = models.DefaultDeviceID
+	}
+
+	// Keep the previously configured plant and model when only the flag changes
+	cfg, _ := config.GetAIConfig(ownerID, deviceID)
+	cfg.UserID = ownerID
+	cfg.DeviceID = deviceID
+	cfg.Enabled = body.Enabled
+	if body.Plant != "" {
+		cfg.PlantName = body.Plant
+	}
+	if body.ModelVersion != "" {
+		cfg.ModelVersion = body.ModelVersion
+	}
+
+	if cfg.Enabled && cfg.PlantName == "" {
+		if _, err := utils.ActivePlanting(config.DB, ownerID, deviceID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A plant or an active planting is required to enable AI"})
+			return
+		}
+	}
+
+	cfg, err := config.SetAIConfig(config.DB, cfg, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save AI configuration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "AI toggle updated", "config": cfg})
+}
+
+// GetAIConfigs lists the AI configuration of every device owned by the caller.
+func GetAIConfigs(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, config.ListAIConfigs(userID))
+}
+
+// GetAIConfigHistory returns the audit trail of a device's AI configuration.
+func GetAIConfigHistory(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var currentUser models.User
+	config.DB.First(&currentUser, userID)
+
+	query := config.DB.Where("device_id = ?", c.Param("device_id"))
+	if !utils.CanManageAny(currentUser, models.ResourceAIConfig) {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var history []models.AIConfigAudit
+	if err := query.Order("changed_at desc").Find(&history).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch AI configuration history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// GetForecast returns the predicted soil moisture of a device for the next
+// hours (default 24). Admins may forecast another user's device with ?user_id=.
+func GetForecast(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	hours, err := strconv.Atoi(c.DefaultQuery("hours", "24"))
+	if err != nil || hours < 1 || hours > utils.MaxForecastHours {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hours must be between 1 and 48"})
+		return
+	}
+
+	ownerID := userID
+	if requested := c.Query("user_id"); requested != "" {
+		var currentUser models.User
+		config.DB.First(&currentUser, userID)
+		if !utils.CanManageAny(currentUser, models.ResourceSensorData) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+		id, err := strconv.ParseUint(requested, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+			return
+		}
+		ownerID = uint(id)
+	}
+
+	deviceID := c.Param("device_id")
+	plant := ""
+	if aiConfig, _ := config.GetAIConfig(ownerID, deviceID); aiConfig.Enabled {
+		plant = aiConfig.PlantName
+		if _, plantModel := utils.PlantingThresholds(config.DB, ownerID, deviceID, time.Now()); plantModel != "" {
+			plant = plantModel
+		}
+	}
+
+	forecast, err := utils.ForecastSoilMoisture(c.Request.Context(), config.DB, ownerID, deviceID, plant, hours)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, forecast)
+}
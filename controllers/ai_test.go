@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+)
+
+// latitudeWeather reports the latitude asked about as the temperature, so a
+// test can tell whose location was looked up.
+type latitudeWeather struct{}
+
+func (latitudeWeather) Name() string { return "latitude" }
+
+func (latitudeWeather) Current(lat, lon float64) (models.WeatherRecord, error) {
+	return models.WeatherRecord{Kind: models.WeatherCurrent, Temperature: lat, ValidAt: time.Now()}, nil
+}
+
+func (latitudeWeather) Forecast(lat, lon float64, hours int) ([]models.WeatherRecord, error) {
+	return nil, fmt.Errorf("no forecast")
+}
+
+func TestPredictionUsesTheReadingsDevice(t *testing.T) {
+	testDB(t)
+	owner := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	utils.SetWeatherProvider(latitudeWeather{})
+	t.Cleanup(func() { utils.SetWeatherProvider(nil) })
+
+	var sent utils.AIRequestData
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&sent)
+		fmt.Fprint(w, `{"predicted_soil_moisture": 42, "timestamp": "2024-01-01 12:00:00"}`)
+	}))
+	defer server.Close()
+	t.Setenv("AI_URL", server.URL)
+
+	now := time.Now().Truncate(time.Second)
+	for i, device := range []struct {
+		id          string
+		lat         float64
+		temperature float32
+	}{{"probe-1", 10, 15}, {"probe-2", 20, 35}} {
+		// probe-2 reported its location last
+		config.DB.Create(&models.DeviceLocation{UserID: owner.ID, DeviceID: device.id, Latitude: device.lat, Longitude: 1, Timestamp: now.Add(time.Duration(i) * time.Minute)})
+		config.DB.Create(&models.SensorData{UserID: owner.ID, DeviceID: device.id, Timestamp: now.Add(-time.Hour), Temperature: device.temperature, Humidity: 50})
+	}
+
+	_, predicted, err := utils.GetPredictedSoilMoisture(config.DB, owner.ID, "probe-1", "tomato", now.Format("2006-01-02 15:04:05"), 16, 50)
+	if err != nil || predicted != 42 {
+		t.Fatalf("GetPredictedSoilMoisture = %v, %v", predicted, err)
+	}
+	if sent.OutdoorTemperature != "10.0" {
+		t.Fatalf("outdoor_temperature = %q, want the weather at probe-1's location", sent.OutdoorTemperature)
+	}
+	if sent.TempRolling24 != "15.0" {
+		t.Fatalf("temp_rolling_24 = %q, want probe-1's readings only", sent.TempRolling24)
+	}
+}
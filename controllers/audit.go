@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// recordAudit appends an action taken by the caller to the audit log within tx.
+func recordAudit(c *gin.Context, tx *gorm.DB, action, targetType string, targetID, before, after interface{}) error {
+	entry := utils.AuditEntry{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     before,
+		After:      after,
+	}
+	if c != nil {
+		if userID, ok := getUserID(c); ok {
+			entry.ActorID = &userID
+		}
+		entry.IP = c.ClientIP()
+	}
+	return utils.RecordAudit(tx, entry)
+}
+
+// auditDeveloperMode returns a recorder for config.SetDeveloperModeState. A nil
+// context records the change as taken by the system.
+func auditDeveloperMode(c *gin.Context, isEnabled bool, startTime time.Time) func(*gorm.DB, models.DeveloperModeSetting) error {
+	return func(tx *gorm.DB, previous models.DeveloperModeSetting) error {
+		after := models.DeveloperModeSetting{ID: previous.ID, IsEnabled: isEnabled, StartTime: startTime}
+		return recordAudit(c, tx, models.AuditDeveloperMode, models.AuditTargetDeveloperMode, previous.ID, previous, after)
+	}
+}
+
+// auditFilter reads audit log filters from the query string.
+func auditFilter(c *gin.Context) (utils.AuditFilter, error) {
+	filter := utils.AuditFilter{
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+	}
+	if value := c.Query("actor_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return filter, fmt.Errorf("actor_id must be a user ID")
+		}
+		actorID := uint(id)
+		filter.ActorID = &actorID
+	}
+	for name, dest := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := c.Query(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*dest = parsed
+		}
+	}
+	if value := c.Query("before_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return filter, fmt.Errorf("before_id must be an audit log ID")
+		}
+		filter.BeforeID = uint(id)
+	}
+	return filter, nil
+}
+
+// GetAuditLogs lists audit log entries, newest first. Pass next_before_id
+// back as before_id for the next page.
+func GetAuditLogs(c *gin.Context) {
+	filter, err := auditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit := defaultAuditLimit
+	if value := c.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit)})
+			return
+		}
+	}
+
+	logs := []models.AuditLog{}
+	if err := utils.AuditQuery(config.DB, filter).Limit(limit).Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit log"})
+		return
+	}
+	var nextBeforeID *uint
+	if len(logs) == limit {
+		nextBeforeID = &logs[len(logs)-1].ID
+	}
+	c.JSON(http.StatusOK, gin.H{"logs": logs, "next_before_id": nextBeforeID})
+}
+
+// ExportAuditLogs streams every matching audit log entry as CSV or JSON.
+func ExportAuditLogs(c *gin.Context) {
+	filter, err := auditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or json"})
+		return
+	}
+
+	rows, err := utils.AuditQuery(config.DB, filter).Rows()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export audit log"})
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Disposition", "attachment; filename=audit_log."+format)
+	if format == "json" {
+		c.Header("Content-Type", "application/json")
+		c.Writer.WriteString("[")
+		for first := true; rows.Next(); first = false {
+			var entry models.AuditLog
+			if err := config.DB.ScanRows(rows, &entry); err != nil {
+				break
+			}
+			encoded, _ := json.Marshal(entry)
+			if !first {
+				c.Writer.WriteString(",")
+			}
+			c.Writer.Write(encoded)
+		}
+		c.Writer.WriteString("]")
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	writer := csv.NewWriter(c.Writer)
+	defer writer.Flush()
+	writer.Write([]string{"id", "created_at", "actor_id", "action", "target_type", "target_id", "before", "after", "ip"})
+	for rows.Next() {
+		var entry models.AuditLog
+		if err := config.DB.ScanRows(rows, &entry); err != nil {
+			break
+		}
+		actorID := ""
+		if entry.ActorID != nil {
+			actorID = strconv.FormatUint(uint64(*entry.ActorID), 10)
+		}
+		writer.Write([]string{
+			strconv.FormatUint(uint64(entry.ID), 10),
+			entry.CreatedAt.UTC().Format(time.RFC3339),
+			actorID,
+			entry.Action,
+			entry.TargetType,
+			entry.TargetID,
+			string(entry.Before),
+			string(entry.After),
+			entry.IP,
+		})
+	}
+}
@@ -1,48 +1,99 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"fyp/config"
+	"fyp/middlewares"
 	"fyp/models"
 	"fyp/utils"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
-var secretKey = []byte("your-secret-key") // Replace with a strong secret key
+// loginAccountLimit throttles login attempts per username.
+var loginAccountLimit = utils.PerMinute(5, 10)
 
-// Signup registers a new user.
+// getUserID reads the authenticated user's ID set by AuthMiddleware.
+func getUserID(c *gin.Context) (uint, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return 0, false
+	}
+
+	switch v := userID.(type) {
+	case float64:
+		return uint(v), true
+	case uint:
+		return v, true
+	case string:
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return 0, false
+		}
+		return uint(id), true
+	}
+	return 0, false
+}
+
+// Signup registers a new user and mails them a link to verify their email.
 func Signup(c *gin.Context) {
-	var user models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	var req models.SignupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A username, a valid email and a password are required"})
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = normaliseEmail(req.Email)
+	if err := utils.ValidatePassword(req.Password, req.Username, req.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error hashing password"})
 		return
 	}
-	user.Password = string(hashedPassword)
+
+	// Roles are granted by admins, never chosen at signup
+	user := models.User{
+		Username: req.Username,
+		Email:    req.Email,
+		Password: string(hashedPassword),
+		Role:     models.RoleUser,
+	}
 
 	// Save the user to the database
 	if err := config.DB.Create(&user).Error; err != nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
 		return
 	}
-	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully"})
+	if err := utils.SendVerificationEmail(config.DB, user); err != nil {
+		fmt.Println("❌ Failed to send verification email:", err)
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully, check your email to verify your address"})
+}
+
+// normaliseEmail trims and lower-cases an email so lookups match however it
+// was typed.
+func normaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
 // Login authenticates a user and returns a JWT token.
 func Login(c *gin.Context) {
-	var input models.User
+	// User hides its password from JSON, so credentials need their own type
+	var input models.LoginRequest
 	var user models.User
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -50,74 +101,301 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	// Throttle guesses against one account however many IPs they come from
+	ip := c.ClientIP()
+	if ok, wait := utils.Allow(utils.LoginAccountKey(input.Username), loginAccountLimit); !ok {
+		utils.AuditLoginFailure(config.DB, nil, input.Username, ip, models.LoginFailedRateLimited)
+		middlewares.RejectRateLimited(c, wait)
+		return
+	}
+
 	if err := config.DB.Where("username = ?", input.Username).First(&user).Error; err != nil {
+		utils.AuditLoginFailure(config.DB, nil, input.Username, ip, models.LoginFailedUnknownUser)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
+	// A locked account is refused before the password is checked, so
+	// guessing during the lock learns nothing
+	if until := utils.LockedUntil(config.DB, user.ID); !until.IsZero() {
+		utils.AuditLoginFailure(config.DB, &user.ID, input.Username, ip, models.LoginFailedLocked)
+		respondLocked(c, until)
+		return
+	}
+
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		utils.AuditLoginFailure(config.DB, &user.ID, input.Username, ip, models.LoginFailedBadPassword)
+		until, err := utils.RecordLoginFailure(config.DB, user.ID)
+		if err != nil {
+			fmt.Println("❌ Failed to record login failure:", err)
+		}
+		if !until.IsZero() {
+			respondLocked(c, until)
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
+	if !user.EmailVerified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Verify your email address before logging in"})
+		return
+	}
+
+	// Failures are only cleared once the second factor is in too, so the
+	// password cannot be used to reset the count between code guesses
+	if utils.TwoFactorEnabled(config.DB, user.ID) {
+		respondTwoFactorChallenge(c, user)
+		return
+	}
+	completeLogin(c, user)
+}
 
-	// Generate JWT Token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"exp":     time.Now().Add(time.Hour * 24 * 365).Unix(), // Expires in 24 hours
+// respondTwoFactorChallenge answers a login that still needs the second
+// factor with a challenge token for LoginTwoFactor.
+func respondTwoFactorChallenge(c *gin.Context, user models.User) {
+	challenge, claims, err := utils.IssueTwoFactorChallenge(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"two_factor_required": true,
+		"challenge_token":     challenge,
+		"expires_in":          claims.ExpiresAt - claims.IssuedAt,
 	})
-	tokenString, err := token.SignedString(secretKey)
+}
+
+// completeLogin clears the user's failed attempts and starts a session.
+func completeLogin(c *gin.Context, user models.User) {
+	if err := utils.ClearLoginFailures(config.DB, user.ID); err != nil {
+		fmt.Println("❌ Failed to clear login failures:", err)
+	}
+	refreshToken, err := utils.IssueRefreshToken(config.DB, user.ID, "")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
 		return
 	}
+	respondWithTokens(c, user.ID, refreshToken)
+}
+
+// checkCallerSecret confirms a password or 2FA code a signed-in caller sends
+// before a sensitive change. Guesses share the login throttle and lockout, so
+// a stolen session cannot be used to brute force them. check reports whether
+// the secret matched; the error response is written and false returned
+// unless it did.
+func checkCallerSecret(c *gin.Context, user models.User, reason string, check func() (bool, error)) bool {
+	ip := c.ClientIP()
+	if ok, wait := utils.Allow(utils.LoginAccountKey(user.Username), loginAccountLimit); !ok {
+		utils.AuditLoginFailure(config.DB, &user.ID, user.Username, ip, models.LoginFailedRateLimited)
+		middlewares.RejectRateLimited(c, wait)
+		return false
+	}
+	if until := utils.LockedUntil(config.DB, user.ID); !until.IsZero() {
+		utils.AuditLoginFailure(config.DB, &user.ID, user.Username, ip, models.LoginFailedLocked)
+		respondLocked(c, until)
+		return false
+	}
 
-	c.JSON(http.StatusOK, gin.H{"token": tokenString})
+	matched, err := check()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check credentials"})
+		return false
+	}
+	if matched {
+		return true
+	}
+	utils.AuditLoginFailure(config.DB, &user.ID, user.Username, ip, reason)
+	until, err := utils.RecordLoginFailure(config.DB, user.ID)
+	if err != nil {
+		fmt.Println("❌ Failed to record login failure:", err)
+	}
+	if !until.IsZero() {
+		respondLocked(c, until)
+		return false
+	}
+	if reason == models.LoginFailedBadPassword {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+	} else {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+	}
+	return false
 }
 
-// PromoteToAdmin promotes a user to an admin role.
-func PromoteToAdmin(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
+// passwordCheck checks a password for checkCallerSecret.
+func passwordCheck(user models.User, password string) func() (bool, error) {
+	return func() (bool, error) {
+		return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil, nil
 	}
+}
 
-	var currentUser models.User
-	config.DB.First(&currentUser, userID)
-	if currentUser.Role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+// respondLocked tells the client the account is locked and until when.
+func respondLocked(c *gin.Context, until time.Time) {
+	seconds := int(math.Ceil(time.Until(until).Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusLocked, gin.H{"error": "Account temporarily locked after repeated failed logins", "retry_after": seconds})
+}
+
+// respondWithTokens issues an access token and returns it with refreshToken.
+func respondWithTokens(c *gin.Context, userID uint, refreshToken string) {
+	accessToken, claims, err := utils.IssueAccessToken(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"token":         accessToken, // Kept for clients that predate refresh tokens
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    claims.ExpiresAt - claims.IssuedAt,
+	})
+}
 
-	var req struct {
-		Email string `json:"email"`
-	}
+// RefreshSession exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token works once.
+func RefreshSession(c *gin.Context) {
+	var req models.RefreshTokenRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
 		return
 	}
 
-	if err := updateUserRole(req.Email, "admin"); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
+	userID, refreshToken, err := utils.RotateRefreshToken(config.DB, req.RefreshToken)
+	switch {
+	case errors.Is(err, utils.ErrRefreshTokenReused):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, please log in again"})
+		return
+	case errors.Is(err, utils.ErrInvalidToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "User promoted to admin successfully"})
+	respondWithTokens(c, userID, refreshToken)
 }
 
-func PromoteToUser(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
+// Logout revokes the access token used for the request and the session of
+// the given refresh token, or every session of the user with "all".
+func Logout(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
+	var req models.LogoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
 
-	var currentUser models.User
-	config.DB.First(&currentUser, userID)
-	if currentUser.Role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+	if claims, ok := c.Get(middlewares.AccessClaimsKey); ok {
+		if err := utils.RevokeAccessToken(config.DB, claims.(utils.AccessClaims)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+			return
+		}
+	}
+
+	var err error
+	switch {
+	case req.All:
+		err = utils.RevokeUserSessions(config.DB, userID)
+	case req.RefreshToken != "":
+		var token models.RefreshToken
+		if config.DB.Where("token_hash = ? AND user_id = ?", utils.HashToken(req.RefreshToken), userID).First(&token).Error == nil {
+			err = utils.RevokeRefreshFamily(config.DB, token.FamilyID)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end session"})
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// StartTokenCleanup periodically removes expired refresh tokens and
+// revocation entries.
+func StartTokenCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := utils.PurgeExpiredTokens(config.DB); err != nil {
+				fmt.Println("❌ Token cleanup failed:", err)
+			}
+		}
+	}()
+}
 
+// StartLimiterSweep periodically forgets idle rate limit buckets when they
+// are kept in memory.
+func StartLimiterSweep(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if store, ok := utils.GetLimiterStore().(*utils.MemoryLimiterStore); ok {
+				store.Sweep(interval)
+			}
+		}
+	}()
+}
+
+// UnlockAccount lifts a lockout and the login throttle on an account (requires users:manage).
+func UnlockAccount(c *gin.Context) {
+	var user models.User
+	if err := config.DB.First(&user, c.Param("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	var lockout models.AccountLockout
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Limit(1).Find(&lockout).Error; err != nil {
+			return err
+		}
+		if err := utils.ClearLoginFailures(tx, user.ID); err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditUserUnlock, models.AuditTargetUser, user.ID, lockout, nil)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock account"})
+		return
+	}
+	utils.GetLimiterStore().Reset(utils.LoginAccountKey(user.Username))
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked"})
+}
+
+// GetLoginAttempts lists failed logins, newest first, filtered by user_id,
+// username, ip or reason (requires users:read).
+func GetLoginAttempts(c *gin.Context) {
+	query := config.DB.Model(&models.LoginAttempt{})
+	for _, column := range []string{"user_id", "username", "ip", "reason"} {
+		if value := c.Query(column); value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	if since := c.Query("since"); since != "" {
+		from, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be an RFC 3339 time"})
+			return
+		}
+		query = query.Where("created_at >= ?", from)
+	}
+
+	var attempts []models.LoginAttempt
+	if err := query.Order("created_at desc").Limit(500).Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch login attempts"})
+		return
+	}
+	c.JSON(http.StatusOK, attempts)
+}
+
+// PromoteToAdmin promotes a user to an admin role (requires users:manage).
+func PromoteToAdmin(c *gin.Context) {
 	var req struct {
 		Email string `json:"email"`
 	}
@@ -126,34 +404,50 @@ func PromoteToUser(c *gin.Context) {
 		return
 	}
 
-	if err := updateUserRole(req.Email, "user"); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
+	if err := updateUserRole(c, req.Email, models.RoleAdmin); err != nil {
+		respondRoleError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "User promoted to admin successfully"})
 }
 
-func updateUserRole(email string, newRole string) error {
-	result := config.DB.Model(&models.User{}).Where("email = ?", email).Update("role", newRole)
-	if result.Error != nil {
-		return result.Error
+// PromoteToUser demotes an admin to a regular user (requires users:manage).
+func PromoteToUser(c *gin.Context) {
+	var req struct {
+		Email string `json:"email"`
 	}
-	fmt.Printf("✅ Updated role for %s to %s\n", email, newRole)
-	return nil
-}
-
-func ToggleAI(c *gin.Context) {
-	var body models.ToggleAIRequest
-	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid request", "details": err.Error()})
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
 		return
 	}
 
-	// Log the received body to ensure it's being parsed correctly
-	fmt.Println("Received request body:", body)
+	if err := updateUserRole(c, req.Email, models.RoleUser); err != nil {
+		respondRoleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User promoted to admin successfully"})
+}
 
-	// Set the AI enabled status for the specific plant
-	utils.SetGlobalAIEnabled(body.Enabled, body.Plant)
+// updateUserRole changes the role of the user with an email and records the
+// change in the audit log.
+func updateUserRole(c *gin.Context, email string, newRole string) error {
+	return config.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Where("email = ?", email).First(&user).Error; err != nil {
+			return err
+		}
+		before := gin.H{"role": user.Role}
+		if err := tx.Model(&user).Update("role", newRole).Error; err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditUserRoleChange, models.AuditTargetUser, user.ID, before, gin.H{"role": newRole})
+	})
+}
 
-	c.JSON(200, gin.H{"message": "AI toggle updated"})
+func respondRoleError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
 }
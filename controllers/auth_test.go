@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"fyp/config"
+	"fyp/middlewares"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authenticated calls a route behind AuthMiddleware with a bearer token.
+func authenticated(token string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.GET("/profile", middlewares.AuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/profile", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestTokenTypesAreNotInterchangeable(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+
+	recorder := serve(Login, http.MethodPost, "/login", map[string]string{"username": "grower", "password": "Grower-password-1"}, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	body := decode(t, recorder)
+	access, _ := body["access_token"].(string)
+	refresh, _ := body["refresh_token"].(string)
+	expectStatus(t, authenticated(access), http.StatusNoContent)
+
+	// A refresh token is no bearer token, and an access token refreshes nothing
+	expectStatus(t, authenticated(refresh), http.StatusUnauthorized)
+	expectStatus(t, serve(RefreshSession, http.MethodPost, "/token/refresh", map[string]string{"refresh_token": access}, nil), http.StatusUnauthorized)
+
+	// Nor does a 2FA challenge stand in for an access token
+	challenge, _, err := utils.IssueTwoFactorChallenge(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, authenticated(challenge), http.StatusUnauthorized)
+	expectStatus(t, serve(RefreshSession, http.MethodPost, "/token/refresh", map[string]string{"refresh_token": challenge}, nil), http.StatusUnauthorized)
+}
+
+func TestRevokedAccessTokenIsRejected(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	token, claims, err := utils.IssueAccessToken(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, authenticated(token), http.StatusNoContent)
+	if err := utils.RevokeAccessToken(config.DB, claims); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, authenticated(token), http.StatusUnauthorized)
+}
@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ListCrops returns the crop catalog with varieties and growth stages.
+func ListCrops(c *gin.Context) {
+	var crops []models.Crop
+	if err := config.DB.Preload("Varieties").Preload("Stages", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sequence asc")
+	}).Order("species asc").Find(&crops).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch crops"})
+		return
+	}
+	c.JSON(http.StatusOK, crops)
+}
+
+// GetCrop returns a single crop catalog entry.
+func GetCrop(c *gin.Context) {
+	var crop models.Crop
+	if err := config.DB.Preload("Varieties").Preload("Stages", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sequence asc")
+	}).First(&crop, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Crop not found"})
+		return
+	}
+	c.JSON(http.StatusOK, crop)
+}
+
+// CreateCrop adds a crop with its varieties and growth stages to the catalog (requires crops:create).
+func CreateCrop(c *gin.Context) {
+	var crop models.Crop
+	if err := c.ShouldBindJSON(&crop); err != nil || crop.Species == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid crop data"})
+		return
+	}
+	crop.ID = 0
+	if crop.CapTemperature != 0 && crop.CapTemperature <= crop.BaseTemperature {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cap_temperature must be above base_temperature"})
+		return
+	}
+	if _, err := utils.GDDMethodFor(crop, ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range crop.Stages {
+		stage := &crop.Stages[i]
+		if stage.Name == "" || stage.DurationDays <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Every growth stage needs a name and a positive duration"})
+			return
+		}
+		if stage.GDDRequired < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Growth stage " + stage.Name + " has a negative gdd_required"})
+			return
+		}
+		if stage.MaxTemperature == 0 && stage.MinTemperature == 0 || stage.MaxHumidity <= 0 || stage.MaxSoilMoisture <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Growth stage " + stage.Name + " needs temperature, humidity and soil moisture ranges"})
+			return
+		}
+		if stage.MinTemperature > stage.MaxTemperature || stage.MinHumidity > stage.MaxHumidity ||
+			stage.MinSoilMoisture > stage.MaxSoilMoisture {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Growth stage " + stage.Name + " has a minimum above its maximum"})
+			return
+		}
+		if stage.Sequence == 0 {
+			stage.Sequence = i + 1
+		}
+	}
+
+	if err := config.DB.Create(&crop).Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Crop already exists"})
+		return
+	}
+	c.JSON(http.StatusCreated, crop)
+}
+
+// DeleteCrop removes a crop from the catalog (requires crops:delete). Crops
+// still used by an active planting cannot be deleted.
+func DeleteCrop(c *gin.Context) {
+	var count int64
+	config.DB.Model(&models.Planting{}).Where("crop_id = ? AND active = ?", c.Param("id"), true).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Crop is used by active plantings"})
+		return
+	}
+
+	result := config.DB.Delete(&models.Crop{}, c.Param("id"))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete crop"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Crop not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Crop deleted successfully"})
+}
+
+// CreatePlanting assigns one of the caller's devices, or a field of a farm
+// they own, to a crop. Any planting already active on the device or field is
+// ended.
+func CreatePlanting(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreatePlantingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid planting data"})
+		return
+	}
+	if req.FieldID != nil && req.DeviceID != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Plant either a device_id or a field_id"})
+		return
+	}
+	if req.FieldID == nil && req.DeviceID == "" {
+		req.DeviceID = models.DefaultDeviceID
+	}
+	if req.FieldID != nil {
+		var field models.Field
+		if err := config.DB.First(&field, *req.FieldID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown field"})
+			return
+		}
+		if _, ok := ownedFarm(c, user, field.FarmID); !ok {
+			return
+		}
+	}
+
+	var crop models.Crop
+	if err := config.DB.First(&crop, req.CropID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown crop"})
+		return
+	}
+	if req.VarietyID != nil {
+		var variety models.CropVariety
+		if err := config.DB.Where("id = ? AND crop_id = ?", *req.VarietyID, crop.ID).First(&variety).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Variety does not belong to crop"})
+			return
+		}
+	}
+
+	planting := models.Planting{
+		UserID:    user.ID,
+		DeviceID:  req.DeviceID,
+		FieldID:   req.FieldID,
+		CropID:    req.CropID,
+		VarietyID: req.VarietyID,
+		SowDate:   req.SowDate,
+		Active:    true,
+	}
+
+	now := time.Now()
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		current := tx.Model(&models.Planting{}).Where("active = ?", true)
+		if req.FieldID != nil {
+			current = current.Where("field_id = ?", *req.FieldID)
+		} else {
+			current = current.Where("user_id = ? AND device_id = ? AND field_id IS NULL", user.ID, req.DeviceID)
+		}
+		if err := current.Updates(map[string]interface{}{"active": false, "ended_at": now}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&planting).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create planting"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, planting)
+}
+
+// GetPlantings lists the caller's plantings, newest first.
+func GetPlantings(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var plantings []models.Planting
+	if err := config.DB.Preload("Crop").Preload("Variety").
+		Where("user_id = ?", userID).Order("sow_date desc").Find(&plantings).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch plantings"})
+		return
+	}
+	c.JSON(http.StatusOK, plantings)
+}
+
+// GetPlantingStage reports the current growth stage of a planting, its ideal
+// ranges and the full stage schedule.
+func GetPlantingStage(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var planting models.Planting
+	if err := config.DB.Preload("Crop.Stages").Preload("Variety").
+		Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&planting).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Planting not found"})
+		return
+	}
+
+	now := time.Now()
+	response := gin.H{
+		"planting":      planting,
+		"days_in_field": int(now.Sub(planting.SowDate).Hours() / 24),
+		"schedule":      utils.StageSchedule(planting.Crop.Stages, planting.SowDate),
+	}
+	if window, ok := utils.CurrentStage(planting.Crop.Stages, planting.SowDate, now); ok {
+		response["current_stage"] = window
+		response["thresholds"] = utils.StageThresholds(window.Stage)
+	} else {
+		response["current_stage"] = nil
+		response["thresholds"] = utils.DefaultThresholds
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetPlantingGDD returns the growing degree days a planting has accumulated
+// since its sow date, day by day, with the predicted stage transitions.
+// ?method= overrides the crop's GDD method.
+func GetPlantingGDD(c *gin.Context) {
+	planting, method, ok := loadPlantingForGDD(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	days, err := utils.AccumulateGDD(config.DB, planting, method, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute degree days"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"planting_id":      planting.ID,
+		"base_temperature": planting.Crop.BaseTemperature,
+		"cap_temperature":  planting.Crop.CapTemperature,
+		"days":             days,
+		"prediction":       utils.PredictStages(planting, days, method, now),
+	})
+}
+
+// GetPlantingPrediction predicts a planting's next growth-stage transition and
+// its harvest window.
+func GetPlantingPrediction(c *gin.Context) {
+	planting, method, ok := loadPlantingForGDD(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	days, err := utils.AccumulateGDD(config.DB, planting, method, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute degree days"})
+		return
+	}
+	c.JSON(http.StatusOK, utils.PredictStages(planting, days, method, now))
+}
+
+// loadPlantingForGDD loads the caller's planting with its crop stages and
+// resolves the GDD method, writing the error response on failure.
+func loadPlantingForGDD(c *gin.Context) (models.Planting, string, bool) {
+	var planting models.Planting
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return planting, "", false
+	}
+
+	if err := config.DB.Preload("Crop.Stages").
+		Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&planting).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Planting not found"})
+		return planting, "", false
+	}
+
+	method, err := utils.GDDMethodFor(planting.Crop, c.Query("method"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return planting, "", false
+	}
+	return planting, method, true
+}
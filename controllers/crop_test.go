@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+)
+
+// testCrop saves a crop with one 100 day stage.
+func testCrop(t *testing.T, species string, minMoisture, maxMoisture float32) models.Crop {
+	t.Helper()
+	crop := models.Crop{Species: species, Stages: []models.GrowthStage{{
+		Name: "Growing", Sequence: 1, DurationDays: 100,
+		MinTemperature: 10, MaxTemperature: 30, MinHumidity: 40, MaxHumidity: 80,
+		MinSoilMoisture: minMoisture, MaxSoilMoisture: maxMoisture,
+	}}}
+	if err := config.DB.Create(&crop).Error; err != nil {
+		t.Fatal(err)
+	}
+	return crop
+}
+
+// testField saves a farm owned by user with one field and zone.
+func testField(t *testing.T, user models.User) (models.Field, models.Zone) {
+	t.Helper()
+	farm := models.Farm{OwnerID: user.ID, Name: "Home"}
+	if err := config.DB.Create(&farm).Error; err != nil {
+		t.Fatal(err)
+	}
+	field := models.Field{FarmID: farm.ID, Name: "North"}
+	if err := config.DB.Create(&field).Error; err != nil {
+		t.Fatal(err)
+	}
+	zone := models.Zone{FieldID: field.ID, Name: "Rows 1-4"}
+	if err := config.DB.Create(&zone).Error; err != nil {
+		t.Fatal(err)
+	}
+	return field, zone
+}
+
+func TestCreateCropRejectsInvertedRanges(t *testing.T) {
+	testDB(t)
+	admin := createTestUser(t, "admin", "admin@example.com", "Admin-password-1")
+
+	crop := map[string]interface{}{
+		"species": "Solanum lycopersicum",
+		"stages": []map[string]interface{}{{
+			"name": "Seedling", "duration_days": 20, "min_soil_moisture": 70, "max_soil_moisture": 40,
+		}},
+	}
+	expectStatus(t, serve(CreateCrop, http.MethodPost, "/crops", crop, &admin), http.StatusBadRequest)
+
+	// A range left at 0/0 would flag every reading as abnormal
+	crop["stages"] = []map[string]interface{}{{
+		"name": "Seedling", "duration_days": 20, "min_soil_moisture": 40, "max_soil_moisture": 70,
+	}}
+	expectStatus(t, serve(CreateCrop, http.MethodPost, "/crops", crop, &admin), http.StatusBadRequest)
+
+	crop["stages"] = []map[string]interface{}{{
+		"name": "Seedling", "duration_days": 20, "gdd_required": -50, "min_temperature": 15, "max_temperature": 30,
+		"min_humidity": 50, "max_humidity": 85, "min_soil_moisture": 40, "max_soil_moisture": 70,
+	}}
+	recorder := serve(CreateCrop, http.MethodPost, "/crops", crop, &admin)
+	expectStatus(t, recorder, http.StatusBadRequest)
+	if message := decode(t, recorder)["error"]; message != "Growth stage Seedling has a negative gdd_required" {
+		t.Fatalf("error = %v, want the negative gdd_required named", message)
+	}
+
+	crop["stages"] = []map[string]interface{}{{
+		"name": "Seedling", "duration_days": 20, "min_temperature": 15, "max_temperature": 30,
+		"min_humidity": 50, "max_humidity": 85, "min_soil_moisture": 40, "max_soil_moisture": 70,
+	}}
+	expectStatus(t, serve(CreateCrop, http.MethodPost, "/crops", crop, &admin), http.StatusCreated)
+}
+
+func TestFieldPlantingCoversItsDevices(t *testing.T) {
+	testDB(t)
+	owner := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	field, zone := testField(t, owner)
+	wheat := testCrop(t, "Triticum aestivum", 20, 35)
+	tomato := testCrop(t, "Solanum lycopersicum", 40, 70)
+	sown := time.Now().AddDate(0, 0, -10)
+	if err := config.DB.Create(&models.Device{UserID: owner.ID, DeviceID: "probe-1", ZoneID: &zone.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	planting := map[string]interface{}{"field_id": field.ID, "crop_id": wheat.ID, "sow_date": sown}
+	expectStatus(t, serve(CreatePlanting, http.MethodPost, "/plantings", planting, &owner), http.StatusCreated)
+
+	thresholds, model := utils.PlantingThresholds(config.DB, owner.ID, "probe-1", time.Now())
+	if model != wheat.Species || thresholds.MinSoilMoisture != 20 {
+		t.Fatalf("device in a planted field got %q %+v, want the field's crop", model, thresholds)
+	}
+	if _, model := utils.PlantingThresholds(config.DB, owner.ID, "elsewhere", time.Now()); model != "" {
+		t.Fatalf("device outside the field got the field's crop %q", model)
+	}
+
+	// A device's own planting overrides its field's
+	planting = map[string]interface{}{"device_id": "probe-1", "crop_id": tomato.ID, "sow_date": sown}
+	expectStatus(t, serve(CreatePlanting, http.MethodPost, "/plantings", planting, &owner), http.StatusCreated)
+	if _, model := utils.PlantingThresholds(config.DB, owner.ID, "probe-1", time.Now()); model != tomato.Species {
+		t.Fatalf("device with its own planting got %q, want %q", model, tomato.Species)
+	}
+	if _, err := utils.ActiveFieldPlanting(config.DB, field.ID); err != nil {
+		t.Fatalf("planting the device ended the field's planting: %v", err)
+	}
+}
+
+func TestFieldPlantingNeedsTheFarmOwner(t *testing.T) {
+	testDB(t)
+	owner := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	other := createTestUser(t, "neighbour", "neighbour@example.com", "Neighbour-password-1")
+	field, _ := testField(t, owner)
+	crop := testCrop(t, "Triticum aestivum", 20, 35)
+
+	planting := map[string]interface{}{"field_id": field.ID, "crop_id": crop.ID, "sow_date": time.Now()}
+	expectStatus(t, serve(CreatePlanting, http.MethodPost, "/plantings", planting, &other), http.StatusForbidden)
+
+	planting["device_id"] = "probe-1"
+	expectStatus(t, serve(CreatePlanting, http.MethodPost, "/plantings", planting, &owner), http.StatusBadRequest)
+}
@@ -2,7 +2,6 @@ package controllers
 
 import (
 	"fyp/config"
-	"fyp/utils"
 	"net/http"
 	"time"
 
@@ -12,45 +11,63 @@ import (
 const developerModeDuration = 14 * 24 * time.Hour // 14 days
 // const developerModeDuration = 5 * time.Minute // 5 minutes for testing
 
-// GET /device-config/esp32-001
+// developerModeActive reports whether developer mode is on, switching it off
+// once developerModeDuration has passed. AI prediction is suspended for every
+// device while developer mode is active.
+func developerModeActive() (bool, time.Time, error) {
+	enabled, startTime := config.GetDeveloperModeState()
+	if !enabled {
+		return false, startTime, nil
+	}
+	if time.Since(startTime) < developerModeDuration {
+		return true, startTime, nil
+	}
+
+	// Developer mode duration has passed, reset it
+	if err := config.SetDeveloperModeState(config.DB, false, time.Time{}, auditDeveloperMode(nil, false, time.Time{})); err != nil {
+		return false, startTime, err
+	}
+	return false, time.Time{}, nil
+}
+
+// GET /device-config/:device_id
 func GetDeviceConfig(c *gin.Context) {
-	currentDevModeEnabled, currentDevModeStartTime := config.GetDeveloperModeState()
-	now := time.Now()
-	devModeActive := false
-	var responseStartTime time.Time = currentDevModeStartTime // Use this for the JSON response
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if deviceID, ok := credentialDevice(c); ok && deviceID != c.Param("device_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Device key does not belong to this device"})
+		return
+	}
 
-	if currentDevModeEnabled {
-		if now.Sub(currentDevModeStartTime) < developerModeDuration {
-			devModeActive = true
-		} else {
-			// Developer mode duration has passed, reset it and re-enable AI
-			err := config.SetDeveloperModeState(config.DB, false, time.Time{})
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update developer mode state"})
-				return
-			}
-			utils.SetGlobalAIEnabled(true, "Hebe andersonii") // Placeholder plant name
-			responseStartTime = time.Time{}                   // Reflect that it's now off
-		}
+	devModeActive, responseStartTime, err := developerModeActive()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update developer mode state"})
+		return
 	}
 
-	aiEnabled, _ := utils.IsGlobalAIEnabled()
+	aiConfig, _ := config.GetAIConfig(userID, c.Param("device_id"))
 	c.JSON(http.StatusOK, gin.H{
 		"developer_mode":  devModeActive,
 		"start_timestamp": responseStartTime.Unix(), // Use Unix timestamp of responseStartTime
-		"ai_enabled":      aiEnabled,
+		"ai_enabled":      aiConfig.Enabled && !devModeActive,
+		"plant_name":      aiConfig.PlantName,
+		"model_version":   aiConfig.ModelVersion,
 	})
 }
 
-// POST /device-config/esp32-001/trigger-dev
+// POST /admin/developer-mode/start
+// Developer mode is a single switch for the whole system, not per device.
 func TriggerDeveloperMode(c *gin.Context) {
 	startTime := time.Now()
-	err := config.SetDeveloperModeState(config.DB, true, startTime)
+	err := config.SetDeveloperModeState(config.DB, true, startTime, auditDeveloperMode(c, true, startTime))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate developer mode"})
 		return
 	}
-	utils.SetGlobalAIEnabled(false, "") // Disable AI
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":         "Developer mode activated for 14 days. AI disabled.",
@@ -59,17 +76,16 @@ func TriggerDeveloperMode(c *gin.Context) {
 	})
 }
 
-// POST /device-config/esp32-001/stop-dev (Note: Changed from GET in original plan for consistency with Trigger)
+// POST /admin/developer-mode/stop
 func StopDeveloperMode(c *gin.Context) {
-	err := config.SetDeveloperModeState(config.DB, false, time.Time{})
+	err := config.SetDeveloperModeState(config.DB, false, time.Time{}, auditDeveloperMode(c, false, time.Time{}))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to stop developer mode"})
 		return
 	}
-	utils.SetGlobalAIEnabled(true, "Hebe andersonii") // Re-enable AI with placeholder plant name
 
+	// AI resumes with each device's own persisted configuration
 	c.JSON(http.StatusOK, gin.H{
-		"message":    "✅ Developer mode has been stopped manually. AI enabled.",
-		"ai_enabled": true,
+		"message": "✅ Developer mode has been stopped manually.",
 	})
 }
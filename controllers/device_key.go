@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"fyp/config"
+	"fyp/middlewares"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// credentialDevice returns the device a request was authenticated as, or
+// false for requests made with a user token.
+func credentialDevice(c *gin.Context) (string, bool) {
+	if c.GetString(middlewares.PrincipalKey) != middlewares.PrincipalDevice {
+		return "", false
+	}
+	return c.GetString(middlewares.DeviceIDKey), true
+}
+
+// ownedDevice loads one of the caller's devices, registering it if it has not
+// reported yet so it can be provisioned with a key first.
+func ownedDevice(c *gin.Context) (models.Device, bool) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return models.Device{}, false
+	}
+	device, err := utils.EnsureDevice(config.DB, userID, c.Param("device_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load device"})
+		return device, false
+	}
+	return device, true
+}
+
+// deviceCredential loads a key belonging to the device in the URL.
+func deviceCredential(c *gin.Context, device models.Device) (models.DeviceCredential, bool) {
+	var credential models.DeviceCredential
+	if err := config.DB.Where("id = ? AND user_id = ? AND device_id = ?", c.Param("id"), device.UserID, device.DeviceID).
+		First(&credential).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device key not found"})
+		return credential, false
+	}
+	return credential, true
+}
+
+// CreateDeviceKey issues a key for one of the caller's devices. The key is
+// only ever returned here.
+func CreateDeviceKey(c *gin.Context) {
+	device, ok := ownedDevice(c)
+	if !ok {
+		return
+	}
+	var req models.CreateDeviceKeyRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+	}
+
+	credential, key, err := utils.NewDeviceKey(device.UserID, device.DeviceID, req.Name, device.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate key"})
+		return
+	}
+	if err := config.DB.Create(&credential).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store key"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Device key created, it will not be shown again", "key": key, "credential": credential})
+}
+
+// GetDeviceKeys lists a device's keys without their secrets.
+func GetDeviceKeys(c *gin.Context) {
+	device, ok := ownedDevice(c)
+	if !ok {
+		return
+	}
+	var credentials []models.DeviceCredential
+	if err := config.DB.Where("user_id = ? AND device_id = ?", device.UserID, device.DeviceID).
+		Order("created_at desc").Find(&credentials).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch device keys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": credentials})
+}
+
+// RotateDeviceKey replaces a key with a new one. The old key keeps working for
+// grace_minutes so the device can be updated without losing readings.
+func RotateDeviceKey(c *gin.Context) {
+	device, ok := ownedDevice(c)
+	if !ok {
+		return
+	}
+	var req models.RotateDeviceKeyRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+	}
+	grace := time.Duration(req.GraceMinutes) * time.Minute
+	if grace < 0 || grace > utils.MaxDeviceKeyGrace {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "grace_minutes must be between 0 and 10080"})
+		return
+	}
+
+	old, ok := deviceCredential(c, device)
+	if !ok {
+		return
+	}
+	if old.RevokedAt != nil || (old.ExpiresAt != nil && time.Now().After(*old.ExpiresAt)) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Device key is no longer active"})
+		return
+	}
+
+	credential, key, err := utils.RotateDeviceKey(config.DB, old, grace, device.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate key"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Device key rotated, it will not be shown again", "key": key, "credential": credential})
+}
+
+// RevokeDeviceKey stops a key from authenticating at once.
+func RevokeDeviceKey(c *gin.Context) {
+	device, ok := ownedDevice(c)
+	if !ok {
+		return
+	}
+	credential, ok := deviceCredential(c, device)
+	if !ok {
+		return
+	}
+	if credential.RevokedAt == nil {
+		if err := config.DB.Model(&credential).Update("revoked_at", time.Now()).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke key"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Device key revoked"})
+}
@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportSensorData streams sensor data in the format named by ?format= or
+// the Accept header (csv, jsonl, parquet or xlsx), with the same filters as
+// GetHistory.
+func ExportSensorData(c *gin.Context) {
+	exporter, err := utils.NegotiateExporter(c.Query("format"), c.GetHeader("Accept"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	exportReadings(c, exporter)
+}
+
+// exportReadings writes the readings of the history filters with exporter,
+// reading them from a cursor rather than loading them all.
+func exportReadings(c *gin.Context, exporter utils.Exporter) {
+	query, ok := historyQuery(c)
+	if !ok {
+		return
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", exporter.ContentType())
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=sensor_data.%s", exporter.Extension()))
+	writer := exporter.NewWriter(c.Writer)
+	for rows.Next() {
+		var reading models.SensorData
+		if err := config.DB.ScanRows(rows, &reading); err != nil {
+			fmt.Println("❌ Export failed:", err)
+			break
+		}
+		if err := writer.Write(reading); err != nil {
+			fmt.Println("❌ Export failed:", err)
+			break
+		}
+	}
+	if err := writer.Close(); err != nil {
+		fmt.Println("❌ Export failed:", err)
+	}
+}
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+)
+
+func TestCSVExportImportsAgain(t *testing.T) {
+	testDB(t)
+	source := createTestUser(t, "source", "source@example.com", "Source-password-1")
+	target := createTestUser(t, "target", "target@example.com", "Target-password-1")
+	start := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	readings := []models.SensorData{
+		{UserID: source.ID, DeviceID: "probe-1", Timestamp: start, Temperature: 21.5, Humidity: 60.25, SoilMoisture: 35.5},
+		{UserID: source.ID, DeviceID: "probe-1", Timestamp: start.Add(time.Hour), Temperature: 24, Humidity: 55, SoilMoisture: 2, IsAbnormal: true},
+		{UserID: source.ID, DeviceID: "probe-2", Timestamp: start.Add(2 * time.Hour), Temperature: 30.75, Humidity: 41, SoilMoisture: 50},
+	}
+	for i := range readings {
+		if err := config.DB.Create(&readings[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder := serve(DownloadCSV, http.MethodGet, "/download-csv", nil, &source)
+	expectStatus(t, recorder, http.StatusOK)
+	result, err := utils.ImportSensorCSV(config.DB, bytes.NewReader(recorder.Body.Bytes()), utils.ImportOptions{UserID: target.ID})
+	if err != nil || result.Imported != len(readings) || len(result.Extra) != 0 {
+		t.Fatalf("import = %+v, %v; want %d readings and no extra columns", result, err, len(readings))
+	}
+
+	var imported []models.SensorData
+	if err := config.DB.Where("user_id = ?", target.ID).Order("timestamp asc").Find(&imported).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range readings {
+		got := imported[i]
+		if got.DeviceID != want.DeviceID || !got.Timestamp.Equal(want.Timestamp) || got.Temperature != want.Temperature ||
+			got.Humidity != want.Humidity || got.SoilMoisture != want.SoilMoisture || got.IsAbnormal != want.IsAbnormal {
+			t.Fatalf("reading %d imported as %+v, want %+v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,534 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// hierarchyNode reads the most specific of the zone_id, field_id and farm_id
+// query parameters. found is false when none is given.
+func hierarchyNode(c *gin.Context) (level string, id uint, found bool, err error) {
+	for _, candidate := range []string{models.LevelZone, models.LevelField, models.LevelFarm} {
+		raw := c.Query(candidate + "_id")
+		if raw == "" {
+			continue
+		}
+		parsed, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return "", 0, false, err
+		}
+		return candidate, uint(parsed), true, nil
+	}
+	return "", 0, false, nil
+}
+
+// hierarchyFilter limits sensor data to the devices below the farm, field or
+// zone selected in the query that the user can see. filtered is false when no
+// node was selected; ok is false when an error response has been written.
+func hierarchyFilter(c *gin.Context, user models.User) (scope func(*gorm.DB) *gorm.DB, filtered bool, ok bool) {
+	level, id, found, err := hierarchyNode(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid farm, field or zone ID"})
+		return nil, false, false
+	}
+	if !found {
+		return nil, false, true
+	}
+
+	keys, err := utils.NodeDevices(config.DB, user, level, id)
+	if errors.Is(err, utils.ErrNoNodeAccess) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return nil, false, false
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Farm, field or zone not found"})
+		return nil, false, false
+	}
+	return utils.ReadingsOf(keys), true, true
+}
+
+// ownedFarm loads a farm the caller owns, or any farm for admins, writing the
+// error response on failure.
+func ownedFarm(c *gin.Context, user models.User, farmID interface{}) (models.Farm, bool) {
+	var farm models.Farm
+	if err := config.DB.First(&farm, farmID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Farm not found"})
+		return farm, false
+	}
+	if farm.OwnerID != user.ID && !utils.CanManageAny(user, models.ResourceFarms) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return farm, false
+	}
+	return farm, true
+}
+
+// currentUser returns the caller as loaded by RequirePermission, or loads it,
+// writing the error response on failure.
+func currentUser(c *gin.Context) (models.User, bool) {
+	if user, ok := c.Get("user"); ok {
+		if loaded, ok := user.(models.User); ok {
+			return loaded, true
+		}
+	}
+
+	var user models.User
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return user, false
+	}
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return user, false
+	}
+	return user, true
+}
+
+// CreateFarm creates a farm owned by the caller.
+func CreateFarm(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreateFarmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid farm data"})
+		return
+	}
+
+	farm := models.Farm{OwnerID: user.ID, Name: req.Name, Latitude: req.Latitude, Longitude: req.Longitude}
+	if err := config.DB.Create(&farm).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create farm"})
+		return
+	}
+	c.JSON(http.StatusCreated, farm)
+}
+
+// GetFarms lists the farms the caller owns or is a member of.
+func GetFarms(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	query := config.DB.Order("name asc")
+	if !utils.CanManageAny(user, models.ResourceFarms) {
+		memberOf := config.DB.Model(&models.FarmMember{}).Select("farm_id").Where("user_id = ?", user.ID)
+		query = query.Where("owner_id = ? OR id IN (?)", user.ID, memberOf)
+	}
+
+	var farms []models.Farm
+	if err := query.Find(&farms).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch farms"})
+		return
+	}
+	c.JSON(http.StatusOK, farms)
+}
+
+// GetFarm returns a farm with its fields, zones and the devices in each zone.
+// Members only see the fields and zones they have access to.
+func GetFarm(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var farm models.Farm
+	if err := config.DB.Preload("Fields.Zones").First(&farm, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Farm not found"})
+		return
+	}
+
+	full := utils.CanManageAny(user, models.ResourceFarms) || farm.OwnerID == user.ID
+	visible := map[uint]bool{}
+	if !full {
+		var err error
+		if visible, err = utils.VisibleZones(config.DB, user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve access"})
+			return
+		}
+	}
+
+	var zoneIDs []uint
+	fields := farm.Fields[:0]
+	for _, field := range farm.Fields {
+		zones := field.Zones[:0]
+		for _, zone := range field.Zones {
+			if full || visible[zone.ID] {
+				zones = append(zones, zone)
+				zoneIDs = append(zoneIDs, zone.ID)
+			}
+		}
+		field.Zones = zones
+		if full || len(zones) > 0 {
+			fields = append(fields, field)
+		}
+	}
+	farm.Fields = fields
+	if !full && len(zoneIDs) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var devices []models.Device
+	if len(zoneIDs) > 0 {
+		config.DB.Where("zone_id IN ?", zoneIDs).Order("device_id asc").Find(&devices)
+	}
+	c.JSON(http.StatusOK, gin.H{"farm": farm, "devices": devices})
+}
+
+// DeleteFarm removes a farm with its fields and zones (owner only). Devices in
+// it are left unassigned.
+func DeleteFarm(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	farm, ok := ownedFarm(c, user, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		zoneIDs, err := utils.ZonesUnder(tx, models.LevelFarm, farm.ID)
+		if err != nil {
+			return err
+		}
+		if len(zoneIDs) > 0 {
+			if err := tx.Model(&models.Device{}).Where("zone_id IN ?", zoneIDs).Update("zone_id", nil).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("id IN ?", zoneIDs).Delete(&models.Zone{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("farm_id = ?", farm.ID).Delete(&models.Field{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("farm_id = ?", farm.ID).Delete(&models.FarmMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&farm).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete farm"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Farm deleted successfully"})
+}
+
+// CreateField adds a field to a farm the caller owns.
+func CreateField(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	farm, ok := ownedFarm(c, user, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var req models.CreateFieldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid field data"})
+		return
+	}
+
+	field := models.Field{FarmID: farm.ID, Name: req.Name, AreaM2: req.AreaM2}
+	if err := config.DB.Create(&field).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create field"})
+		return
+	}
+	c.JSON(http.StatusCreated, field)
+}
+
+// CreateZone adds a zone to a field of a farm the caller owns.
+func CreateZone(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var field models.Field
+	if err := config.DB.First(&field, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Field not found"})
+		return
+	}
+	if _, ok := ownedFarm(c, user, field.FarmID); !ok {
+		return
+	}
+
+	var req models.CreateZoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid zone data"})
+		return
+	}
+
+	zone := models.Zone{FieldID: field.ID, Name: req.Name}
+	if err := config.DB.Create(&zone).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create zone"})
+		return
+	}
+	c.JSON(http.StatusCreated, zone)
+}
+
+// GetDevices lists the devices the caller can see.
+func GetDevices(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	devices, err := utils.VisibleDevices(config.DB, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch devices"})
+		return
+	}
+	c.JSON(http.StatusOK, devices)
+}
+
+// AssignDevice places one of the caller's devices in a zone of a farm they
+// own, or removes it from its zone.
+func AssignDevice(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.AssignDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device data"})
+		return
+	}
+
+	if req.ZoneID != nil {
+		farm, err := utils.FarmOfNode(config.DB, models.LevelZone, *req.ZoneID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown zone"})
+			return
+		}
+		if farm.OwnerID != user.ID && !utils.CanManageAny(user, models.ResourceFarms) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Devices can only be placed in your own farms"})
+			return
+		}
+	}
+
+	device, err := utils.EnsureDevice(config.DB, user.ID, c.Param("device_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load device"})
+		return
+	}
+	device.ZoneID = req.ZoneID
+	if req.Name != "" {
+		device.Name = req.Name
+	}
+	if err := config.DB.Save(&device).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+		return
+	}
+	c.JSON(http.StatusOK, device)
+}
+
+// AddFarmMember gives a user access to a farm, or to one field or zone of it
+// (owner only).
+func AddFarmMember(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	farm, ok := ownedFarm(c, user, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var req models.AddFarmMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid member data"})
+		return
+	}
+	if err := config.DB.First(&models.User{}, req.UserID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown user"})
+		return
+	}
+
+	member := models.FarmMember{FarmID: farm.ID, UserID: req.UserID, FieldID: req.FieldID, ZoneID: req.ZoneID}
+	switch {
+	case req.ZoneID != nil:
+		zoneFarm, err := utils.FarmOfNode(config.DB, models.LevelZone, *req.ZoneID)
+		if err != nil || zoneFarm.ID != farm.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Zone does not belong to farm"})
+			return
+		}
+		member.FieldID = nil
+	case req.FieldID != nil:
+		fieldFarm, err := utils.FarmOfNode(config.DB, models.LevelField, *req.FieldID)
+		if err != nil || fieldFarm.ID != farm.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Field does not belong to farm"})
+			return
+		}
+	}
+
+	if err := config.DB.Create(&member).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add member"})
+		return
+	}
+	c.JSON(http.StatusCreated, member)
+}
+
+// GetFarmMembers lists who has access to a farm (owner only).
+func GetFarmMembers(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	farm, ok := ownedFarm(c, user, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var members []models.FarmMember
+	if err := config.DB.Where("farm_id = ?", farm.ID).Order("created_at asc").Find(&members).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch members"})
+		return
+	}
+	c.JSON(http.StatusOK, members)
+}
+
+// RemoveFarmMember revokes a membership (owner only).
+func RemoveFarmMember(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	farm, ok := ownedFarm(c, user, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	result := config.DB.Where("id = ? AND farm_id = ?", c.Param("member_id"), farm.ID).Delete(&models.FarmMember{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// GetRollup aggregates sensor readings at a farm, field or zone (selected
+// with farm_id, field_id or zone_id) and breaks them down one level further:
+// a farm by field, a field by zone and a zone by device. from and to
+// (RFC 3339) bound the readings.
+func GetRollup(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	level, id, found, err := hierarchyNode(c)
+	if err != nil || !found {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "farm_id, field_id or zone_id is required"})
+		return
+	}
+	var from, to time.Time
+	if raw := c.Query("from"); raw != "" {
+		if from, err = time.Parse(time.RFC3339, raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be RFC 3339"})
+			return
+		}
+	}
+	if raw := c.Query("to"); raw != "" {
+		if to, err = time.Parse(time.RFC3339, raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be RFC 3339"})
+			return
+		}
+	}
+
+	keys, err := utils.NodeDevices(config.DB, user, level, id)
+	if errors.Is(err, utils.ErrNoNodeAccess) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Farm, field or zone not found"})
+		return
+	}
+	totals, err := utils.AggregateReadings(config.DB, keys, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate readings"})
+		return
+	}
+
+	type child struct {
+		Level    string          `json:"level"`
+		ID       uint            `json:"id,omitempty"`
+		Name     string          `json:"name"`
+		Devices  int             `json:"devices"`
+		Readings utils.Aggregate `json:"readings"`
+	}
+	children := []child{}
+	addChild := func(childLevel string, childID uint, name string, childKeys []utils.DeviceKey) error {
+		aggregate, err := utils.AggregateReadings(config.DB, childKeys, from, to)
+		if err != nil {
+			return err
+		}
+		children = append(children, child{Level: childLevel, ID: childID, Name: name, Devices: len(childKeys), Readings: aggregate})
+		return nil
+	}
+
+	switch level {
+	case models.LevelFarm, models.LevelField:
+		childLevel := models.LevelField
+		var nodes []struct {
+			ID   uint
+			Name string
+		}
+		if level == models.LevelFarm {
+			err = config.DB.Model(&models.Field{}).Where("farm_id = ?", id).Order("name asc").Find(&nodes).Error
+		} else {
+			childLevel = models.LevelZone
+			err = config.DB.Model(&models.Zone{}).Where("field_id = ?", id).Order("name asc").Find(&nodes).Error
+		}
+		for i := 0; err == nil && i < len(nodes); i++ {
+			childKeys, nodeErr := utils.NodeDevices(config.DB, user, childLevel, nodes[i].ID)
+			switch {
+			case errors.Is(nodeErr, utils.ErrNoNodeAccess):
+				// Members only see the parts of the farm they were given
+			case nodeErr != nil:
+				err = nodeErr
+			default:
+				err = addChild(childLevel, nodes[i].ID, nodes[i].Name, childKeys)
+			}
+		}
+	case models.LevelZone:
+		for _, key := range keys {
+			if err = addChild(models.LevelDevice, 0, key.DeviceID, []utils.DeviceKey{key}); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate readings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"level":    level,
+		"id":       id,
+		"devices":  keys,
+		"totals":   totals,
+		"children": children,
+	})
+}
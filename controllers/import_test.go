@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+)
+
+func importCSV(t *testing.T, csv string, opts utils.ImportOptions) utils.ImportResult {
+	t.Helper()
+	result, err := utils.ImportSensorCSV(config.DB, strings.NewReader(csv), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestImportRejectsTheFileUnlessInvalidRowsAreSkipped(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	csv := "timestamp,device_id,temperature,humidity,soil_moisture\n" +
+		"2024-05-01 06:00:00,probe-1,21,60,35\n" +
+		"2024-05-01 07:00:00,probe-1,22,150,35\n" +
+		"2024-05-01 08:00:00,probe-1,23,58,34\n"
+
+	result := importCSV(t, csv, utils.ImportOptions{UserID: user.ID})
+	if result.Imported != 0 || result.Valid != 2 || result.Invalid != 1 || readingCount(user.ID) != 0 {
+		t.Fatalf("import = %+v with %d stored, want the whole file rejected", result, readingCount(user.ID))
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Row != 3 || result.Errors[0].Column != "humidity" {
+		t.Fatalf("errors = %+v, want humidity on line 3", result.Errors)
+	}
+
+	result = importCSV(t, csv, utils.ImportOptions{UserID: user.ID, SkipInvalid: true, BatchSize: 1})
+	if result.Imported != 2 || readingCount(user.ID) != 2 {
+		t.Fatalf("import skipping invalid rows = %+v with %d stored, want 2", result, readingCount(user.ID))
+	}
+}
+
+func TestImportDryRunWritesNothing(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	csv := "timestamp,temperature,humidity,soil_moisture\n2024-05-01 06:00:00,21,60,35\n"
+
+	result := importCSV(t, csv, utils.ImportOptions{UserID: user.ID, DryRun: true})
+	if !result.DryRun || result.Valid != 1 || result.Imported != 0 || len(result.Devices) != 1 || result.Devices[0] != models.DefaultDeviceID {
+		t.Fatalf("dry run = %+v, want one valid row for the default device", result)
+	}
+	var devices int64
+	config.DB.Model(&models.Device{}).Where("user_id = ?", user.ID).Count(&devices)
+	if readingCount(user.ID) != 0 || devices != 0 {
+		t.Fatalf("dry run stored %d readings and %d devices, want none", readingCount(user.ID), devices)
+	}
+}
+
+func TestImportStoresExtraColumnsAndClassifiesByPlanting(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	tomato := testCrop(t, "Solanum lycopersicum", 40, 70)
+	planting := models.Planting{UserID: user.ID, DeviceID: "probe-1", CropID: tomato.ID, SowDate: time.Date(2024, 4, 20, 0, 0, 0, 0, time.UTC), Active: true}
+	if err := config.DB.Create(&planting).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Inside the default soil moisture range, but too dry for tomatoes
+	csv := "timestamp,temperature,humidity,soil_moisture,pH\n" +
+		"2024-05-01 06:00:00,21,60,30,6.5\n" +
+		"2024-05-01 07:00:00,22,60,55,\n"
+	result := importCSV(t, csv, utils.ImportOptions{UserID: user.ID, DefaultDeviceID: "probe-1"})
+	if result.Imported != 2 || result.Abnormal != 1 || len(result.Extra) != 1 || result.Extra[0] != "ph" {
+		t.Fatalf("import = %+v, want 2 readings, 1 abnormal and a ph column", result)
+	}
+
+	var readings []models.SensorData
+	config.DB.Where("user_id = ?", user.ID).Order("timestamp asc").Find(&readings)
+	if len(readings) != 2 || readings[0].DeviceID != "probe-1" || !readings[0].IsAbnormal || readings[1].IsAbnormal {
+		t.Fatalf("readings = %+v, want probe-1's dry reading flagged", readings)
+	}
+	if readings[0].Extra != `{"ph":6.5}` || readings[1].Extra != "" {
+		t.Fatalf("extra = %q and %q, want the pH only where it was given", readings[0].Extra, readings[1].Extra)
+	}
+	if err := config.DB.Where("user_id = ? AND device_id = ?", user.ID, "probe-1").First(&models.Device{}).Error; err != nil {
+		t.Fatalf("imported device was not registered: %v", err)
+	}
+}
+
+func TestImportRejectsUnusableHeaders(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	for _, header := range []string{
+		"",
+		"timestamp,temperature,humidity\n",
+		"timestamp,temperature,humidity,soil_moisture,Humidity\n",
+	} {
+		if _, err := utils.ImportSensorCSV(config.DB, strings.NewReader(header), utils.ImportOptions{UserID: user.ID}); !errors.Is(err, utils.ErrImportFile) {
+			t.Errorf("header %q: err = %v, want ErrImportFile", header, err)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateActuator registers a valve or pump on one of the caller's devices.
+func CreateActuator(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var actuator models.Actuator
+	if err := c.ShouldBindJSON(&actuator); err != nil || actuator.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid actuator data"})
+		return
+	}
+	if actuator.Kind == "" {
+		actuator.Kind = models.ActuatorValve
+	}
+	if actuator.Kind != models.ActuatorValve && actuator.Kind != models.ActuatorPump {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Actuator kind must be valve or pump"})
+		return
+	}
+	if actuator.DeviceID == "" {
+		actuator.DeviceID = models.DefaultDeviceID
+	}
+	if actuator.MaxRunSeconds <= 0 || actuator.MaxRunSeconds > int(utils.MaxIrrigationRunTime.Seconds()) {
+		actuator.MaxRunSeconds = int(utils.MaxIrrigationRunTime.Seconds())
+	}
+	actuator.ID = 0
+	actuator.UserID = userID
+
+	if err := config.DB.Create(&actuator).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create actuator"})
+		return
+	}
+	c.JSON(http.StatusCreated, actuator)
+}
+
+// GetActuators lists the caller's actuators.
+func GetActuators(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var actuators []models.Actuator
+	if err := config.DB.Where("user_id = ?", userID).Order("id asc").Find(&actuators).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch actuators"})
+		return
+	}
+	c.JSON(http.StatusOK, actuators)
+}
+
+// findOwnedActuator loads an actuator by the :id path parameter if it belongs to userID.
+func findOwnedActuator(c *gin.Context, userID uint) (models.Actuator, bool) {
+	var actuator models.Actuator
+	if err := config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&actuator).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Actuator not found"})
+		return actuator, false
+	}
+	return actuator, true
+}
+
+// StartIrrigation queues a manual start command for an actuator.
+func StartIrrigation(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	actuator, ok := findOwnedActuator(c, userID)
+	if !ok {
+		return
+	}
+
+	var req models.IrrigationStartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "duration_seconds is required"})
+		return
+	}
+
+	command, err := utils.QueueIrrigationCommand(config.DB, actuator, models.IrrigationStart, req.DurationSeconds, "manual", userID)
+	if err != nil {
+		respondIrrigationError(c, err)
+		return
+	}
+
+	NotifyIrrigationCommand(command)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Irrigation start queued", "command": command})
+}
+
+// StopIrrigation queues a manual stop command for an actuator.
+func StopIrrigation(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	actuator, ok := findOwnedActuator(c, userID)
+	if !ok {
+		return
+	}
+
+	command, err := utils.QueueIrrigationCommand(config.DB, actuator, models.IrrigationStop, 0, "manual", userID)
+	if err != nil {
+		respondIrrigationError(c, err)
+		return
+	}
+
+	NotifyIrrigationCommand(command)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Irrigation stop queued", "command": command})
+}
+
+func respondIrrigationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, utils.ErrActuatorBusy):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, utils.ErrInvalidDuration), errors.Is(err, utils.ErrInvalidTransition):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue irrigation command"})
+	}
+}
+
+// PollIrrigationCommands is polled by the ESP32 for commands queued for it.
+// Returned commands are marked as sent and must be acknowledged.
+func PollIrrigationCommands(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	deviceID := c.DefaultQuery("device_id", models.DefaultDeviceID)
+	if credentialID, ok := credentialDevice(c); ok {
+		deviceID = credentialID
+	}
+	commands, err := utils.FetchPendingCommands(config.DB, userID, deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch commands"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"commands": commands})
+}
+
+// AcknowledgeIrrigationCommand records the device's report on a command.
+func AcknowledgeIrrigationCommand(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.IrrigationAckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status is required"})
+		return
+	}
+
+	// A device key may only acknowledge its own device's commands
+	query := config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID)
+	if deviceID, ok := credentialDevice(c); ok {
+		query = query.Where("device_id = ?", deviceID)
+	}
+	var command models.IrrigationCommand
+	if err := query.First(&command).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Command not found"})
+		return
+	}
+
+	if err := utils.AcknowledgeCommand(config.DB, &command, req.Status, req.Error); err != nil {
+		respondIrrigationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Command updated", "command": command})
+}
+
+// GetIrrigationCommands lists the caller's commands, newest first.
+func GetIrrigationCommands(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	query := config.DB.Where("user_id = ?", userID)
+	if deviceID := c.Query("device_id"); deviceID != "" {
+		query = query.Where("device_id = ?", deviceID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var commands []models.IrrigationCommand
+	if err := query.Order("created_at desc").Limit(500).Find(&commands).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch commands"})
+		return
+	}
+	c.JSON(http.StatusOK, commands)
+}
+
+// GetWateringHistory lists the caller's watering events, optionally filtered
+// by device_id and a from/to range (RFC 3339).
+func GetWateringHistory(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	query := config.DB.Where("user_id = ?", userID)
+	if deviceID := c.Query("device_id"); deviceID != "" {
+		query = query.Where("device_id = ?", deviceID)
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from time"})
+			return
+		}
+		query = query.Where("started_at >= ?", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to time"})
+			return
+		}
+		query = query.Where("started_at < ?", t)
+	}
+
+	var events []models.WateringEvent
+	if err := query.Order("started_at desc").Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch watering history"})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// StartIrrigationMonitor periodically times out unacknowledged commands and
+// closes overrunning watering events. It should be started once on startup.
+func StartIrrigationMonitor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			expired, err := utils.ExpireIrrigationCommands(config.DB)
+			if err != nil {
+				fmt.Println("❌ Irrigation monitor failed:", err)
+				continue
+			}
+			if expired > 0 {
+				fmt.Printf("⏱️ Timed out %d irrigation commands\n", expired)
+			}
+		}
+	}()
+}
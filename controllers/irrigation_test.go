@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testActuator saves a valve for a user.
+func testActuator(t *testing.T, user models.User) models.Actuator {
+	t.Helper()
+	actuator := models.Actuator{UserID: user.ID, DeviceID: models.DefaultDeviceID, Name: "Valve", MaxRunSeconds: 600}
+	if err := config.DB.Create(&actuator).Error; err != nil {
+		t.Fatal(err)
+	}
+	return actuator
+}
+
+func TestConcurrentStartsQueueOneCommand(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	actuator := testActuator(t, user)
+
+	// A manual start, a rule and a schedule racing for the same valve
+	errs := make([]error, 6)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = utils.QueueIrrigationCommand(config.DB, actuator, models.IrrigationStart, 60, "manual", user.ID)
+		}(i)
+	}
+	wg.Wait()
+
+	queued := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			queued++
+		case !errors.Is(err, utils.ErrActuatorBusy):
+			t.Fatalf("QueueIrrigationCommand: %v", err)
+		}
+	}
+	var commands int64
+	config.DB.Model(&models.IrrigationCommand{}).Where("actuator_id = ?", actuator.ID).Count(&commands)
+	if queued != 1 || commands != 1 {
+		t.Fatalf("%d starts succeeded and %d commands stored, want one of each", queued, commands)
+	}
+}
+
+func TestIrrigationCommandLifecycle(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	actuator := testActuator(t, user)
+	id := gin.Param{Key: "id", Value: strconv.Itoa(int(actuator.ID))}
+
+	expectStatus(t, serve(StartIrrigation, http.MethodPost, "/irrigation/actuators/1/start", gin.H{"duration_seconds": 601}, &user, id), http.StatusBadRequest)
+	expectStatus(t, serve(StartIrrigation, http.MethodPost, "/irrigation/actuators/1/start", gin.H{"duration_seconds": 300}, &user, id), http.StatusAccepted)
+	expectStatus(t, serve(StartIrrigation, http.MethodPost, "/irrigation/actuators/1/start", gin.H{"duration_seconds": 300}, &user, id), http.StatusConflict)
+
+	commands, err := utils.FetchPendingCommands(config.DB, user.ID, actuator.DeviceID)
+	if err != nil || len(commands) != 1 || commands[0].Status != models.CommandSent {
+		t.Fatalf("FetchPendingCommands = %+v, %v; want the start command, sent", commands, err)
+	}
+	command := commands[0]
+	if err := utils.AcknowledgeCommand(config.DB, &command, models.CommandAcknowledged, ""); err != nil {
+		t.Fatal(err)
+	}
+	var event models.WateringEvent
+	if err := config.DB.Where("command_id = ?", command.ID).First(&event).Error; err != nil || event.Status != models.WateringRunning {
+		t.Fatalf("watering event = %+v, %v; want one running", event, err)
+	}
+	// Still busy while the valve is open
+	expectStatus(t, serve(StartIrrigation, http.MethodPost, "/irrigation/actuators/1/start", gin.H{"duration_seconds": 300}, &user, id), http.StatusConflict)
+
+	if err := utils.AcknowledgeCommand(config.DB, &command, models.CommandCompleted, ""); err != nil {
+		t.Fatal(err)
+	}
+	config.DB.First(&event, event.ID)
+	if event.Status != models.WateringCompleted || event.EndedAt == nil {
+		t.Fatalf("watering event = %+v, want completed", event)
+	}
+	if err := utils.AcknowledgeCommand(config.DB, &command, models.CommandAcknowledged, ""); !errors.Is(err, utils.ErrInvalidTransition) {
+		t.Fatalf("acknowledging a completed command = %v, want ErrInvalidTransition", err)
+	}
+	expectStatus(t, serve(StartIrrigation, http.MethodPost, "/irrigation/actuators/1/start", gin.H{"duration_seconds": 300}, &user, id), http.StatusAccepted)
+}
+
+func TestStopCancelsAnUnfetchedStart(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	actuator := testActuator(t, user)
+
+	start, err := utils.QueueIrrigationCommand(config.DB, actuator, models.IrrigationStart, 60, "manual", user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utils.QueueIrrigationCommand(config.DB, actuator, models.IrrigationStop, 0, "manual", user.ID); err != nil {
+		t.Fatal(err)
+	}
+	config.DB.First(&start, start.ID)
+	if start.Status != models.CommandCancelled {
+		t.Fatalf("start command is %s after a stop, want cancelled", start.Status)
+	}
+	commands, _ := utils.FetchPendingCommands(config.DB, user.ID, actuator.DeviceID)
+	if len(commands) != 1 || commands[0].Action != models.IrrigationStop {
+		t.Fatalf("device fetched %+v, want only the stop", commands)
+	}
+}
+
+func TestExpireIrrigationCommands(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	actuator := testActuator(t, user)
+	config.DB.Model(&actuator).Update("flow_rate_lpm", 12)
+	now := time.Now()
+	sentAt := now.Add(-5 * time.Minute)
+
+	unfetched := models.IrrigationCommand{UserID: user.ID, DeviceID: actuator.DeviceID, ActuatorID: actuator.ID, Action: models.IrrigationStart,
+		Status: models.CommandPending, CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute)}
+	unacknowledged := models.IrrigationCommand{UserID: user.ID, DeviceID: actuator.DeviceID, ActuatorID: actuator.ID, Action: models.IrrigationStop,
+		Status: models.CommandSent, CreatedAt: sentAt, SentAt: &sentAt, ExpiresAt: now.Add(time.Minute)}
+	waiting := models.IrrigationCommand{UserID: user.ID, DeviceID: actuator.DeviceID, ActuatorID: actuator.ID, Action: models.IrrigationStop,
+		Status: models.CommandPending, CreatedAt: now, ExpiresAt: now.Add(time.Minute)}
+	overran := models.WateringEvent{UserID: user.ID, DeviceID: actuator.DeviceID, ActuatorID: actuator.ID, Status: models.WateringRunning,
+		StartedAt: now.Add(-time.Hour), PlannedSeconds: 300}
+	for _, row := range []interface{}{&unfetched, &unacknowledged, &waiting, &overran} {
+		if err := config.DB.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if expired, err := utils.ExpireIrrigationCommands(config.DB); err != nil || expired != 2 {
+		t.Fatalf("ExpireIrrigationCommands = %d, %v; want 2 timed out", expired, err)
+	}
+	for _, command := range []*models.IrrigationCommand{&unfetched, &unacknowledged, &waiting} {
+		config.DB.First(command, command.ID)
+	}
+	if unfetched.Status != models.CommandTimedOut || unacknowledged.Status != models.CommandTimedOut || waiting.Status != models.CommandPending {
+		t.Fatalf("statuses = %s, %s, %s; want timed_out, timed_out, pending", unfetched.Status, unacknowledged.Status, waiting.Status)
+	}
+	config.DB.First(&overran, overran.ID)
+	if overran.Status != models.WateringCompleted || overran.DurationSeconds != 300 || overran.VolumeLiters != 60 {
+		t.Fatalf("overrun event = %+v, want completed after its planned 300 s and 60 L", overran)
+	}
+}
@@ -10,5 +10,77 @@ import (
 // MigrateModels runs the database migrations
 func MigrateModels(db *gorm.DB) {
 	config.DB = db
-	db.AutoMigrate(&models.User{}, &models.SensorData{}, &models.DeveloperModeSetting{})
+	migrateDeviceLocations(db)
+	migrateEmailVerification(db)
+	db.AutoMigrate(&models.User{}, &models.SensorData{}, &models.DeveloperModeSetting{},
+		&models.AIConfig{}, &models.AIConfigAudit{}, &models.TrainingRun{},
+		&models.Crop{}, &models.CropVariety{}, &models.GrowthStage{}, &models.Planting{},
+		&models.Actuator{}, &models.IrrigationCommand{}, &models.WateringEvent{},
+		&models.IrrigationRule{}, &models.IrrigationDecision{},
+		&models.IrrigationSchedule{}, &models.IrrigationScheduleRun{},
+		&models.WeatherRecord{}, &models.SoilProfile{}, &models.FieldSoilProfile{}, &models.WaterBalanceDay{},
+		&models.Farm{}, &models.Field{}, &models.Zone{}, &models.Device{}, &models.FarmMember{},
+		&models.Organisation{}, &models.OrgMember{}, &models.OrgInvitation{},
+		&models.DeviceCredential{}, &models.RefreshToken{}, &models.RevokedToken{},
+		&models.UserToken{}, &models.LoginAttempt{}, &models.AccountLockout{},
+		&models.TwoFactor{}, &models.RecoveryCode{},
+		&models.OIDCProvider{}, &models.OIDCGroupMapping{}, &models.ExternalIdentity{},
+		&models.OIDCLoginState{}, &models.OIDCLoginCode{},
+		&models.AuditLog{}, &models.ReadingRevision{},
+		&models.ReportTemplate{}, &models.Report{})
+	protectAuditLog(db)
+}
+
+// migrateDeviceLocations moves the user ID that device_locations.device_id
+// used to hold into its own column, and points existing rows at the default
+// device, before AutoMigrate turns device_id into a text column.
+func migrateDeviceLocations(db *gorm.DB) {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&models.DeviceLocation{}) || migrator.HasColumn(&models.DeviceLocation{}, "UserID") {
+		return
+	}
+	db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE device_locations ADD COLUMN user_id bigint").Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("UPDATE device_locations SET user_id = device_id").Error; err != nil {
+			return err
+		}
+		return tx.Exec("ALTER TABLE device_locations ALTER COLUMN device_id TYPE text USING '" + models.DefaultDeviceID + "'").Error
+	})
+}
+
+// migrateEmailVerification adds users.email_verified and marks the accounts
+// that existed before verification was required as verified, so they can
+// still log in.
+func migrateEmailVerification(db *gorm.DB) {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&models.User{}) || migrator.HasColumn(&models.User{}, "EmailVerified") {
+		return
+	}
+	db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE users ADD COLUMN email_verified boolean DEFAULT false").Error; err != nil {
+			return err
+		}
+		return tx.Exec("UPDATE users SET email_verified = true").Error
+	})
+}
+
+// protectAuditLog makes audit_logs append-only: the database rejects any
+// update, delete or truncate, whatever the application does.
+func protectAuditLog(db *gorm.DB) {
+	db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`CREATE OR REPLACE FUNCTION audit_logs_append_only() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION 'audit_logs is append-only';
+			END;
+			$$ LANGUAGE plpgsql`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DROP TRIGGER IF EXISTS audit_logs_append_only ON audit_logs").Error; err != nil {
+			return err
+		}
+		return tx.Exec(`CREATE TRIGGER audit_logs_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_logs
+			FOR EACH STATEMENT EXECUTE PROCEDURE audit_logs_append_only()`).Error
+	})
 }
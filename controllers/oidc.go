@@ -0,0 +1,521 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStateTTL     = 10 * time.Minute
+	oidcLoginCodeTTL = time.Minute
+)
+
+var (
+	oidcSlugPattern     = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+	usernameUnsafeChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+	errOIDCNoRole          = errors.New("no organisation role for these groups")
+	errOIDCLinkedElsewhere = errors.New("identity is linked to another user")
+)
+
+// oidcRedirectURI is the callback registered with the provider, under
+// OIDC_REDIRECT_BASE, the backend's public URL.
+func oidcRedirectURI(provider models.OIDCProvider) string {
+	base := os.Getenv("OIDC_REDIRECT_BASE")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return strings.TrimSuffix(base, "/") + "/oidc/" + provider.Slug + "/callback"
+}
+
+// enabledProvider loads an enabled provider by the slug in the URL.
+func enabledProvider(c *gin.Context) (models.OIDCProvider, bool) {
+	var provider models.OIDCProvider
+	if err := config.DB.Preload("GroupMappings").Where("slug = ? AND enabled = ?", c.Param("slug"), true).
+		First(&provider).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity provider not found"})
+		return provider, false
+	}
+	return provider, true
+}
+
+// StartOIDCLogin sends the user to the identity provider with a PKCE
+// challenge. return_to is the frontend path to come back to.
+func StartOIDCLogin(c *gin.Context) {
+	provider, ok := enabledProvider(c)
+	if !ok {
+		return
+	}
+	authURL, ok := beginOIDCLogin(c, provider, c.DefaultQuery("return_to", "/"), nil)
+	if !ok {
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// LinkOIDCIdentity starts an OIDC login that links the identity to the
+// caller's account, which they prove with their password. It returns the
+// URL to send the browser to rather than redirecting, since the request
+// carries the caller's bearer token.
+func LinkOIDCIdentity(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	provider, ok := enabledProvider(c)
+	if !ok {
+		return
+	}
+	var req models.OIDCLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password is required"})
+		return
+	}
+	if !checkCallerSecret(c, user, models.LoginFailedBadPassword, passwordCheck(user, req.Password)) {
+		return
+	}
+	if req.ReturnTo == "" {
+		req.ReturnTo = "/"
+	}
+	authURL, ok := beginOIDCLogin(c, provider, req.ReturnTo, &user.ID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+}
+
+// beginOIDCLogin records the state of a new authorization request and
+// returns the provider URL that starts it. It writes the error response and
+// returns false on failure.
+func beginOIDCLogin(c *gin.Context, provider models.OIDCProvider, returnTo string, linkUserID *uint) (string, bool) {
+	// Only paths on our own frontend, never another site
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.Contains(returnTo, "\\") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "return_to must be a path"})
+		return "", false
+	}
+
+	state, stateHash, err := utils.NewInvitationToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return "", false
+	}
+	nonce, _, err := utils.NewInvitationToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return "", false
+	}
+	verifier, challenge, err := utils.NewPKCE()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return "", false
+	}
+
+	authURL, err := utils.OIDCAuthorizationURL(provider, oidcRedirectURI(provider), state, nonce, challenge)
+	if err != nil {
+		fmt.Println("❌ OIDC discovery failed:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return "", false
+	}
+	if err := config.DB.Create(&models.OIDCLoginState{
+		StateHash:    stateHash,
+		ProviderID:   provider.ID,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ReturnTo:     returnTo,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return "", false
+	}
+	return authURL, true
+}
+
+// OIDCCallback completes the authorization code flow: it redeems the code,
+// verifies the ID token, links or provisions the user and sends them back to
+// the frontend with a single-use login code.
+func OIDCCallback(c *gin.Context) {
+	provider, ok := enabledProvider(c)
+	if !ok {
+		return
+	}
+	if idpError := c.Query("error"); idpError != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Identity provider refused the login", "reason": idpError})
+		return
+	}
+
+	var state models.OIDCLoginState
+	if err := config.DB.Where("state_hash = ? AND provider_id = ?", utils.HashToken(c.Query("state")), provider.ID).
+		First(&state).Error; err != nil || state.UsedAt != nil || time.Now().After(state.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login state, please try again"})
+		return
+	}
+	result := config.DB.Model(&models.OIDCLoginState{}).Where("id = ? AND used_at IS NULL", state.ID).Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login state, please try again"})
+		return
+	}
+
+	rawToken, err := utils.ExchangeOIDCCode(provider, c.Query("code"), oidcRedirectURI(provider), state.CodeVerifier)
+	if err != nil {
+		fmt.Println("❌ OIDC code exchange failed:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to complete login with the identity provider"})
+		return
+	}
+	claims, err := utils.VerifyIDToken(provider, rawToken, state.Nonce)
+	if err != nil {
+		fmt.Println("❌ OIDC ID token rejected:", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Identity provider returned an invalid ID token"})
+		return
+	}
+
+	user, err := resolveOIDCUser(provider, claims, state.LinkUserID)
+	if errors.Is(err, errOIDCNoRole) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your groups do not grant access to this organisation"})
+		return
+	}
+	if errors.Is(err, errOIDCLinkedElsewhere) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This identity is already linked to another account"})
+		return
+	}
+	if err != nil {
+		fmt.Println("❌ OIDC provisioning failed:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+
+	code, codeHash, err := utils.NewInvitationToken()
+	if err == nil {
+		err = config.DB.Create(&models.OIDCLoginCode{CodeHash: codeHash, UserID: user.ID, ExpiresAt: time.Now().Add(oidcLoginCodeTTL)}).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+	separator := "?"
+	if strings.Contains(state.ReturnTo, "?") {
+		separator = "&"
+	}
+	c.Redirect(http.StatusFound, utils.AppURL()+state.ReturnTo+separator+"login_code="+url.QueryEscape(code))
+}
+
+// ExchangeOIDCLoginCode swaps the login code from OIDCCallback for tokens,
+// or for a 2FA challenge like Login when the user has 2FA enabled.
+func ExchangeOIDCLoginCode(c *gin.Context) {
+	var req models.OIDCExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "login_code is required"})
+		return
+	}
+	var code models.OIDCLoginCode
+	if err := config.DB.Where("code_hash = ?", utils.HashToken(req.LoginCode)).First(&code).Error; err != nil ||
+		code.UsedAt != nil || time.Now().After(code.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login code"})
+		return
+	}
+	result := config.DB.Model(&models.OIDCLoginCode{}).Where("id = ? AND used_at IS NULL", code.ID).Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login code"})
+		return
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, code.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	// A locked account stays locked however the password was skipped
+	if until := utils.LockedUntil(config.DB, user.ID); !until.IsZero() {
+		utils.AuditLoginFailure(config.DB, &user.ID, user.Username, c.ClientIP(), models.LoginFailedLocked)
+		respondLocked(c, until)
+		return
+	}
+	if utils.TwoFactorEnabled(config.DB, user.ID) {
+		respondTwoFactorChallenge(c, user)
+		return
+	}
+	completeLogin(c, user)
+}
+
+// resolveOIDCUser finds the user an ID token belongs to: the user already
+// linked to the subject, else the signed-in user linking it, else a new
+// user. Existing accounts are never claimed by email, since an organisation
+// owner controls what its issuer vouches for; their owners link the identity
+// themselves with their password. The user joins the provider's organisation
+// with the role their groups map to, which is updated on every login.
+func resolveOIDCUser(provider models.OIDCProvider, claims utils.IDTokenClaims, linkUserID *uint) (models.User, error) {
+	var user models.User
+	role := utils.MapGroupsToRole(provider, claims.Groups)
+	if !utils.ValidOrgRole(role) {
+		return user, errOIDCNoRole
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var identity models.ExternalIdentity
+		err := tx.Where("provider_id = ? AND subject = ?", provider.ID, claims.Subject).First(&identity).Error
+		switch {
+		case err == nil:
+			if linkUserID != nil && *linkUserID != identity.UserID {
+				return errOIDCLinkedElsewhere
+			}
+			if err := tx.First(&user, identity.UserID).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&identity).Updates(map[string]interface{}{"email": claims.Email, "last_login_at": now}).Error; err != nil {
+				return err
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if linkUserID != nil {
+				if err := tx.First(&user, *linkUserID).Error; err != nil {
+					return err
+				}
+			} else if user, err = provisionOIDCUser(tx, provider, claims); err != nil {
+				return err
+			}
+			identity = models.ExternalIdentity{
+				ProviderID:  provider.ID,
+				Subject:     claims.Subject,
+				UserID:      user.ID,
+				Email:       claims.Email,
+				LastLoginAt: now,
+			}
+			if err := tx.Create(&identity).Error; err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+		return syncOIDCMembership(tx, provider.OrganisationID, user.ID, role)
+	})
+	return user, err
+}
+
+// provisionOIDCUser creates a user for a first-time OIDC login. The user has
+// no usable password until they reset one.
+func provisionOIDCUser(tx *gorm.DB, provider models.OIDCProvider, claims utils.IDTokenClaims) (models.User, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = strings.Trim(usernameUnsafeChars.ReplaceAllString(base, "-"), "-")
+	if base == "" {
+		base = provider.Slug + "-user"
+	}
+	username := base
+	for i := 2; ; i++ {
+		var count int64
+		tx.Model(&models.User{}).Where("username = ?", username).Count(&count)
+		if count == 0 {
+			break
+		}
+		username = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	email := normaliseEmail(claims.Email)
+	verified := claims.EmailVerified
+	if email != "" {
+		var count int64
+		tx.Model(&models.User{}).Where("LOWER(email) = ?", email).Count(&count)
+		if count > 0 {
+			// The address belongs to an account this login may not claim
+			email, verified = "", false
+		}
+	}
+	if email == "" {
+		// Email is required and unique, so stand in an address that cannot receive mail
+		email = provider.Slug + "+" + usernameUnsafeChars.ReplaceAllString(claims.Subject, "-") + "@oidc.invalid"
+	}
+	secret, _, err := utils.NewInvitationToken()
+	if err != nil {
+		return models.User{}, err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user := models.User{
+		Username:      username,
+		Email:         email,
+		Password:      string(hashed),
+		Role:          models.RoleUser,
+		EmailVerified: verified,
+	}
+	return user, tx.Create(&user).Error
+}
+
+// syncOIDCMembership gives a user the role their groups map to in the
+// provider's organisation. The organisation's last owner is never demoted.
+func syncOIDCMembership(tx *gorm.DB, orgID, userID uint, role string) error {
+	var member models.OrgMember
+	err := tx.Where("organisation_id = ? AND user_id = ?", orgID, userID).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return tx.Create(&models.OrgMember{OrganisationID: orgID, UserID: userID, Role: role}).Error
+	}
+	if err != nil || member.Role == role {
+		return err
+	}
+	if member.Role == models.OrgRoleOwner && countOwners(tx, orgID) <= 1 {
+		return nil
+	}
+	return tx.Model(&member).Update("role", role).Error
+}
+
+// GetOIDCProviders lists an organisation's identity providers (owners only).
+func GetOIDCProviders(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	org, _, ok := orgForMember(c, user, models.OrgRoleOwner)
+	if !ok {
+		return
+	}
+	var providers []models.OIDCProvider
+	if err := config.DB.Preload("GroupMappings").Where("organisation_id = ?", org.ID).Find(&providers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch identity providers"})
+		return
+	}
+	c.JSON(http.StatusOK, providers)
+}
+
+// CreateOIDCProvider adds an identity provider to an organisation (owners only).
+func CreateOIDCProvider(c *gin.Context) {
+	saveOIDCProvider(c, false)
+}
+
+// UpdateOIDCProvider replaces an identity provider's settings and group
+// mappings (owners only).
+func UpdateOIDCProvider(c *gin.Context) {
+	saveOIDCProvider(c, true)
+}
+
+func saveOIDCProvider(c *gin.Context, existing bool) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	org, _, ok := orgForMember(c, user, models.OrgRoleOwner)
+	if !ok {
+		return
+	}
+	var req models.OIDCProviderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "slug, issuer_url and client_id are required"})
+		return
+	}
+	if !oidcSlugPattern.MatchString(req.Slug) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "slug may only contain lowercase letters, digits and dashes"})
+		return
+	}
+	if err := utils.ValidateIssuerURL(req.IssuerURL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DefaultRole != "" && !utils.ValidOrgRole(req.DefaultRole) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "default_role must be owner, manager, viewer or empty"})
+		return
+	}
+	for _, mapping := range req.GroupMappings {
+		if mapping.Group == "" || !utils.ValidOrgRole(mapping.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Each group mapping needs a group and a role of owner, manager or viewer"})
+			return
+		}
+	}
+
+	provider := models.OIDCProvider{OrganisationID: org.ID, Enabled: true}
+	if existing {
+		if err := config.DB.Where("id = ? AND organisation_id = ?", c.Param("provider_id"), org.ID).First(&provider).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Identity provider not found"})
+			return
+		}
+	}
+	provider.Slug = req.Slug
+	provider.Name = req.Name
+	provider.IssuerURL = strings.TrimSuffix(req.IssuerURL, "/")
+	provider.ClientID = req.ClientID
+	provider.Scopes = req.Scopes
+	provider.GroupsClaim = req.GroupsClaim
+	provider.DefaultRole = req.DefaultRole
+	if req.ClientSecret != nil {
+		provider.ClientSecret = *req.ClientSecret
+	}
+	if req.Enabled != nil {
+		provider.Enabled = *req.Enabled
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("GroupMappings").Save(&provider).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("provider_id = ?", provider.ID).Delete(&models.OIDCGroupMapping{}).Error; err != nil {
+			return err
+		}
+		provider.GroupMappings = make([]models.OIDCGroupMapping, 0, len(req.GroupMappings))
+		for _, mapping := range req.GroupMappings {
+			mapping.ID = 0
+			mapping.ProviderID = provider.ID
+			if err := tx.Create(&mapping).Error; err != nil {
+				return err
+			}
+			provider.GroupMappings = append(provider.GroupMappings, mapping)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to save identity provider, the slug may be taken"})
+		return
+	}
+
+	status := http.StatusCreated
+	if existing {
+		status = http.StatusOK
+	}
+	c.JSON(status, gin.H{"provider": provider, "redirect_uri": oidcRedirectURI(provider)})
+}
+
+// DeleteOIDCProvider removes an identity provider and its linked identities
+// (owners only). Users it provisioned keep their accounts.
+func DeleteOIDCProvider(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	org, _, ok := orgForMember(c, user, models.OrgRoleOwner)
+	if !ok {
+		return
+	}
+	var provider models.OIDCProvider
+	if err := config.DB.Where("id = ? AND organisation_id = ?", c.Param("provider_id"), org.ID).First(&provider).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity provider not found"})
+		return
+	}
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.OIDCGroupMapping{}, &models.ExternalIdentity{}, &models.OIDCLoginState{}} {
+			if err := tx.Where("provider_id = ?", provider.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&provider).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete identity provider"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Identity provider deleted"})
+}
@@ -0,0 +1,352 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// oidcTestIssuer is a mock identity provider. Its token endpoint answers
+// every code authorized with a matching PKCE verifier with an ID token for
+// the claims of the test's next login, carrying the nonce from the
+// authorization URL.
+type oidcTestIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]oidcTestGrant
+}
+
+type oidcTestGrant struct {
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newOIDCTestIssuer(t *testing.T) *oidcTestIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &oidcTestIssuer{key: key, grants: make(map[string]oidcTestGrant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(utils.OIDCDiscovery{
+			Issuer:                issuer.server.URL,
+			AuthorizationEndpoint: issuer.server.URL + "/authorize",
+			TokenEndpoint:         issuer.server.URL + "/token",
+			JWKSURI:               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "key-1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		issuer.mu.Lock()
+		grant, ok := issuer.grants[r.PostForm.Get("code")]
+		delete(issuer.grants, r.PostForm.Get("code"))
+		issuer.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss": issuer.server.URL, "aud": "fyp-client", "nonce": grant.nonce,
+			"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+		}
+		for name, value := range grant.claims {
+			claims[name] = value
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "key-1"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	issuer.server = httptest.NewTLSServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	client := utils.OIDCHTTPClient
+	utils.OIDCHTTPClient = issuer.server.Client()
+	t.Cleanup(func() { utils.OIDCHTTPClient = client })
+	return issuer
+}
+
+// createProvider saves a provider for the issuer on a new organisation that
+// maps the "growers" group to viewers.
+func (i *oidcTestIssuer) createProvider(t *testing.T) models.OIDCProvider {
+	t.Helper()
+	org := models.Organisation{Name: "Test Farms"}
+	if err := config.DB.Create(&org).Error; err != nil {
+		t.Fatal(err)
+	}
+	provider := models.OIDCProvider{
+		OrganisationID: org.ID, Slug: "mock", IssuerURL: i.server.URL, ClientID: "fyp-client", Enabled: true,
+		GroupMappings: []models.OIDCGroupMapping{{Group: "growers", Role: models.OrgRoleViewer}, {Group: "leads", Role: models.OrgRoleManager}},
+	}
+	if err := config.DB.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// login follows an authorization URL through the mock provider's login page,
+// returning the callback query the provider would redirect back with.
+func (i *oidcTestIssuer) login(t *testing.T, authURL string, claims jwt.MapClaims) url.Values {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	code := "code-" + query.Get("state")[:8]
+	i.mu.Lock()
+	i.grants[code] = oidcTestGrant{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), claims: claims}
+	i.mu.Unlock()
+	return url.Values{"code": {code}, "state": {query.Get("state")}}
+}
+
+var mockSlug = gin.Param{Key: "slug", Value: "mock"}
+
+// startLogin begins an OIDC login and returns the provider's authorization URL.
+func startLogin(t *testing.T) string {
+	t.Helper()
+	recorder := serve(StartOIDCLogin, http.MethodGet, "/oidc/mock/login?return_to=/dashboard", nil, nil, mockSlug)
+	expectStatus(t, recorder, http.StatusFound)
+	return recorder.Header().Get("Location")
+}
+
+// callback completes a login at the callback and returns the login code.
+func callback(t *testing.T, query url.Values, status int) string {
+	t.Helper()
+	recorder := serve(OIDCCallback, http.MethodGet, "/oidc/mock/callback?"+query.Encode(), nil, nil, mockSlug)
+	expectStatus(t, recorder, status)
+	if status != http.StatusFound {
+		return ""
+	}
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil || location.Path != "/dashboard" {
+		t.Fatalf("callback redirected to %q, want the return_to page", recorder.Header().Get("Location"))
+	}
+	return location.Query().Get("login_code")
+}
+
+func exchange(code string) *httptest.ResponseRecorder {
+	return serve(ExchangeOIDCLoginCode, http.MethodPost, "/oidc/exchange", gin.H{"login_code": code}, nil)
+}
+
+func identityOwner(t *testing.T, provider models.OIDCProvider, subject string) uint {
+	t.Helper()
+	var identity models.ExternalIdentity
+	if err := config.DB.Where("provider_id = ? AND subject = ?", provider.ID, subject).First(&identity).Error; err != nil {
+		t.Fatalf("no identity for %s: %v", subject, err)
+	}
+	return identity.UserID
+}
+
+func TestOIDCLoginProvisionsUserAndMapsGroups(t *testing.T) {
+	testDB(t)
+	issuer := newOIDCTestIssuer(t)
+	provider := issuer.createProvider(t)
+
+	query := issuer.login(t, startLogin(t), jwt.MapClaims{
+		"sub": "alice", "email": "alice@example.com", "email_verified": true, "preferred_username": "alice",
+		"groups": []string{"growers", "leads"},
+	})
+	code := callback(t, query, http.StatusFound)
+
+	recorder := exchange(code)
+	expectStatus(t, recorder, http.StatusOK)
+	if decode(t, recorder)["access_token"] == "" {
+		t.Fatal("exchange returned no access token")
+	}
+	userID := identityOwner(t, provider, "alice")
+	var member models.OrgMember
+	if err := config.DB.Where("organisation_id = ? AND user_id = ?", provider.OrganisationID, userID).First(&member).Error; err != nil {
+		t.Fatal(err)
+	}
+	if member.Role != models.OrgRoleManager {
+		t.Fatalf("role = %q, want the highest mapped role %q", member.Role, models.OrgRoleManager)
+	}
+
+	// The login code works once
+	expectStatus(t, exchange(code), http.StatusUnauthorized)
+
+	// Groups are synced on every login
+	query = issuer.login(t, startLogin(t), jwt.MapClaims{"sub": "alice", "groups": []string{"growers"}})
+	callback(t, query, http.StatusFound)
+	config.DB.First(&member, member.ID)
+	if member.Role != models.OrgRoleViewer {
+		t.Fatalf("role after regrouping = %q, want %q", member.Role, models.OrgRoleViewer)
+	}
+
+	// Groups that map to nothing are refused
+	query = issuer.login(t, startLogin(t), jwt.MapClaims{"sub": "bob", "groups": []string{"contractors"}})
+	callback(t, query, http.StatusForbidden)
+}
+
+func TestOIDCCallbackRejectsReplayedState(t *testing.T) {
+	testDB(t)
+	issuer := newOIDCTestIssuer(t)
+	issuer.createProvider(t)
+
+	authURL := startLogin(t)
+	query := issuer.login(t, authURL, jwt.MapClaims{"sub": "alice", "groups": []string{"growers"}})
+	callback(t, query, http.StatusFound)
+
+	// The same state cannot complete a second login, even with a fresh code
+	replay := issuer.login(t, authURL, jwt.MapClaims{"sub": "mallory", "groups": []string{"growers"}})
+	replay.Set("code", replay.Get("code")+"-2")
+	issuer.mu.Lock()
+	issuer.grants[replay.Get("code")] = issuer.grants[query.Get("code")]
+	issuer.mu.Unlock()
+	callback(t, replay, http.StatusBadRequest)
+
+	// Nor can a state that was never issued
+	callback(t, url.Values{"code": {"anything"}, "state": {"forged"}}, http.StatusBadRequest)
+}
+
+func TestOIDCCallbackRejectsTokenForAnotherLogin(t *testing.T) {
+	testDB(t)
+	issuer := newOIDCTestIssuer(t)
+	issuer.createProvider(t)
+
+	// An ID token minted for one login's nonce is refused at another's callback
+	first := issuer.login(t, startLogin(t), jwt.MapClaims{"sub": "alice", "groups": []string{"growers"}})
+	second := issuer.login(t, startLogin(t), jwt.MapClaims{"sub": "alice", "groups": []string{"growers"}})
+	issuer.mu.Lock()
+	grant := issuer.grants[second.Get("code")]
+	grant.nonce = issuer.grants[first.Get("code")].nonce
+	issuer.grants[second.Get("code")] = grant
+	issuer.mu.Unlock()
+	callback(t, second, http.StatusUnauthorized)
+
+	// A code redeemed without the login's PKCE verifier is refused
+	third := issuer.login(t, startLogin(t), jwt.MapClaims{"sub": "alice", "groups": []string{"growers"}})
+	issuer.mu.Lock()
+	grant = issuer.grants[third.Get("code")]
+	grant.challenge = "not-the-challenge"
+	issuer.grants[third.Get("code")] = grant
+	issuer.mu.Unlock()
+	callback(t, third, http.StatusBadGateway)
+}
+
+func TestOIDCLoginNeverClaimsAccountByEmail(t *testing.T) {
+	testDB(t)
+	issuer := newOIDCTestIssuer(t)
+	provider := issuer.createProvider(t)
+	outsider := createTestUser(t, "outsider", "outsider@example.com", "Outsider-password-1")
+	member := createTestUser(t, "member", "member@example.com", "Member-password-1")
+	config.DB.Model(&member).Update("role", models.RoleAdmin)
+	config.DB.Create(&models.OrgMember{OrganisationID: provider.OrganisationID, UserID: member.ID, Role: models.OrgRoleViewer})
+
+	// Whatever the organisation's issuer vouches for, the address does not
+	// sign in to the account holding it, in the organisation or not
+	for _, victim := range []models.User{outsider, member} {
+		subject := "fake-" + victim.Username
+		query := issuer.login(t, startLogin(t), jwt.MapClaims{
+			"sub": subject, "email": victim.Email, "email_verified": true, "groups": []string{"growers"},
+		})
+		recorder := exchange(callback(t, query, http.StatusFound))
+		expectStatus(t, recorder, http.StatusOK)
+
+		owner := identityOwner(t, provider, subject)
+		if owner == victim.ID {
+			t.Fatalf("identity was linked to %s's account by email", victim.Username)
+		}
+		claims, err := utils.ParseAccessToken(decode(t, recorder)["access_token"].(string))
+		if err != nil || claims.UserID != owner {
+			t.Fatalf("login signed in as user %d (%v), want the provisioned user %d", claims.UserID, err, owner)
+		}
+		var provisioned models.User
+		config.DB.First(&provisioned, owner)
+		if provisioned.EmailVerified || strings.EqualFold(provisioned.Email, victim.Email) || provisioned.Role != models.RoleUser {
+			t.Fatalf("provisioned user took %s's address or role: %+v", victim.Username, provisioned)
+		}
+	}
+}
+
+func TestLinkOIDCIdentityRequiresPassword(t *testing.T) {
+	testDB(t)
+	issuer := newOIDCTestIssuer(t)
+	provider := issuer.createProvider(t)
+	user := createTestUser(t, "linker", "linker@example.com", "Linker-password-1")
+	other := createTestUser(t, "other", "other@example.com", "Other-password-1")
+
+	recorder := serve(LinkOIDCIdentity, http.MethodPost, "/oidc/mock/link", gin.H{"password": "wrong"}, &user, mockSlug)
+	expectStatus(t, recorder, http.StatusUnauthorized)
+
+	recorder = serve(LinkOIDCIdentity, http.MethodPost, "/oidc/mock/link", gin.H{"password": "Linker-password-1", "return_to": "/dashboard"}, &user, mockSlug)
+	expectStatus(t, recorder, http.StatusOK)
+	authURL, _ := decode(t, recorder)["authorization_url"].(string)
+	query := issuer.login(t, authURL, jwt.MapClaims{
+		"sub": "linked-sub", "email": "someone-else@example.com", "groups": []string{"growers"},
+	})
+	callback(t, query, http.StatusFound)
+	if identityOwner(t, provider, "linked-sub") != user.ID {
+		t.Fatal("identity was not linked to the caller")
+	}
+
+	// An identity linked to one account cannot be linked to another
+	recorder = serve(LinkOIDCIdentity, http.MethodPost, "/oidc/mock/link", gin.H{"password": "Other-password-1", "return_to": "/dashboard"}, &other, mockSlug)
+	expectStatus(t, recorder, http.StatusOK)
+	authURL, _ = decode(t, recorder)["authorization_url"].(string)
+	query = issuer.login(t, authURL, jwt.MapClaims{"sub": "linked-sub", "groups": []string{"growers"}})
+	callback(t, query, http.StatusConflict)
+}
+
+func TestOIDCExchangeRequiresSecondFactor(t *testing.T) {
+	testDB(t)
+	issuer := newOIDCTestIssuer(t)
+	provider := issuer.createProvider(t)
+
+	query := issuer.login(t, startLogin(t), jwt.MapClaims{"sub": "carol", "groups": []string{"growers"}})
+	expectStatus(t, exchange(callback(t, query, http.StatusFound)), http.StatusOK)
+	var user models.User
+	config.DB.First(&user, identityOwner(t, provider, "carol"))
+	recoveryCodes := enableTwoFactor(t, user)
+
+	query = issuer.login(t, startLogin(t), jwt.MapClaims{"sub": "carol", "groups": []string{"growers"}})
+	recorder := exchange(callback(t, query, http.StatusFound))
+	expectStatus(t, recorder, http.StatusOK)
+	body := decode(t, recorder)
+	if body["two_factor_required"] != true || body["access_token"] != nil {
+		t.Fatalf("exchange with 2FA enabled = %v, want a challenge", body)
+	}
+	recorder = serve(LoginTwoFactor, http.MethodPost, "/login/2fa", gin.H{
+		"challenge_token": body["challenge_token"], "recovery_code": recoveryCodes[0],
+	}, nil)
+	expectStatus(t, recorder, http.StatusOK)
+
+	// A locked account cannot sign in through its provider either
+	for i := 0; i < utils.LockoutThreshold; i++ {
+		utils.RecordLoginFailure(config.DB, user.ID)
+	}
+	query = issuer.login(t, startLogin(t), jwt.MapClaims{"sub": "carol", "groups": []string{"growers"}})
+	expectStatus(t, exchange(callback(t, query, http.StatusFound)), http.StatusLocked)
+}
@@ -0,0 +1,423 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// invitationTTL is how long an invitation token can be accepted.
+const invitationTTL = 7 * 24 * time.Hour
+
+// dataOwnerScope limits sensor data to the owners the caller asked for and
+// may read: ?org_id= for every owner of an organisation, ?user_id= for one
+// user, otherwise the caller's own data (everyone's for admins). ok is false
+// when an error response has been written.
+func dataOwnerScope(c *gin.Context, user models.User) (func(*gorm.DB) *gorm.DB, bool) {
+	if raw := c.Query("org_id"); raw != "" {
+		orgID, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organisation ID"})
+			return nil, false
+		}
+		if !utils.CanManageAny(user, models.ResourceSensorData) && utils.OrgRole(config.DB, uint(orgID), user.ID) == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return nil, false
+		}
+		owners, err := utils.OrgDataOwners(config.DB, uint(orgID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve organisation"})
+			return nil, false
+		}
+		return func(db *gorm.DB) *gorm.DB { return db.Where("user_id IN ?", append(owners, 0)) }, true
+	}
+
+	if raw := c.Query("user_id"); raw != "" {
+		ownerID, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return nil, false
+		}
+		if !utils.CanAccessUserData(config.DB, user, uint(ownerID), utils.AccessRead) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return nil, false
+		}
+		return func(db *gorm.DB) *gorm.DB { return db.Where("user_id = ?", ownerID) }, true
+	}
+
+	if utils.CanManageAny(user, models.ResourceSensorData) {
+		return func(db *gorm.DB) *gorm.DB { return db }, true
+	}
+	return func(db *gorm.DB) *gorm.DB { return db.Where("user_id = ?", user.ID) }, true
+}
+
+// orgForMember loads an organisation the caller belongs to with at least
+// role min, writing the error response on failure. Admins may act as owners.
+func orgForMember(c *gin.Context, user models.User, min string) (models.Organisation, string, bool) {
+	var org models.Organisation
+	if err := config.DB.First(&org, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organisation not found"})
+		return org, "", false
+	}
+	role := utils.OrgRole(config.DB, org.ID, user.ID)
+	if utils.CanManageAny(user, models.ResourceOrganisations) {
+		role = models.OrgRoleOwner
+	}
+	if role == "" || !utils.OrgRoleAtLeast(role, min) {
+		var member models.OrgMember
+		if org.RequireTwoFactor && config.DB.Where("organisation_id = ? AND user_id = ?", org.ID, user.ID).First(&member).Error == nil &&
+			utils.OrgRoleAtLeast(member.Role, min) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This organisation requires two-factor authentication for your role"})
+			return org, role, false
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return org, role, false
+	}
+	return org, role, true
+}
+
+// CreateOrganisation creates an organisation with the caller as its owner.
+func CreateOrganisation(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreateOrganisationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organisation data"})
+		return
+	}
+
+	org := models.Organisation{Name: req.Name, CreatedBy: user.ID}
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.OrgMember{OrganisationID: org.ID, UserID: user.ID, Role: models.OrgRoleOwner}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create organisation"})
+		return
+	}
+	c.JSON(http.StatusCreated, org)
+}
+
+// GetOrganisations lists the organisations the caller belongs to with their role.
+func GetOrganisations(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var orgs []struct {
+		models.Organisation
+		Role string `json:"role"`
+	}
+	if err := config.DB.Model(&models.Organisation{}).
+		Select("organisations.*, org_members.role").
+		Joins("JOIN org_members ON org_members.organisation_id = organisations.id").
+		Where("org_members.user_id = ?", user.ID).
+		Order("organisations.name asc").Scan(&orgs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch organisations"})
+		return
+	}
+	c.JSON(http.StatusOK, orgs)
+}
+
+// GetOrganisation returns an organisation and its members (members only).
+func GetOrganisation(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	org, role, ok := orgForMember(c, user, models.OrgRoleViewer)
+	if !ok {
+		return
+	}
+
+	var members []struct {
+		models.OrgMember
+		Username string `json:"username"`
+		Email    string `json:"email"`
+	}
+	if err := config.DB.Model(&models.OrgMember{}).
+		Select("org_members.*, users.username, users.email").
+		Joins("JOIN users ON users.id = org_members.user_id AND users.deleted_at IS NULL").
+		Where("org_members.organisation_id = ?", org.ID).
+		Order("org_members.created_at asc").Scan(&members).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch members"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"organisation": org, "role": role, "members": members})
+}
+
+// UpdateOrgSettings changes an organisation's policies (owners only). An
+// owner must have two-factor authentication to require it of others.
+func UpdateOrgSettings(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	org, _, ok := orgForMember(c, user, models.OrgRoleOwner)
+	if !ok {
+		return
+	}
+	var req models.OrgSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	if req.RequireTwoFactor != nil {
+		if *req.RequireTwoFactor && !utils.TwoFactorEnabled(config.DB, user.ID) && !utils.CanManageAny(user, models.ResourceOrganisations) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Enable two-factor authentication on your own account first"})
+			return
+		}
+		org.RequireTwoFactor = *req.RequireTwoFactor
+	}
+	if err := config.DB.Model(&org).Update("require_two_factor", org.RequireTwoFactor).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update organisation"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Organisation updated", "organisation": org})
+}
+
+// InviteMember invites an email address to the organisation (managers and
+// owners). Only owners can invite owners. The token needed to accept is
+// mailed to the address, never returned to the inviter.
+func InviteMember(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	org, role, ok := orgForMember(c, user, models.OrgRoleManager)
+	if !ok {
+		return
+	}
+
+	var req models.InviteMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !utils.ValidOrgRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An email and a role of owner, manager or viewer are required"})
+		return
+	}
+	if req.Role == models.OrgRoleOwner && role != models.OrgRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can invite owners"})
+		return
+	}
+
+	token, hash, err := utils.NewInvitationToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+	invitation := models.OrgInvitation{
+		OrganisationID: org.ID,
+		Email:          strings.ToLower(strings.TrimSpace(req.Email)),
+		Role:           req.Role,
+		TokenHash:      hash,
+		InvitedBy:      user.ID,
+		ExpiresAt:      time.Now().Add(invitationTTL),
+	}
+	if err := config.DB.Create(&invitation).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	if err := utils.SendInvitationEmail(org, user, invitation, token); err != nil {
+		fmt.Println("❌ Failed to send invitation:", err)
+		config.DB.Delete(&invitation)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send invitation"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"invitation": invitation})
+}
+
+// GetInvitations lists an organisation's open invitations (managers and owners).
+func GetInvitations(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	org, _, ok := orgForMember(c, user, models.OrgRoleManager)
+	if !ok {
+		return
+	}
+
+	var invitations []models.OrgInvitation
+	if err := config.DB.Where("organisation_id = ? AND accepted_at IS NULL AND expires_at > ?", org.ID, time.Now()).
+		Order("created_at desc").Find(&invitations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invitations"})
+		return
+	}
+	c.JSON(http.StatusOK, invitations)
+}
+
+// RevokeInvitation deletes an open invitation (managers and owners).
+func RevokeInvitation(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	org, _, ok := orgForMember(c, user, models.OrgRoleManager)
+	if !ok {
+		return
+	}
+
+	result := config.DB.Where("id = ? AND organisation_id = ? AND accepted_at IS NULL", c.Param("invitation_id"), org.ID).
+		Delete(&models.OrgInvitation{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked successfully"})
+}
+
+// AcceptInvitation joins the caller to an organisation with an invitation
+// token sent to their email address. Existing members keep the higher of
+// their current and invited role.
+func AcceptInvitation(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	var invitation models.OrgInvitation
+	if err := config.DB.Where("token_hash = ?", utils.HashToken(req.Token)).First(&invitation).Error; err != nil ||
+		invitation.AcceptedAt != nil || time.Now().After(invitation.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invitation is invalid or has expired"})
+		return
+	}
+	if !strings.EqualFold(invitation.Email, strings.TrimSpace(user.Email)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invitation was sent to a different email address"})
+		return
+	}
+
+	var member models.OrgMember
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&models.OrgInvitation{}).Where("id = ? AND accepted_at IS NULL", invitation.ID).
+			Updates(map[string]interface{}{"accepted_at": now, "accepted_by": user.ID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("invitation already accepted")
+		}
+
+		err := tx.Where("organisation_id = ? AND user_id = ?", invitation.OrganisationID, user.ID).First(&member).Error
+		if err == gorm.ErrRecordNotFound {
+			member = models.OrgMember{OrganisationID: invitation.OrganisationID, UserID: user.ID, Role: invitation.Role}
+			return tx.Create(&member).Error
+		}
+		if err != nil {
+			return err
+		}
+		if !utils.OrgRoleAtLeast(member.Role, invitation.Role) {
+			member.Role = invitation.Role
+			return tx.Save(&member).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to accept invitation", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, member)
+}
+
+// countOwners returns how many owners an organisation has.
+func countOwners(tx *gorm.DB, orgID uint) int64 {
+	var count int64
+	tx.Model(&models.OrgMember{}).Where("organisation_id = ? AND role = ?", orgID, models.OrgRoleOwner).Count(&count)
+	return count
+}
+
+// UpdateMemberRole changes a member's role (owners only). The last owner
+// cannot be demoted.
+func UpdateMemberRole(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	org, _, ok := orgForMember(c, user, models.OrgRoleOwner)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !utils.ValidOrgRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be owner, manager or viewer"})
+		return
+	}
+
+	var member models.OrgMember
+	if err := config.DB.Where("id = ? AND organisation_id = ?", c.Param("member_id"), org.ID).First(&member).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	if member.Role == models.OrgRoleOwner && req.Role != models.OrgRoleOwner && countOwners(config.DB, org.ID) <= 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "An organisation needs at least one owner"})
+		return
+	}
+
+	member.Role = req.Role
+	if err := config.DB.Save(&member).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+		return
+	}
+	c.JSON(http.StatusOK, member)
+}
+
+// RemoveMember removes a member from an organisation. Owners can remove
+// anyone and every member can leave; the last owner cannot.
+func RemoveMember(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	org, _, ok := orgForMember(c, user, models.OrgRoleViewer)
+	if !ok {
+		return
+	}
+
+	var member models.OrgMember
+	if err := config.DB.Where("id = ? AND organisation_id = ?", c.Param("member_id"), org.ID).First(&member).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	role := utils.OrgRole(config.DB, org.ID, user.ID)
+	if member.UserID != user.ID && role != models.OrgRoleOwner && !utils.CanManageAny(user, models.ResourceOrganisations) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can remove other members"})
+		return
+	}
+	if member.Role == models.OrgRoleOwner && countOwners(config.DB, org.ID) <= 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "An organisation needs at least one owner"})
+		return
+	}
+
+	if err := config.DB.Delete(&member).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
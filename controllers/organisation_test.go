@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"fyp/config"
+	"fyp/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestInvitationTokenIsMailedToTheInvitee(t *testing.T) {
+	testDB(t)
+	mailer := captureMail(t)
+	owner := createTestUser(t, "owner", "owner@example.com", "Owner-password-1")
+	invitee := createTestUser(t, "invitee", "invitee@example.com", "Invitee-password-1")
+
+	recorder := serve(CreateOrganisation, http.MethodPost, "/orgs", gin.H{"name": "Valley Farms"}, &owner)
+	expectStatus(t, recorder, http.StatusCreated)
+	org := gin.Param{Key: "id", Value: fmt.Sprint(decode(t, recorder)["id"])}
+
+	recorder = serve(InviteMember, http.MethodPost, "/orgs/1/invitations", gin.H{"email": "Invitee@example.com", "role": models.OrgRoleViewer}, &owner, org)
+	expectStatus(t, recorder, http.StatusCreated)
+	if strings.Contains(recorder.Body.String(), "token") {
+		t.Fatalf("invitation response %s carries the token", recorder.Body.String())
+	}
+	token := lastToken(t, mailer, "invitee@example.com")
+	if token == "" || !strings.Contains(mailer.Messages()[0].Body, "/accept-invitation?token=") {
+		t.Fatalf("invitee was not mailed an accept link: %+v", mailer.Messages())
+	}
+
+	// Only the invited address can use it
+	expectStatus(t, serve(AcceptInvitation, http.MethodPost, "/invitations/accept", gin.H{"token": token}, &owner), http.StatusForbidden)
+	expectStatus(t, serve(AcceptInvitation, http.MethodPost, "/invitations/accept", gin.H{"token": token}, &invitee), http.StatusOK)
+	var member models.OrgMember
+	if err := config.DB.Where("user_id = ?", invitee.ID).First(&member).Error; err != nil || member.Role != models.OrgRoleViewer {
+		t.Fatalf("invitee membership = %+v, %v; want viewer", member, err)
+	}
+}
@@ -0,0 +1,410 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"fyp/config"
+	"fyp/middlewares"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reportEmailLimit throttles reports emailed on demand per user, as each one
+// mails every recipient of the template.
+var reportEmailLimit = utils.PerMinute(1, 5)
+
+// generateReport builds, renders and stores a template's report on the
+// readings from from to to, and emails it when email is set and the template
+// has recipients. Email failures are recorded on the report rather than
+// returned.
+func generateReport(template models.ReportTemplate, from, to time.Time, scheduledFor *time.Time, email bool) (models.Report, error) {
+	report := models.Report{
+		TemplateID:   template.ID,
+		UserID:       template.UserID,
+		ScheduledFor: scheduledFor,
+		Format:       template.Format,
+		PeriodFrom:   from,
+		PeriodTo:     to,
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, template.UserID).Error; err != nil {
+		return report, err
+	}
+	data, err := utils.BuildReport(config.DB, user, template, from, to)
+	if err != nil {
+		return report, err
+	}
+	if report.Content, err = utils.RenderReport(data, template.Format); err != nil {
+		return report, err
+	}
+	report.Size = len(report.Content)
+
+	// The unique index on (template_id, scheduled_for) keeps an occurrence from being reported twice
+	if err := config.DB.Create(&report).Error; err != nil {
+		return report, err
+	}
+
+	if email && len(utils.ReportRecipients(template)) > 0 {
+		sent, err := utils.EmailReport(template, report, data)
+		report.EmailedTo = strings.Join(sent, ", ")
+		if err != nil {
+			report.EmailError = err.Error()
+		}
+		config.DB.Model(&report).Select("emailed_to", "email_error").Updates(&report)
+	}
+	return report, nil
+}
+
+// runReportTemplate generates a template's reports that have come due by now,
+// in order. last_checked_at only moves past the occurrences that were
+// reported, so one that failed is retried on the next tick. An occurrence
+// that already has a report (e.g. from a run cut short) counts as reported.
+func runReportTemplate(template models.ReportTemplate, now time.Time) {
+	recurrence, err := utils.ReportRecurrence(template)
+	if err != nil {
+		fmt.Printf("❌ Report template %d has an invalid rrule: %v\n", template.ID, err)
+		return
+	}
+
+	since := template.CreatedAt
+	if template.LastCheckedAt != nil {
+		since = *template.LastCheckedAt
+	}
+	checked := now
+	for _, occurrence := range recurrence.Between(since, now, 0) {
+		occurrence := occurrence
+		var reported int64
+		config.DB.Model(&models.Report{}).Where("template_id = ? AND scheduled_for = ?", template.ID, occurrence).Count(&reported)
+		if reported > 0 {
+			since = occurrence
+			continue
+		}
+		from := occurrence.AddDate(0, 0, -template.PeriodDays)
+		if _, err := generateReport(template, from, occurrence, &occurrence, true); err != nil {
+			fmt.Printf("❌ Failed to generate report %d for %s: %v\n", template.ID, occurrence.Format(time.RFC3339), err)
+			checked = since
+			break
+		}
+		since = occurrence
+	}
+	config.DB.Model(&template).Update("last_checked_at", checked)
+}
+
+// StartReportScheduler generates the reports of every enabled template that
+// have come due at a fixed interval. It should be started once on startup.
+func StartReportScheduler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			var templates []models.ReportTemplate
+			if err := config.DB.Where("enabled = ?", true).Find(&templates).Error; err != nil {
+				fmt.Println("❌ Failed to load report templates:", err)
+				continue
+			}
+
+			now := time.Now()
+			for _, template := range templates {
+				runReportTemplate(template, now)
+			}
+		}
+	}()
+}
+
+// bindReportTemplate reads and validates a report template from the request
+// body, writing the error response on failure. A template is enabled unless
+// the body says otherwise.
+func bindReportTemplate(c *gin.Context, user models.User) (models.ReportTemplate, bool) {
+	template := models.ReportTemplate{Enabled: true}
+	if err := c.ShouldBindJSON(&template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid report template data"})
+		return template, false
+	}
+	if template.Format == "" {
+		template.Format = models.ReportFormatHTML
+	}
+	if template.PeriodDays == 0 {
+		template.PeriodDays = utils.DefaultReportPeriodDays
+	}
+	if template.RRule == "" {
+		template.RRule = utils.DefaultReportRRule
+	}
+	if template.StartsAt.IsZero() {
+		template.StartsAt = time.Now()
+	}
+	if err := utils.ValidateReportTemplate(template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return template, false
+	}
+
+	level, id := models.LevelFarm, template.FarmID
+	if template.FieldID != nil {
+		level, id = models.LevelField, *template.FieldID
+	}
+	farm, err := utils.FarmOfNode(config.DB, level, id)
+	if err != nil || farm.ID != template.FarmID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown farm or field"})
+		return template, false
+	}
+	if ok, err := utils.CanViewNode(config.DB, user, level, id); err != nil || !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return template, false
+	}
+	return template, true
+}
+
+// loadReportTemplate loads one of the caller's report templates by the :id
+// parameter, writing the error response on failure.
+func loadReportTemplate(c *gin.Context, userID uint) (models.ReportTemplate, bool) {
+	var template models.ReportTemplate
+	if err := config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&template).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Report template not found"})
+		return template, false
+	}
+	return template, true
+}
+
+// reportPeriod reads the from and to (RFC 3339) of a report made on demand.
+// By default it covers the template's period up to now.
+func reportPeriod(c *gin.Context, template models.ReportTemplate) (time.Time, time.Time, bool) {
+	to := time.Now()
+	if raw := c.Query("to"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be RFC 3339"})
+			return time.Time{}, time.Time{}, false
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -template.PeriodDays)
+	if raw := c.Query("from"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be RFC 3339"})
+			return time.Time{}, time.Time{}, false
+		}
+		from = parsed
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// respondReportError writes the response for a report that could not be built.
+func respondReportError(c *gin.Context, err error) {
+	if errors.Is(err, utils.ErrNoNodeAccess) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can no longer see this farm or field"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate report"})
+}
+
+// CreateReportTemplate adds a scheduled report for the caller.
+func CreateReportTemplate(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	template, ok := bindReportTemplate(c, user)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	template.ID = 0
+	template.UserID = user.ID
+	template.LastCheckedAt = &now
+
+	if err := config.DB.Create(&template).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create report template"})
+		return
+	}
+	c.JSON(http.StatusCreated, template)
+}
+
+// GetReportTemplates lists the caller's report templates.
+func GetReportTemplates(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var templates []models.ReportTemplate
+	if err := config.DB.Where("user_id = ?", userID).Order("id asc").Find(&templates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch report templates"})
+		return
+	}
+	c.JSON(http.StatusOK, templates)
+}
+
+// UpdateReportTemplate replaces the settings of one of the caller's report
+// templates. Occurrences before the update are not reported retroactively.
+func UpdateReportTemplate(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	existing, ok := loadReportTemplate(c, user.ID)
+	if !ok {
+		return
+	}
+
+	template, ok := bindReportTemplate(c, user)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	template.ID = existing.ID
+	template.UserID = user.ID
+	template.CreatedAt = existing.CreatedAt
+	template.LastCheckedAt = &now
+
+	if err := config.DB.Save(&template).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update report template"})
+		return
+	}
+	c.JSON(http.StatusOK, template)
+}
+
+// DeleteReportTemplate removes one of the caller's report templates and the
+// reports generated from it.
+func DeleteReportTemplate(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	template, ok := loadReportTemplate(c, userID)
+	if !ok {
+		return
+	}
+
+	if err := config.DB.Where("template_id = ?", template.ID).Delete(&models.Report{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reports"})
+		return
+	}
+	if err := config.DB.Delete(&template).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete report template"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Report template deleted successfully"})
+}
+
+// PreviewReport renders a template's report without storing or emailing it,
+// for the template's period up to now or ?from= and ?to=. ?format= overrides
+// the template's format.
+func PreviewReport(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	template, ok := loadReportTemplate(c, user.ID)
+	if !ok {
+		return
+	}
+	if format := c.Query("format"); format != "" {
+		if format != models.ReportFormatHTML && format != models.ReportFormatPDF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be html or pdf"})
+			return
+		}
+		template.Format = format
+	}
+	from, to, ok := reportPeriod(c, template)
+	if !ok {
+		return
+	}
+
+	data, err := utils.BuildReport(config.DB, user, template, from, to)
+	if err != nil {
+		respondReportError(c, err)
+		return
+	}
+	content, err := utils.RenderReport(data, template.Format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render report"})
+		return
+	}
+	c.Data(http.StatusOK, utils.ReportContentType(template.Format), content)
+}
+
+// GenerateReport generates and stores a template's report now, for the
+// template's period up to now or ?from= and ?to=. ?email=true also sends it
+// to the template's recipients, at most reportEmailLimit times per user.
+func GenerateReport(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	template, ok := loadReportTemplate(c, userID)
+	if !ok {
+		return
+	}
+	from, to, ok := reportPeriod(c, template)
+	if !ok {
+		return
+	}
+
+	email := c.Query("email") == "true"
+	if email {
+		if ok, wait := utils.Allow(fmt.Sprintf("report:email:%d", userID), reportEmailLimit); !ok {
+			middlewares.RejectRateLimited(c, wait)
+			return
+		}
+	}
+
+	report, err := generateReport(template, from, to, nil, email)
+	if err != nil {
+		respondReportError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, report)
+}
+
+// GetReports lists the caller's generated reports, newest first, optionally
+// those of one template (?template_id=).
+func GetReports(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	query := config.DB.Omit("content").Where("user_id = ?", userID)
+	if templateID := c.Query("template_id"); templateID != "" {
+		query = query.Where("template_id = ?", templateID)
+	}
+	var reports []models.Report
+	if err := query.Order("created_at desc").Limit(500).Find(&reports).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reports"})
+		return
+	}
+	c.JSON(http.StatusOK, reports)
+}
+
+// DownloadReport sends one of the caller's generated reports as a file.
+func DownloadReport(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var report models.Report
+	if err := config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&report).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename="+utils.ReportFilename(report))
+	c.Data(http.StatusOK, utils.ReportContentType(report.Format), report.Content)
+}
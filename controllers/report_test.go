@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReportSchedulerRetriesFailedOccurrences(t *testing.T) {
+	testDB(t)
+	owner := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	other := createTestUser(t, "neighbour", "neighbour@example.com", "Neighbour-password-1")
+	field, _ := testField(t, other)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	checked := now.AddDate(0, 0, -3)
+	template := models.ReportTemplate{
+		UserID:        owner.ID,
+		Name:          "Daily",
+		FarmID:        field.FarmID,
+		Format:        models.ReportFormatHTML,
+		Sections:      models.ReportSectionSummary,
+		PeriodDays:    1,
+		RRule:         "FREQ=DAILY;BYHOUR=7;BYMINUTE=0",
+		StartsAt:      now.AddDate(0, 0, -10),
+		Timezone:      "UTC",
+		Enabled:       true,
+		LastCheckedAt: &checked,
+	}
+	if err := config.DB.Create(&template).Error; err != nil {
+		t.Fatal(err)
+	}
+	reports := func() int64 {
+		var count int64
+		config.DB.Model(&models.Report{}).Where("template_id = ?", template.ID).Count(&count)
+		return count
+	}
+
+	// The owner cannot see the farm, so every occurrence fails
+	runReportTemplate(template, now)
+	config.DB.First(&template, template.ID)
+	if reports() != 0 || !template.LastCheckedAt.Equal(checked) {
+		t.Fatalf("last_checked_at = %v with %d reports, want %v kept for a retry", template.LastCheckedAt, reports(), checked)
+	}
+
+	config.DB.Model(&models.Farm{}).Where("id = ?", field.FarmID).Update("owner_id", owner.ID)
+	// An occurrence reported by a run cut short is not reported again
+	first := time.Date(checked.Year(), checked.Month(), checked.Day(), 7, 0, 0, 0, time.UTC)
+	if !first.After(checked) {
+		first = first.AddDate(0, 0, 1)
+	}
+	if err := config.DB.Create(&models.Report{TemplateID: template.ID, UserID: owner.ID, ScheduledFor: &first}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	runReportTemplate(template, now)
+	config.DB.First(&template, template.ID)
+	if reports() != 3 || !template.LastCheckedAt.Equal(now) {
+		t.Fatalf("last_checked_at = %v with %d reports, want %v and one report a day", template.LastCheckedAt, reports(), now)
+	}
+}
+
+func TestReportRecipientsAreCapped(t *testing.T) {
+	testDB(t)
+	owner := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	field, _ := testField(t, owner)
+
+	recipients := make([]string, 21)
+	for i := range recipients {
+		recipients[i] = fmt.Sprintf("reader%d@example.com", i)
+	}
+	template := map[string]interface{}{"name": "Weekly", "farm_id": field.FarmID, "recipients": strings.Join(recipients, ",")}
+	expectStatus(t, serve(CreateReportTemplate, http.MethodPost, "/reports/templates", template, &owner), http.StatusBadRequest)
+
+	template["recipients"] = strings.Join(recipients[:20], ",")
+	expectStatus(t, serve(CreateReportTemplate, http.MethodPost, "/reports/templates", template, &owner), http.StatusCreated)
+}
+
+func TestEmailedReportsAreRateLimited(t *testing.T) {
+	testDB(t)
+	mailer := captureMail(t)
+	owner := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	field, _ := testField(t, owner)
+
+	template := models.ReportTemplate{
+		UserID:     owner.ID,
+		Name:       "Weekly",
+		FarmID:     field.FarmID,
+		Format:     models.ReportFormatHTML,
+		Sections:   models.ReportSectionSummary,
+		PeriodDays: 7,
+		RRule:      "FREQ=WEEKLY;BYDAY=MO;BYHOUR=7;BYMINUTE=0",
+		Recipients: "reader@example.com",
+		Enabled:    true,
+	}
+	if err := config.DB.Create(&template).Error; err != nil {
+		t.Fatal(err)
+	}
+	id := gin.Param{Key: "id", Value: strconv.FormatUint(uint64(template.ID), 10)}
+
+	for i := 0; i < reportEmailLimit.Burst; i++ {
+		expectStatus(t, serve(GenerateReport, http.MethodPost, "/reports/templates/1/generate?email=true", nil, &owner, id), http.StatusCreated)
+	}
+	expectStatus(t, serve(GenerateReport, http.MethodPost, "/reports/templates/1/generate?email=true", nil, &owner, id), http.StatusTooManyRequests)
+	if len(mailer.Messages()) != reportEmailLimit.Burst {
+		t.Fatalf("sent %d emails, want %d", len(mailer.Messages()), reportEmailLimit.Burst)
+	}
+	// Reports that are only stored are not limited
+	expectStatus(t, serve(GenerateReport, http.MethodPost, "/reports/templates/1/generate", nil, &owner, id), http.StatusCreated)
+}
+
+func TestReportTemplateCreatedDisabledStaysDisabled(t *testing.T) {
+	testDB(t)
+	owner := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	field, _ := testField(t, owner)
+
+	for _, tt := range []struct {
+		body    map[string]interface{}
+		enabled bool
+	}{
+		{map[string]interface{}{"name": "Paused", "farm_id": field.FarmID, "recipients": "reader@example.com", "enabled": false}, false},
+		{map[string]interface{}{"name": "Default", "farm_id": field.FarmID, "recipients": "reader@example.com"}, true},
+	} {
+		recorder := serve(CreateReportTemplate, http.MethodPost, "/reports/templates", tt.body, &owner)
+		expectStatus(t, recorder, http.StatusCreated)
+		var template models.ReportTemplate
+		if err := config.DB.First(&template, decode(t, recorder)["id"]).Error; err != nil {
+			t.Fatal(err)
+		}
+		if template.Enabled != tt.enabled {
+			t.Fatalf("template %q stored with enabled = %v, want %v", template.Name, template.Enabled, tt.enabled)
+		}
+	}
+}
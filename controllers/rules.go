@@ -0,0 +1,284 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ruleMutex serialises rule evaluation so the reading hook and the scheduler
+// cannot both queue a run for the same rule.
+var ruleMutex sync.Mutex
+
+// runIrrigationRule evaluates a rule, queues a command when it decides to
+// water and records the decision with its inputs.
+func runIrrigationRule(rule models.IrrigationRule, trigger string) (models.IrrigationDecision, error) {
+	ruleMutex.Lock()
+	defer ruleMutex.Unlock()
+
+	decision := models.IrrigationDecision{
+		RuleID:      rule.ID,
+		UserID:      rule.UserID,
+		Trigger:     trigger,
+		EvaluatedAt: time.Now(),
+	}
+
+	var actuator models.Actuator
+	if err := config.DB.Where("id = ? AND user_id = ?", rule.ActuatorID, rule.UserID).First(&actuator).Error; err != nil {
+		decision.Decision = models.DecisionSkip
+		decision.Reason = "actuator not found"
+		recordIrrigationDecision(&decision)
+		return decision, err
+	}
+
+	inputs, err := utils.GatherRuleInputs(config.DB, rule, actuator, decision.EvaluatedAt)
+	if err != nil {
+		return decision, err
+	}
+	encoded, _ := json.Marshal(inputs)
+	decision.Inputs = string(encoded)
+	decision.Decision, decision.Reason = utils.EvaluateRule(rule, inputs)
+
+	if decision.Decision == models.DecisionIrrigate {
+		command, err := utils.QueueIrrigationCommand(config.DB, actuator, models.IrrigationStart, rule.DurationSeconds, "rule", rule.UserID)
+		if err != nil {
+			decision.Decision = models.DecisionSkip
+			decision.Reason = fmt.Sprintf("%s; failed to queue command: %v", decision.Reason, err)
+		} else {
+			decision.CommandID = &command.ID
+			NotifyIrrigationCommand(command)
+		}
+	}
+
+	if err := recordIrrigationDecision(&decision); err != nil {
+		return decision, err
+	}
+	return decision, nil
+}
+
+// recordIrrigationDecision saves a decision. The scheduler evaluates every
+// rule each minute, so its decisions are only saved when they queue a
+// command or differ from the rule's last recorded decision.
+func recordIrrigationDecision(decision *models.IrrigationDecision) error {
+	if decision.Trigger == "scheduler" && decision.CommandID == nil {
+		var last models.IrrigationDecision
+		err := config.DB.Where("rule_id = ?", decision.RuleID).Order("evaluated_at desc").First(&last).Error
+		if err == nil && last.Decision == decision.Decision {
+			return nil
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+	return config.DB.Create(decision).Error
+}
+
+// evaluateRulesForReading runs the enabled rules driven by the device that
+// just reported a reading.
+func evaluateRulesForReading(userID uint, deviceID string) {
+	var rules []models.IrrigationRule
+	if err := config.DB.Where("user_id = ? AND device_id = ? AND enabled = ?", userID, deviceID, true).Find(&rules).Error; err != nil {
+		fmt.Println("❌ Failed to load irrigation rules:", err)
+		return
+	}
+	for _, rule := range rules {
+		if _, err := runIrrigationRule(rule, "reading"); err != nil {
+			fmt.Printf("❌ Irrigation rule %d failed: %v\n", rule.ID, err)
+		}
+	}
+}
+
+// StartIrrigationRuleScheduler evaluates every enabled rule at a fixed
+// interval so sustained conditions and quiet-hour ends are acted on even
+// between readings. It should be started once on startup.
+func StartIrrigationRuleScheduler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			var rules []models.IrrigationRule
+			if err := config.DB.Where("enabled = ?", true).Find(&rules).Error; err != nil {
+				fmt.Println("❌ Failed to load irrigation rules:", err)
+				continue
+			}
+			for _, rule := range rules {
+				if _, err := runIrrigationRule(rule, "scheduler"); err != nil {
+					fmt.Printf("❌ Irrigation rule %d failed: %v\n", rule.ID, err)
+				}
+			}
+		}
+	}()
+}
+
+// bindIrrigationRule reads and validates a rule from the request body. A
+// rule is enabled unless the body says otherwise.
+func bindIrrigationRule(c *gin.Context, userID uint) (models.IrrigationRule, bool) {
+	rule := models.IrrigationRule{Enabled: true}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule data"})
+		return rule, false
+	}
+	if rule.DeviceID == "" {
+		rule.DeviceID = models.DefaultDeviceID
+	}
+	if err := utils.ValidateIrrigationRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return rule, false
+	}
+
+	var actuator models.Actuator
+	if err := config.DB.Where("id = ? AND user_id = ?", rule.ActuatorID, userID).First(&actuator).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown actuator"})
+		return rule, false
+	}
+	if rule.DurationSeconds > utils.MaxRunSeconds(actuator) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("duration_seconds exceeds the actuator maximum of %d", utils.MaxRunSeconds(actuator))})
+		return rule, false
+	}
+	return rule, true
+}
+
+// CreateIrrigationRule adds an automatic irrigation rule for the caller.
+func CreateIrrigationRule(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	rule, ok := bindIrrigationRule(c, userID)
+	if !ok {
+		return
+	}
+	rule.ID = 0
+	rule.UserID = userID
+
+	if err := config.DB.Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create rule"})
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+// GetIrrigationRules lists the caller's irrigation rules.
+func GetIrrigationRules(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var rules []models.IrrigationRule
+	if err := config.DB.Where("user_id = ?", userID).Order("id asc").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rules"})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// UpdateIrrigationRule replaces the settings of one of the caller's rules.
+func UpdateIrrigationRule(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var existing models.IrrigationRule
+	if err := config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&existing).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+
+	rule, ok := bindIrrigationRule(c, userID)
+	if !ok {
+		return
+	}
+	rule.ID = existing.ID
+	rule.UserID = userID
+	rule.CreatedAt = existing.CreatedAt
+
+	if err := config.DB.Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rule"})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// DeleteIrrigationRule removes one of the caller's rules. Its decision log is kept.
+func DeleteIrrigationRule(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	result := config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).Delete(&models.IrrigationRule{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rule"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Rule deleted successfully"})
+}
+
+// EvaluateIrrigationRule runs one of the caller's rules immediately and
+// returns the decision.
+func EvaluateIrrigationRule(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var rule models.IrrigationRule
+	if err := config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&rule).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+
+	decision, err := runIrrigationRule(rule, "manual")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate rule", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, decision)
+}
+
+// GetIrrigationDecisions returns the caller's rule decision log, optionally
+// filtered by rule_id and decision.
+func GetIrrigationDecisions(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	query := config.DB.Where("user_id = ?", userID)
+	if ruleID := c.Query("rule_id"); ruleID != "" {
+		query = query.Where("rule_id = ?", ruleID)
+	}
+	if decision := c.Query("decision"); decision != "" {
+		query = query.Where("decision = ?", decision)
+	}
+
+	var decisions []models.IrrigationDecision
+	if err := query.Order("evaluated_at desc").Limit(500).Find(&decisions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch decisions"})
+		return
+	}
+	c.JSON(http.StatusOK, decisions)
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIrrigationRuleCreatedDisabledStaysDisabled(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	actuator := testActuator(t, user)
+
+	for _, tt := range []struct {
+		body    gin.H
+		enabled bool
+	}{
+		{gin.H{"name": "Paused", "actuator_id": actuator.ID, "threshold": 30, "duration_seconds": 60, "enabled": false}, false},
+		{gin.H{"name": "Default", "actuator_id": actuator.ID, "threshold": 30, "duration_seconds": 60}, true},
+	} {
+		recorder := serve(CreateIrrigationRule, http.MethodPost, "/irrigation/rules", tt.body, &user)
+		expectStatus(t, recorder, http.StatusCreated)
+		var rule models.IrrigationRule
+		if err := config.DB.First(&rule, decode(t, recorder)["id"]).Error; err != nil {
+			t.Fatal(err)
+		}
+		if rule.Enabled != tt.enabled {
+			t.Fatalf("rule %q stored with enabled = %v, want %v", rule.Name, rule.Enabled, tt.enabled)
+		}
+	}
+}
+
+func TestSchedulerRecordsOnlyChangedDecisions(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	actuator := testActuator(t, user)
+	rule := models.IrrigationRule{UserID: user.ID, Name: "Dry", DeviceID: models.DefaultDeviceID, ActuatorID: actuator.ID,
+		Enabled: true, DryRun: true, Threshold: 30, DurationSeconds: 60}
+	if err := config.DB.Create(&rule).Error; err != nil {
+		t.Fatal(err)
+	}
+	decisions := func(want int) {
+		t.Helper()
+		var count int64
+		config.DB.Model(&models.IrrigationDecision{}).Where("rule_id = ?", rule.ID).Count(&count)
+		if count != int64(want) {
+			t.Fatalf("%d decisions recorded, want %d", count, want)
+		}
+	}
+
+	// No readings yet: skipped as stale, once
+	for i := 0; i < 3; i++ {
+		if _, err := runIrrigationRule(rule, "scheduler"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decisions(1)
+
+	reading := models.SensorData{UserID: user.ID, DeviceID: models.DefaultDeviceID, Temperature: 25, Humidity: 60, SoilMoisture: 10, Timestamp: time.Now()}
+	if err := config.DB.Create(&reading).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if decision, err := runIrrigationRule(rule, "scheduler"); err != nil || decision.Decision != models.DecisionDryRun {
+			t.Fatalf("runIrrigationRule = %+v, %v; want a dry run", decision, err)
+		}
+	}
+	decisions(2)
+
+	// Evaluations asked for by a reading or a user are always recorded
+	runIrrigationRule(rule, "reading")
+	runIrrigationRule(rule, "manual")
+	decisions(4)
+}
@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// missedOccurrenceGrace is how late an occurrence may still be run; older ones
+// (e.g. while the server was down) are recorded as missed.
+const missedOccurrenceGrace = 10 * time.Minute
+
+// runScheduleOccurrence waters (or skips) one occurrence of a schedule and
+// records the outcome. The run is recorded before any command is queued, in
+// the same transaction, so an occurrence claimed by another runner is never
+// watered twice.
+func runScheduleOccurrence(schedule models.IrrigationSchedule, occurrence time.Time, now time.Time) {
+	run := models.IrrigationScheduleRun{
+		ScheduleID:   schedule.ID,
+		UserID:       schedule.UserID,
+		ScheduledFor: occurrence,
+		Decision:     models.DecisionSkip,
+		CreatedAt:    now,
+	}
+
+	// A missed occurrence is only recorded, without checking the weather for it
+	if now.Sub(occurrence) > missedOccurrenceGrace {
+		run.Reason = "missed: occurrence passed while the scheduler was not running"
+		if err := config.DB.Create(&run).Error; err != nil {
+			fmt.Printf("❌ Failed to record schedule %d run: %v\n", schedule.ID, err)
+		}
+		return
+	}
+
+	// Checked outside the transaction, as the rain forecast may call out
+	skip, note := utils.ScheduleSkipReason(config.DB, schedule)
+	var queued *models.IrrigationCommand
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		// The unique index on (schedule_id, scheduled_for) fails the claim if
+		// the occurrence already ran
+		if err := tx.Create(&run).Error; err != nil {
+			return err
+		}
+
+		var actuator models.Actuator
+		switch {
+		case skip != "":
+			run.Reason = skip
+		case tx.Where("id = ? AND user_id = ?", schedule.ActuatorID, schedule.UserID).First(&actuator).Error != nil:
+			run.Reason = "actuator not found"
+		default:
+			command, err := utils.QueueIrrigationCommand(tx, actuator, models.IrrigationStart, schedule.DurationSeconds, "schedule", schedule.UserID)
+			if err != nil {
+				run.Reason = fmt.Sprintf("failed to queue command: %v", err)
+				break
+			}
+			queued = &command
+			run.Decision = models.DecisionIrrigate
+			run.CommandID = &command.ID
+			run.Reason = fmt.Sprintf("watering for %d seconds", schedule.DurationSeconds)
+			if note != "" {
+				run.Reason += "; " + note
+			}
+		}
+		return tx.Save(&run).Error
+	})
+	if err != nil {
+		fmt.Printf("❌ Failed to record schedule %d run: %v\n", schedule.ID, err)
+		return
+	}
+	if queued != nil {
+		NotifyIrrigationCommand(*queued)
+	}
+}
+
+// StartIrrigationScheduleRunner checks every enabled schedule for due
+// occurrences at a fixed interval. It should be started once on startup.
+func StartIrrigationScheduleRunner(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			var schedules []models.IrrigationSchedule
+			if err := config.DB.Where("enabled = ?", true).Find(&schedules).Error; err != nil {
+				fmt.Println("❌ Failed to load irrigation schedules:", err)
+				continue
+			}
+
+			now := time.Now()
+			for _, schedule := range schedules {
+				recurrence, err := utils.ScheduleRecurrence(schedule)
+				if err != nil {
+					fmt.Printf("❌ Schedule %d has an invalid rrule: %v\n", schedule.ID, err)
+					continue
+				}
+
+				since := schedule.CreatedAt
+				if schedule.LastCheckedAt != nil {
+					since = *schedule.LastCheckedAt
+				}
+				for _, occurrence := range recurrence.Between(since, now, 0) {
+					runScheduleOccurrence(schedule, occurrence, now)
+				}
+				config.DB.Model(&schedule).Update("last_checked_at", now)
+			}
+		}
+	}()
+}
+
+// bindIrrigationSchedule reads and validates a schedule from the request body.
+// A schedule is enabled unless the body says otherwise.
+func bindIrrigationSchedule(c *gin.Context, userID uint) (models.IrrigationSchedule, bool) {
+	schedule := models.IrrigationSchedule{Enabled: true}
+	if err := c.ShouldBindJSON(&schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule data"})
+		return schedule, false
+	}
+	if schedule.DeviceID == "" {
+		schedule.DeviceID = models.DefaultDeviceID
+	}
+	if schedule.StartsAt.IsZero() {
+		schedule.StartsAt = time.Now()
+	}
+	if err := utils.ValidateIrrigationSchedule(schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return schedule, false
+	}
+
+	var actuator models.Actuator
+	if err := config.DB.Where("id = ? AND user_id = ?", schedule.ActuatorID, userID).First(&actuator).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown actuator"})
+		return schedule, false
+	}
+	if schedule.DurationSeconds > utils.MaxRunSeconds(actuator) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("duration_seconds exceeds the actuator maximum of %d", utils.MaxRunSeconds(actuator))})
+		return schedule, false
+	}
+	return schedule, true
+}
+
+// CreateIrrigationSchedule adds a recurring irrigation schedule for the caller.
+func CreateIrrigationSchedule(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	schedule, ok := bindIrrigationSchedule(c, userID)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	schedule.ID = 0
+	schedule.UserID = userID
+	schedule.LastCheckedAt = &now
+
+	if err := config.DB.Create(&schedule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule"})
+		return
+	}
+	c.JSON(http.StatusCreated, schedule)
+}
+
+// GetIrrigationSchedules lists the caller's schedules.
+func GetIrrigationSchedules(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var schedules []models.IrrigationSchedule
+	if err := config.DB.Where("user_id = ?", userID).Order("id asc").Find(&schedules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedules"})
+		return
+	}
+	c.JSON(http.StatusOK, schedules)
+}
+
+// UpdateIrrigationSchedule replaces the settings of one of the caller's schedules.
+// Occurrences before the update are not run retroactively.
+func UpdateIrrigationSchedule(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var existing models.IrrigationSchedule
+	if err := config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&existing).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+
+	schedule, ok := bindIrrigationSchedule(c, userID)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	schedule.ID = existing.ID
+	schedule.UserID = userID
+	schedule.CreatedAt = existing.CreatedAt
+	schedule.LastCheckedAt = &now
+
+	if err := config.DB.Save(&schedule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule"})
+		return
+	}
+	c.JSON(http.StatusOK, schedule)
+}
+
+// DeleteIrrigationSchedule removes one of the caller's schedules.
+func DeleteIrrigationSchedule(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	result := config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).Delete(&models.IrrigationSchedule{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete schedule"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted successfully"})
+}
+
+// PreviewIrrigationSchedule returns the next upcoming runs of a schedule
+// (?count=, default 10, max 100) in both its local time zone and UTC.
+func PreviewIrrigationSchedule(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var schedule models.IrrigationSchedule
+	if err := config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&schedule).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+
+	count, err := strconv.Atoi(c.DefaultQuery("count", "10"))
+	if err != nil || count < 1 || count > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "count must be between 1 and 100"})
+		return
+	}
+
+	recurrence, err := utils.ScheduleRecurrence(schedule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	runs := []gin.H{}
+	for _, occurrence := range recurrence.Next(time.Now(), count) {
+		runs = append(runs, gin.H{
+			"local":            occurrence.Format("2006-01-02 15:04 MST"),
+			"utc":              occurrence.UTC(),
+			"duration_seconds": schedule.DurationSeconds,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"schedule_id": schedule.ID,
+		"timezone":    recurrence.Location.String(),
+		"enabled":     schedule.Enabled,
+		"upcoming":    runs,
+	})
+}
+
+// GetIrrigationScheduleRuns returns what happened at past occurrences of one
+// of the caller's schedules.
+func GetIrrigationScheduleRuns(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var runs []models.IrrigationScheduleRun
+	if err := config.DB.Where("schedule_id = ? AND user_id = ?", c.Param("id"), userID).
+		Order("scheduled_for desc").Limit(500).Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedule runs"})
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// testSchedule saves a user's schedule on a new actuator.
+func testSchedule(t *testing.T, user models.User) models.IrrigationSchedule {
+	t.Helper()
+	actuator := models.Actuator{UserID: user.ID, DeviceID: models.DefaultDeviceID, Name: "Valve", MaxRunSeconds: 600}
+	if err := config.DB.Create(&actuator).Error; err != nil {
+		t.Fatal(err)
+	}
+	schedule := models.IrrigationSchedule{
+		UserID:          user.ID,
+		Name:            "Mornings",
+		ActuatorID:      actuator.ID,
+		DeviceID:        models.DefaultDeviceID,
+		RRule:           "FREQ=DAILY;BYHOUR=6",
+		DurationSeconds: 60,
+		Enabled:         true,
+	}
+	if err := config.DB.Create(&schedule).Error; err != nil {
+		t.Fatal(err)
+	}
+	return schedule
+}
+
+// stubRainForecast answers rain forecasts with rain and counts the calls.
+func stubRainForecast(t *testing.T, rain float64) *int {
+	t.Helper()
+	previous := utils.RainForecast
+	calls := 0
+	utils.RainForecast = func(*gorm.DB, uint, string, int) (float64, error) {
+		calls++
+		return rain, nil
+	}
+	t.Cleanup(func() { utils.RainForecast = previous })
+	return &calls
+}
+
+func TestScheduleOccurrenceRunsOnce(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	schedule := testSchedule(t, user)
+	now := time.Now()
+	occurrence := now.Add(-time.Minute).Truncate(time.Second)
+
+	runScheduleOccurrence(schedule, occurrence, now)
+	// The first command is still pending, so a second queue would be refused
+	// as busy; clear it to show the claim alone stops the rerun
+	config.DB.Model(&models.IrrigationCommand{}).Where("1 = 1").Update("status", models.CommandCancelled)
+	runScheduleOccurrence(schedule, occurrence, now)
+
+	var runs []models.IrrigationScheduleRun
+	config.DB.Find(&runs)
+	if len(runs) != 1 || runs[0].Decision != models.DecisionIrrigate || runs[0].CommandID == nil {
+		t.Fatalf("runs = %+v, want one irrigate run with its command", runs)
+	}
+	var commands int64
+	config.DB.Model(&models.IrrigationCommand{}).Count(&commands)
+	if commands != 1 {
+		t.Fatalf("%d commands queued for one occurrence, want 1", commands)
+	}
+}
+
+func TestMissedOccurrenceIsNotChecked(t *testing.T) {
+	testDB(t)
+	calls := stubRainForecast(t, 0)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	schedule := testSchedule(t, user)
+	schedule.SkipIfRainMM = 5
+	now := time.Now()
+
+	runScheduleOccurrence(schedule, now.Add(-time.Hour), now)
+	if *calls != 0 {
+		t.Fatalf("rain forecast checked %d times for a missed occurrence", *calls)
+	}
+	var run models.IrrigationScheduleRun
+	config.DB.First(&run)
+	if run.Decision != models.DecisionSkip || run.CommandID != nil {
+		t.Fatalf("missed run = %+v, want a skip without a command", run)
+	}
+
+	runScheduleOccurrence(schedule, now.Add(-time.Minute), now)
+	if *calls != 1 {
+		t.Fatalf("rain forecast checked %d times for a due occurrence, want 1", *calls)
+	}
+}
+
+func TestScheduleCreatedDisabledStaysDisabled(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	actuator := testSchedule(t, user).ActuatorID
+
+	for _, tt := range []struct {
+		body    gin.H
+		enabled bool
+	}{
+		{gin.H{"name": "Paused", "actuator_id": actuator, "rrule": "FREQ=DAILY;BYHOUR=6", "duration_seconds": 60, "enabled": false}, false},
+		{gin.H{"name": "Default", "actuator_id": actuator, "rrule": "FREQ=DAILY;BYHOUR=6", "duration_seconds": 60}, true},
+	} {
+		recorder := serve(CreateIrrigationSchedule, http.MethodPost, "/irrigation/schedules", tt.body, &user)
+		expectStatus(t, recorder, http.StatusCreated)
+		var schedule models.IrrigationSchedule
+		if err := config.DB.First(&schedule, decode(t, recorder)["id"]).Error; err != nil {
+			t.Fatal(err)
+		}
+		if schedule.Enabled != tt.enabled {
+			t.Fatalf("schedule %q stored with enabled = %v, want %v", schedule.Name, schedule.Enabled, tt.enabled)
+		}
+	}
+}
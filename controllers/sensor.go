@@ -2,12 +2,15 @@ package controllers
 
 import (
 	"bytes"
-	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"fyp/config"
@@ -15,24 +18,30 @@ import (
 	"fyp/utils"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // ReceiveData processes incoming sensor data.
 func ReceiveData(c *gin.Context) {
-	var data models.SensorData
+	var req models.SensorDataRequest
 	userID, exists := c.Get("user_id")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	if err := c.ShouldBindJSON(&data); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
 		return
 	}
 
-	loc, _ := time.LoadLocation("Asia/Kuala_Lumpur")
-	data.Timestamp = time.Now().In(loc)
+	data := models.SensorData{
+		DeviceID:     req.DeviceID,
+		Timestamp:    time.Now().In(utils.LocalTimezone()),
+		Temperature:  req.Temperature,
+		Humidity:     req.Humidity,
+		SoilMoisture: req.SoilMoisture,
+	}
 
 	// Convert userID to uint
 	switch v := userID.(type) {
@@ -45,11 +54,31 @@ func ReceiveData(c *gin.Context) {
 		return
 	}
 
-	isAIEnabled, plantAI := utils.IsGlobalAIEnabled()
+	if deviceID, ok := credentialDevice(c); ok {
+		data.DeviceID = deviceID
+	} else if data.DeviceID == "" {
+		data.DeviceID = models.DefaultDeviceID
+	}
+	if _, err := utils.EnsureDevice(config.DB, data.UserID, data.DeviceID); err != nil {
+		fmt.Println("❌ Failed to register device:", err)
+	}
+
+	devModeActive, _, err := developerModeActive()
+	if err != nil {
+		fmt.Println("❌ Failed to check developer mode:", err)
+	}
+	aiConfig, _ := config.GetAIConfig(data.UserID, data.DeviceID)
+
+	// The planted crop's current growth stage decides thresholds and AI model
+	thresholds, plantModel := utils.PlantingThresholds(config.DB, data.UserID, data.DeviceID, data.Timestamp)
+	plantAI := aiConfig.PlantName
+	if plantModel != "" {
+		plantAI = plantModel
+	}
 
-	if isAIEnabled {
+	if aiConfig.Enabled && !devModeActive && plantAI != "" {
 		timestamp := data.Timestamp.Format("2006-01-02 15:04:05")
-		predictedTimestamp, predicted, err := utils.GetPredictedSoilMoisture(config.DB, data.UserID, plantAI, timestamp, float32(data.Temperature), float32(data.Humidity))
+		predictedTimestamp, predicted, err := utils.GetPredictedSoilMoisture(config.DB, data.UserID, data.DeviceID, plantAI, timestamp, float32(data.Temperature), float32(data.Humidity))
 
 		if err == nil {
 			fmt.Println("🔮 Using AI Predicted Soil Moisture:", predicted, "for timestamp:", predictedTimestamp)
@@ -60,8 +89,13 @@ func ReceiveData(c *gin.Context) {
 		}
 	}
 
-	data.IsAbnormal = utils.CheckAbnormality(data)
-	config.DB.Create(&data)
+	data.IsAbnormal = utils.CheckAbnormalityWith(data, thresholds)
+	if err := config.DB.Create(&data).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store data"})
+		return
+	}
+	utils.InvalidateForecasts(data.UserID, data.DeviceID)
+	go evaluateRulesForReading(data.UserID, data.DeviceID)
 
 	// Broadcast data updates
 	BroadcastUpdate(data)
@@ -75,44 +109,64 @@ func ReceiveData(c *gin.Context) {
 // GetHistory returns sensor data history.
 func GetHistory(c *gin.Context) {
 	var records []models.SensorData
+	query, ok := historyQuery(c)
+	if !ok {
+		return
+	}
+
+	if err := query.Find(&records).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	c.JSON(http.StatusOK, records)
+}
+
+// historyQuery selects the readings the history filters ask for, newest
+// first, and responds with an error if they are invalid or not allowed.
+func historyQuery(c *gin.Context) (*gorm.DB, bool) {
 	userIDFromToken, exists := c.Get("user_id")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
+		return nil, false
 	}
 
 	var user models.User
 	if err := config.DB.First(&user, userIDFromToken).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
-		return
+		return nil, false
 	}
 
-	// Check if admin and see if query param `user_id` is passed
-	requestedUserID := c.Query("user_id")
+	// A farm, field or zone in the query rolls up every device below it
+	scope, filtered, ok := hierarchyFilter(c, user)
+	if !ok {
+		return nil, false
+	}
 
-	if user.Role == "admin" {
-		if requestedUserID != "" {
-			// Admin filtering by user_id
-			if err := config.DB.Where("user_id = ?", requestedUserID).Order("timestamp desc").Find(&records).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data for requested user"})
-				return
-			}
-		} else {
-			// Admin requesting all users' data
-			if err := config.DB.Order("timestamp desc").Find(&records).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch all data"})
-				return
-			}
-		}
-	} else {
-		// Normal user can only see their own data
-		if err := config.DB.Where("user_id = ?", userIDFromToken).Order("timestamp desc").Find(&records).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user data"})
-			return
+	// Otherwise the caller's own data, or that of a user or organisation
+	// (?user_id=, ?org_id=) they share membership with
+	if !filtered {
+		if scope, ok = dataOwnerScope(c, user); !ok {
+			return nil, false
 		}
 	}
 
-	c.JSON(http.StatusOK, records)
+	values, ok := readingValues(c)
+	if !ok {
+		return nil, false
+	}
+	return config.DB.Model(&models.SensorData{}).Scopes(scope, values).Order("timestamp desc"), true
+}
+
+// readingValues reads ?values=raw|corrected, corrected by default, and
+// responds with an error if it is neither.
+func readingValues(c *gin.Context) (func(*gorm.DB) *gorm.DB, bool) {
+	values, err := utils.ReadingValues(c.Query("values"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return values, true
 }
 
 func GetProfile(c *gin.Context) {
@@ -129,9 +183,11 @@ func GetProfile(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"id":       user.ID,
-		"username": user.Username,
-		"role":     user.Role,
+		"id":             user.ID,
+		"username":       user.Username,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"role":           user.Role,
 	})
 }
 
@@ -155,11 +211,16 @@ func GetAbnormalCount(c *gin.Context) {
 
 	var user models.User
 	config.DB.First(&user, userID)
-	if user.Role == "admin" {
-		config.DB.Model(&models.SensorData{}).Where("is_abnormal = ?", true).Count(&count)
-	} else {
-		config.DB.Model(&models.SensorData{}).Where("is_abnormal = ? AND user_id = ?", true, userID).Count(&count)
+	scope, filtered, ok := hierarchyFilter(c, user)
+	if !ok {
+		return
+	}
+	if !filtered {
+		if scope, ok = dataOwnerScope(c, user); !ok {
+			return
+		}
 	}
+	config.DB.Model(&models.SensorData{}).Scopes(scope).Where("is_abnormal = ?", true).Count(&count)
 	c.JSON(http.StatusOK, gin.H{"count": count})
 }
 
@@ -174,21 +235,40 @@ func GetAbnormalHistory(c *gin.Context) {
 
 	var user models.User
 	config.DB.First(&user, userID)
-	query := config.DB.Where("is_abnormal = ?", true)
-	if user.Role != "admin" {
-		query = query.Where("user_id = ?", userID)
+	scope, filtered, ok := hierarchyFilter(c, user)
+	if !ok {
+		return
+	}
+	if !filtered {
+		if scope, ok = dataOwnerScope(c, user); !ok {
+			return
+		}
 	}
+	query := config.DB.Where("is_abnormal = ?", true).Scopes(scope)
 
 	if err := query.Order("timestamp desc").Find(&records).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Thresholds only change with the growth stage, so look them up once a day
+	thresholds := make(map[string]utils.Thresholds)
 	var response []map[string]interface{}
 	for _, record := range records {
+		cacheKey := fmt.Sprintf("%d/%s/%s", record.UserID, record.DeviceID, record.Timestamp.Format("2006-01-02"))
+		limits, ok := thresholds[cacheKey]
+		if !ok {
+			limits, _ = utils.PlantingThresholds(config.DB, record.UserID, record.DeviceID, record.Timestamp)
+			thresholds[cacheKey] = limits
+		}
+		abnormalType := utils.GetAbnormalTypeWith(record, limits)
+		if abnormalType == "" {
+			abnormalType = "Unknown"
+		}
 		response = append(response, map[string]interface{}{
 			"timestamp": record.Timestamp.Format("2006-01-02 15:04:05"),
-			"type":      utils.GetAbnormalType(record),
+			"device_id": record.DeviceID,
+			"type":      abnormalType,
 		})
 	}
 
@@ -197,35 +277,109 @@ func GetAbnormalHistory(c *gin.Context) {
 
 // DownloadCSV sends sensor data as a CSV file.
 func DownloadCSV(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	exporter, _ := utils.NegotiateExporter("csv", "")
+	exportReadings(c, exporter)
+}
+
+// maxImportSize caps the size of an uploaded CSV file.
+const maxImportSize = 512 << 20
+
+// ImportCSV imports historical sensor data from CSV in the layout DownloadCSV
+// writes, uploaded as the "file" field of a form or as the request body.
+// ?dry_run=true only validates, ?skip_invalid=true imports the valid rows of a
+// file with errors, ?device_id= applies to rows without one and ?user_id=
+// imports for another user whose data the caller may edit.
+func ImportCSV(c *gin.Context) {
+	if _, ok := credentialDevice(c); ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Devices cannot import data"})
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
 		return
 	}
 
-	var user models.User
-	config.DB.First(&user, userID)
-	query := config.DB.Order("timestamp desc")
-	if user.Role != "admin" {
-		query = query.Where("user_id = ?", userID)
+	opts := utils.ImportOptions{
+		UserID:          user.ID,
+		DefaultDeviceID: c.Query("device_id"),
+		DryRun:          c.Query("dry_run") == "true",
+		SkipInvalid:     c.Query("skip_invalid") == "true",
+	}
+	if value := c.Query("user_id"); value != "" {
+		ownerID, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "user_id must be a user ID"})
+			return
+		}
+		opts.UserID = uint(ownerID)
+		if !utils.CanAccessUserData(config.DB, user, opts.UserID, utils.AccessWrite) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to import data for this user"})
+			return
+		}
 	}
 
-	var records []models.SensorData
-	query.Find(&records)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+	body, err := importBody(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	c.Header("Content-Type", "text/csv")
-	c.Header("Content-Disposition", "attachment; filename=sensor_data.csv")
-	writer := csv.NewWriter(c.Writer)
-	defer writer.Flush()
+	var result utils.ImportResult
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if result, err = utils.ImportSensorCSV(tx, body, opts); err != nil || result.Imported == 0 {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditSensorDataImport, models.AuditTargetUser, opts.UserID, nil,
+			gin.H{"imported": result.Imported, "devices": result.Devices})
+	})
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, utils.ErrImportFile):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.As(err, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("The file is larger than %d MB", maxImportSize>>20)})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import data"})
+		return
+	}
+	if result.Imported > 0 {
+		for _, deviceID := range result.Devices {
+			utils.InvalidateForecasts(opts.UserID, deviceID)
+		}
+	}
 
-	writer.Write([]string{"timestamp", "temperature", "humidity", "soil_moisture"})
-	for _, record := range records {
-		writer.Write([]string{
-			record.Timestamp.Format("2006-01-02 15:04:05"),
-			fmt.Sprintf("%.2f", record.Temperature),
-			fmt.Sprintf("%.2f", record.Humidity),
-			fmt.Sprintf("%.2f", record.SoilMoisture),
-		})
+	status := http.StatusOK
+	if result.Invalid > 0 && result.Imported == 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	c.JSON(status, result)
+}
+
+// importBody returns the uploaded CSV: the "file" part of a multipart form,
+// read as it arrives, or else the request body.
+func importBody(c *gin.Context) (io.Reader, error) {
+	if !strings.HasPrefix(c.ContentType(), "multipart/") {
+		return c.Request.Body, nil
+	}
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("the form has no file field")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
 	}
 }
 
@@ -246,44 +400,35 @@ func DeleteRecord(c *gin.Context) {
 
 	var user models.User
 	config.DB.First(&user, userID)
-	if err := config.DB.Delete(&record).Error; err != nil {
+	if !utils.CanAccessUserData(config.DB, user, record.UserID, utils.AccessWrite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to delete this record"})
+		return
+	}
+	batch, _, err := trashReadings(c, models.AuditSensorDataDelete, models.AuditTargetSensorData, record.ID, record,
+		sensorDataWhere("id = ?", record.ID))
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete record"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Record deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Record moved to the trash", "trash_batch": batch})
 }
 
-// DeleteAllRecords deletes all sensor data records (admin only).
+// DeleteAllRecords moves all sensor data records to the trash (requires sensor_data:manage).
 func DeleteAllRecords(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
-	}
-
-	var user models.User
-	config.DB.First(&user, userID)
-	if user.Role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to delete all records"})
-		return
-	}
-
-	if err := config.DB.Exec("DELETE FROM sensor_data").Error; err != nil {
+	batch, count, err := trashReadings(c, models.AuditSensorDataDeleteAll, models.AuditTargetSensorData, nil, nil)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete records"})
 		return
 	}
-	if err := config.DB.Exec("ALTER SEQUENCE sensor_data_id_seq RESTART WITH 1").Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset primary key sequence"})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"message": "All records deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "All records moved to the trash", "deleted_count": count, "trash_batch": batch})
 }
 
-// Update edit a  sensor data record.
+// UpdateRecord corrects a sensor data record. Every correction is kept as a
+// revision with its reason and author, and the abnormality flag is recomputed.
 func UpdateRecord(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
+	userID, ok := getUserID(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
@@ -297,27 +442,76 @@ func UpdateRecord(c *gin.Context) {
 		return
 	}
 
-	// Bind the JSON input to a temporary struct
-	var input models.SensorData
+	var input models.CorrectReadingRequest
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input, a reason for the correction is required"})
+		return
+	}
+	input.Reason = strings.TrimSpace(input.Reason)
+	if input.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason for the correction is required"})
 		return
 	}
 
-	// Update record fields
-	record.Temperature = input.Temperature
-	record.Humidity = input.Humidity
-	record.SoilMoisture = input.SoilMoisture
-
-	// Save changes to the database
 	var user models.User
 	config.DB.First(&user, userID)
-	if err := config.DB.Save(&record).Error; err != nil {
+	if !utils.CanAccessUserData(config.DB, user, record.UserID, utils.AccessWrite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to edit this record"})
+		return
+	}
+
+	var revision models.ReadingRevision
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if record, revision, err = utils.CorrectReading(tx, record.ID, input, userID); err != nil {
+			return err
+		}
+		before := gin.H{
+			"temperature":   revision.PreviousTemperature,
+			"humidity":      revision.PreviousHumidity,
+			"soil_moisture": revision.PreviousSoilMoisture,
+			"is_abnormal":   revision.PreviousIsAbnormal,
+		}
+		return recordAudit(c, tx, models.AuditSensorDataUpdate, models.AuditTargetSensorData, record.ID, before, revision)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update record"})
 		return
 	}
+	utils.InvalidateForecasts(record.UserID, record.DeviceID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Record updated successfully", "updated_record": record, "revision": revision})
+}
+
+// GetRecordRevisions returns a sensor data record as reported and every
+// correction made to it since, oldest first.
+func GetRecordRevisions(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var record models.SensorData
+	if err := config.DB.First(&record, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return
+	}
+	if !utils.CanAccessUserData(config.DB, user, record.UserID, utils.AccessRead) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to view this record"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Record updated successfully", "updated_record": record})
+	var original models.SensorData
+	if err := config.DB.Scopes(utils.RawReadings).Where("sensor_data.id = ?", record.ID).Take(&original).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch the original reading"})
+		return
+	}
+	revisions := []models.ReadingRevision{}
+	if err := config.DB.Where("sensor_data_id = ?", record.ID).Order("revision asc").Find(&revisions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revisions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"record": record, "original": original, "revisions": revisions})
 }
 
 func HandleDeviceLocation(c *gin.Context) {
@@ -356,11 +550,17 @@ func HandleDeviceLocation(c *gin.Context) {
 	}
 
 	// Store location in the database
+	deviceID := c.DefaultQuery("device_id", models.DefaultDeviceID)
+	if credentialID, ok := credentialDevice(c); ok {
+		deviceID = credentialID
+	}
 	deviceLocation := models.DeviceLocation{
-		DeviceID:  userIDUint, // Use converted user ID
+		UserID:    userIDUint,
+		DeviceID:  deviceID,
 		Latitude:  location.Location.Lat,
 		Longitude: location.Location.Lng,
 		Accuracy:  location.Accuracy,
+		Timestamp: time.Now(),
 	}
 
 	if err := config.DB.Create(&deviceLocation).Error; err != nil {
@@ -377,14 +577,19 @@ func HandleDeviceLocation(c *gin.Context) {
 	})
 }
 
-// GetDeviceLocation: Retrieves the latest location by user_id
+// GetDeviceLocation: Retrieves the latest location of one of the caller's devices
 func GetDeviceLocation(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
 	deviceID := c.Param("device_id")
 
 	var location models.DeviceLocation
 
-	// Find the latest location by device_id
-	if err := config.DB.Where("device_id = ?", deviceID).Order("timestamp DESC").First(&location).Error; err != nil {
+	// Find the latest location of the device
+	if err := config.DB.Where("user_id = ? AND device_id = ?", userID, deviceID).Order("timestamp DESC").First(&location).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
 		return
 	}
@@ -434,17 +639,19 @@ func DeleteMyRecords(c *gin.Context) {
 		return
 	}
 
-	// Delete all records for the current user
-	result := config.DB.Where("user_id = ?", userID).Delete(&models.SensorData{})
-	if result.Error != nil {
+	// Move all records of the current user to the trash
+	batch, count, err := trashReadings(c, models.AuditSensorDataDeleteUser, models.AuditTargetUser, user.ID, nil,
+		sensorDataWhere("user_id = ?", user.ID))
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete your records"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":       fmt.Sprintf("Successfully deleted all %d records for your account", result.RowsAffected),
-		"deleted_count": result.RowsAffected,
+		"message":       fmt.Sprintf("Moved all %d records for your account to the trash", count),
+		"deleted_count": count,
 		"username":      user.Username,
+		"trash_batch":   batch,
 	})
 }
 
@@ -464,12 +671,6 @@ func DeleteUserRecords(c *gin.Context) {
 	// Get target user ID from URL parameter
 	targetUserID := c.Param("user_id")
 
-	// Check permissions
-	if user.Role != "admin" && fmt.Sprintf("%v", userID) != targetUserID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You can only delete your own records or must be an admin"})
-		return
-	}
-
 	// Verify target user exists
 	var targetUser models.User
 	if err := config.DB.First(&targetUser, targetUserID).Error; err != nil {
@@ -477,21 +678,30 @@ func DeleteUserRecords(c *gin.Context) {
 		return
 	}
 
-	// Delete all records for the target user
-	result := config.DB.Where("user_id = ?", targetUserID).Delete(&models.SensorData{})
-	if result.Error != nil {
+	// Check permissions: the user, an admin, or a manager or owner of an
+	// organisation the user owns
+	if !utils.CanAccessUserData(config.DB, user, targetUser.ID, utils.AccessWrite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to delete this user's records"})
+		return
+	}
+
+	// Move all records of the target user to the trash
+	batch, count, err := trashReadings(c, models.AuditSensorDataDeleteUser, models.AuditTargetUser, targetUser.ID, nil,
+		sensorDataWhere("user_id = ?", targetUser.ID))
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user records"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":       fmt.Sprintf("Successfully deleted %d records for user %s", result.RowsAffected, targetUser.Username),
-		"deleted_count": result.RowsAffected,
+		"message":       fmt.Sprintf("Moved %d records for user %s to the trash", count, targetUser.Username),
+		"deleted_count": count,
 		"user_id":       targetUserID,
+		"trash_batch":   batch,
 	})
 }
 
-// DeleteUserAccount allows admins to delete a user account and all associated data
+// DeleteUserAccount deletes a user account and all associated data (requires users:delete)
 func DeleteUserAccount(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -499,18 +709,6 @@ func DeleteUserAccount(c *gin.Context) {
 		return
 	}
 
-	// Check if current user is admin
-	var currentUser models.User
-	if err := config.DB.First(&currentUser, userID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find current user"})
-		return
-	}
-
-	if currentUser.Role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can delete user accounts"})
-		return
-	}
-
 	// Get target user ID from URL parameter
 	targetUserID := c.Param("user_id")
 
@@ -527,6 +725,13 @@ func DeleteUserAccount(c *gin.Context) {
 		return
 	}
 
+	// The account and its data go to the trash together and are restored together
+	batch, err := utils.NewTrashBatch()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user account"})
+		return
+	}
+
 	// Start a database transaction to ensure data consistency
 	tx := config.DB.Begin()
 	defer func() {
@@ -535,21 +740,34 @@ func DeleteUserAccount(c *gin.Context) {
 		}
 	}()
 
-	// Delete all sensor data records for the user
-	sensorDataResult := tx.Where("user_id = ?", targetUserID).Delete(&models.SensorData{})
-	if sensorDataResult.Error != nil {
+	// Move all sensor data records of the user to the trash
+	sensorRecords, err := utils.TrashSensorData(tx, batch, sensorDataWhere("user_id = ?", targetUser.ID))
+	if err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user's sensor data"})
 		return
 	}
 
-	// Delete the user account
-	if err := tx.Delete(&targetUser).Error; err != nil {
+	// Move the user account to the trash and end its sessions
+	if err := utils.TrashUser(tx, targetUser.ID, batch); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user account"})
+		return
+	}
+	if err := utils.RevokeUserSessions(tx, targetUser.ID); err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user account"})
 		return
 	}
 
+	// Record the deletion with the account as it was
+	if err := recordAudit(c, tx, models.AuditUserDelete, models.AuditTargetUser, targetUser.ID,
+		gin.H{"user": targetUser, "sensor_records": sensorRecords}, gin.H{"trash_batch": batch}); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record the deletion"})
+		return
+	}
+
 	// Commit the transaction
 	if err := tx.Commit().Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
@@ -557,12 +775,13 @@ func DeleteUserAccount(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": fmt.Sprintf("Successfully deleted user account '%s' and all associated data", targetUser.Username),
+		"message": fmt.Sprintf("Moved user account '%s' and all associated data to the trash", targetUser.Username),
 		"deleted_user": gin.H{
 			"id":       targetUser.ID,
 			"username": targetUser.Username,
 			"role":     targetUser.Role,
 		},
-		"deleted_sensor_records": sensorDataResult.RowsAffected,
+		"deleted_sensor_records": sensorRecords,
+		"trash_batch":            batch,
 	})
 }
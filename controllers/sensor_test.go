@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReceiveDataIgnoresServerSetFields(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+
+	body := gin.H{
+		"device_id": "probe-1", "temperature": 21.5, "humidity": 60, "soil_moisture": 35,
+		"id": 99, "revision": 3, "is_abnormal": true, "extra": `{"ph": 6.5}`,
+	}
+	expectStatus(t, serve(ReceiveData, http.MethodPost, "/sensor-data", body, &user), http.StatusOK)
+
+	var reading models.SensorData
+	if err := config.DB.Where("device_id = ?", "probe-1").First(&reading).Error; err != nil {
+		t.Fatal(err)
+	}
+	if reading.ID == 99 || reading.Revision != 0 || reading.Extra != "" || reading.SoilMoisture != 35 {
+		t.Fatalf("stored %+v, want the reported values only", reading)
+	}
+
+	// The first correction is revision 1, and the raw values are those reported
+	soil := float32(40)
+	if _, revision, err := utils.CorrectReading(config.DB, reading.ID, models.CorrectReadingRequest{SoilMoisture: &soil, Reason: "recalibrated"}, user.ID); err != nil || revision.Revision != 1 {
+		t.Fatalf("CorrectReading = revision %d, %v; want revision 1", revision.Revision, err)
+	}
+}
+
+func TestAbnormalHistoryUsesTheCropStageThresholds(t *testing.T) {
+	testDB(t)
+	user := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	if err := config.DB.Create(&models.Device{UserID: user.ID, DeviceID: "probe-1"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	tomato := testCrop(t, "Solanum lycopersicum", 40, 70)
+	planting := gin.H{"device_id": "probe-1", "crop_id": tomato.ID, "sow_date": time.Now().AddDate(0, 0, -10)}
+	expectStatus(t, serve(CreatePlanting, http.MethodPost, "/plantings", planting, &user), http.StatusCreated)
+
+	// Dry for tomatoes, but inside the default soil moisture range
+	reading := models.SensorData{UserID: user.ID, DeviceID: "probe-1", Temperature: 22, Humidity: 60, SoilMoisture: 30, IsAbnormal: true, Timestamp: time.Now()}
+	if err := config.DB.Create(&reading).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(GetAbnormalHistory, http.MethodGet, "/abnormal-history", nil, &user)
+	expectStatus(t, recorder, http.StatusOK)
+	var history []map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &history); err != nil || len(history) != 1 {
+		t.Fatalf("history = %s, want one record", recorder.Body.String())
+	}
+	if history[0]["type"] != "Soil Moisture" {
+		t.Fatalf("type = %v, want Soil Moisture", history[0]["type"])
+	}
+}
@@ -2,11 +2,15 @@ package controllers
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"fyp/config"
 	"fyp/models"
+	"fyp/utils"
 	"io"
 	"mime/multipart"
 	"net/http"
@@ -17,8 +21,8 @@ import (
 )
 
 func TrainModel(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
+	userID, ok := getUserID(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
@@ -41,8 +45,51 @@ func TrainModel(c *gin.Context) {
 
 	// Get sensor data for CSV
 	query := config.DB.Order("timestamp desc")
-	if user.Role != "admin" {
-		query = query.Where("user_id = ?", userID)
+
+	// A field or zone covers the devices in it the caller can see. Otherwise
+	// non-admins can only ever train on their own data.
+	level, nodeID := "", uint(0)
+	switch {
+	case req.FieldID != nil && req.ZoneID != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Choose a field or a zone, not both"})
+		return
+	case req.FieldID != nil:
+		level, nodeID = models.LevelField, *req.FieldID
+	case req.ZoneID != nil:
+		level, nodeID = models.LevelZone, *req.ZoneID
+	}
+	if level != "" {
+		keys, err := utils.NodeDevices(config.DB, user, level, nodeID)
+		if errors.Is(err, utils.ErrNoNodeAccess) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Field or zone not found"})
+			return
+		}
+		query = query.Scopes(utils.ReadingsOf(keys))
+	}
+	if !utils.CanManageAny(user, models.ResourceAIModels) {
+		req.UserIDs = nil
+		if level == "" {
+			req.UserIDs = []uint{userID}
+		}
+	}
+	if len(req.UserIDs) > 0 {
+		query = query.Where("user_id IN ?", req.UserIDs)
+	}
+	if len(req.DeviceIDs) > 0 {
+		query = query.Where("device_id IN ?", req.DeviceIDs)
+	}
+	if req.StartTime != nil {
+		query = query.Where("timestamp >= ?", *req.StartTime)
+	}
+	if req.EndTime != nil {
+		query = query.Where("timestamp < ?", *req.EndTime)
+	}
+	if req.ExcludeAbnormal {
+		query = query.Where("is_abnormal = ?", false)
 	}
 
 	var records []models.SensorData
@@ -51,57 +98,149 @@ func TrainModel(c *gin.Context) {
 		return
 	}
 
+	// Only keep rows that were actually collected for this plant
+	audits, err := utils.PlantAudits(config.DB, records)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve plant assignments"})
+		return
+	}
+	records = utils.FilterByPlant(records, audits, req.PlantName, req.IncludeUnassigned)
+
+	if req.ExcludeOutliers {
+		if req.OutlierZScore == 0 {
+			req.OutlierZScore = 3
+		}
+		records = utils.ExcludeOutliers(records, req.OutlierZScore)
+	}
+
 	if len(records) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No sensor data available for training"})
 		return
 	}
 
+	train, validation, err := utils.SplitTrainingData(records, req.ValidationSplit, req.SplitPolicy, req.SplitSeed)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Create CSV data in memory
-	csvData, err := createCSVData(records)
+	csvData, err := createCSVData(train, validation, req.ValidationSplit > 0)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create CSV data"})
 		return
 	}
 
+	run := newTrainingRun(userID, req, train, validation, csvData)
+
 	// Send data to Python training service
 	response, err := sendTrainingRequest(req.PlantName, csvData)
 	if err != nil {
+		run.Error = err.Error()
+		config.DB.Create(&run)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to train model",
-			"details": err.Error(),
+			"error":           "Failed to train model",
+			"details":         err.Error(),
+			"training_run_id": run.ID,
 		})
 		return
 	}
 
+	run.Success = response.Success
+	run.ModelPath = response.ModelPath
+	run.BestModel = response.BestModel
+	run.R2Score = response.R2Score
+	run.RMSE = response.RMSE
+	run.MAE = response.MAE
+	if err := config.DB.Create(&run).Error; err != nil {
+		fmt.Println("❌ Failed to record training run:", err)
+	}
+
 	// Return the training response
 	c.JSON(http.StatusOK, gin.H{
 		"message":       "Model training completed",
 		"plant_name":    req.PlantName,
 		"training_data": response,
+		"training_run":  run,
 	})
 }
 
-// createCSVData converts sensor records to CSV format
-func createCSVData(records []models.SensorData) ([]byte, error) {
+// newTrainingRun captures the dataset snapshot of a training request.
+func newTrainingRun(userID uint, req models.TrainModelRequest, train, validation []models.SensorData, csvData []byte) models.TrainingRun {
+	filters, _ := json.Marshal(req)
+	checksum := sha256.Sum256(csvData)
+
+	run := models.TrainingRun{
+		PlantName:       req.PlantName,
+		RequestedBy:     userID,
+		Filters:         string(filters),
+		RowCount:        len(train) + len(validation),
+		TrainCount:      len(train),
+		ValidationCount: len(validation),
+		TrainIDs:        recordIDs(train),
+		ValidationIDs:   recordIDs(validation),
+		Checksum:        hex.EncodeToString(checksum[:]),
+		CreatedAt:       time.Now(),
+	}
+
+	for i, record := range append(append([]models.SensorData(nil), train...), validation...) {
+		if i == 0 || record.Timestamp.Before(run.DataFrom) {
+			run.DataFrom = record.Timestamp
+		}
+		if record.Timestamp.After(run.DataTo) {
+			run.DataTo = record.Timestamp
+		}
+	}
+	return run
+}
+
+func recordIDs(records []models.SensorData) string {
+	ids := make([]uint, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	encoded, _ := json.Marshal(ids)
+	return string(encoded)
+}
+
+// createCSVData converts sensor records to CSV format. When withSplit is set a
+// "split" column marks each row as train or validation.
+func createCSVData(train, validation []models.SensorData, withSplit bool) ([]byte, error) {
 	var buf bytes.Buffer
 	writer := csv.NewWriter(&buf)
 
 	// Write CSV header
-	if err := writer.Write([]string{"timestamp", "temperature", "humidity", "soil_moisture"}); err != nil {
+	header := []string{"timestamp", "temperature", "humidity", "soil_moisture"}
+	if withSplit {
+		header = append(header, "split")
+	}
+	if err := writer.Write(header); err != nil {
 		return nil, err
 	}
 
 	// Write data rows
-	for _, record := range records {
-		row := []string{
-			record.Timestamp.Format("2006-01-02 15:04:05"),
-			fmt.Sprintf("%.2f", record.Temperature),
-			fmt.Sprintf("%.2f", record.Humidity),
-			fmt.Sprintf("%.2f", record.SoilMoisture),
-		}
-		if err := writer.Write(row); err != nil {
-			return nil, err
+	writeRows := func(records []models.SensorData, split string) error {
+		for _, record := range records {
+			row := []string{
+				record.Timestamp.Format("2006-01-02 15:04:05"),
+				fmt.Sprintf("%.2f", record.Temperature),
+				fmt.Sprintf("%.2f", record.Humidity),
+				fmt.Sprintf("%.2f", record.SoilMoisture),
+			}
+			if withSplit {
+				row = append(row, split)
+			}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
+	if err := writeRows(train, "train"); err != nil {
+		return nil, err
+	}
+	if err := writeRows(validation, "validation"); err != nil {
+		return nil, err
 	}
 
 	writer.Flush()
@@ -112,6 +251,34 @@ func createCSVData(records []models.SensorData) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// GetTrainingRuns lists recorded training runs, optionally filtered by plant_name.
+// Non-admins only see their own runs.
+func GetTrainingRuns(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var user models.User
+	config.DB.First(&user, userID)
+
+	query := config.DB.Order("created_at desc")
+	if !utils.CanManageAny(user, models.ResourceAIModels) {
+		query = query.Where("requested_by = ?", userID)
+	}
+	if plantName := c.Query("plant_name"); plantName != "" {
+		query = query.Where("plant_name = ?", plantName)
+	}
+
+	var runs []models.TrainingRun
+	if err := query.Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch training runs"})
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
+
 // sendTrainingRequest sends the plant name and CSV data to Python training service
 func sendTrainingRequest(plantName string, csvData []byte) (*models.TrainModelResponse, error) {
 	// Python training service URL (adjust as needed)
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPlantAuditsCoverOnlyTheTrainedDevices(t *testing.T) {
+	testDB(t)
+	start := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	audits := []models.AIConfigAudit{
+		{UserID: 1, DeviceID: "probe-1", PlantName: "tomato", ChangedAt: start},
+		{UserID: 1, DeviceID: "probe-2", PlantName: "tomato", ChangedAt: start},
+		{UserID: 2, DeviceID: "probe-1", PlantName: "tomato", ChangedAt: start},
+		{UserID: 1, DeviceID: "probe-1", PlantName: "basil", ChangedAt: start.Add(24 * time.Hour)},
+	}
+	for i := range audits {
+		if err := config.DB.Create(&audits[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	records := []models.SensorData{
+		{UserID: 1, DeviceID: "probe-1", Timestamp: start.Add(time.Hour)},
+		{UserID: 1, DeviceID: "probe-1", Timestamp: start.Add(2 * time.Hour)},
+	}
+
+	loaded, err := utils.PlantAudits(config.DB, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0].ID != audits[0].ID {
+		t.Fatalf("loaded %+v, want only probe-1's change before its readings", loaded)
+	}
+	if kept := utils.FilterByPlant(records, loaded, "tomato", false); len(kept) != 2 {
+		t.Fatalf("FilterByPlant kept %d of 2 tomato readings", len(kept))
+	}
+
+	if loaded, err := utils.PlantAudits(config.DB, nil); err != nil || len(loaded) != 0 {
+		t.Fatalf("PlantAudits without records = %v, %v; want none", loaded, err)
+	}
+}
+
+func TestTrainModelOnAField(t *testing.T) {
+	testDB(t)
+	trainer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.TrainModelResponse{Success: true, BestModel: "ridge"})
+	}))
+	defer trainer.Close()
+	t.Setenv("PYTHON_TRAINING_SERVICE_URL", trainer.URL)
+
+	owner := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	outsider := createTestUser(t, "outsider", "outsider@example.com", "Outsider-password-1")
+	field, zone := testField(t, owner)
+	if err := config.DB.Create(&models.Device{UserID: owner.ID, DeviceID: "probe-1", ZoneID: &zone.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Hour)
+	for i, deviceID := range []string{"probe-1", "probe-1", "probe-2"} {
+		reading := models.SensorData{UserID: owner.ID, DeviceID: deviceID, Temperature: 20, Humidity: 60, SoilMoisture: 30, Timestamp: start.Add(time.Duration(i) * time.Minute)}
+		if err := config.DB.Create(&reading).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := gin.H{"plant_name": "tomato", "field_id": field.ID, "include_unassigned": true}
+	expectStatus(t, serve(TrainModel, http.MethodPost, "/train-model", req, &outsider), http.StatusForbidden)
+	expectStatus(t, serve(TrainModel, http.MethodPost, "/train-model", req, &owner), http.StatusOK)
+
+	var run models.TrainingRun
+	if err := config.DB.First(&run).Error; err != nil {
+		t.Fatal(err)
+	}
+	if run.RowCount != 2 {
+		t.Fatalf("trained on %d readings, want the 2 from the field's device", run.RowCount)
+	}
+	var filters models.TrainModelRequest
+	if err := json.Unmarshal([]byte(run.Filters), &filters); err != nil || filters.FieldID == nil || *filters.FieldID != field.ID {
+		t.Fatalf("snapshot filters %s do not record the field", run.Filters)
+	}
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// sensorDataWhere selects the readings matching a condition.
+func sensorDataWhere(query string, args ...interface{}) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(query, args...)
+	}
+}
+
+// trashReadings moves the readings matching scopes to the trash under a new
+// batch and records the deletion in the audit log in the same transaction.
+// With no scopes every reading is trashed.
+func trashReadings(c *gin.Context, action, targetType string, targetID, before interface{}, scopes ...func(*gorm.DB) *gorm.DB) (string, int64, error) {
+	batch, err := utils.NewTrashBatch()
+	if err != nil {
+		return "", 0, err
+	}
+	var count int64
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if count, err = utils.TrashSensorData(tx, batch, scopes...); err != nil {
+			return err
+		}
+		return recordAudit(c, tx, action, targetType, targetID, before, gin.H{"trash_batch": batch, "records": count})
+	})
+	return batch, count, err
+}
+
+// GetTrash lists the caller's deleted sensor data awaiting restore or purge.
+// Pass user_id for the trash of another user whose data the caller may
+// delete; admins see every user's trash by default.
+func GetTrash(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	userIDs := []uint{user.ID}
+	if value := c.Query("user_id"); value != "" {
+		var ownerID uint
+		if _, err := fmt.Sscan(value, &ownerID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "user_id must be a user ID"})
+			return
+		}
+		if !utils.CanAccessUserData(config.DB, user, ownerID, utils.AccessWrite) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to see this user's trash"})
+			return
+		}
+		userIDs = []uint{ownerID}
+	} else if utils.CanManageAny(user, models.ResourceSensorData) {
+		userIDs = nil
+	}
+
+	retention := config.GetTrashRetention()
+	trash, err := utils.ListTrash(config.DB, userIDs, retention)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"trash": trash, "retention_hours": retention.Hours()})
+}
+
+// RestoreTrash brings back everything one deletion moved to the trash. The
+// caller must be allowed to delete the data of every user in it, and to
+// delete accounts if it holds one.
+func RestoreTrash(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	batch := c.Param("batch")
+
+	owners, err := utils.TrashBatchUsers(config.DB, batch)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+		return
+	}
+	if len(owners) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Nothing in the trash under this batch"})
+		return
+	}
+	for _, ownerID := range owners {
+		if !utils.CanAccessUserData(config.DB, user, ownerID, utils.AccessWrite) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to restore this data"})
+			return
+		}
+	}
+	var accounts int64
+	config.DB.Unscoped().Model(&models.User{}).Where("trash_batch = ?", batch).Count(&accounts)
+	if accounts > 0 && !utils.HasPermission(user.Role, models.NewPermission(models.ResourceUsers, models.ActionDelete)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can restore deleted accounts"})
+		return
+	}
+
+	records, users, err := restoreBatch(c, batch)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Restored from the trash", "restored_records": records, "restored_users": users})
+}
+
+// RestoreUserAccount brings back a deleted account with the data deleted
+// along with it (requires users:delete).
+func RestoreUserAccount(c *gin.Context) {
+	var user models.User
+	if err := config.DB.Unscoped().Where("deleted_at IS NOT NULL").First(&user, c.Param("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No deleted account with this ID"})
+		return
+	}
+
+	records, _, err := restoreBatch(c, user.TrashBatch)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore account"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":          fmt.Sprintf("Restored user account '%s'", user.Username),
+		"restored_records": records,
+	})
+}
+
+// GetTrashedUsers lists deleted accounts awaiting restore or purge (requires users:delete).
+func GetTrashedUsers(c *gin.Context) {
+	users, err := utils.ListTrashedUsers(config.DB, config.GetTrashRetention())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deleted accounts"})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+func restoreBatch(c *gin.Context, batch string) (records int64, users int64, err error) {
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if records, users, err = utils.RestoreTrash(tx, batch); err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditTrashRestore, models.AuditTargetTrash, batch, nil,
+			gin.H{"records": records, "users": users})
+	})
+	return records, users, err
+}
+
+// StartTrashPurge periodically deletes for good whatever has been in the
+// trash longer than the retention period.
+func StartTrashPurge(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			cutoff := time.Now().Add(-config.GetTrashRetention())
+			if _, _, err := utils.PurgeTrash(config.DB, cutoff); err != nil {
+				fmt.Println("❌ Trash purge failed:", err)
+			}
+		}
+	}()
+}
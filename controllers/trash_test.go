@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testReadings saves n readings for a user.
+func testReadings(t *testing.T, user models.User, n int) []models.SensorData {
+	t.Helper()
+	readings := make([]models.SensorData, n)
+	for i := range readings {
+		readings[i] = models.SensorData{UserID: user.ID, DeviceID: "probe-1", Temperature: 22, Humidity: 60, SoilMoisture: 40,
+			Timestamp: time.Now().Add(-time.Duration(i) * time.Hour)}
+		if err := config.DB.Create(&readings[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return readings
+}
+
+func readingCount(userID uint) int64 {
+	var count int64
+	config.DB.Model(&models.SensorData{}).Where("user_id = ?", userID).Count(&count)
+	return count
+}
+
+func TestDeletedReadingsCanBeRestored(t *testing.T) {
+	testDB(t)
+	owner := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	outsider := createTestUser(t, "outsider", "outsider@example.com", "Outsider-password-1")
+	testReadings(t, owner, 3)
+
+	recorder := serve(DeleteMyRecords, http.MethodDelete, "/delete/my-records", nil, &owner)
+	expectStatus(t, recorder, http.StatusOK)
+	batch, _ := decode(t, recorder)["trash_batch"].(string)
+	if batch == "" || readingCount(owner.ID) != 0 {
+		t.Fatalf("after deleting, %d readings are visible under batch %q; want none under a batch", readingCount(owner.ID), batch)
+	}
+
+	var trashed int64
+	config.DB.Unscoped().Model(&models.SensorData{}).Where("trash_batch = ? AND deleted_at IS NOT NULL", batch).Count(&trashed)
+	if trashed != 3 {
+		t.Fatalf("%d readings in the trash under %s, want 3", trashed, batch)
+	}
+
+	param := gin.Param{Key: "batch", Value: batch}
+	expectStatus(t, serve(RestoreTrash, http.MethodPost, "/trash/"+batch+"/restore", nil, &outsider, param), http.StatusForbidden)
+	expectStatus(t, serve(RestoreTrash, http.MethodPost, "/trash/"+batch+"/restore", nil, &owner, param), http.StatusOK)
+	if readingCount(owner.ID) != 3 {
+		t.Fatalf("%d readings after restoring, want 3", readingCount(owner.ID))
+	}
+	expectStatus(t, serve(RestoreTrash, http.MethodPost, "/trash/"+batch+"/restore", nil, &owner, param), http.StatusNotFound)
+}
+
+func TestDeletedAccountIsRestoredWithItsData(t *testing.T) {
+	testDB(t)
+	admin := createTestUser(t, "admin", "admin@example.com", "Admin-password-1")
+	admin.Role = models.RoleAdmin
+	member := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	testReadings(t, member, 2)
+	id := gin.Param{Key: "user_id", Value: strconv.Itoa(int(member.ID))}
+
+	expectStatus(t, serve(DeleteUserAccount, http.MethodDelete, "/admin/delete-user/1", nil, &admin, id), http.StatusOK)
+	if err := config.DB.First(&models.User{}, member.ID).Error; err == nil || readingCount(member.ID) != 0 {
+		t.Fatal("the deleted account or its readings are still visible")
+	}
+
+	expectStatus(t, serve(RestoreUserAccount, http.MethodPost, "/admin/users/1/restore", nil, &admin, id), http.StatusOK)
+	if err := config.DB.First(&models.User{}, member.ID).Error; err != nil || readingCount(member.ID) != 2 {
+		t.Fatalf("after restoring, account lookup = %v with %d readings; want the account and 2 readings", err, readingCount(member.ID))
+	}
+}
+
+func TestPurgeTrashDeletesOnlyExpiredTrash(t *testing.T) {
+	testDB(t)
+	owner := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	readings := testReadings(t, owner, 2)
+	soil := float32(45)
+	if _, _, err := utils.CorrectReading(config.DB, readings[0].ID, models.CorrectReadingRequest{SoilMoisture: &soil, Reason: "recalibrated"}, owner.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, reading := range readings {
+		batch := "batch-" + strconv.Itoa(i)
+		if _, err := utils.TrashSensorData(config.DB, batch, sensorDataWhere("id = ?", reading.ID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first deletion is past the retention period
+	config.DB.Unscoped().Model(&models.SensorData{}).Where("trash_batch = ?", "batch-0").Update("deleted_at", time.Now().Add(-48*time.Hour))
+
+	records, users, err := utils.PurgeTrash(config.DB, time.Now().Add(-24*time.Hour))
+	if err != nil || records != 1 || users != 0 {
+		t.Fatalf("PurgeTrash = %d records, %d users, %v; want 1 record", records, users, err)
+	}
+	var remaining, revisions int64
+	config.DB.Unscoped().Model(&models.SensorData{}).Where("user_id = ?", owner.ID).Count(&remaining)
+	config.DB.Model(&models.ReadingRevision{}).Where("sensor_data_id = ?", readings[0].ID).Count(&revisions)
+	if remaining != 1 || revisions != 0 {
+		t.Fatalf("%d readings and %d revisions of the purged reading left, want 1 and 0", remaining, revisions)
+	}
+	if restored, _, err := utils.RestoreTrash(config.DB, "batch-1"); err != nil || restored != 1 {
+		t.Fatalf("RestoreTrash of the recent deletion = %d, %v; want 1", restored, err)
+	}
+}
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// LoginTwoFactor completes a login that Login answered with a challenge,
+// using a TOTP code or a recovery code.
+func LoginTwoFactor(c *gin.Context) {
+	var req models.TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "challenge_token and a code or recovery_code are required"})
+		return
+	}
+	claims, err := utils.ParseTwoFactorChallenge(req.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge, please log in again"})
+		return
+	}
+	var user models.User
+	if err := config.DB.First(&user, claims.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge, please log in again"})
+		return
+	}
+
+	// Code guesses share the login throttle and lockout with passwords
+	if !checkCallerSecret(c, user, models.LoginFailedTwoFactor, twoFactorCheck(user.ID, req.Code, req.RecoveryCode)) {
+		return
+	}
+	completeLogin(c, user)
+}
+
+// twoFactorCheck checks a TOTP or recovery code for checkCallerSecret.
+func twoFactorCheck(userID uint, code, recovery string) func() (bool, error) {
+	return func() (bool, error) {
+		err := utils.VerifyTwoFactor(config.DB, userID, code, recovery)
+		if errors.Is(err, utils.ErrInvalidTwoFactor) || errors.Is(err, utils.ErrTwoFactorNotEnabled) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+}
+
+// GetTwoFactorStatus reports whether the caller has 2FA enabled and whether
+// an organisation requires it of them.
+func GetTwoFactorStatus(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  utils.TwoFactorEnabled(config.DB, user.ID),
+		"recovery_codes_remaining": utils.RemainingRecoveryCodes(config.DB, user.ID),
+		"required_by":              utils.OrgsRequiringTwoFactor(config.DB, user.ID),
+	})
+}
+
+// SetupTwoFactor starts enrolment and returns the secret and the otpauth://
+// URI to show as a QR code. It takes effect once confirmed.
+func SetupTwoFactor(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if utils.TwoFactorEnabled(config.DB, user.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled, disable it first"})
+		return
+	}
+	secret, err := utils.BeginTwoFactorSetup(config.DB, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start two-factor setup"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": utils.TOTPProvisioningURI(utils.TOTPIssuer(), user.Email, secret),
+	})
+}
+
+// ConfirmTwoFactor enables 2FA with a code from the authenticator app and
+// returns the recovery codes, which are only shown this once.
+func ConfirmTwoFactor(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+	if utils.TwoFactorEnabled(config.DB, user.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	codes, err := utils.ConfirmTwoFactor(config.DB, user.ID, req.Code)
+	switch {
+	case errors.Is(err, utils.ErrTwoFactorNotEnabled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start two-factor setup first"})
+		return
+	case errors.Is(err, utils.ErrInvalidTwoFactor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled", "recovery_codes": codes})
+}
+
+// verifyCallerTwoFactor checks a TOTP or recovery code from the caller under
+// the login throttle and lockout. It writes the error response and returns
+// false if the code is wrong.
+func verifyCallerTwoFactor(c *gin.Context, user models.User) bool {
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return false
+	}
+	code, recovery := req.Code, ""
+	if strings.Contains(code, "-") {
+		code, recovery = "", req.Code
+	}
+	if !utils.TwoFactorEnabled(config.DB, user.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return false
+	}
+	return checkCallerSecret(c, user, models.LoginFailedTwoFactor, twoFactorCheck(user.ID, code, recovery))
+}
+
+// StepUpHeader carries a current TOTP or recovery code on the routes guarded
+// by RequireTwoFactorStepUp.
+const StepUpHeader = "X-2FA-Code"
+
+// RequireTwoFactorStepUp guards the admin routes that destroy data or hand
+// out roles: the caller must have 2FA enabled and send a current code in
+// StepUpHeader, checked under the login throttle and lockout, so a stolen
+// admin session alone cannot wipe the database.
+func RequireTwoFactorStepUp(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		c.Abort()
+		return
+	}
+	if !utils.TwoFactorEnabled(config.DB, user.ID) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Enable two-factor authentication to do this"})
+		return
+	}
+	code, recovery := strings.TrimSpace(c.GetHeader(StepUpHeader)), ""
+	if code == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "A two-factor code is required in " + StepUpHeader, "two_factor_required": true})
+		return
+	}
+	if strings.Contains(code, "-") {
+		code, recovery = "", code
+	}
+	if !checkCallerSecret(c, user, models.LoginFailedTwoFactor, twoFactorCheck(user.ID, code, recovery)) {
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// RegenerateRecoveryCodes replaces the caller's recovery codes.
+func RegenerateRecoveryCodes(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok || !verifyCallerTwoFactor(c, user) {
+		return
+	}
+	codes, err := utils.ReplaceRecoveryCodes(config.DB, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableTwoFactor turns 2FA off for the caller. Organisations that require
+// it stop treating them as an owner or manager.
+func DisableTwoFactor(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok || !verifyCallerTwoFactor(c, user) {
+		return
+	}
+	if err := utils.DisableTwoFactor(config.DB, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// ResetUserTwoFactor removes a user's 2FA when they have lost both their
+// authenticator and recovery codes (requires users:manage).
+func ResetUserTwoFactor(c *gin.Context) {
+	var user models.User
+	if err := config.DB.First(&user, c.Param("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		before := gin.H{"enabled": utils.TwoFactorEnabled(tx, user.ID)}
+		if err := utils.DisableTwoFactor(tx, user.ID); err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditUserTwoFactorReset, models.AuditTargetUser, user.ID, before, gin.H{"enabled": false})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset two-factor authentication"})
+		return
+	}
+	if err := utils.RevokeUserSessions(config.DB, user.ID); err != nil {
+		fmt.Println("❌ Failed to revoke sessions:", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication reset"})
+}
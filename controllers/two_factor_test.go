@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"fyp/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stepUp calls a route behind RequireTwoFactorStepUp as the signed-in user.
+func stepUp(user models.User, code string) *httptest.ResponseRecorder {
+	r := gin.New()
+	signIn := func(c *gin.Context) {
+		c.Set("user_id", user.ID)
+		c.Set("user", user)
+	}
+	r.DELETE("/delete/all", signIn, RequireTwoFactorStepUp, func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodDelete, "/delete/all", nil)
+	if code != "" {
+		request.Header.Set(StepUpHeader, code)
+	}
+	r.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestDestructiveAdminRoutesNeedTwoFactorStepUp(t *testing.T) {
+	testDB(t)
+	admin := createTestUser(t, "admin", "admin@example.com", "password123")
+	admin.Role = models.RoleAdmin
+
+	expectStatus(t, stepUp(admin, "123456"), http.StatusForbidden)
+
+	recoveryCodes := enableTwoFactor(t, admin)
+	recorder := stepUp(admin, "")
+	expectStatus(t, recorder, http.StatusUnauthorized)
+	if decode(t, recorder)["two_factor_required"] != true {
+		t.Fatalf("missing code should ask for two-factor: %s", recorder.Body.String())
+	}
+	expectStatus(t, stepUp(admin, "000000"), http.StatusUnauthorized)
+
+	expectStatus(t, stepUp(admin, recoveryCodes[0]), http.StatusNoContent)
+	expectStatus(t, stepUp(admin, recoveryCodes[0]), http.StatusUnauthorized)
+}
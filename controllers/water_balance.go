@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSoilProfile returns the soil profile used for a device's water balance.
+func GetSoilProfile(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	c.JSON(http.StatusOK, utils.SoilProfileFor(config.DB, userID, c.Param("device_id")))
+}
+
+// UpdateSoilProfile creates or replaces the soil profile of a device.
+func UpdateSoilProfile(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	params, ok := bindSoilParameters(c)
+	if !ok {
+		return
+	}
+
+	deviceID := c.Param("device_id")
+	profile := models.SoilProfile{SoilParameters: params, UpdatedAt: time.Now()}
+	var stored models.SoilProfile
+	if err := config.DB.Where(models.SoilProfile{UserID: userID, DeviceID: deviceID}).
+		Assign(profile).FirstOrCreate(&stored).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save soil profile"})
+		return
+	}
+	c.JSON(http.StatusOK, stored)
+}
+
+// bindSoilParameters reads and validates soil parameters from the request
+// body, writing the error response on failure.
+func bindSoilParameters(c *gin.Context) (models.SoilParameters, bool) {
+	var params models.SoilParameters
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid soil profile"})
+		return params, false
+	}
+	if err := utils.ValidateSoilProfile(params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return params, false
+	}
+	return params, true
+}
+
+// GetWaterBalance recomputes and returns a device's daily root-zone water
+// balance for the last days (default 14).
+func GetWaterBalance(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	days, ok := balanceDays(c)
+	if !ok {
+		return
+	}
+
+	deviceID := c.Param("device_id")
+	balance, err := utils.ComputeWaterBalance(config.DB, userID, deviceID, days)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to compute water balance", "details": err.Error()})
+		return
+	}
+
+	profile := utils.SoilProfileFor(config.DB, userID, deviceID)
+	response := gin.H{"profile": profile, "days": balance}
+	if len(balance) > 0 {
+		latest := balance[len(balance)-1]
+		response["depletion_mm"] = latest.Depletion
+		response["readily_available_mm"] = latest.RAW
+		response["irrigation_needed"] = latest.IrrigationNeeded
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// balanceDays reads the days query parameter (default 14), writing the error
+// response when it is out of range.
+func balanceDays(c *gin.Context) (int, bool) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "14"))
+	if err != nil || days < 1 || days > utils.MaxWaterBalanceDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 90"})
+		return 0, false
+	}
+	return days, true
+}
+
+// visibleField loads a field the caller can see any part of, writing the
+// error response on failure.
+func visibleField(c *gin.Context, user models.User) (models.Field, bool) {
+	var field models.Field
+	if err := config.DB.First(&field, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Field not found"})
+		return field, false
+	}
+	if ok, err := utils.CanViewNode(config.DB, user, models.LevelField, field.ID); err != nil || !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return field, false
+	}
+	return field, true
+}
+
+// GetFieldSoilProfile returns the soil profile used for a field's water balance.
+func GetFieldSoilProfile(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	field, ok := visibleField(c, user)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, utils.FieldSoilProfileFor(config.DB, field))
+}
+
+// UpdateFieldSoilProfile creates or replaces the soil profile of a field of a
+// farm the caller owns.
+func UpdateFieldSoilProfile(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	var field models.Field
+	if err := config.DB.First(&field, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Field not found"})
+		return
+	}
+	if _, ok := ownedFarm(c, user, field.FarmID); !ok {
+		return
+	}
+	params, ok := bindSoilParameters(c)
+	if !ok {
+		return
+	}
+
+	profile := models.FieldSoilProfile{SoilParameters: params, UpdatedAt: time.Now()}
+	var stored models.FieldSoilProfile
+	if err := config.DB.Where(models.FieldSoilProfile{FieldID: field.ID}).
+		Assign(profile).FirstOrCreate(&stored).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save soil profile"})
+		return
+	}
+	c.JSON(http.StatusOK, stored)
+}
+
+// GetFieldWaterBalance returns a field's daily root-zone water balance for the
+// last days (default 14), pooled over the devices in the field the caller can
+// see, under the field's soil profile.
+func GetFieldWaterBalance(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	days, ok := balanceDays(c)
+	if !ok {
+		return
+	}
+	field, ok := visibleField(c, user)
+	if !ok {
+		return
+	}
+
+	keys, err := utils.NodeDevices(config.DB, user, models.LevelField, field.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load the field's devices"})
+		return
+	}
+	balance, err := utils.ComputeFieldWaterBalance(config.DB, field, keys, days)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to compute water balance", "details": err.Error()})
+		return
+	}
+
+	response := gin.H{"field_id": balance.FieldID, "devices": balance.Devices, "profile": balance.Profile, "days": balance.Days}
+	if len(balance.Days) > 0 {
+		latest := balance.Days[len(balance.Days)-1]
+		response["depletion_mm"] = latest.Depletion
+		response["readily_available_mm"] = latest.RAW
+		response["irrigation_needed"] = latest.IrrigationNeeded
+	}
+	c.JSON(http.StatusOK, response)
+}
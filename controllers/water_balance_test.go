@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFieldWaterBalancePoolsItsDevices(t *testing.T) {
+	testDB(t)
+	owner := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	other := createTestUser(t, "neighbour", "neighbour@example.com", "Neighbour-password-1")
+	field, zone := testField(t, owner)
+	lat, lon := 52.0, -1.0
+	config.DB.Model(&models.Farm{}).Where("id = ?", field.FarmID).Updates(map[string]interface{}{"latitude": lat, "longitude": lon})
+
+	now := time.Now()
+	for deviceID, moisture := range map[string]float32{"probe-1": 20, "probe-2": 30} {
+		if err := config.DB.Create(&models.Device{UserID: owner.ID, DeviceID: deviceID, ZoneID: &zone.ID}).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, at := range []time.Time{now.Add(-time.Minute), now} {
+			reading := models.SensorData{UserID: owner.ID, DeviceID: deviceID, Timestamp: at, Temperature: 20, Humidity: 60, SoilMoisture: moisture}
+			if err := config.DB.Create(&reading).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Not in the field
+	config.DB.Create(&models.SensorData{UserID: owner.ID, DeviceID: "greenhouse", Timestamp: now, Temperature: 20, SoilMoisture: 90})
+
+	id := gin.Param{Key: "id", Value: strconv.FormatUint(uint64(field.ID), 10)}
+	profile := map[string]interface{}{"field_capacity": 40, "wilting_point": 10, "root_depth_m": 0.5, "depletion_fraction": 0.5, "area_m2": 100}
+	expectStatus(t, serve(UpdateFieldSoilProfile, http.MethodPut, "/fields/1/soil-profile", profile, &other, id), http.StatusForbidden)
+	expectStatus(t, serve(UpdateFieldSoilProfile, http.MethodPut, "/fields/1/soil-profile", profile, &owner, id), http.StatusOK)
+
+	expectStatus(t, serve(GetFieldWaterBalance, http.MethodGet, "/fields/1/water-balance?days=1", nil, &other, id), http.StatusForbidden)
+	recorder := serve(GetFieldWaterBalance, http.MethodGet, "/fields/1/water-balance?days=1", nil, &owner, id)
+	expectStatus(t, recorder, http.StatusOK)
+	body := decode(t, recorder)
+
+	if devices, _ := body["devices"].([]interface{}); len(devices) != 2 {
+		t.Fatalf("devices = %v, want the field's two devices", body["devices"])
+	}
+	if fc := body["profile"].(map[string]interface{})["field_capacity"]; fc != 40.0 {
+		t.Fatalf("profile field_capacity = %v, want the field's 40", fc)
+	}
+	days, _ := body["days"].([]interface{})
+	if len(days) != 1 {
+		t.Fatalf("days = %v, want today", body["days"])
+	}
+	day := days[0].(map[string]interface{})
+	if day["soil_moisture_mean"] != 25.0 {
+		t.Fatalf("soil_moisture_mean = %v, want 25 pooled over both devices", day["soil_moisture_mean"])
+	}
+	// (40 - 25)% of a 0.5 m root zone, before the day's ETc
+	if depletion, _ := day["depletion"].(float64); depletion < 75 || day["taw"] != 150.0 {
+		t.Fatalf("depletion = %v of taw %v, want at least 75 of 150", day["depletion"], day["taw"])
+	}
+}
+
+func TestDeviceSoilProfileKeepsItsShape(t *testing.T) {
+	testDB(t)
+	owner := createTestUser(t, "grower", "grower@example.com", "Grower-password-1")
+	device := gin.Param{Key: "device_id", Value: "probe-1"}
+
+	profile := map[string]interface{}{"field_capacity": 40, "wilting_point": 10, "root_depth_m": 0.5, "depletion_fraction": 0.5}
+	expectStatus(t, serve(UpdateSoilProfile, http.MethodPut, "/water-balance/probe-1/profile", profile, &owner, device), http.StatusOK)
+	profile["wilting_point"] = 45
+	expectStatus(t, serve(UpdateSoilProfile, http.MethodPut, "/water-balance/probe-1/profile", profile, &owner, device), http.StatusBadRequest)
+
+	body := decode(t, serve(GetSoilProfile, http.MethodGet, "/water-balance/probe-1/profile", nil, &owner, device))
+	if body["device_id"] != "probe-1" || body["field_capacity"] != 40.0 || body["wilting_point"] != 10.0 {
+		t.Fatalf("profile = %v, want the stored one with its fields at the top level", body)
+	}
+}
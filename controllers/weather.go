@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"fyp/config"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetCurrentWeather returns current outdoor conditions at a device's location.
+func GetCurrentWeather(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	lat, lon, err := utils.DeviceCoordinates(config.DB, userID, c.Param("device_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	weather, err := utils.CurrentWeather(config.DB, lat, lon)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Weather unavailable", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, weather)
+}
+
+// GetWeatherForecast returns the hourly weather forecast at a device's
+// location for the next hours (default 24).
+func GetWeatherForecast(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	hours, err := strconv.Atoi(c.DefaultQuery("hours", "24"))
+	if err != nil || hours < 1 || hours > utils.MaxForecastHours {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hours must be between 1 and 48"})
+		return
+	}
+
+	lat, lon, err := utils.DeviceCoordinates(config.DB, userID, c.Param("device_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	forecast, err := utils.ForecastWeather(config.DB, lat, lon, hours)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Weather forecast unavailable", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, forecast)
+}
@@ -2,12 +2,15 @@ package controllers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"fyp/config"
 	"fyp/models"
+	"fyp/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -22,9 +25,36 @@ var wsUpgrader = websocket.Upgrader{
 type Client struct {
 	Conn   *websocket.Conn
 	UserID uint
+
+	writeMu sync.Mutex // A connection takes one writer at a time
+}
+
+// write sends a message on the client's connection, waiting for any other
+// write in progress.
+func (c *Client) write(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.Conn.WriteMessage(messageType, data)
 }
 
-var clients = make(map[*websocket.Conn]Client)
+// clients are the open WebSocket connections. Broadcasts come from request
+// handlers and background goroutines alike, so clientsMu guards the map.
+var (
+	clients   = make(map[*websocket.Conn]*Client)
+	clientsMu sync.RWMutex
+)
+
+// connectedClients returns the clients connected right now.
+func connectedClients() []*Client {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	connected := make([]*Client, 0, len(clients))
+	for _, client := range clients {
+		connected = append(connected, client)
+	}
+	return connected
+}
 
 func HandleWebSocket(c *gin.Context) {
 	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
@@ -57,10 +87,13 @@ func HandleWebSocket(c *gin.Context) {
 		return
 	}
 
-	clients[conn] = Client{
+	client := &Client{
 		Conn:   conn,
 		UserID: userID,
 	}
+	clientsMu.Lock()
+	clients[conn] = client
+	clientsMu.Unlock()
 	conn.SetReadLimit(512)
 	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	conn.SetPongHandler(func(string) error {
@@ -72,13 +105,15 @@ func HandleWebSocket(c *gin.Context) {
 		defer ticker.Stop()
 		for {
 			<-ticker.C
-			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := client.write(websocket.PingMessage, nil); err != nil {
 				break
 			}
 		}
 	}()
 	defer func() {
+		clientsMu.Lock()
 		delete(clients, conn)
+		clientsMu.Unlock()
 		conn.Close()
 	}()
 
@@ -90,15 +125,26 @@ func HandleWebSocket(c *gin.Context) {
 	}
 }
 
-// BroadcastUpdate sends sensor data updates to all WebSocket clients.
+// BroadcastUpdate sends a sensor data update to the WebSocket clients of
+// every user who can see the device.
 func BroadcastUpdate(data models.SensorData) {
 	msg, _ := json.Marshal(data)
-	for client := range clients {
-		client.WriteMessage(websocket.TextMessage, msg)
+	audience := deviceAudience(data)
+	for _, client := range connectedClients() {
+		if audience[client.UserID] {
+			client.write(websocket.TextMessage, msg)
+		}
 	}
 }
+
+// BroadcastNotification alerts every user who can see the device, from its
+// owner up to the farm owner, about an abnormal reading.
 func BroadcastNotification(data models.SensorData) {
-	for _, client := range clients {
+	audience := deviceAudience(data)
+	for _, client := range connectedClients() {
+		if !audience[client.UserID] {
+			continue
+		}
 		// Query only for this user's abnormal count
 		var count int64
 		config.DB.Model(&models.SensorData{}).
@@ -112,6 +158,34 @@ func BroadcastNotification(data models.SensorData) {
 		}
 
 		msg, _ := json.Marshal(notification)
-		client.Conn.WriteMessage(websocket.TextMessage, msg)
+		client.write(websocket.TextMessage, msg)
+	}
+}
+
+// deviceAudience returns the set of users a reading is pushed to.
+func deviceAudience(data models.SensorData) map[uint]bool {
+	users, err := utils.DeviceAudience(config.DB, data.UserID, data.DeviceID)
+	if err != nil {
+		fmt.Println("❌ Failed to resolve device audience:", err)
+	}
+	audience := make(map[uint]bool, len(users))
+	for _, id := range users {
+		audience[id] = true
+	}
+	return audience
+}
+
+// NotifyIrrigationCommand pushes a newly queued irrigation command to the
+// owner's WebSocket connections so a connected device need not wait for its
+// next poll.
+func NotifyIrrigationCommand(command models.IrrigationCommand) {
+	msg, _ := json.Marshal(map[string]interface{}{
+		"type":    "irrigation_command",
+		"command": command,
+	})
+	for _, client := range connectedClients() {
+		if client.UserID == command.UserID {
+			client.write(websocket.TextMessage, msg)
+		}
 	}
 }
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fyp/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestNotificationsRaceConnectingClients(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/ws", func(c *gin.Context) { c.Set("user_id", uint(1)) }, HandleWebSocket)
+	server := httptest.NewServer(r)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	command := models.IrrigationCommand{ID: 1, UserID: 1, Action: models.IrrigationStart}
+	stop := make(chan struct{})
+	var notifiers sync.WaitGroup
+	// The rule scheduler, reading hook and schedule runner all notify at once
+	for i := 0; i < 3; i++ {
+		notifiers.Add(1)
+		go func() {
+			defer notifiers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					NotifyIrrigationCommand(command)
+				}
+			}
+		}()
+	}
+
+	// Meanwhile clients come and go, each seeing whole messages
+	for i := 0; i < 20; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, msg, err := conn.ReadMessage()
+		if err != nil || !strings.Contains(string(msg), `"irrigation_command"`) {
+			t.Fatalf("read %q, %v; want the notification", msg, err)
+		}
+		conn.Close()
+	}
+	close(stop)
+	notifiers.Wait()
+}
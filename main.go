@@ -3,11 +3,13 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
 	"fyp/config"
 	"fyp/controllers"
 	"fyp/middlewares"
 	"fyp/models"
+	"fyp/utils"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -37,6 +39,74 @@ func main() {
 		log.Fatalf("Failed to initialize developer mode state: %v", err)
 	}
 
+	// Load the per-device AI configuration from DB
+	if err := config.InitAIConfigState(config.DB); err != nil {
+		log.Fatalf("Failed to initialize AI configuration: %v", err)
+	}
+
+	// Load the token signing keys and lifetimes
+	if err := config.InitAuthSettings(); err != nil {
+		log.Fatalf("Failed to load token settings: %v", err)
+	}
+
+	// Send account email through SMTP_HOST, or to the log without it
+	if err := utils.InitMailer(); err != nil {
+		log.Fatalf("Failed to initialize mailer: %v", err)
+	}
+
+	// Keep deleted data restorable for TRASH_RETENTION
+	if err := config.InitTrashRetention(); err != nil {
+		log.Fatalf("Failed to initialize trash retention: %v", err)
+	}
+
+	// Select the weather provider from WEATHER_PROVIDER
+	if err := utils.InitWeatherProvider(); err != nil {
+		log.Fatalf("Failed to initialize weather provider: %v", err)
+	}
+
+	// Time out unacknowledged irrigation commands in the background
+	controllers.StartIrrigationMonitor(30 * time.Second)
+	controllers.StartIrrigationRuleScheduler(time.Minute)
+	controllers.StartIrrigationScheduleRunner(time.Minute)
+	controllers.StartTokenCleanup(time.Hour)
+	controllers.StartLimiterSweep(10 * time.Minute)
+	controllers.StartTrashPurge(time.Hour)
+	controllers.StartReportScheduler(time.Minute)
+
+	r, guard := setupRouter()
+
+	// Believe X-Forwarded-For only from our own proxies, or every per-IP
+	// limit and logged IP is whatever the client claims
+	proxies, err := utils.TrustedProxies()
+	if err != nil {
+		log.Fatalf("Failed to load trusted proxies: %v", err)
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("Failed to set trusted proxies: %v", err)
+	}
+
+	// Refuse to start with a route nobody decided the access rules for
+	if err := guard.Verify(r.Routes(), publicRoutes...); err != nil {
+		log.Fatal(err)
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	r.Run(":" + port)
+}
+
+// publicRoutes are the routes anyone may call without signing in.
+var publicRoutes = []string{
+	"POST /signup", "POST /login", "POST /login/2fa", "POST /token/refresh",
+	"GET /oidc/:slug/login", "GET /oidc/:slug/callback", "POST /oidc/exchange",
+	"POST /verify-email", "POST /verify-email/resend", "POST /email/confirm", "POST /password/forgot", "POST /password/reset",
+}
+
+// setupRouter registers every route, returning the guard that holds the
+// permission each protected route requires.
+func setupRouter() (*gin.Engine, *middlewares.Guard) {
 	// Set up Gin router with CORS configuration
 	r := gin.Default()
 	r.Use(cors.New(cors.Config{
@@ -48,40 +118,160 @@ func main() {
 
 	// Public routes
 
-	r.POST("/signup", controllers.Signup)
-	r.POST("/login", controllers.Login)
-	r.POST("/toggle-ai", controllers.ToggleAI)
+	// Public routes are throttled per client IP
+	loginLimit := middlewares.RateLimitByIP("login", utils.PerMinute(20, 10))
+	signupLimit := middlewares.RateLimitByIP("signup", utils.PerMinute(3, 5))
+	mailLimit := middlewares.RateLimitByIP("mail", utils.PerMinute(3, 5))
+	tokenLimit := middlewares.RateLimitByIP("token", utils.PerMinute(30, 30))
+
+	r.POST("/signup", signupLimit, controllers.Signup)
+	r.POST("/login", loginLimit, controllers.Login)
+	r.POST("/login/2fa", loginLimit, controllers.LoginTwoFactor)
+	r.POST("/token/refresh", tokenLimit, controllers.RefreshSession)
+	r.GET("/oidc/:slug/login", loginLimit, controllers.StartOIDCLogin)
+	r.GET("/oidc/:slug/callback", loginLimit, controllers.OIDCCallback)
+	r.POST("/oidc/exchange", tokenLimit, controllers.ExchangeOIDCLoginCode)
+	r.POST("/verify-email", tokenLimit, controllers.VerifyEmail)
+	r.POST("/verify-email/resend", mailLimit, controllers.ResendVerification)
+	r.POST("/email/confirm", tokenLimit, controllers.ConfirmEmailChange)
+	r.POST("/password/forgot", mailLimit, controllers.ForgotPassword)
+	r.POST("/password/reset", tokenLimit, controllers.ResetPassword)
 
 	// Protected routes using auth middleware
 	auth := r.Group("/")
 	auth.Use(middlewares.AuthMiddleware())
-	auth.GET("/ws", controllers.HandleWebSocket)
-	auth.POST("/promote-admin", controllers.PromoteToAdmin)
-	auth.POST("/promote-user", controllers.PromoteToUser)
-	auth.POST("/sensor-data", controllers.ReceiveData)
-	auth.POST("/device-config/esp32-001/stop-dev", controllers.StopDeveloperMode)
-	auth.POST("/device-config/esp32-001/trigger-dev", controllers.TriggerDeveloperMode)
-	auth.GET("/history", controllers.GetHistory)
-	auth.GET("/users", controllers.GetUsers)
-	auth.GET("/profile", controllers.GetProfile)
-	auth.GET("/abnormal-count", controllers.GetAbnormalCount)
-	auth.GET("/abnormal-history", controllers.GetAbnormalHistory)
-	auth.GET("/download-csv", controllers.DownloadCSV)
-	auth.GET("/device-config/esp32-001", controllers.GetDeviceConfig)
-	auth.PUT("/update/:id", controllers.UpdateRecord)
-	auth.DELETE("/delete/:id", controllers.DeleteRecord)
-	auth.DELETE("/delete/all", controllers.DeleteAllRecords)
-	auth.DELETE("/delete/my-records", controllers.DeleteMyRecords)
-	auth.DELETE("/delete/user/:user_id", controllers.DeleteUserRecords)
-	auth.DELETE("/admin/delete-user/:user_id", controllers.DeleteUserAccount)
-	auth.POST("/location", controllers.HandleDeviceLocation)            // POST location from ESP32
-	auth.GET("/get-location/:device_id", controllers.GetDeviceLocation) // GET location for frontend
-	auth.POST("/train-model", controllers.TrainModel)
-	auth.GET("/model/status/:plant_name", controllers.GetTrainingStatus)
-	auth.GET("/models", controllers.ListAvailableModels)
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	r.Run(":" + port)
+
+	// Every protected route declares the permission it requires
+	guard := middlewares.NewGuard(auth)
+	can := models.NewPermission
+	// Admin routes that destroy data or hand out roles also need a current 2FA code
+	stepUp := controllers.RequireTwoFactorStepUp
+	guard.POST("/logout", can(models.ResourceSessions, models.ActionDelete), controllers.Logout)
+	guard.GET("/ws", can(models.ResourceNotifications, models.ActionRead), controllers.HandleWebSocket)
+	guard.POST("/promote-admin", can(models.ResourceUsers, models.ActionManage), stepUp, controllers.PromoteToAdmin)
+	guard.POST("/promote-user", can(models.ResourceUsers, models.ActionManage), stepUp, controllers.PromoteToUser)
+	guard.POST("/sensor-data", can(models.ResourceSensorData, models.ActionCreate), controllers.ReceiveData)
+	guard.POST("/admin/developer-mode/stop", can(models.ResourceDeveloperMode, models.ActionManage), controllers.StopDeveloperMode)
+	guard.POST("/admin/developer-mode/start", can(models.ResourceDeveloperMode, models.ActionManage), controllers.TriggerDeveloperMode)
+	guard.GET("/history", can(models.ResourceSensorData, models.ActionRead), controllers.GetHistory)
+	guard.GET("/users", can(models.ResourceUsers, models.ActionRead), controllers.GetUsers)
+	guard.POST("/admin/users/:user_id/unlock", can(models.ResourceUsers, models.ActionManage), controllers.UnlockAccount)
+	guard.POST("/admin/users/:user_id/2fa/reset", can(models.ResourceUsers, models.ActionManage), stepUp, controllers.ResetUserTwoFactor)
+	guard.GET("/admin/login-attempts", can(models.ResourceUsers, models.ActionRead), controllers.GetLoginAttempts)
+	guard.GET("/admin/audit-logs", can(models.ResourceAuditLog, models.ActionRead), controllers.GetAuditLogs)
+	guard.GET("/admin/audit-logs/export", can(models.ResourceAuditLog, models.ActionRead), controllers.ExportAuditLogs)
+	guard.GET("/profile", can(models.ResourceProfile, models.ActionRead), controllers.GetProfile)
+	guard.PUT("/profile", can(models.ResourceProfile, models.ActionUpdate), controllers.UpdateProfile)
+	guard.POST("/password/change", can(models.ResourceProfile, models.ActionUpdate), controllers.ChangePassword)
+	guard.GET("/2fa", can(models.ResourceProfile, models.ActionRead), controllers.GetTwoFactorStatus)
+	guard.POST("/2fa/setup", can(models.ResourceProfile, models.ActionUpdate), controllers.SetupTwoFactor)
+	guard.POST("/2fa/confirm", can(models.ResourceProfile, models.ActionUpdate), controllers.ConfirmTwoFactor)
+	guard.POST("/2fa/recovery-codes", can(models.ResourceProfile, models.ActionUpdate), controllers.RegenerateRecoveryCodes)
+	guard.POST("/2fa/disable", can(models.ResourceProfile, models.ActionUpdate), controllers.DisableTwoFactor)
+	guard.POST("/oidc/:slug/link", can(models.ResourceProfile, models.ActionUpdate), controllers.LinkOIDCIdentity)
+	guard.GET("/abnormal-count", can(models.ResourceSensorData, models.ActionRead), controllers.GetAbnormalCount)
+	guard.GET("/abnormal-history", can(models.ResourceSensorData, models.ActionRead), controllers.GetAbnormalHistory)
+	guard.GET("/download-csv", can(models.ResourceSensorData, models.ActionRead), controllers.DownloadCSV)
+	guard.GET("/export", can(models.ResourceSensorData, models.ActionRead), controllers.ExportSensorData)
+	guard.POST("/upload-csv", can(models.ResourceSensorData, models.ActionCreate), controllers.ImportCSV)
+	guard.GET("/device-config/:device_id", can(models.ResourceDeviceConfig, models.ActionRead), controllers.GetDeviceConfig)
+	guard.POST("/toggle-ai", can(models.ResourceAIConfig, models.ActionUpdate), controllers.ToggleAI)
+	guard.GET("/ai-config", can(models.ResourceAIConfig, models.ActionRead), controllers.GetAIConfigs)
+	guard.GET("/ai-config/:device_id/history", can(models.ResourceAIConfig, models.ActionRead), controllers.GetAIConfigHistory)
+	guard.GET("/forecast/:device_id", can(models.ResourceSensorData, models.ActionRead), controllers.GetForecast)
+	guard.GET("/weather/:device_id/current", can(models.ResourceWeather, models.ActionRead), controllers.GetCurrentWeather)
+	guard.GET("/weather/:device_id/forecast", can(models.ResourceWeather, models.ActionRead), controllers.GetWeatherForecast)
+	guard.GET("/water-balance/:device_id", can(models.ResourceWaterBalance, models.ActionRead), controllers.GetWaterBalance)
+	guard.GET("/water-balance/:device_id/profile", can(models.ResourceWaterBalance, models.ActionRead), controllers.GetSoilProfile)
+	guard.PUT("/water-balance/:device_id/profile", can(models.ResourceWaterBalance, models.ActionUpdate), controllers.UpdateSoilProfile)
+	guard.GET("/fields/:id/water-balance", can(models.ResourceWaterBalance, models.ActionRead), controllers.GetFieldWaterBalance)
+	guard.GET("/fields/:id/soil-profile", can(models.ResourceWaterBalance, models.ActionRead), controllers.GetFieldSoilProfile)
+	guard.PUT("/fields/:id/soil-profile", can(models.ResourceWaterBalance, models.ActionUpdate), controllers.UpdateFieldSoilProfile)
+	guard.PUT("/update/:id", can(models.ResourceSensorData, models.ActionUpdate), controllers.UpdateRecord)
+	guard.GET("/records/:id/revisions", can(models.ResourceSensorData, models.ActionRead), controllers.GetRecordRevisions)
+	guard.DELETE("/delete/:id", can(models.ResourceSensorData, models.ActionDelete), controllers.DeleteRecord)
+	guard.DELETE("/delete/all", can(models.ResourceSensorData, models.ActionManage), stepUp, controllers.DeleteAllRecords)
+	guard.DELETE("/delete/my-records", can(models.ResourceSensorData, models.ActionDelete), controllers.DeleteMyRecords)
+	guard.DELETE("/delete/user/:user_id", can(models.ResourceSensorData, models.ActionDelete), controllers.DeleteUserRecords)
+	guard.DELETE("/admin/delete-user/:user_id", can(models.ResourceUsers, models.ActionDelete), stepUp, controllers.DeleteUserAccount)
+	guard.GET("/trash", can(models.ResourceSensorData, models.ActionDelete), controllers.GetTrash)
+	guard.POST("/trash/:batch/restore", can(models.ResourceSensorData, models.ActionDelete), controllers.RestoreTrash)
+	guard.GET("/admin/trash/users", can(models.ResourceUsers, models.ActionDelete), controllers.GetTrashedUsers)
+	guard.POST("/admin/users/:user_id/restore", can(models.ResourceUsers, models.ActionDelete), controllers.RestoreUserAccount)
+	guard.POST("/location", can(models.ResourceLocation, models.ActionCreate), controllers.HandleDeviceLocation)          // POST location from ESP32
+	guard.GET("/get-location/:device_id", can(models.ResourceLocation, models.ActionRead), controllers.GetDeviceLocation) // GET location for frontend
+	guard.POST("/train-model", can(models.ResourceAIModels, models.ActionCreate), controllers.TrainModel)
+	guard.GET("/model/status/:plant_name", can(models.ResourceAIModels, models.ActionRead), controllers.GetTrainingStatus)
+	guard.GET("/models", can(models.ResourceAIModels, models.ActionRead), controllers.ListAvailableModels)
+	guard.GET("/training-runs", can(models.ResourceAIModels, models.ActionRead), controllers.GetTrainingRuns)
+	guard.GET("/crops", can(models.ResourceCrops, models.ActionRead), controllers.ListCrops)
+	guard.GET("/crops/:id", can(models.ResourceCrops, models.ActionRead), controllers.GetCrop)
+	guard.POST("/orgs", can(models.ResourceOrganisations, models.ActionCreate), controllers.CreateOrganisation)
+	guard.GET("/orgs", can(models.ResourceOrganisations, models.ActionRead), controllers.GetOrganisations)
+	guard.GET("/orgs/:id", can(models.ResourceOrganisations, models.ActionRead), controllers.GetOrganisation)
+	guard.PUT("/orgs/:id/settings", can(models.ResourceOrganisations, models.ActionUpdate), controllers.UpdateOrgSettings)
+	guard.GET("/orgs/:id/oidc-providers", can(models.ResourceOrganisations, models.ActionUpdate), controllers.GetOIDCProviders)
+	guard.POST("/orgs/:id/oidc-providers", can(models.ResourceOrganisations, models.ActionUpdate), controllers.CreateOIDCProvider)
+	guard.PUT("/orgs/:id/oidc-providers/:provider_id", can(models.ResourceOrganisations, models.ActionUpdate), controllers.UpdateOIDCProvider)
+	guard.DELETE("/orgs/:id/oidc-providers/:provider_id", can(models.ResourceOrganisations, models.ActionUpdate), controllers.DeleteOIDCProvider)
+	guard.POST("/orgs/:id/invitations", can(models.ResourceOrganisations, models.ActionUpdate), controllers.InviteMember)
+	guard.GET("/orgs/:id/invitations", can(models.ResourceOrganisations, models.ActionRead), controllers.GetInvitations)
+	guard.DELETE("/orgs/:id/invitations/:invitation_id", can(models.ResourceOrganisations, models.ActionUpdate), controllers.RevokeInvitation)
+	guard.PUT("/orgs/:id/members/:member_id", can(models.ResourceOrganisations, models.ActionUpdate), controllers.UpdateMemberRole)
+	guard.DELETE("/orgs/:id/members/:member_id", can(models.ResourceOrganisations, models.ActionUpdate), controllers.RemoveMember)
+	guard.POST("/invitations/accept", can(models.ResourceOrganisations, models.ActionUpdate), controllers.AcceptInvitation)
+	guard.POST("/farms", can(models.ResourceFarms, models.ActionCreate), controllers.CreateFarm)
+	guard.GET("/farms", can(models.ResourceFarms, models.ActionRead), controllers.GetFarms)
+	guard.GET("/farms/:id", can(models.ResourceFarms, models.ActionRead), controllers.GetFarm)
+	guard.DELETE("/farms/:id", can(models.ResourceFarms, models.ActionDelete), controllers.DeleteFarm)
+	guard.POST("/farms/:id/fields", can(models.ResourceFarms, models.ActionUpdate), controllers.CreateField)
+	guard.POST("/fields/:id/zones", can(models.ResourceFarms, models.ActionUpdate), controllers.CreateZone)
+	guard.GET("/farms/:id/members", can(models.ResourceFarms, models.ActionRead), controllers.GetFarmMembers)
+	guard.POST("/farms/:id/members", can(models.ResourceFarms, models.ActionUpdate), controllers.AddFarmMember)
+	guard.DELETE("/farms/:id/members/:member_id", can(models.ResourceFarms, models.ActionUpdate), controllers.RemoveFarmMember)
+	guard.GET("/devices", can(models.ResourceDevices, models.ActionRead), controllers.GetDevices)
+	guard.PUT("/devices/:device_id", can(models.ResourceDevices, models.ActionUpdate), controllers.AssignDevice)
+	guard.POST("/devices/:device_id/keys", can(models.ResourceDeviceKeys, models.ActionCreate), controllers.CreateDeviceKey)
+	guard.GET("/devices/:device_id/keys", can(models.ResourceDeviceKeys, models.ActionRead), controllers.GetDeviceKeys)
+	guard.POST("/devices/:device_id/keys/:id/rotate", can(models.ResourceDeviceKeys, models.ActionUpdate), controllers.RotateDeviceKey)
+	guard.DELETE("/devices/:device_id/keys/:id", can(models.ResourceDeviceKeys, models.ActionDelete), controllers.RevokeDeviceKey)
+	guard.GET("/rollup", can(models.ResourceSensorData, models.ActionRead), controllers.GetRollup)
+	guard.POST("/crops", can(models.ResourceCrops, models.ActionCreate), controllers.CreateCrop)
+	guard.DELETE("/crops/:id", can(models.ResourceCrops, models.ActionDelete), controllers.DeleteCrop)
+	guard.POST("/plantings", can(models.ResourcePlantings, models.ActionCreate), controllers.CreatePlanting)
+	guard.GET("/plantings", can(models.ResourcePlantings, models.ActionRead), controllers.GetPlantings)
+	guard.GET("/plantings/:id/stage", can(models.ResourcePlantings, models.ActionRead), controllers.GetPlantingStage)
+	guard.GET("/plantings/:id/gdd", can(models.ResourcePlantings, models.ActionRead), controllers.GetPlantingGDD)
+	guard.GET("/plantings/:id/prediction", can(models.ResourcePlantings, models.ActionRead), controllers.GetPlantingPrediction)
+	guard.POST("/irrigation/actuators", can(models.ResourceIrrigation, models.ActionCreate), controllers.CreateActuator)
+	guard.GET("/irrigation/actuators", can(models.ResourceIrrigation, models.ActionRead), controllers.GetActuators)
+	guard.POST("/irrigation/actuators/:id/start", can(models.ResourceIrrigation, models.ActionUpdate), controllers.StartIrrigation)
+	guard.POST("/irrigation/actuators/:id/stop", can(models.ResourceIrrigation, models.ActionUpdate), controllers.StopIrrigation)
+	guard.GET("/irrigation/commands", can(models.ResourceIrrigation, models.ActionRead), controllers.GetIrrigationCommands)
+	guard.GET("/irrigation/commands/poll", can(models.ResourceDeviceCommand, models.ActionRead), controllers.PollIrrigationCommands) // Polled by ESP32
+	guard.POST("/irrigation/commands/:id/ack", can(models.ResourceDeviceCommand, models.ActionUpdate), controllers.AcknowledgeIrrigationCommand)
+	guard.GET("/irrigation/events", can(models.ResourceIrrigation, models.ActionRead), controllers.GetWateringHistory)
+	guard.POST("/irrigation/rules", can(models.ResourceIrrigation, models.ActionCreate), controllers.CreateIrrigationRule)
+	guard.GET("/irrigation/rules", can(models.ResourceIrrigation, models.ActionRead), controllers.GetIrrigationRules)
+	guard.PUT("/irrigation/rules/:id", can(models.ResourceIrrigation, models.ActionUpdate), controllers.UpdateIrrigationRule)
+	guard.DELETE("/irrigation/rules/:id", can(models.ResourceIrrigation, models.ActionDelete), controllers.DeleteIrrigationRule)
+	guard.POST("/irrigation/rules/:id/evaluate", can(models.ResourceIrrigation, models.ActionUpdate), controllers.EvaluateIrrigationRule)
+	guard.GET("/irrigation/decisions", can(models.ResourceIrrigation, models.ActionRead), controllers.GetIrrigationDecisions)
+	guard.POST("/irrigation/schedules", can(models.ResourceIrrigation, models.ActionCreate), controllers.CreateIrrigationSchedule)
+	guard.GET("/irrigation/schedules", can(models.ResourceIrrigation, models.ActionRead), controllers.GetIrrigationSchedules)
+	guard.PUT("/irrigation/schedules/:id", can(models.ResourceIrrigation, models.ActionUpdate), controllers.UpdateIrrigationSchedule)
+	guard.DELETE("/irrigation/schedules/:id", can(models.ResourceIrrigation, models.ActionDelete), controllers.DeleteIrrigationSchedule)
+	guard.GET("/irrigation/schedules/:id/preview", can(models.ResourceIrrigation, models.ActionRead), controllers.PreviewIrrigationSchedule)
+	guard.GET("/irrigation/schedules/:id/runs", can(models.ResourceIrrigation, models.ActionRead), controllers.GetIrrigationScheduleRuns)
+	guard.POST("/reports/templates", can(models.ResourceReports, models.ActionCreate), controllers.CreateReportTemplate)
+	guard.GET("/reports/templates", can(models.ResourceReports, models.ActionRead), controllers.GetReportTemplates)
+	guard.PUT("/reports/templates/:id", can(models.ResourceReports, models.ActionUpdate), controllers.UpdateReportTemplate)
+	guard.DELETE("/reports/templates/:id", can(models.ResourceReports, models.ActionDelete), controllers.DeleteReportTemplate)
+	guard.GET("/reports/templates/:id/preview", can(models.ResourceReports, models.ActionRead), controllers.PreviewReport)
+	guard.POST("/reports/templates/:id/generate", can(models.ResourceReports, models.ActionCreate), controllers.GenerateReport)
+	guard.GET("/reports", can(models.ResourceReports, models.ActionRead), controllers.GetReports)
+	guard.GET("/reports/:id/download", can(models.ResourceReports, models.ActionRead), controllers.DownloadReport)
+	guard.GET("/admin/permissions", can(models.ResourcePermissions, models.ActionRead), guard.MatrixHandler())
+
+	return r, guard
 }
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Who may call a route
+type access int
+
+const (
+	public          access = iota // Anyone, without signing in
+	adminOnly                     // Admins
+	signedIn                      // Users and admins
+	usersAndDevices               // Users, admins and device keys
+)
+
+// routeAccess is the expected access to every route. A route missing from
+// it fails the test, so adding one means deciding who may call it here too.
+var routeAccess = map[string]access{
+	"POST /signup":                                 public,
+	"POST /login":                                  public,
+	"POST /login/2fa":                              public,
+	"POST /token/refresh":                          public,
+	"GET /oidc/:slug/login":                        public,
+	"GET /oidc/:slug/callback":                     public,
+	"POST /oidc/exchange":                          public,
+	"POST /verify-email":                           public,
+	"POST /verify-email/resend":                    public,
+	"POST /email/confirm":                          public,
+	"POST /password/forgot":                        public,
+	"POST /password/reset":                         public,
+	"POST /logout":                                 signedIn,
+	"GET /ws":                                      signedIn,
+	"POST /promote-admin":                          adminOnly,
+	"POST /promote-user":                           adminOnly,
+	"POST /sensor-data":                            usersAndDevices,
+	"POST /admin/developer-mode/stop":              adminOnly,
+	"POST /admin/developer-mode/start":             adminOnly,
+	"GET /history":                                 signedIn,
+	"GET /users":                                   adminOnly,
+	"POST /admin/users/:user_id/unlock":            adminOnly,
+	"POST /admin/users/:user_id/2fa/reset":         adminOnly,
+	"GET /admin/login-attempts":                    adminOnly,
+	"GET /admin/audit-logs":                        adminOnly,
+	"GET /admin/audit-logs/export":                 adminOnly,
+	"GET /profile":                                 signedIn,
+	"PUT /profile":                                 signedIn,
+	"POST /password/change":                        signedIn,
+	"GET /2fa":                                     signedIn,
+	"POST /2fa/setup":                              signedIn,
+	"POST /2fa/confirm":                            signedIn,
+	"POST /2fa/recovery-codes":                     signedIn,
+	"POST /2fa/disable":                            signedIn,
+	"POST /oidc/:slug/link":                        signedIn,
+	"GET /abnormal-count":                          signedIn,
+	"GET /abnormal-history":                        signedIn,
+	"GET /download-csv":                            signedIn,
+	"GET /export":                                  signedIn,
+	"POST /upload-csv":                             usersAndDevices,
+	"GET /device-config/:device_id":                usersAndDevices,
+	"POST /toggle-ai":                              signedIn,
+	"GET /ai-config":                               signedIn,
+	"GET /ai-config/:device_id/history":            signedIn,
+	"GET /forecast/:device_id":                     signedIn,
+	"GET /weather/:device_id/current":              signedIn,
+	"GET /weather/:device_id/forecast":             signedIn,
+	"GET /water-balance/:device_id":                signedIn,
+	"GET /water-balance/:device_id/profile":        signedIn,
+	"PUT /water-balance/:device_id/profile":        signedIn,
+	"GET /fields/:id/water-balance":                signedIn,
+	"GET /fields/:id/soil-profile":                 signedIn,
+	"PUT /fields/:id/soil-profile":                 signedIn,
+	"PUT /update/:id":                              signedIn,
+	"GET /records/:id/revisions":                   signedIn,
+	"DELETE /delete/:id":                           signedIn,
+	"DELETE /delete/all":                           adminOnly,
+	"DELETE /delete/my-records":                    signedIn,
+	"DELETE /delete/user/:user_id":                 signedIn,
+	"DELETE /admin/delete-user/:user_id":           adminOnly,
+	"GET /trash":                                   signedIn,
+	"POST /trash/:batch/restore":                   signedIn,
+	"GET /admin/trash/users":                       adminOnly,
+	"POST /admin/users/:user_id/restore":           adminOnly,
+	"POST /location":                               usersAndDevices,
+	"GET /get-location/:device_id":                 signedIn,
+	"POST /train-model":                            signedIn,
+	"GET /model/status/:plant_name":                signedIn,
+	"GET /models":                                  signedIn,
+	"GET /training-runs":                           signedIn,
+	"GET /crops":                                   signedIn,
+	"GET /crops/:id":                               signedIn,
+	"POST /orgs":                                   signedIn,
+	"GET /orgs":                                    signedIn,
+	"GET /orgs/:id":                                signedIn,
+	"PUT /orgs/:id/settings":                       signedIn,
+	"GET /orgs/:id/oidc-providers":                 signedIn,
+	"POST /orgs/:id/oidc-providers":                signedIn,
+	"PUT /orgs/:id/oidc-providers/:provider_id":    signedIn,
+	"DELETE /orgs/:id/oidc-providers/:provider_id": signedIn,
+	"POST /orgs/:id/invitations":                   signedIn,
+	"GET /orgs/:id/invitations":                    signedIn,
+	"DELETE /orgs/:id/invitations/:invitation_id":  signedIn,
+	"PUT /orgs/:id/members/:member_id":             signedIn,
+	"DELETE /orgs/:id/members/:member_id":          signedIn,
+	"POST /invitations/accept":                     signedIn,
+	"POST /farms":                                  signedIn,
+	"GET /farms":                                   signedIn,
+	"GET /farms/:id":                               signedIn,
+	"DELETE /farms/:id":                            signedIn,
+	"POST /farms/:id/fields":                       signedIn,
+	"POST /fields/:id/zones":                       signedIn,
+	"GET /farms/:id/members":                       signedIn,
+	"POST /farms/:id/members":                      signedIn,
+	"DELETE /farms/:id/members/:member_id":         signedIn,
+	"GET /devices":                                 signedIn,
+	"PUT /devices/:device_id":                      signedIn,
+	"POST /devices/:device_id/keys":                signedIn,
+	"GET /devices/:device_id/keys":                 signedIn,
+	"POST /devices/:device_id/keys/:id/rotate":     signedIn,
+	"DELETE /devices/:device_id/keys/:id":          signedIn,
+	"GET /rollup":                                  signedIn,
+	"POST /crops":                                  adminOnly,
+	"DELETE /crops/:id":                            adminOnly,
+	"POST /plantings":                              signedIn,
+	"GET /plantings":                               signedIn,
+	"GET /plantings/:id/stage":                     signedIn,
+	"GET /plantings/:id/gdd":                       signedIn,
+	"GET /plantings/:id/prediction":                signedIn,
+	"POST /irrigation/actuators":                   signedIn,
+	"GET /irrigation/actuators":                    signedIn,
+	"POST /irrigation/actuators/:id/start":         signedIn,
+	"POST /irrigation/actuators/:id/stop":          signedIn,
+	"GET /irrigation/commands":                     signedIn,
+	"GET /irrigation/commands/poll":                usersAndDevices,
+	"POST /irrigation/commands/:id/ack":            usersAndDevices,
+	"GET /irrigation/events":                       signedIn,
+	"POST /irrigation/rules":                       signedIn,
+	"GET /irrigation/rules":                        signedIn,
+	"PUT /irrigation/rules/:id":                    signedIn,
+	"DELETE /irrigation/rules/:id":                 signedIn,
+	"POST /irrigation/rules/:id/evaluate":          signedIn,
+	"GET /irrigation/decisions":                    signedIn,
+	"POST /irrigation/schedules":                   signedIn,
+	"GET /irrigation/schedules":                    signedIn,
+	"PUT /irrigation/schedules/:id":                signedIn,
+	"DELETE /irrigation/schedules/:id":             signedIn,
+	"GET /irrigation/schedules/:id/preview":        signedIn,
+	"GET /irrigation/schedules/:id/runs":           signedIn,
+	"POST /reports/templates":                      signedIn,
+	"GET /reports/templates":                       signedIn,
+	"PUT /reports/templates/:id":                   signedIn,
+	"DELETE /reports/templates/:id":                signedIn,
+	"GET /reports/templates/:id/preview":           signedIn,
+	"POST /reports/templates/:id/generate":         signedIn,
+	"GET /reports":                                 signedIn,
+	"GET /reports/:id/download":                    signedIn,
+	"GET /admin/permissions":                       adminOnly,
+}
+
+func (a access) allows(role string) bool {
+	switch role {
+	case "anonymous":
+		return a == public
+	case models.RoleDevice:
+		return a == public || a == usersAndDevices
+	case models.RoleUser:
+		return a != adminOnly
+	}
+	return true
+}
+
+// samplePath fills a route's parameters in so it can be requested.
+func samplePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "1"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func TestRouteAccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r, guard := setupRouter()
+	if err := guard.Verify(r.Routes(), publicRoutes...); err != nil {
+		t.Fatal(err)
+	}
+
+	registered := map[string]bool{}
+	for _, route := range r.Routes() {
+		key := route.Method + " " + route.Path
+		registered[key] = true
+		if _, ok := routeAccess[key]; !ok {
+			t.Errorf("%s has no expected access in routeAccess", key)
+		}
+	}
+	for key := range routeAccess {
+		if !registered[key] {
+			t.Errorf("%s is expected but not registered", key)
+		}
+	}
+
+	rules := map[string]models.Permission{}
+	for _, rule := range guard.Rules() {
+		rules[rule.Method+" "+rule.Path] = rule.Permission
+	}
+	for key, expected := range routeAccess {
+		permission, guarded := rules[key]
+		for _, role := range []string{"anonymous", models.RoleUser, models.RoleAdmin, models.RoleDevice} {
+			var allowed bool
+			switch {
+			case !guarded:
+				allowed = true
+			case role != "anonymous":
+				allowed = utils.HasPermission(role, permission)
+			}
+			if allowed != expected.allows(role) {
+				t.Errorf("%s for %s: allowed = %v, want %v", key, role, allowed, expected.allows(role))
+			}
+		}
+	}
+}
+
+// TestGuardedRoutesRejectAnonymous sends a request without credentials to
+// every guarded route through the router itself.
+func TestGuardedRoutesRejectAnonymous(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	r, guard := setupRouter()
+	for _, rule := range guard.Rules() {
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, httptest.NewRequest(rule.Method, samplePath(rule.Path), nil))
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without credentials: status %d, want %d", rule.Method, rule.Path, recorder.Code, http.StatusUnauthorized)
+		}
+	}
+}
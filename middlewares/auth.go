@@ -4,15 +4,53 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/dgrijalva/jwt-go"
+	"fyp/config"
+	"fyp/utils"
+
 	"github.com/gin-gonic/gin"
 )
 
-var secretKey = []byte("your-secret-key") // Must match token generation secret
+// Context keys set for requests authenticated with a device key
+const (
+	PrincipalKey        = "principal"
+	PrincipalDevice     = "device"
+	DeviceIDKey         = "device_id"
+	DeviceCredentialKey = "device_credential_id"
+)
+
+// AccessClaimsKey holds the utils.AccessClaims of requests authenticated
+// with an access token.
+const AccessClaimsKey = "access_claims"
+
+// deviceKeyFromRequest returns the device key sent in the X-Device-Key header
+// or as "Authorization: Device <key>".
+func deviceKeyFromRequest(c *gin.Context) string {
+	if key := c.GetHeader("X-Device-Key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(c.GetHeader("Authorization"), "Device ")
+}
 
 // AuthMiddleware validates the JWT token from header OR query parameter.
+// Devices may instead authenticate with a device key, in which case the
+// owner and device come from the credential.
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key := deviceKeyFromRequest(c); utils.IsDeviceKey(key) {
+			credential, err := utils.AuthenticateDeviceKey(config.DB, key)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or revoked device key"})
+				c.Abort()
+				return
+			}
+			c.Set("user_id", credential.UserID)
+			c.Set(PrincipalKey, PrincipalDevice)
+			c.Set(DeviceIDKey, credential.DeviceID)
+			c.Set(DeviceCredentialKey, credential.ID)
+			c.Next()
+			return
+		}
+
 		var tokenString string
 
 		// 1. Try Authorization header
@@ -33,27 +71,27 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Parse JWT token
-		claims := jwt.MapClaims{}
-		_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return secretKey, nil
-		})
+		claims, err := utils.ParseAccessToken(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
-
-		// Extract user_id from token claims
-		userID, ok := claims["user_id"]
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token payload"})
+		revoked, err := utils.IsTokenRevoked(config.DB, claims.Id)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to verify token, try again later"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
 			c.Abort()
 			return
 		}
 
-		// Save user_id to context
-		c.Set("user_id", userID)
+		// Save user_id and the token's claims to context
+		c.Set("user_id", claims.UserID)
+		c.Set(AccessClaimsKey, claims)
 		c.Next()
 	}
 }
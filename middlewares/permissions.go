@@ -0,0 +1,154 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"fyp/config"
+	"fyp/models"
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RouteRule is the permission a route requires.
+type RouteRule struct {
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Permission models.Permission `json:"permission"`
+}
+
+// RequirePermission rejects requests from users whose role does not grant
+// the permission. It must run after AuthMiddleware and stores the loaded
+// user in the context as "user". Devices are checked against the device role
+// rather than their owner's.
+func RequirePermission(required models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(PrincipalKey) == PrincipalDevice {
+			if !utils.HasPermission(models.RoleDevice, required) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Device keys cannot access this endpoint", "required": required.String()})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var user models.User
+		if err := config.DB.First(&user, userID).Error; err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			return
+		}
+		if !utils.HasPermission(user.Role, required) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access denied", "required": required.String()})
+			return
+		}
+
+		c.Set("user", user)
+		c.Next()
+	}
+}
+
+// Guard registers routes on a group together with the permission each one
+// requires, and keeps the resulting table of rules.
+type Guard struct {
+	group *gin.RouterGroup
+	rules []RouteRule
+}
+
+// NewGuard guards routes registered on group.
+func NewGuard(group *gin.RouterGroup) *Guard {
+	return &Guard{group: group}
+}
+
+// Handle registers a route that requires permission.
+func (g *Guard) Handle(method, path string, required models.Permission, handlers ...gin.HandlerFunc) {
+	full := strings.TrimSuffix(g.group.BasePath(), "/") + path
+	g.rules = append(g.rules, RouteRule{Method: method, Path: full, Permission: required})
+	g.group.Handle(method, path, append([]gin.HandlerFunc{RequirePermission(required)}, handlers...)...)
+}
+
+func (g *Guard) GET(path string, required models.Permission, handlers ...gin.HandlerFunc) {
+	g.Handle(http.MethodGet, path, required, handlers...)
+}
+
+func (g *Guard) POST(path string, required models.Permission, handlers ...gin.HandlerFunc) {
+	g.Handle(http.MethodPost, path, required, handlers...)
+}
+
+func (g *Guard) PUT(path string, required models.Permission, handlers ...gin.HandlerFunc) {
+	g.Handle(http.MethodPut, path, required, handlers...)
+}
+
+func (g *Guard) DELETE(path string, required models.Permission, handlers ...gin.HandlerFunc) {
+	g.Handle(http.MethodDelete, path, required, handlers...)
+}
+
+// Rules returns the table of guarded routes.
+func (g *Guard) Rules() []RouteRule {
+	return append([]RouteRule(nil), g.rules...)
+}
+
+// Verify checks that every route of the engine is either guarded or listed as
+// public ("METHOD /path"), so a route cannot be added without deciding who
+// may call it.
+func (g *Guard) Verify(routes gin.RoutesInfo, public ...string) error {
+	allowed := make(map[string]bool, len(g.rules)+len(public))
+	for _, rule := range g.rules {
+		allowed[rule.Method+" "+rule.Path] = true
+	}
+	for _, route := range public {
+		allowed[route] = true
+	}
+
+	var unguarded []string
+	for _, route := range routes {
+		if !allowed[route.Method+" "+route.Path] {
+			unguarded = append(unguarded, route.Method+" "+route.Path)
+		}
+	}
+	if len(unguarded) > 0 {
+		sort.Strings(unguarded)
+		return fmt.Errorf("routes without a permission rule: %s", strings.Join(unguarded, ", "))
+	}
+	return nil
+}
+
+// AccessMatrix evaluates every rule for every platform role, the table of
+// who may call what.
+func (g *Guard) AccessMatrix() []gin.H {
+	roles := make([]string, 0, len(utils.RolePermissions))
+	for role := range utils.RolePermissions {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	matrix := make([]gin.H, 0, len(g.rules))
+	for _, rule := range g.rules {
+		access := gin.H{}
+		for _, role := range roles {
+			access[role] = utils.HasPermission(role, rule.Permission)
+		}
+		matrix = append(matrix, gin.H{
+			"method":     rule.Method,
+			"path":       rule.Path,
+			"permission": rule.Permission.String(),
+			"roles":      access,
+		})
+	}
+	return matrix
+}
+
+// MatrixHandler serves the access matrix.
+func (g *Guard) MatrixHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, g.AccessMatrix())
+	}
+}
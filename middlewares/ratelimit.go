@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fyp/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitByIP rejects clients that exceed limit on the routes it guards,
+// counted per client IP under name.
+func RateLimitByIP(name string, limit utils.RateLimit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, wait := utils.Allow(name+":ip:"+c.ClientIP(), limit); !ok {
+			RejectRateLimited(c, wait)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RejectRateLimited aborts with 429 and tells the client when to retry.
+func RejectRateLimited(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, try again later", "retry_after": seconds})
+}
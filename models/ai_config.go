@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// DefaultDeviceID is used when a reading or request does not name a device.
+// It matches the identifier the ESP32 firmware has always used.
+const DefaultDeviceID = "esp32-001"
+
+// AIConfig stores the AI prediction settings of a single device.
+type AIConfig struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	UserID       uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_ai_config_owner_device"`
+	DeviceID     string    `json:"device_id" gorm:"not null;uniqueIndex:idx_ai_config_owner_device"`
+	Enabled      bool      `json:"enabled" gorm:"default:false"`
+	PlantName    string    `json:"plant_name"`
+	ModelVersion string    `json:"model_version"`
+	UpdatedBy    uint      `json:"updated_by"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// AIConfigAudit records every change made to an AIConfig.
+type AIConfigAudit struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	AIConfigID   uint      `json:"ai_config_id" gorm:"index"`
+	UserID       uint      `json:"user_id"`
+	DeviceID     string    `json:"device_id"`
+	ChangedBy    uint      `json:"changed_by"`
+	Enabled      bool      `json:"enabled"`
+	PlantName    string    `json:"plant_name"`
+	ModelVersion string    `json:"model_version"`
+	ChangedAt    time.Time `json:"changed_at"`
+}
+
+type ToggleAIRequest struct {
+	DeviceID     string `json:"device_id"`
+	UserID       uint   `json:"user_id"` // Only honoured for admins acting on another user's device
+	Plant        string `json:"plant"`
+	ModelVersion string `json:"model_version"`
+	Enabled      bool   `json:"enabled"`
+}
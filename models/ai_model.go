@@ -1,7 +1,51 @@
 package models
 
+import "time"
+
 type TrainModelRequest struct {
 	PlantName string `json:"plant_name" binding:"required"`
+
+	// Data selection
+	StartTime         *time.Time `json:"start_time"`
+	EndTime           *time.Time `json:"end_time"`
+	DeviceIDs         []string   `json:"device_ids"`
+	UserIDs           []uint     `json:"user_ids"`           // Admins only; other users always train on their own data
+	FieldID           *uint      `json:"field_id"`           // Devices in the field that the caller can see
+	ZoneID            *uint      `json:"zone_id"`            // Devices in the zone that the caller can see
+	IncludeUnassigned bool       `json:"include_unassigned"` // Include rows from devices never configured with a plant
+	ExcludeAbnormal   bool       `json:"exclude_abnormal"`
+	ExcludeOutliers   bool       `json:"exclude_outliers"`
+	OutlierZScore     float64    `json:"outlier_z_score"` // Defaults to 3
+
+	// Train/validation split
+	ValidationSplit float64 `json:"validation_split"` // Fraction held out for validation, 0 leaves the split to the training service
+	SplitPolicy     string  `json:"split_policy"`     // "chronological" (default) or "random"
+	SplitSeed       int64   `json:"split_seed"`
+}
+
+// TrainingRun records the exact dataset a model was trained on so the
+// training can be reproduced.
+type TrainingRun struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	PlantName       string    `json:"plant_name" gorm:"index"`
+	RequestedBy     uint      `json:"requested_by"`
+	Filters         string    `json:"filters" gorm:"type:text"` // TrainModelRequest as JSON
+	RowCount        int       `json:"row_count"`
+	TrainCount      int       `json:"train_count"`
+	ValidationCount int       `json:"validation_count"`
+	DataFrom        time.Time `json:"data_from"`
+	DataTo          time.Time `json:"data_to"`
+	TrainIDs        string    `json:"-" gorm:"type:text"` // JSON array of SensorData IDs
+	ValidationIDs   string    `json:"-" gorm:"type:text"` // JSON array of SensorData IDs
+	Checksum        string    `json:"checksum"`           // SHA-256 of the CSV sent for training
+	Success         bool      `json:"success"`
+	ModelPath       string    `json:"model_path"`
+	BestModel       string    `json:"best_model"`
+	R2Score         float64   `json:"r2_score"`
+	RMSE            float64   `json:"rmse"`
+	MAE             float64   `json:"mae"`
+	Error           string    `json:"error,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
 }
 
 // TrainModelResponse represents the response from Python training service
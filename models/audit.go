@@ -0,0 +1,75 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// Audited actions
+const (
+	AuditSensorDataUpdate     = "sensor_data.update"
+	AuditSensorDataDelete     = "sensor_data.delete"
+	AuditSensorDataDeleteUser = "sensor_data.delete_user" // Every record of one user
+	AuditSensorDataDeleteAll  = "sensor_data.delete_all"
+	AuditSensorDataImport     = "sensor_data.import"
+	AuditUserRoleChange       = "user.role_change"
+	AuditUserDelete           = "user.delete"
+	AuditUserUnlock           = "user.unlock"
+	AuditUserTwoFactorReset   = "user.two_factor_reset"
+	AuditDeveloperMode        = "developer_mode.toggle"
+	AuditTrashRestore         = "trash.restore"
+	AuditTrashPurge           = "trash.purge"
+)
+
+// Kinds of audited targets
+const (
+	AuditTargetSensorData    = "sensor_data"
+	AuditTargetUser          = "user"
+	AuditTargetDeveloperMode = "developer_mode"
+	AuditTargetTrash         = "trash" // Identified by the trash batch
+)
+
+// JSONText is JSON kept in a text column and written out as-is.
+type JSONText string
+
+func (j JSONText) MarshalJSON() ([]byte, error) {
+	if j == "" {
+		return []byte("null"), nil
+	}
+	return []byte(j), nil
+}
+
+// Value stores the JSON as text.
+func (j JSONText) Value() (driver.Value, error) {
+	return string(j), nil
+}
+
+// Scan reads the column, treating NULL as no value.
+func (j *JSONText) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*j = ""
+	case []byte:
+		*j = JSONText(v)
+	case string:
+		*j = JSONText(v)
+	default:
+		return fmt.Errorf("cannot scan %T into JSONText", value)
+	}
+	return nil
+}
+
+// AuditLog records an administrative or destructive action. Rows are only
+// ever inserted; the database refuses updates and deletes.
+type AuditLog struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	ActorID    *uint     `json:"actor_id" gorm:"index"` // Nil for actions the system takes itself
+	Action     string    `json:"action" gorm:"index;not null"`
+	TargetType string    `json:"target_type" gorm:"index:idx_audit_target"`
+	TargetID   string    `json:"target_id" gorm:"index:idx_audit_target"`
+	Before     JSONText  `json:"before" gorm:"type:text"`
+	After      JSONText  `json:"after" gorm:"type:text"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+}
@@ -0,0 +1,75 @@
+package models
+
+import "time"
+
+// Growing degree day methods
+const (
+	GDDMinMax     = "min_max"    // Daily mean of the clamped minimum and maximum temperature
+	GDDIntegrated = "integrated" // Time-weighted over every reading of the day
+)
+
+// Crop is a catalog entry for a plant species.
+type Crop struct {
+	ID              uint          `json:"id" gorm:"primaryKey"`
+	Species         string        `json:"species" gorm:"unique;not null"`
+	CommonName      string        `json:"common_name"`
+	BaseTemperature float64       `json:"base_temperature"` // °C below which the crop does not develop
+	CapTemperature  float64       `json:"cap_temperature"`  // °C above which development does not speed up, 0 means no cap
+	GDDMethod       string        `json:"gdd_method"`       // min_max (default) or integrated
+	Varieties       []CropVariety `json:"varieties" gorm:"constraint:OnDelete:CASCADE"`
+	Stages          []GrowthStage `json:"stages" gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// CropVariety is a cultivar of a Crop.
+type CropVariety struct {
+	ID     uint   `json:"id" gorm:"primaryKey"`
+	CropID uint   `json:"crop_id" gorm:"index;not null"`
+	Name   string `json:"name" gorm:"not null"`
+	Notes  string `json:"notes"`
+}
+
+// GrowthStage is one phase of a crop's life cycle with the ideal range of
+// every metric while the crop is in it. Stages run back to back in Sequence
+// order starting from the sow date. The last stage is the harvest window.
+type GrowthStage struct {
+	ID              uint    `json:"id" gorm:"primaryKey"`
+	CropID          uint    `json:"crop_id" gorm:"index;not null"`
+	Name            string  `json:"name" gorm:"not null"`
+	Sequence        int     `json:"sequence"`
+	DurationDays    int     `json:"duration_days"`
+	MinTemperature  float32 `json:"min_temperature"`
+	MaxTemperature  float32 `json:"max_temperature"`
+	MinHumidity     float32 `json:"min_humidity"`
+	MaxHumidity     float32 `json:"max_humidity"`
+	MinSoilMoisture float32 `json:"min_soil_moisture"`
+	MaxSoilMoisture float32 `json:"max_soil_moisture"`
+	ModelName       string  `json:"model_name"`       // AI model to use during this stage, defaults to the crop species
+	CropCoefficient float64 `json:"crop_coefficient"` // FAO-56 Kc, ETc = Kc × ET0; 0 means 1
+	GDDRequired     float64 `json:"gdd_required"`     // Thermal time to complete the stage; stages run on DurationDays unless every stage sets it
+}
+
+// Planting assigns a device, or a whole field, to a crop from its sow date
+// until it ends. A field planting has FieldID set and no DeviceID, and covers
+// every device placed in the field that has no planting of its own.
+type Planting struct {
+	ID        uint         `json:"id" gorm:"primaryKey"`
+	UserID    uint         `json:"user_id" gorm:"index;not null"`
+	DeviceID  string       `json:"device_id" gorm:"index;not null"`
+	FieldID   *uint        `json:"field_id" gorm:"index"`
+	CropID    uint         `json:"crop_id" gorm:"not null"`
+	Crop      Crop         `json:"crop,omitempty"`
+	VarietyID *uint        `json:"variety_id"`
+	Variety   *CropVariety `json:"variety,omitempty"`
+	SowDate   time.Time    `json:"sow_date"`
+	EndedAt   *time.Time   `json:"ended_at"`
+	Active    bool         `json:"active" gorm:"default:true"`
+}
+
+// CreatePlantingRequest plants a device, or a field when FieldID is set.
+type CreatePlantingRequest struct {
+	DeviceID  string    `json:"device_id"`
+	FieldID   *uint     `json:"field_id"`
+	CropID    uint      `json:"crop_id" binding:"required"`
+	VarietyID *uint     `json:"variety_id"`
+	SowDate   time.Time `json:"sow_date" binding:"required"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// DeviceCredential is an API key a device authenticates with instead of its
+// owner's JWT. Only the SHA-256 hash of the key is stored; Prefix identifies
+// the key in lookups and listings.
+type DeviceCredential struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	UserID        uint       `json:"user_id" gorm:"index;not null"`
+	DeviceID      string     `json:"device_id" gorm:"index;not null"`
+	Name          string     `json:"name"`
+	Prefix        string     `json:"prefix" gorm:"uniqueIndex;not null"`
+	KeyHash       string     `json:"-" gorm:"not null"`
+	CreatedBy     uint       `json:"created_by"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastUsedAt    *time.Time `json:"last_used_at"`
+	ExpiresAt     *time.Time `json:"expires_at"` // Set on the old key during a rotation grace period
+	RevokedAt     *time.Time `json:"revoked_at"`
+	RotatedFromID *uint      `json:"rotated_from_id"`
+}
+
+type CreateDeviceKeyRequest struct {
+	Name string `json:"name"`
+}
+
+type RotateDeviceKeyRequest struct {
+	GraceMinutes int `json:"grace_minutes"` // Keep the old key working this long, 0 revokes it at once
+}
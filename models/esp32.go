@@ -24,7 +24,8 @@ type GeolocationResponse struct {
 // DeviceLocation: Store device location in the database
 type DeviceLocation struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
-	DeviceID  uint      `json:"user_id" gorm:"not null"`
+	UserID    uint      `json:"user_id" gorm:"index;not null"`
+	DeviceID  string    `json:"device_id" gorm:"index;not null;default:esp32-001"`
 	Latitude  float64   `json:"latitude"`
 	Longitude float64   `json:"longitude"`
 	Accuracy  float64   `json:"accuracy"`
@@ -0,0 +1,85 @@
+package models
+
+import "time"
+
+// Hierarchy levels
+const (
+	LevelFarm   = "farm"
+	LevelField  = "field"
+	LevelZone   = "zone"
+	LevelDevice = "device"
+)
+
+// Farm is the top of the Farm → Field → Zone hierarchy devices are organised in.
+type Farm struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	OwnerID   uint      `json:"owner_id" gorm:"index;not null"`
+	Name      string    `json:"name" gorm:"not null"`
+	Latitude  *float64  `json:"latitude"` // Used for devices that have not reported a location
+	Longitude *float64  `json:"longitude"`
+	Fields    []Field   `json:"fields,omitempty" gorm:"constraint:OnDelete:CASCADE"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Field is a plot of land within a Farm.
+type Field struct {
+	ID     uint    `json:"id" gorm:"primaryKey"`
+	FarmID uint    `json:"farm_id" gorm:"index;not null"`
+	Name   string  `json:"name" gorm:"not null"`
+	AreaM2 float64 `json:"area_m2"`
+	Zones  []Zone  `json:"zones,omitempty" gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// Zone is an area within a Field that devices are placed in.
+type Zone struct {
+	ID      uint   `json:"id" gorm:"primaryKey"`
+	FieldID uint   `json:"field_id" gorm:"index;not null"`
+	Name    string `json:"name" gorm:"not null"`
+}
+
+// Device is a sensor node registered to its owner, optionally placed in a Zone.
+type Device struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_device_owner_device"`
+	DeviceID  string    `json:"device_id" gorm:"not null;uniqueIndex:idx_device_owner_device"`
+	Name      string    `json:"name"`
+	ZoneID    *uint     `json:"zone_id" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FarmMember lets a user see part of a farm they do not own: the whole farm,
+// one field or one zone, and every device below it.
+type FarmMember struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	FarmID    uint      `json:"farm_id" gorm:"index;not null"`
+	UserID    uint      `json:"user_id" gorm:"index;not null"`
+	FieldID   *uint     `json:"field_id"` // Narrows access to a field
+	ZoneID    *uint     `json:"zone_id"`  // Narrows access to a zone
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateFarmRequest struct {
+	Name      string   `json:"name" binding:"required"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+}
+
+type CreateFieldRequest struct {
+	Name   string  `json:"name" binding:"required"`
+	AreaM2 float64 `json:"area_m2"`
+}
+
+type CreateZoneRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type AssignDeviceRequest struct {
+	ZoneID *uint  `json:"zone_id"` // nil removes the device from its zone
+	Name   string `json:"name"`
+}
+
+type AddFarmMemberRequest struct {
+	UserID  uint  `json:"user_id" binding:"required"`
+	FieldID *uint `json:"field_id"`
+	ZoneID  *uint `json:"zone_id"`
+}
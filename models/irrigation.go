@@ -0,0 +1,92 @@
+package models
+
+import "time"
+
+// Actuator kinds
+const (
+	ActuatorValve = "valve"
+	ActuatorPump  = "pump"
+)
+
+// Irrigation command actions
+const (
+	IrrigationStart = "start"
+	IrrigationStop  = "stop"
+)
+
+// Irrigation command states. A command is queued as pending, becomes sent once
+// the device has fetched it and then ends as acknowledged, completed, failed,
+// timed_out or cancelled.
+const (
+	CommandPending      = "pending"
+	CommandSent         = "sent"
+	CommandAcknowledged = "acknowledged"
+	CommandCompleted    = "completed"
+	CommandFailed       = "failed"
+	CommandTimedOut     = "timed_out"
+	CommandCancelled    = "cancelled"
+)
+
+// Watering event states
+const (
+	WateringRunning   = "running"
+	WateringCompleted = "completed"
+	WateringAborted   = "aborted"
+)
+
+// Actuator is a valve or pump controlled by a device.
+type Actuator struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	UserID        uint      `json:"user_id" gorm:"index;not null"`
+	DeviceID      string    `json:"device_id" gorm:"index;not null"`
+	Name          string    `json:"name" gorm:"not null"`
+	Kind          string    `json:"kind" gorm:"default:valve"`
+	Channel       int       `json:"channel"`       // Output pin or relay index on the device
+	FlowRateLPM   float64   `json:"flow_rate_lpm"` // Litres per minute, used to estimate volume
+	MaxRunSeconds int       `json:"max_run_seconds"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// IrrigationCommand is a start or stop instruction queued for a device.
+type IrrigationCommand struct {
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	UserID          uint       `json:"user_id" gorm:"index;not null"`
+	DeviceID        string     `json:"device_id" gorm:"index;not null"`
+	ActuatorID      uint       `json:"actuator_id" gorm:"index;not null"`
+	Channel         int        `json:"channel"`
+	Action          string     `json:"action"`
+	DurationSeconds int        `json:"duration_seconds"`
+	Status          string     `json:"status" gorm:"index;default:pending"`
+	Source          string     `json:"source"` // manual, rule or schedule
+	RequestedBy     uint       `json:"requested_by"`
+	CreatedAt       time.Time  `json:"created_at"`
+	SentAt          *time.Time `json:"sent_at"`
+	AckedAt         *time.Time `json:"acked_at"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// WateringEvent is one period during which an actuator was running.
+type WateringEvent struct {
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	UserID          uint       `json:"user_id" gorm:"index;not null"`
+	DeviceID        string     `json:"device_id" gorm:"index;not null"`
+	ActuatorID      uint       `json:"actuator_id" gorm:"index;not null"`
+	CommandID       uint       `json:"command_id"`
+	Source          string     `json:"source"`
+	Status          string     `json:"status"`
+	StartedAt       time.Time  `json:"started_at"`
+	EndedAt         *time.Time `json:"ended_at"`
+	PlannedSeconds  int        `json:"planned_seconds"`
+	DurationSeconds int        `json:"duration_seconds"`
+	VolumeLiters    float64    `json:"volume_liters"`
+}
+
+type IrrigationStartRequest struct {
+	DurationSeconds int `json:"duration_seconds" binding:"required"`
+}
+
+type IrrigationAckRequest struct {
+	Status string `json:"status" binding:"required"` // acknowledged, completed or failed
+	Error  string `json:"error"`
+}
@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// Irrigation rule decisions
+const (
+	DecisionIrrigate = "irrigate"
+	DecisionDryRun   = "dry_run"
+	DecisionSkip     = "skip"
+)
+
+// IrrigationRule waters an actuator when a device's soil moisture stays below
+// a threshold, subject to quiet hours, cooldowns and safety interlocks.
+type IrrigationRule struct {
+	ID         uint   `json:"id" gorm:"primaryKey"`
+	UserID     uint   `json:"user_id" gorm:"index;not null"`
+	Name       string `json:"name" gorm:"not null"`
+	DeviceID   string `json:"device_id" gorm:"not null"` // Device whose readings drive the rule
+	ActuatorID uint   `json:"actuator_id" gorm:"not null"`
+	Enabled    bool   `json:"enabled"` // True unless the request says otherwise
+	DryRun     bool   `json:"dry_run"` // Log decisions without queueing commands
+
+	// Trigger: soil moisture below Threshold for SustainMinutes, or predicted to
+	// drop below it within ForecastHours when UseForecast is set
+	Threshold      float32 `json:"threshold"`
+	SustainMinutes int     `json:"sustain_minutes"`
+	UseForecast    bool    `json:"use_forecast"`
+	ForecastHours  int     `json:"forecast_hours"`
+
+	DurationSeconds int `json:"duration_seconds"`
+
+	// Constraints
+	QuietStart      string `json:"quiet_start"` // "HH:MM", no watering from QuietStart until QuietEnd
+	QuietEnd        string `json:"quiet_end"`
+	Timezone        string `json:"timezone"`
+	MaxRunsPerDay   int    `json:"max_runs_per_day"`
+	CooldownMinutes int    `json:"cooldown_minutes"`
+
+	// Safety interlocks
+	MaxDailyVolumeLiters float64 `json:"max_daily_volume_liters"`
+	StaleAfterMinutes    int     `json:"stale_after_minutes"` // Lock out when the latest reading is older than this
+
+	// Weather, applied only when a weather provider is configured
+	SkipIfRainMM       float64 `json:"skip_if_rain_mm"` // Skip when this much rain is forecast within RainLookaheadHours
+	RainLookaheadHours int     `json:"rain_lookahead_hours"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IrrigationDecision is the explainable record of one rule evaluation.
+type IrrigationDecision struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	RuleID      uint      `json:"rule_id" gorm:"index;not null"`
+	UserID      uint      `json:"user_id" gorm:"index;not null"`
+	Trigger     string    `json:"trigger"` // reading, scheduler or manual
+	Decision    string    `json:"decision"`
+	Reason      string    `json:"reason"`
+	Inputs      string    `json:"inputs" gorm:"type:text"` // RuleInputs as JSON
+	CommandID   *uint     `json:"command_id"`
+	EvaluatedAt time.Time `json:"evaluated_at" gorm:"index"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// IrrigationSchedule waters an actuator at fixed times described by an RRULE,
+// e.g. "FREQ=WEEKLY;BYDAY=MO,WE,FR;BYHOUR=6;BYMINUTE=0".
+type IrrigationSchedule struct {
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	UserID          uint       `json:"user_id" gorm:"index;not null"`
+	Name            string     `json:"name" gorm:"not null"`
+	ActuatorID      uint       `json:"actuator_id" gorm:"not null"`
+	DeviceID        string     `json:"device_id"` // Device whose readings drive the skip conditions
+	RRule           string     `json:"rrule" gorm:"not null"`
+	StartsAt        time.Time  `json:"starts_at"`
+	Timezone        string     `json:"timezone"`
+	DurationSeconds int        `json:"duration_seconds"`
+	Enabled         bool       `json:"enabled"` // True unless the request says otherwise
+	LastCheckedAt   *time.Time `json:"last_checked_at"`
+
+	// Skip conditions, zero disables a condition
+	SkipIfMoistureAbove float32 `json:"skip_if_moisture_above"` // Already wet
+	SkipIfRainMM        float64 `json:"skip_if_rain_mm"`        // Rain forecast within RainLookaheadHours
+	RainLookaheadHours  int     `json:"rain_lookahead_hours"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IrrigationScheduleRun records what happened at one scheduled occurrence.
+type IrrigationScheduleRun struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	ScheduleID   uint      `json:"schedule_id" gorm:"uniqueIndex:idx_schedule_occurrence;not null"`
+	UserID       uint      `json:"user_id" gorm:"index;not null"`
+	ScheduledFor time.Time `json:"scheduled_for" gorm:"uniqueIndex:idx_schedule_occurrence"`
+	Decision     string    `json:"decision"`
+	Reason       string    `json:"reason"`
+	CommandID    *uint     `json:"command_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Reasons a login attempt failed
+const (
+	LoginFailedUnknownUser = "unknown_user"
+	LoginFailedBadPassword = "bad_password"
+	LoginFailedTwoFactor   = "bad_two_factor"
+	LoginFailedLocked      = "locked"
+	LoginFailedRateLimited = "rate_limited"
+)
+
+// LoginAttempt audits a failed login.
+type LoginAttempt struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    *uint     `json:"user_id" gorm:"index"` // Nil when the username is unknown
+	Username  string    `json:"username" gorm:"index"`
+	IP        string    `json:"ip" gorm:"index"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// AccountLockout counts an account's consecutive failed logins and how long
+// it is locked for.
+type AccountLockout struct {
+	UserID         uint       `json:"user_id" gorm:"primaryKey"`
+	FailedAttempts int        `json:"failed_attempts"`
+	LockedUntil    *time.Time `json:"locked_until"`
+	LastFailedAt   time.Time  `json:"last_failed_at"`
+}
@@ -0,0 +1,90 @@
+package models
+
+import "time"
+
+// OIDCProvider is an organisation's OpenID Connect identity provider. Users
+// who sign in through it join the organisation with the role their IdP
+// groups map to.
+type OIDCProvider struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	OrganisationID uint      `json:"organisation_id" gorm:"index;not null"`
+	Slug           string    `json:"slug" gorm:"uniqueIndex;not null"` // Names the provider in login URLs
+	Name           string    `json:"name"`
+	IssuerURL      string    `json:"issuer_url" gorm:"not null"`
+	ClientID       string    `json:"client_id" gorm:"not null"`
+	ClientSecret   string    `json:"-"` // Empty for public clients, which rely on PKCE alone
+	Scopes         string    `json:"scopes"`
+	GroupsClaim    string    `json:"groups_claim"`
+	DefaultRole    string    `json:"default_role"` // Role when no group maps, "" refuses such users
+	Enabled        bool      `json:"enabled"`
+	CreatedAt      time.Time `json:"created_at"`
+
+	GroupMappings []OIDCGroupMapping `json:"group_mappings" gorm:"foreignKey:ProviderID;constraint:OnDelete:CASCADE"`
+}
+
+// OIDCGroupMapping gives members of an IdP group an organisation role.
+type OIDCGroupMapping struct {
+	ID         uint   `json:"id" gorm:"primaryKey"`
+	ProviderID uint   `json:"provider_id" gorm:"index;not null"`
+	Group      string `json:"group" gorm:"not null"`
+	Role       string `json:"role" gorm:"not null"`
+}
+
+// ExternalIdentity links a user to their subject at an identity provider.
+type ExternalIdentity struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	ProviderID  uint      `json:"provider_id" gorm:"not null;uniqueIndex:idx_external_identity"`
+	Subject     string    `json:"subject" gorm:"not null;uniqueIndex:idx_external_identity"`
+	UserID      uint      `json:"user_id" gorm:"index;not null"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// OIDCLoginState carries an authorization request from the redirect to the
+// IdP through to its callback. It is used once.
+type OIDCLoginState struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	StateHash    string     `json:"-" gorm:"uniqueIndex;not null"`
+	ProviderID   uint       `json:"provider_id" gorm:"not null"`
+	CodeVerifier string     `json:"-" gorm:"not null"`
+	Nonce        string     `json:"-" gorm:"not null"`
+	ReturnTo     string     `json:"return_to"` // Frontend page to send the user back to
+	LinkUserID   *uint      `json:"-"`         // Set when a signed-in user links the identity to their account
+	ExpiresAt    time.Time  `json:"expires_at"`
+	UsedAt       *time.Time `json:"used_at"`
+}
+
+// OIDCLoginCode is a short-lived, single-use code the frontend exchanges for
+// tokens after an OIDC login, so tokens never appear in a URL.
+type OIDCLoginCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CodeHash  string     `json:"-" gorm:"uniqueIndex;not null"`
+	UserID    uint       `json:"user_id" gorm:"not null"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+type OIDCProviderRequest struct {
+	Slug          string             `json:"slug" binding:"required"`
+	Name          string             `json:"name"`
+	IssuerURL     string             `json:"issuer_url" binding:"required"`
+	ClientID      string             `json:"client_id" binding:"required"`
+	ClientSecret  *string            `json:"client_secret"` // Omit to keep the stored secret
+	Scopes        string             `json:"scopes"`
+	GroupsClaim   string             `json:"groups_claim"`
+	DefaultRole   string             `json:"default_role"`
+	Enabled       *bool              `json:"enabled"`
+	GroupMappings []OIDCGroupMapping `json:"group_mappings"`
+}
+
+// OIDCLinkRequest starts linking an identity to the caller's account. The
+// password proves the caller owns the account.
+type OIDCLinkRequest struct {
+	Password string `json:"password" binding:"required"`
+	ReturnTo string `json:"return_to"`
+}
+
+type OIDCExchangeRequest struct {
+	LoginCode string `json:"login_code" binding:"required"`
+}
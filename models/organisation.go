@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// Organisation roles, from most to least privileged
+const (
+	OrgRoleOwner   = "owner"   // Shares their data with the organisation and manages its members
+	OrgRoleManager = "manager" // Reads, edits and deletes the owners' data and invites members
+	OrgRoleViewer  = "viewer"  // Reads the owners' data
+)
+
+// Organisation is a team sharing the sensor data of its owners with its
+// other members.
+type Organisation struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"not null"`
+	CreatedBy uint      `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	// RequireTwoFactor makes owners and managers without two-factor
+	// authentication act as viewers until they enable it.
+	RequireTwoFactor bool `json:"require_two_factor" gorm:"default:false"`
+}
+
+// OrgMember is a user's role in an organisation.
+type OrgMember struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	OrganisationID uint      `json:"organisation_id" gorm:"not null;uniqueIndex:idx_org_member"`
+	UserID         uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_org_member;index"`
+	Role           string    `json:"role" gorm:"not null"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// OrgInvitation invites an email address to join an organisation. Only the
+// SHA-256 hash of the token is stored.
+type OrgInvitation struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	OrganisationID uint       `json:"organisation_id" gorm:"index;not null"`
+	Email          string     `json:"email" gorm:"not null"`
+	Role           string     `json:"role" gorm:"not null"`
+	TokenHash      string     `json:"-" gorm:"uniqueIndex;not null"`
+	InvitedBy      uint       `json:"invited_by"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at"`
+	AcceptedBy     *uint      `json:"accepted_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type CreateOrganisationRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type InviteMemberRequest struct {
+	Email string `json:"email" binding:"required"`
+	Role  string `json:"role" binding:"required"`
+}
+
+type AcceptInvitationRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
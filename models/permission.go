@@ -0,0 +1,64 @@
+package models
+
+// Platform roles stored in User.Role
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+	// RoleDevice is held by requests authenticated with a device key, never by a user.
+	RoleDevice = "device"
+)
+
+// Resources permissions apply to
+const (
+	ResourceSensorData    = "sensor_data"
+	ResourceUsers         = "users"
+	ResourceProfile       = "profile"
+	ResourceSessions      = "sessions"
+	ResourceDeveloperMode = "developer_mode"
+	ResourceDeviceConfig  = "device_config"
+	ResourceAIConfig      = "ai_config"
+	ResourceAIModels      = "ai_models"
+	ResourceCrops         = "crops"
+	ResourcePlantings     = "plantings"
+	ResourceIrrigation    = "irrigation"
+	ResourceWeather       = "weather"
+	ResourceWaterBalance  = "water_balance"
+	ResourceLocation      = "location"
+	ResourceFarms         = "farms"
+	ResourceDevices       = "devices"
+	ResourceDeviceKeys    = "device_keys"
+	ResourceDeviceCommand = "device_commands" // Irrigation commands as seen by the device
+	ResourceOrganisations = "organisations"
+	ResourceNotifications = "notifications"
+	ResourcePermissions   = "permissions"
+	ResourceAuditLog      = "audit_log" // Admins only
+	ResourceReports       = "reports"
+)
+
+// Actions on a resource
+const (
+	ActionRead      = "read"
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionDelete    = "delete"
+	ActionManage    = "manage"     // Platform-wide changes, such as toggling developer mode
+	ActionManageAny = "manage_any" // Act on any user's resources, not only one's own
+)
+
+// PermissionWildcard matches any resource or action.
+const PermissionWildcard = "*"
+
+// Permission is an action on a resource.
+type Permission struct {
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+}
+
+func (p Permission) String() string {
+	return p.Resource + ":" + p.Action
+}
+
+// NewPermission returns the permission to take action on resource.
+func NewPermission(resource, action string) Permission {
+	return Permission{Resource: resource, Action: action}
+}
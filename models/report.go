@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// Report formats
+const (
+	ReportFormatHTML = "html"
+	ReportFormatPDF  = "pdf"
+)
+
+// Report sections a template can include
+const (
+	ReportSectionSummary  = "summary"  // Per-field summary statistics
+	ReportSectionCharts   = "charts"   // Daily averages per field
+	ReportSectionAbnormal = "abnormal" // Table of abnormal readings
+	ReportSectionAccuracy = "accuracy" // Latest training metrics of the models in use
+)
+
+// ReportTemplate describes a report on a farm, or one field of it, generated
+// at the times described by an RRULE and covering the PeriodDays before each.
+type ReportTemplate struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	UserID        uint       `json:"user_id" gorm:"index;not null"`
+	Name          string     `json:"name" gorm:"not null"`
+	FarmID        uint       `json:"farm_id" gorm:"not null"`
+	FieldID       *uint      `json:"field_id"` // Narrows the report to one field
+	Format        string     `json:"format"`   // html or pdf, html by default
+	Sections      string     `json:"sections"` // Comma-separated, every section when empty
+	PeriodDays    int        `json:"period_days"`
+	RRule         string     `json:"rrule"` // e.g. "FREQ=WEEKLY;BYDAY=MO;BYHOUR=7;BYMINUTE=0"
+	StartsAt      time.Time  `json:"starts_at"`
+	Timezone      string     `json:"timezone"`
+	Recipients    string     `json:"recipients"` // Comma-separated emails, none to only store reports
+	Enabled       bool       `json:"enabled"`    // True unless the request says otherwise
+	LastCheckedAt *time.Time `json:"last_checked_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Report is a generated report kept for download. ScheduledFor is the
+// occurrence it was generated for, nil for reports generated on demand.
+type Report struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	TemplateID   uint       `json:"template_id" gorm:"uniqueIndex:idx_report_occurrence;not null"`
+	UserID       uint       `json:"user_id" gorm:"index;not null"`
+	ScheduledFor *time.Time `json:"scheduled_for" gorm:"uniqueIndex:idx_report_occurrence"`
+	Format       string     `json:"format"`
+	PeriodFrom   time.Time  `json:"period_from"`
+	PeriodTo     time.Time  `json:"period_to"`
+	Size         int        `json:"size"`
+	Content      []byte     `json:"-" gorm:"type:bytea"`
+	EmailedTo    string     `json:"emailed_to,omitempty"`
+	EmailError   string     `json:"email_error,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
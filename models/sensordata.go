@@ -1,17 +1,64 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type SensorData struct {
-	ID           uint      `json:"id" gorm:"primaryKey"`
-	UserID       uint      `json:"user_id" gorm:"not null"`
-	Timestamp    time.Time `json:"timestamp"`
-	Temperature  float32   `json:"temperature"`
-	Humidity     float32   `json:"humidity"`
-	SoilMoisture float32   `json:"soil_moisture"`
-	IsAbnormal   bool      `json:"is_abnormal"`
+	ID           uint           `json:"id" gorm:"primaryKey"`
+	UserID       uint           `json:"user_id" gorm:"not null"`
+	DeviceID     string         `json:"device_id" gorm:"index;default:esp32-001"`
+	Timestamp    time.Time      `json:"timestamp"`
+	Temperature  float32        `json:"temperature"`
+	Humidity     float32        `json:"humidity"`
+	SoilMoisture float32        `json:"soil_moisture"`
+	IsAbnormal   bool           `json:"is_abnormal"`
+	Extra        JSONText       `json:"extra,omitempty" gorm:"type:text"` // Further metrics by name, from imports
+	Revision     int            `json:"revision" gorm:"default:0"`        // Number of corrections, 0 as reported
+	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`                   // Set while the reading is in the trash
+	TrashBatch   string         `json:"-" gorm:"index"`                   // The deletion that trashed it, see Trash
+}
+
+// Which values of corrected readings to read
+const (
+	ReadingValuesCorrected = "corrected"
+	ReadingValuesRaw       = "raw" // As the device reported them
+)
+
+// ReadingRevision is one manual correction of a reading, with the values it
+// replaced. The previous values of revision 1 are the reading as reported.
+type ReadingRevision struct {
+	ID                   uint      `json:"id" gorm:"primaryKey"`
+	SensorDataID         uint      `json:"sensor_data_id" gorm:"uniqueIndex:idx_reading_revision;not null"`
+	Revision             int       `json:"revision" gorm:"uniqueIndex:idx_reading_revision;not null"`
+	PreviousTemperature  float32   `json:"previous_temperature"`
+	PreviousHumidity     float32   `json:"previous_humidity"`
+	PreviousSoilMoisture float32   `json:"previous_soil_moisture"`
+	PreviousIsAbnormal   bool      `json:"previous_is_abnormal"`
+	Temperature          float32   `json:"temperature"`
+	Humidity             float32   `json:"humidity"`
+	SoilMoisture         float32   `json:"soil_moisture"`
+	IsAbnormal           bool      `json:"is_abnormal"`
+	Reason               string    `json:"reason" gorm:"not null"`
+	EditedBy             uint      `json:"edited_by" gorm:"not null"`
+	CreatedAt            time.Time `json:"created_at"`
 }
-type ToggleAIRequest struct {
-	Plant   string `json:"plant"`
-	Enabled bool   `json:"enabled"`
+
+// SensorDataRequest is a reading as a device reports it. Everything else on
+// SensorData (ID, revision, extra metrics) is set by the server.
+type SensorDataRequest struct {
+	DeviceID     string  `json:"device_id"`
+	Temperature  float32 `json:"temperature"`
+	Humidity     float32 `json:"humidity"`
+	SoilMoisture float32 `json:"soil_moisture"`
+}
+
+// CorrectReadingRequest corrects a reading. Omitted values are kept.
+type CorrectReadingRequest struct {
+	Temperature  *float32 `json:"temperature"`
+	Humidity     *float32 `json:"humidity"`
+	SoilMoisture *float32 `json:"soil_moisture"`
+	Reason       string   `json:"reason" binding:"required"`
 }
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Trash is one deletion of sensor data, which can be restored as a whole
+// until it is purged.
+type Trash struct {
+	Batch      string    `json:"batch"`
+	UserID     uint      `json:"user_id"`
+	Records    int64     `json:"records"`
+	DeletedAt  time.Time `json:"deleted_at"`
+	PurgeAfter time.Time `json:"purge_after"`
+}
+
+// TrashedUser is a deleted account awaiting restore or purge.
+type TrashedUser struct {
+	ID         uint      `json:"id"`
+	Username   string    `json:"username"`
+	Email      string    `json:"email"`
+	Role       string    `json:"role"`
+	Batch      string    `json:"batch"`
+	DeletedAt  time.Time `json:"deleted_at"`
+	PurgeAfter time.Time `json:"purge_after"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// TwoFactor is a user's TOTP enrolment. It takes effect once confirmed with
+// a code from the authenticator app.
+type TwoFactor struct {
+	UserID       uint       `json:"user_id" gorm:"primaryKey"`
+	Secret       string     `json:"-" gorm:"not null"`
+	Enabled      bool       `json:"enabled"`
+	ConfirmedAt  *time.Time `json:"confirmed_at"`
+	LastUsedStep int64      `json:"-"` // Last TOTP step accepted, so codes cannot be replayed
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// RecoveryCode is a single-use code that stands in for a TOTP code when the
+// authenticator is lost. Only its hash is stored.
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"index;not null"`
+	CodeHash  string     `json:"-" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"` // TOTP code, or a recovery code where accepted
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+type OrgSettingsRequest struct {
+	RequireTwoFactor *bool `json:"require_two_factor"`
+}
@@ -1,9 +1,14 @@
 package models
 
+import "gorm.io/gorm"
+
 type User struct {
-	ID       uint   `json:"id" gorm:"primaryKey"`
-	Username string `json:"username" gorm:"unique;not null"`
-	Email    string `json:"email" gorm:"unique;not null"`
-	Password string `json:"-"` // Store hashed password
-	Role     string `json:"role" gorm:"default:user"`
+	ID            uint           `json:"id" gorm:"primaryKey"`
+	Username      string         `json:"username" gorm:"unique;not null"`
+	Email         string         `json:"email" gorm:"unique;not null"`
+	Password      string         `json:"-"` // Store hashed password
+	Role          string         `json:"role" gorm:"default:user"`
+	EmailVerified bool           `json:"email_verified" gorm:"default:false"`
+	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index"` // Set while the account is in the trash; its username and email stay taken
+	TrashBatch    string         `json:"-" gorm:"index"`
 }
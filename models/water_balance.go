@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// SoilParameters describe a root zone for the water balance.
+type SoilParameters struct {
+	FieldCapacity     float64 `json:"field_capacity"`     // Volumetric soil moisture %, same scale as SoilMoisture
+	WiltingPoint      float64 `json:"wilting_point"`      // Volumetric soil moisture %
+	RootDepthM        float64 `json:"root_depth_m"`       // Effective rooting depth
+	DepletionFraction float64 `json:"depletion_fraction"` // Fraction of TAW that can be depleted before stress (FAO-56 p)
+	AreaM2            float64 `json:"area_m2"`            // Irrigated area, converts watering volume to depth
+	ElevationM        float64 `json:"elevation_m"`
+}
+
+// SoilProfile describes the root zone under a device for the water balance.
+type SoilProfile struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	UserID   uint   `json:"user_id" gorm:"not null;uniqueIndex:idx_soil_profile_owner_device"`
+	DeviceID string `json:"device_id" gorm:"not null;uniqueIndex:idx_soil_profile_owner_device"`
+	SoilParameters
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FieldSoilProfile describes the root zone of a whole field for the water
+// balance pooled over the field's devices.
+type FieldSoilProfile struct {
+	ID      uint `json:"id" gorm:"primaryKey"`
+	FieldID uint `json:"field_id" gorm:"uniqueIndex;not null"`
+	SoilParameters
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WaterBalanceDay is one day of a device's root-zone water balance. Days of a
+// field's balance are not stored and have no user or device.
+type WaterBalanceDay struct {
+	ID               uint      `json:"id,omitempty" gorm:"primaryKey"`
+	UserID           uint      `json:"user_id,omitempty" gorm:"not null;uniqueIndex:idx_water_balance_day"`
+	DeviceID         string    `json:"device_id,omitempty" gorm:"not null;uniqueIndex:idx_water_balance_day"`
+	Date             time.Time `json:"date" gorm:"type:date;uniqueIndex:idx_water_balance_day"`
+	TMax             float64   `json:"t_max"`
+	TMin             float64   `json:"t_min"`
+	ET0              float64   `json:"et0"` // mm
+	ET0Method        string    `json:"et0_method"`
+	CropCoefficient  float64   `json:"crop_coefficient"`
+	ETc              float64   `json:"etc"`           // mm
+	RainMM           float64   `json:"rain_mm"`       // mm
+	IrrigationMM     float64   `json:"irrigation_mm"` // mm
+	Depletion        float64   `json:"depletion"`     // Root-zone depletion at end of day, mm
+	TAW              float64   `json:"taw"`           // Total available water, mm
+	RAW              float64   `json:"raw"`           // Readily available water, mm
+	SoilMoistureMean *float64  `json:"soil_moisture_mean"`
+	IrrigationNeeded bool      `json:"irrigation_needed"`
+	ComputedAt       time.Time `json:"computed_at"`
+}
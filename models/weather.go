@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Weather record kinds
+const (
+	WeatherCurrent  = "current"
+	WeatherForecast = "forecast"
+)
+
+// WeatherRecord is a cached weather observation or forecast hour for a location.
+type WeatherRecord struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	Provider        string    `json:"provider" gorm:"index:idx_weather_lookup"`
+	Latitude        float64   `json:"latitude" gorm:"index:idx_weather_lookup"` // Rounded to 0.01°
+	Longitude       float64   `json:"longitude" gorm:"index:idx_weather_lookup"`
+	Kind            string    `json:"kind" gorm:"index:idx_weather_lookup"`
+	ValidAt         time.Time `json:"valid_at" gorm:"index"`
+	FetchedAt       time.Time `json:"fetched_at"`
+	Temperature     float64   `json:"temperature"`      // °C
+	Humidity        float64   `json:"humidity"`         // %
+	PrecipitationMM float64   `json:"precipitation_mm"` // Over the hour ending at ValidAt
+	WindSpeed       float64   `json:"wind_speed"`       // m/s at 10 m
+	SolarRadiation  float64   `json:"solar_radiation"`  // W/m²
+	CloudCover      float64   `json:"cloud_cover"`      // %
+}
@@ -2,23 +2,66 @@ package utils
 
 import "fyp/models"
 
+// Thresholds holds the acceptable range of every sensor metric.
+type Thresholds struct {
+	MinTemperature  float32 `json:"min_temperature"`
+	MaxTemperature  float32 `json:"max_temperature"`
+	MinHumidity     float32 `json:"min_humidity"`
+	MaxHumidity     float32 `json:"max_humidity"`
+	MinSoilMoisture float32 `json:"min_soil_moisture"`
+	MaxSoilMoisture float32 `json:"max_soil_moisture"`
+}
+
+// DefaultThresholds applies when a device has no planting in a known growth stage.
+var DefaultThresholds = Thresholds{
+	MinTemperature:  20,
+	MaxTemperature:  50,
+	MinHumidity:     30,
+	MaxHumidity:     90,
+	MinSoilMoisture: 5,
+	MaxSoilMoisture: 95,
+}
+
+// StageThresholds returns the ideal ranges of a growth stage.
+func StageThresholds(stage models.GrowthStage) Thresholds {
+	return Thresholds{
+		MinTemperature:  stage.MinTemperature,
+		MaxTemperature:  stage.MaxTemperature,
+		MinHumidity:     stage.MinHumidity,
+		MaxHumidity:     stage.MaxHumidity,
+		MinSoilMoisture: stage.MinSoilMoisture,
+		MaxSoilMoisture: stage.MaxSoilMoisture,
+	}
+}
+
 // CheckAbnormality determines whether the sensor data is abnormal.
 func CheckAbnormality(data models.SensorData) bool {
-	return data.Temperature < 20 || data.Temperature > 50 ||
-		data.Humidity < 30 || data.Humidity > 90 ||
-		data.SoilMoisture < 5 || data.SoilMoisture > 95
+	return CheckAbnormalityWith(data, DefaultThresholds)
+}
+
+// CheckAbnormalityWith determines whether the sensor data falls outside t.
+func CheckAbnormalityWith(data models.SensorData, t Thresholds) bool {
+	return GetAbnormalTypeWith(data, t) != ""
 }
 
 // GetAbnormalType returns a string describing which sensor reading is abnormal.
 func GetAbnormalType(record models.SensorData) string {
-	if record.Temperature < 20 || record.Temperature > 50 {
+	if abnormalType := GetAbnormalTypeWith(record, DefaultThresholds); abnormalType != "" {
+		return abnormalType
+	}
+	return "Unknown"
+}
+
+// GetAbnormalTypeWith returns which sensor reading falls outside t, or "" if none.
+func GetAbnormalTypeWith(record models.SensorData, t Thresholds) string {
+	if record.Temperature < t.MinTemperature || record.Temperature > t.MaxTemperature {
 		return "Temperature"
 	}
-	if record.Humidity < 30 || record.Humidity > 90 {
+	if record.Humidity < t.MinHumidity || record.Humidity > t.MaxHumidity {
 		return "Humidity"
 	}
-	if record.SoilMoisture < 5 || record.SoilMoisture > 95 {
+	if record.SoilMoisture < t.MinSoilMoisture || record.SoilMoisture > t.MaxSoilMoisture {
 		return "Soil Moisture"
 	}
-	return "Unknown"
+	return ""
 }
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	EmailVerificationTTL = 48 * time.Hour
+	PasswordResetTTL     = time.Hour
+	EmailChangeTTL       = 48 * time.Hour
+)
+
+// ErrInvalidUserToken is returned for unknown, used or expired tokens.
+var ErrInvalidUserToken = errors.New("invalid or expired token")
+
+// NewUserToken creates a single-use token for a user and returns it. Earlier
+// unused tokens of the same purpose stop working.
+func NewUserToken(db *gorm.DB, user models.User, purpose string, ttl time.Duration) (string, error) {
+	token, hash, err := NewInvitationToken()
+	if err != nil {
+		return "", err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&models.UserToken{}).Where("user_id = ? AND purpose = ? AND used_at IS NULL", user.ID, purpose).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.UserToken{
+			UserID:    user.ID,
+			Purpose:   purpose,
+			Email:     user.Email,
+			TokenHash: hash,
+			ExpiresAt: now.Add(ttl),
+		}).Error
+	})
+	return token, err
+}
+
+// ConsumeUserToken marks a token used and returns it. A token works once,
+// even if it is presented twice at the same time.
+func ConsumeUserToken(db *gorm.DB, token, purpose string) (models.UserToken, error) {
+	var record models.UserToken
+	if err := db.Where("token_hash = ? AND purpose = ?", HashToken(token), purpose).First(&record).Error; err != nil {
+		return record, ErrInvalidUserToken
+	}
+	if record.UsedAt != nil || time.Now().After(record.ExpiresAt) {
+		return record, ErrInvalidUserToken
+	}
+	result := db.Model(&models.UserToken{}).Where("id = ? AND used_at IS NULL", record.ID).Update("used_at", time.Now())
+	if result.Error != nil {
+		return record, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return record, ErrInvalidUserToken
+	}
+	return record, nil
+}
+
+// AppURL returns the frontend's base URL from APP_URL.
+func AppURL() string {
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	return strings.TrimSuffix(base, "/")
+}
+
+// AppLink returns a link into the frontend at APP_URL.
+func AppLink(path, token string) string {
+	return AppURL() + path + "?token=" + url.QueryEscape(token)
+}
+
+// SendVerificationEmail mails a user a link to verify their email address.
+func SendVerificationEmail(db *gorm.DB, user models.User) error {
+	token, err := NewUserToken(db, user, models.TokenPurposeVerifyEmail, EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	return SendMail(Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: "Hi " + user.Username + ",\n\nConfirm your email address by opening this link within 48 hours:\n" +
+			AppLink("/verify-email", token) + "\n\nIf you did not sign up, you can ignore this email.\n",
+	})
+}
+
+// SendPasswordResetEmail mails a user a link to choose a new password.
+func SendPasswordResetEmail(db *gorm.DB, user models.User) error {
+	token, err := NewUserToken(db, user, models.TokenPurposeResetPassword, PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+	return SendMail(Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Hi " + user.Username + ",\n\nChoose a new password by opening this link within an hour:\n" +
+			AppLink("/reset-password", token) + "\n\nIf you did not ask to reset your password, you can ignore this email.\n",
+	})
+}
+
+// SendEmailChangeConfirmation mails a link to confirm newEmail as a user's
+// address, which only takes over once the link is opened. The current
+// address is told about the change in case it was not the user's doing.
+func SendEmailChangeConfirmation(db *gorm.DB, user models.User, newEmail string) error {
+	pending := user
+	pending.Email = newEmail
+	token, err := NewUserToken(db, pending, models.TokenPurposeChangeEmail, EmailChangeTTL)
+	if err != nil {
+		return err
+	}
+	if err := SendMail(Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body: "Hi " + user.Username + ",\n\nConfirm this as your new email address by opening this link within 48 hours:\n" +
+			AppLink("/confirm-email", token) + "\n\nUntil then your account keeps using " + user.Email + ".\n",
+	}); err != nil {
+		return err
+	}
+	return SendMail(Message{
+		To:      user.Email,
+		Subject: "Your email address is being changed",
+		Body: "Hi " + user.Username + ",\n\nSomeone signed in to your account asked to change its email address to " + newEmail +
+			". It changes once the link sent there is opened.\n\nIf this was not you, change your password now.\n",
+	})
+}
@@ -2,23 +2,20 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"fyp/models"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"sync"
 	"time"
 
 	"gorm.io/gorm"
 )
 
-var (
-	mu        sync.RWMutex
-	aiEnabled bool
-	plantAI   string
-)
+// AIHTTPClient calls the AI service. Its timeout bounds each prediction.
+var AIHTTPClient = &http.Client{Timeout: 15 * time.Second}
 
 // AIRequestData represents the structure for the AI API request
 type AIRequestData struct {
@@ -32,6 +29,31 @@ type AIRequestData struct {
 	HumidityRolling24 string `json:"humidity_rolling_24"`
 	TempLag1          string `json:"temp_lag_1"`
 	HumidityLag1      string `json:"humidity_lag_1"`
+
+	// Outdoor conditions, sent only when a weather provider is configured
+	OutdoorTemperature string `json:"outdoor_temperature,omitempty"`
+	OutdoorHumidity    string `json:"outdoor_humidity,omitempty"`
+	Precipitation      string `json:"precipitation,omitempty"`
+}
+
+// attachWeather adds the current outdoor conditions at the device's location
+// to the features. Weather is optional, so failures leave the features unchanged.
+func attachWeather(db *gorm.DB, userID uint, deviceID string, features *AIRequestData) {
+	if GetWeatherProvider() == nil {
+		return
+	}
+	lat, lon, err := DeviceCoordinates(db, userID, deviceID)
+	if err != nil {
+		return
+	}
+	weather, err := CurrentWeather(db, lat, lon)
+	if err != nil {
+		fmt.Println("❌ Weather lookup failed:", err)
+		return
+	}
+	features.OutdoorTemperature = fmt.Sprintf("%.1f", weather.Temperature)
+	features.OutdoorHumidity = fmt.Sprintf("%.1f", weather.Humidity)
+	features.Precipitation = fmt.Sprintf("%.1f", weather.PrecipitationMM)
 }
 
 // calculateRollingAverage calculates the average of the last n records
@@ -59,15 +81,15 @@ func calculateRollingAverage(values []float32, n int) float32 {
 	return sum / float32(count)
 }
 
-// getHistoricalData retrieves historical sensor data for calculating features
-func getHistoricalData(db *gorm.DB, userID uint, currentTime time.Time) ([]models.SensorData, error) {
+// getHistoricalData retrieves a device's historical sensor data for calculating features
+func getHistoricalData(db *gorm.DB, userID uint, deviceID string, currentTime time.Time) ([]models.SensorData, error) {
 	var sensorData []models.SensorData
 
 	// Get data from the last 24 hours, ordered by timestamp
 	twentyFourHoursAgo := currentTime.Add(-24 * time.Hour)
 
-	err := db.Where("user_id = ? AND timestamp >= ? AND timestamp < ?",
-		userID, twentyFourHoursAgo, currentTime).
+	err := db.Where("user_id = ? AND device_id = ? AND timestamp >= ? AND timestamp < ?",
+		userID, deviceID, twentyFourHoursAgo, currentTime).
 		Order("timestamp ASC").
 		Find(&sensorData).Error
 
@@ -118,10 +140,8 @@ func calculateFeatures(historicalData []models.SensorData, currentTemp, currentH
 	return features, nil
 }
 
-// GetPredictedSoilMoisture calls the AI API to predict soil moisture with enhanced features
-func GetPredictedSoilMoisture(db *gorm.DB, userID uint, plant string, timestamp string, temperature, humidity float32) (string, float64, error) {
-	apiURL := os.Getenv("AI_URL")
-
+// GetPredictedSoilMoisture calls the AI API to predict a device's soil moisture with enhanced features
+func GetPredictedSoilMoisture(db *gorm.DB, userID uint, deviceID string, plant string, timestamp string, temperature, humidity float32) (string, float64, error) {
 	// Parse timestamp to get the current time
 	currentTime, err := time.Parse("2006-01-02 15:04:05", timestamp)
 	if err != nil {
@@ -129,7 +149,7 @@ func GetPredictedSoilMoisture(db *gorm.DB, userID uint, plant string, timestamp
 	}
 
 	// Get historical data for feature calculation
-	historicalData, err := getHistoricalData(db, userID, currentTime)
+	historicalData, err := getHistoricalData(db, userID, deviceID, currentTime)
 	if err != nil {
 		return "", 0, fmt.Errorf("failed to get historical data: %v", err)
 	}
@@ -143,6 +163,15 @@ func GetPredictedSoilMoisture(db *gorm.DB, userID uint, plant string, timestamp
 	// Set plant name and timestamp
 	features.PlantName = plant
 	features.Timestamp = timestamp
+	attachWeather(db, userID, deviceID, &features)
+
+	return requestPrediction(context.Background(), features)
+}
+
+// requestPrediction posts a feature set to the AI API and returns the predicted soil moisture.
+// The request is abandoned when ctx is done.
+func requestPrediction(ctx context.Context, features AIRequestData) (string, float64, error) {
+	apiURL := os.Getenv("AI_URL")
 
 	// Create the request body
 	requestBody, err := json.Marshal(features)
@@ -155,11 +184,19 @@ func GetPredictedSoilMoisture(db *gorm.DB, userID uint, plant string, timestamp
 	fmt.Printf("AI API Request Body (Historical): %s\n", string(requestBody))
 
 	// Make the HTTP POST request
-	resp, err := http.Post(apiURL, "application/json", bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, bytes.NewReader(requestBody))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := AIHTTPClient.Do(req)
 	if err != nil {
 		return "", 0, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("AI service returned %s", resp.Status)
+	}
 
 	// Read the response body
 	body, err := ioutil.ReadAll(resp.Body)
@@ -209,7 +246,7 @@ func GetPredictedSoilMoistureSimple(plant string, timestamp string, temperature,
 	fmt.Printf("AI API Request Body: %s\n", string(requestBody))
 
 	// Make the HTTP POST request
-	resp, err := http.Post(apiURL, "application/json", bytes.NewBuffer(requestBody))
+	resp, err := AIHTTPClient.Post(apiURL, "application/json", bytes.NewBuffer(requestBody))
 	if err != nil {
 		return "", 0, err
 	}
@@ -228,16 +265,3 @@ func GetPredictedSoilMoistureSimple(plant string, timestamp string, temperature,
 
 	return timestamp, response["predicted_soil_moisture"], nil
 }
-
-func SetGlobalAIEnabled(enabled bool, plant string) {
-	mu.Lock()
-	defer mu.Unlock()
-	aiEnabled = enabled
-	plantAI = plant
-}
-
-func IsGlobalAIEnabled() (bool, string) {
-	mu.RLock()
-	defer mu.RUnlock()
-	return aiEnabled, plantAI
-}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+// AuditEntry describes an action to record. Before and After are any values
+// that marshal to JSON, nil when there is no such state.
+type AuditEntry struct {
+	ActorID    *uint
+	Action     string
+	TargetType string
+	TargetID   interface{}
+	Before     interface{}
+	After      interface{}
+	IP         string
+}
+
+// RecordAudit appends an entry to the audit log. Pass the transaction that
+// makes the change, so the change and its record commit or fail together.
+func RecordAudit(tx *gorm.DB, entry AuditEntry) error {
+	before, err := auditJSON(entry.Before)
+	if err != nil {
+		return err
+	}
+	after, err := auditJSON(entry.After)
+	if err != nil {
+		return err
+	}
+	targetID := ""
+	if entry.TargetID != nil {
+		targetID = fmt.Sprint(entry.TargetID)
+	}
+	return tx.Create(&models.AuditLog{
+		ActorID:    entry.ActorID,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   targetID,
+		Before:     before,
+		After:      after,
+		IP:         entry.IP,
+		CreatedAt:  time.Now(),
+	}).Error
+}
+
+func auditJSON(value interface{}) (models.JSONText, error) {
+	if value == nil {
+		return "", nil
+	}
+	encoded, err := json.Marshal(value)
+	return models.JSONText(encoded), err
+}
+
+// AuditFilter selects audit log entries. Zero fields match everything.
+type AuditFilter struct {
+	ActorID    *uint
+	Action     string
+	TargetType string
+	TargetID   string
+	From       time.Time
+	To         time.Time
+	BeforeID   uint // Only entries older than this ID, for paging
+}
+
+// AuditQuery returns the entries matching a filter, newest first.
+func AuditQuery(db *gorm.DB, filter AuditFilter) *gorm.DB {
+	query := db.Model(&models.AuditLog{})
+	if filter.ActorID != nil {
+		query = query.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.BeforeID != 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+	return query.Order("id desc")
+}
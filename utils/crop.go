@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+// StageWindow is a growth stage with the dates it starts and ends for a planting.
+type StageWindow struct {
+	Stage models.GrowthStage `json:"stage"`
+	Start time.Time          `json:"start"`
+	End   time.Time          `json:"end"`
+}
+
+// StageSchedule lays the crop's growth stages out back to back from sowDate.
+func StageSchedule(stages []models.GrowthStage, sowDate time.Time) []StageWindow {
+	ordered := append([]models.GrowthStage(nil), stages...)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].Sequence < ordered[j].Sequence })
+
+	windows := make([]StageWindow, 0, len(ordered))
+	start := sowDate
+	for _, stage := range ordered {
+		end := start.AddDate(0, 0, stage.DurationDays)
+		windows = append(windows, StageWindow{Stage: stage, Start: start, End: end})
+		start = end
+	}
+	return windows
+}
+
+// CurrentStage returns the growth stage a planting sown on sowDate is in at
+// time at. It returns false before sowing and after the last stage has ended.
+func CurrentStage(stages []models.GrowthStage, sowDate, at time.Time) (StageWindow, bool) {
+	for _, window := range StageSchedule(stages, sowDate) {
+		if !at.Before(window.Start) && at.Before(window.End) {
+			return window, true
+		}
+	}
+	return StageWindow{}, false
+}
+
+// ActivePlanting loads the active planting of a device with its crop catalog
+// entry: the device's own planting, or else that of the field the device is
+// placed in. It returns gorm.ErrRecordNotFound if neither is planted.
+func ActivePlanting(db *gorm.DB, userID uint, deviceID string) (models.Planting, error) {
+	var planting models.Planting
+	err := db.Preload("Crop.Stages").Preload("Variety").
+		Where("user_id = ? AND device_id = ? AND field_id IS NULL AND active = ?", userID, deviceID, true).
+		Order("sow_date desc").First(&planting).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return planting, err
+	}
+
+	var zone models.Zone
+	if db.Joins("JOIN devices ON devices.zone_id = zones.id").
+		Where("devices.user_id = ? AND devices.device_id = ?", userID, deviceID).First(&zone).Error != nil {
+		return planting, err
+	}
+	return ActiveFieldPlanting(db, zone.FieldID)
+}
+
+// ActiveFieldPlanting loads the active planting of a field with its crop
+// catalog entry. It returns gorm.ErrRecordNotFound if the field is not planted.
+func ActiveFieldPlanting(db *gorm.DB, fieldID uint) (models.Planting, error) {
+	var planting models.Planting
+	err := db.Preload("Crop.Stages").Preload("Variety").
+		Where("field_id = ? AND active = ?", fieldID, true).
+		Order("sow_date desc").First(&planting).Error
+	return planting, err
+}
+
+// PlantingDevices returns the devices whose readings a planting grows on: its
+// device, or every device placed in its field.
+func PlantingDevices(db *gorm.DB, planting models.Planting) ([]DeviceKey, error) {
+	if planting.FieldID == nil {
+		return []DeviceKey{{UserID: planting.UserID, DeviceID: planting.DeviceID}}, nil
+	}
+	zoneIDs, err := ZonesUnder(db, models.LevelField, *planting.FieldID)
+	if err != nil || len(zoneIDs) == 0 {
+		return []DeviceKey{}, err
+	}
+	var devices []models.Device
+	if err := db.Where("zone_id IN ?", zoneIDs).Find(&devices).Error; err != nil {
+		return nil, err
+	}
+	keys := make([]DeviceKey, 0, len(devices))
+	for _, device := range devices {
+		keys = append(keys, DeviceKey{UserID: device.UserID, DeviceID: device.DeviceID})
+	}
+	return keys, nil
+}
+
+// PlantingThresholds returns the ideal ranges and AI model name for a device at
+// time at, from its own planting or its field's (see ActivePlanting), falling back to DefaultThresholds and an empty model name when the
+// device has no planting in a known stage.
+func PlantingThresholds(db *gorm.DB, userID uint, deviceID string, at time.Time) (Thresholds, string) {
+	planting, err := ActivePlanting(db, userID, deviceID)
+	if err != nil {
+		return DefaultThresholds, ""
+	}
+
+	window, ok := CurrentStage(planting.Crop.Stages, planting.SowDate, at)
+	if !ok {
+		return DefaultThresholds, planting.Crop.Species
+	}
+
+	modelName := window.Stage.ModelName
+	if modelName == "" {
+		modelName = planting.Crop.Species
+	}
+	return StageThresholds(window.Stage), modelName
+}
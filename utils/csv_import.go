@@ -0,0 +1,323 @@
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultImportBatchSize = 1000
+	maxImportErrors        = 100 // Row errors reported in full; the rest are only counted
+)
+
+// Timestamp layouts accepted on import: DownloadCSV's, read in the local
+// timezone, and RFC 3339.
+var importTimeLayouts = []string{"2006-01-02 15:04:05", time.RFC3339}
+
+// Columns every import must have. device_id is optional and any other column
+// is stored as an extra metric.
+var requiredImportColumns = []string{"timestamp", "temperature", "humidity", "soil_moisture"}
+
+// Columns of a CSV export that the import works out again rather than reads.
+var ignoredImportColumns = []string{"id", "user_id", "is_abnormal", "revision"}
+
+var (
+	// ErrImportFile is returned for CSV files that cannot be imported at all.
+	ErrImportFile = errors.New("cannot import this file")
+	// errImportRejected rolls back an import that found invalid rows.
+	errImportRejected = errors.New("import has invalid rows")
+)
+
+// ImportOptions controls a CSV import.
+type ImportOptions struct {
+	UserID          uint   // Owner of the imported readings
+	DefaultDeviceID string // For rows without a device_id
+	DryRun          bool   // Validate only, write nothing
+	SkipInvalid     bool   // Import the valid rows even if some are invalid
+	BatchSize       int
+}
+
+// ImportRowError is a problem with one row of an import. Row is the line of
+// the file the row starts on, the header being line 1.
+type ImportRowError struct {
+	Row    int    `json:"row"`
+	Column string `json:"column,omitempty"`
+	Error  string `json:"error"`
+}
+
+// ImportResult reports the outcome of a CSV import.
+type ImportResult struct {
+	DryRun   bool             `json:"dry_run"`
+	Rows     int              `json:"rows"`
+	Valid    int              `json:"valid"`
+	Invalid  int              `json:"invalid"`
+	Imported int              `json:"imported"`
+	Abnormal int              `json:"abnormal"`
+	Devices  []string         `json:"devices"`
+	Extra    []string         `json:"extra_columns"`
+	Errors   []ImportRowError `json:"errors"`
+}
+
+// ImportSensorCSV reads readings from CSV in DownloadCSV's layout and writes
+// them in batches, classifying each against the thresholds in force at its
+// timestamp. Unless SkipInvalid is set, a file with any invalid row imports
+// nothing. The returned error is for failures that stop the import, such as a
+// malformed header; row problems are reported in the result.
+func ImportSensorCSV(db *gorm.DB, r io.Reader, opts ImportOptions) (ImportResult, error) {
+	result := ImportResult{DryRun: opts.DryRun, Devices: []string{}, Extra: []string{}, Errors: []ImportRowError{}}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultImportBatchSize
+	}
+	if opts.DefaultDeviceID == "" {
+		opts.DefaultDeviceID = models.DefaultDeviceID
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return result, fmt.Errorf("%w: failed to read the CSV header: %v", ErrImportFile, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, dup := columns[name]; dup {
+			return result, fmt.Errorf("%w: column %q appears twice", ErrImportFile, name)
+		}
+		columns[name] = i
+	}
+	for _, name := range requiredImportColumns {
+		if _, ok := columns[name]; !ok {
+			return result, fmt.Errorf("%w: missing required column %q", ErrImportFile, name)
+		}
+	}
+	for name := range columns {
+		if name != "device_id" && !containsString(requiredImportColumns, name) && !containsString(ignoredImportColumns, name) {
+			result.Extra = append(result.Extra, name)
+		}
+	}
+	sort.Strings(result.Extra)
+
+	importer := &csvImporter{db: db, opts: opts, columns: columns, result: &result,
+		thresholds: make(map[string]Thresholds), devices: make(map[string]bool), registered: make(map[string]bool)}
+	run := func(tx *gorm.DB) error {
+		importer.db = tx
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				var parseErr *csv.ParseError
+				if errors.As(err, &parseErr) {
+					importer.reject(parseErr.StartLine, "", parseErr.Err.Error())
+					continue
+				}
+				return err
+			}
+			row, _ := reader.FieldPos(0)
+			if err := importer.add(row, record); err != nil {
+				return err
+			}
+		}
+		if err := importer.flush(); err != nil {
+			return err
+		}
+		if result.Invalid > 0 && !opts.SkipInvalid {
+			return errImportRejected
+		}
+		return nil
+	}
+
+	if opts.DryRun {
+		err = run(db)
+	} else {
+		err = db.Transaction(run)
+	}
+	if errors.Is(err, errImportRejected) {
+		result.Imported = 0
+		err = nil
+	}
+	for device := range importer.devices {
+		result.Devices = append(result.Devices, device)
+	}
+	sort.Strings(result.Devices)
+	return result, err
+}
+
+// csvImporter validates rows and writes them a batch at a time.
+type csvImporter struct {
+	db         *gorm.DB
+	opts       ImportOptions
+	columns    map[string]int
+	result     *ImportResult
+	batch      []models.SensorData
+	thresholds map[string]Thresholds // By device and day
+	devices    map[string]bool
+	registered map[string]bool // Devices already registered by EnsureDevice
+}
+
+func (im *csvImporter) reject(row int, column, message string) {
+	im.result.Rows++
+	im.result.Invalid++
+	if len(im.result.Errors) < maxImportErrors {
+		im.result.Errors = append(im.result.Errors, ImportRowError{Row: row, Column: column, Error: message})
+	}
+}
+
+func (im *csvImporter) field(record []string, column string) string {
+	if i, ok := im.columns[column]; ok && i < len(record) {
+		return strings.TrimSpace(record[i])
+	}
+	return ""
+}
+
+func (im *csvImporter) add(row int, record []string) error {
+	if len(record) != len(im.columns) {
+		im.reject(row, "", fmt.Sprintf("has %d fields, the header has %d", len(record), len(im.columns)))
+		return nil
+	}
+
+	reading := models.SensorData{UserID: im.opts.UserID, DeviceID: im.field(record, "device_id")}
+	if reading.DeviceID == "" {
+		reading.DeviceID = im.opts.DefaultDeviceID
+	}
+
+	timestamp, err := parseImportTime(im.field(record, "timestamp"))
+	if err != nil {
+		im.reject(row, "timestamp", err.Error())
+		return nil
+	}
+	reading.Timestamp = timestamp
+
+	metrics := []*float32{&reading.Temperature, &reading.Humidity, &reading.SoilMoisture}
+	for i, column := range requiredImportColumns[1:] {
+		value, err := parseImportNumber(im.field(record, column))
+		if err != nil {
+			im.reject(row, column, err.Error())
+			return nil
+		}
+		*metrics[i] = float32(value)
+	}
+	if reading.Humidity < 0 || reading.Humidity > 100 {
+		im.reject(row, "humidity", "must be between 0 and 100")
+		return nil
+	}
+	if reading.SoilMoisture < 0 || reading.SoilMoisture > 100 {
+		im.reject(row, "soil_moisture", "must be between 0 and 100")
+		return nil
+	}
+
+	if len(im.result.Extra) > 0 {
+		extra := make(map[string]float64, len(im.result.Extra))
+		for _, column := range im.result.Extra {
+			text := im.field(record, column)
+			if text == "" {
+				continue
+			}
+			value, err := parseImportNumber(text)
+			if err != nil {
+				im.reject(row, column, err.Error())
+				return nil
+			}
+			extra[column] = value
+		}
+		if len(extra) > 0 {
+			encoded, err := json.Marshal(extra)
+			if err != nil {
+				return err
+			}
+			reading.Extra = models.JSONText(encoded)
+		}
+	}
+
+	reading.IsAbnormal = CheckAbnormalityWith(reading, im.thresholdsAt(reading.DeviceID, reading.Timestamp))
+	im.result.Rows++
+	im.result.Valid++
+	if reading.IsAbnormal {
+		im.result.Abnormal++
+	}
+	im.devices[reading.DeviceID] = true
+	if im.opts.DryRun {
+		return nil
+	}
+	if im.result.Invalid > 0 && !im.opts.SkipInvalid {
+		return nil // Nothing will be written, keep validating
+	}
+	im.batch = append(im.batch, reading)
+	if len(im.batch) >= im.opts.BatchSize {
+		return im.flush()
+	}
+	return nil
+}
+
+// thresholdsAt looks up the thresholds of a device once per day of readings.
+func (im *csvImporter) thresholdsAt(deviceID string, at time.Time) Thresholds {
+	key := deviceID + "|" + at.Format("2006-01-02")
+	thresholds, ok := im.thresholds[key]
+	if !ok {
+		thresholds, _ = PlantingThresholds(im.db, im.opts.UserID, deviceID, at)
+		im.thresholds[key] = thresholds
+	}
+	return thresholds
+}
+
+func (im *csvImporter) flush() error {
+	if len(im.batch) == 0 {
+		return nil
+	}
+	for _, reading := range im.batch {
+		if im.registered[reading.DeviceID] {
+			continue
+		}
+		if _, err := EnsureDevice(im.db, reading.UserID, reading.DeviceID); err != nil {
+			return err
+		}
+		im.registered[reading.DeviceID] = true
+	}
+	if err := im.db.Create(&im.batch).Error; err != nil {
+		return err
+	}
+	im.result.Imported += len(im.batch)
+	im.batch = im.batch[:0]
+	return nil
+}
+
+func parseImportTime(text string) (time.Time, error) {
+	if text == "" {
+		return time.Time{}, errors.New("is required")
+	}
+	for _, layout := range importTimeLayouts {
+		if parsed, err := time.ParseInLocation(layout, text, LocalTimezone()); err == nil {
+			if parsed.After(time.Now().Add(time.Hour)) {
+				return time.Time{}, errors.New("is in the future")
+			}
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time like 2006-01-02 15:04:05", text)
+}
+
+func parseImportNumber(text string) (float64, error) {
+	if text == "" {
+		return 0, errors.New("is required")
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("%q is not a number", text)
+	}
+	return value, nil
+}
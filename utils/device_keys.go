@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// deviceKeyPrefix marks device API keys: dk_<prefix>_<secret>.
+	deviceKeyPrefix = "dk_"
+	// deviceKeyTouchInterval limits how often LastUsedAt is written.
+	deviceKeyTouchInterval = time.Minute
+	// MaxDeviceKeyGrace bounds how long a rotated key keeps working.
+	MaxDeviceKeyGrace = 7 * 24 * time.Hour
+)
+
+// ErrInvalidDeviceKey is returned for unknown, revoked or expired device keys.
+var ErrInvalidDeviceKey = errors.New("invalid device key")
+
+// IsDeviceKey reports whether a credential looks like a device API key.
+func IsDeviceKey(key string) bool {
+	return strings.HasPrefix(key, deviceKeyPrefix)
+}
+
+// randomHex returns n random bytes hex encoded.
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// NewDeviceKey creates a credential for a device and returns it with the
+// plaintext key, which is not stored and cannot be shown again.
+func NewDeviceKey(userID uint, deviceID, name string, createdBy uint) (models.DeviceCredential, string, error) {
+	prefix, err := randomHex(6)
+	if err != nil {
+		return models.DeviceCredential{}, "", err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return models.DeviceCredential{}, "", err
+	}
+	key := deviceKeyPrefix + prefix + "_" + secret
+	credential := models.DeviceCredential{
+		UserID:    userID,
+		DeviceID:  deviceID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   HashToken(key),
+		CreatedBy: createdBy,
+	}
+	return credential, key, nil
+}
+
+// AuthenticateDeviceKey returns the active credential a device key belongs to.
+func AuthenticateDeviceKey(db *gorm.DB, key string) (models.DeviceCredential, error) {
+	var credential models.DeviceCredential
+	parts := strings.SplitN(strings.TrimPrefix(key, deviceKeyPrefix), "_", 2)
+	if !IsDeviceKey(key) || len(parts) != 2 {
+		return credential, ErrInvalidDeviceKey
+	}
+	if err := db.Where("prefix = ?", parts[0]).First(&credential).Error; err != nil {
+		return credential, ErrInvalidDeviceKey
+	}
+	if subtle.ConstantTimeCompare([]byte(credential.KeyHash), []byte(HashToken(key))) != 1 {
+		return credential, ErrInvalidDeviceKey
+	}
+
+	now := time.Now()
+	if credential.RevokedAt != nil || (credential.ExpiresAt != nil && now.After(*credential.ExpiresAt)) {
+		return credential, ErrInvalidDeviceKey
+	}
+	// Keys stop working while their owner's account is in the trash
+	if err := db.Select("id").First(&models.User{}, credential.UserID).Error; err != nil {
+		return credential, ErrInvalidDeviceKey
+	}
+	if credential.LastUsedAt == nil || now.Sub(*credential.LastUsedAt) > deviceKeyTouchInterval {
+		db.Model(&credential).Update("last_used_at", now)
+	}
+	return credential, nil
+}
+
+// RotateDeviceKey issues a new key for the same device and retires the old
+// one, immediately or after grace.
+func RotateDeviceKey(db *gorm.DB, old models.DeviceCredential, grace time.Duration, rotatedBy uint) (models.DeviceCredential, string, error) {
+	credential, key, err := NewDeviceKey(old.UserID, old.DeviceID, old.Name, rotatedBy)
+	if err != nil {
+		return credential, "", err
+	}
+	credential.RotatedFromID = &old.ID
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&credential).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		if grace <= 0 {
+			return tx.Model(&old).Update("revoked_at", now).Error
+		}
+		return tx.Model(&old).Update("expires_at", now.Add(grace)).Error
+	})
+	return credential, key, err
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"math"
+	"time"
+)
+
+// ET0 methods
+const (
+	ET0PenmanMonteith = "penman_monteith"
+	ET0Hargreaves     = "hargreaves"
+)
+
+// DailyWeather holds the daily inputs for reference evapotranspiration.
+// Fields that are unknown are nil.
+type DailyWeather struct {
+	Date           time.Time `json:"date"`
+	TMax           float64   `json:"t_max"` // °C
+	TMin           float64   `json:"t_min"` // °C
+	RHMax          *float64  `json:"rh_max,omitempty"`
+	RHMin          *float64  `json:"rh_min,omitempty"`
+	RHMean         *float64  `json:"rh_mean,omitempty"`
+	WindSpeed10m   *float64  `json:"wind_speed_10m,omitempty"`  // m/s
+	SolarRadiation *float64  `json:"solar_radiation,omitempty"` // MJ/m²/day
+}
+
+// saturationVapourPressure is FAO-56 eq. 11 (kPa).
+func saturationVapourPressure(t float64) float64 {
+	return 0.6108 * math.Exp(17.27*t/(t+237.3))
+}
+
+// ExtraterrestrialRadiation is FAO-56 eq. 21 (MJ/m²/day) for a latitude in
+// degrees and a day of the year.
+func ExtraterrestrialRadiation(latitude float64, dayOfYear int) float64 {
+	const gsc = 0.0820
+	phi := latitude * math.Pi / 180
+	j := float64(dayOfYear)
+	dr := 1 + 0.033*math.Cos(2*math.Pi*j/365)
+	delta := 0.409 * math.Sin(2*math.Pi*j/365-1.39)
+	ws := math.Acos(math.Max(-1, math.Min(1, -math.Tan(phi)*math.Tan(delta))))
+	return 24 * 60 / math.Pi * gsc * dr * (ws*math.Sin(phi)*math.Sin(delta) + math.Cos(phi)*math.Cos(delta)*math.Sin(ws))
+}
+
+// HargreavesET0 is the Hargreaves-Samani temperature method (FAO-56 eq. 52), in mm/day.
+func HargreavesET0(tMax, tMin, latitude float64, dayOfYear int) float64 {
+	ra := ExtraterrestrialRadiation(latitude, dayOfYear)
+	tMean := (tMax + tMin) / 2
+	return math.Max(0, 0.0023*(tMean+17.8)*math.Sqrt(math.Max(0, tMax-tMin))*0.408*ra)
+}
+
+// PenmanMonteithET0 is the FAO-56 Penman-Monteith equation (eq. 6) for daily
+// time steps, in mm/day. It needs humidity, wind and solar radiation.
+func PenmanMonteithET0(w DailyWeather, latitude, elevation float64) float64 {
+	tMean := (w.TMax + w.TMin) / 2
+	dayOfYear := w.Date.YearDay()
+
+	// Vapour pressures (eq. 12, 17, 19)
+	esMax, esMin := saturationVapourPressure(w.TMax), saturationVapourPressure(w.TMin)
+	es := (esMax + esMin) / 2
+	var ea float64
+	switch {
+	case w.RHMax != nil && w.RHMin != nil:
+		ea = (esMin**w.RHMax/100 + esMax**w.RHMin/100) / 2
+	case w.RHMean != nil:
+		ea = *w.RHMean / 100 * es
+	default:
+		ea = esMin // Assume dew point equals the minimum temperature
+	}
+
+	// Slope of the vapour pressure curve (eq. 13) and psychrometric constant (eq. 7, 8)
+	delta := 4098 * saturationVapourPressure(tMean) / math.Pow(tMean+237.3, 2)
+	pressure := 101.3 * math.Pow((293-0.0065*elevation)/293, 5.26)
+	gamma := 0.000665 * pressure
+
+	// Wind speed at 2 m (eq. 47)
+	u2 := *w.WindSpeed10m * 4.87 / math.Log(67.8*10-5.42)
+
+	// Net radiation (eq. 37, 38, 39, 40)
+	ra := ExtraterrestrialRadiation(latitude, dayOfYear)
+	rs := *w.SolarRadiation
+	rso := (0.75 + 2e-5*elevation) * ra
+	rns := (1 - 0.23) * rs
+	relative := 1.0
+	if rso > 0 {
+		relative = math.Min(1, rs/rso)
+	}
+	const sigma = 4.903e-9
+	rnl := sigma * (math.Pow(w.TMax+273.16, 4) + math.Pow(w.TMin+273.16, 4)) / 2 *
+		(0.34 - 0.14*math.Sqrt(math.Max(0, ea))) * (1.35*relative - 0.35)
+	rn := rns - rnl
+
+	et0 := (0.408*delta*rn + gamma*(900/(tMean+273))*u2*(es-ea)) / (delta + gamma*(1+0.34*u2))
+	return math.Max(0, et0)
+}
+
+// ReferenceET0 computes ET0 with Penman-Monteith when wind and solar radiation
+// are known and falls back to Hargreaves otherwise. It returns the value in
+// mm/day and the method used.
+func ReferenceET0(w DailyWeather, latitude, elevation float64) (float64, string) {
+	if w.WindSpeed10m != nil && w.SolarRadiation != nil {
+		return PenmanMonteithET0(w, latitude, elevation), ET0PenmanMonteith
+	}
+	return HargreavesET0(w.TMax, w.TMin, latitude, w.Date.YearDay()), ET0Hargreaves
+}
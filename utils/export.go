@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"fyp/models"
+)
+
+// ReadingWriter writes sensor readings one at a time in an export format.
+// Close finishes the output and must be called after the last reading.
+type ReadingWriter interface {
+	Write(reading models.SensorData) error
+	Close() error
+}
+
+// Exporter is an export format for sensor readings.
+type Exporter interface {
+	Format() string      // Name used in ?format=
+	ContentType() string // MIME type, also matched against Accept
+	Extension() string
+	NewWriter(w io.Writer) ReadingWriter
+}
+
+// exportColumns are the columns of the tabular export formats, in order.
+// CSV exports can be imported again with ImportSensorCSV.
+var exportColumns = []string{"id", "user_id", "device_id", "timestamp", "temperature", "humidity", "soil_moisture", "is_abnormal", "revision"}
+
+// exportTimeLayout is the timestamp layout of text exports, DownloadCSV's
+// original layout in the local timezone.
+const exportTimeLayout = "2006-01-02 15:04:05"
+
+var exporters = map[string]Exporter{}
+
+// RegisterExporter makes an export format available by its name.
+func RegisterExporter(exporter Exporter) {
+	exporters[exporter.Format()] = exporter
+}
+
+func init() {
+	RegisterExporter(csvExporter{})
+	RegisterExporter(jsonLinesExporter{})
+	RegisterExporter(parquetExporter{})
+	RegisterExporter(xlsxExporter{})
+}
+
+// ExportFormats returns the names of the registered export formats.
+func ExportFormats() []string {
+	formats := make([]string, 0, len(exporters))
+	for format := range exporters {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// NegotiateExporter picks the export format named by format or, without one,
+// the first format in the Accept header that is supported. CSV is the default.
+func NegotiateExporter(format, accept string) (Exporter, error) {
+	if format != "" {
+		if exporter, ok := exporters[strings.ToLower(format)]; ok {
+			return exporter, nil
+		}
+		return nil, fmt.Errorf("unknown format %q, use one of %s", format, strings.Join(ExportFormats(), ", "))
+	}
+
+	type candidate struct {
+		exporter Exporter
+		quality  float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			quality = q
+		}
+		for _, exporter := range exporters {
+			if quality > 0 && exporterAccepts(exporter, mediaType) {
+				candidates = append(candidates, candidate{exporter, quality})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].quality > candidates[j].quality })
+	if len(candidates) > 0 {
+		return candidates[0].exporter, nil
+	}
+	return exporters["csv"], nil
+}
+
+// exportAliases are further media types clients send for a format.
+var exportAliases = map[string][]string{
+	"jsonl":   {"application/jsonl", "application/x-ndjson", "application/x-jsonlines"},
+	"parquet": {"application/x-parquet", "application/parquet"},
+}
+
+func exporterAccepts(exporter Exporter, mediaType string) bool {
+	return mediaType == exporter.ContentType() || containsString(exportAliases[exporter.Format()], mediaType)
+}
+
+// exportRow formats a reading as the text cells of exportColumns.
+func exportRow(reading models.SensorData) []string {
+	return []string{
+		strconv.FormatUint(uint64(reading.ID), 10),
+		strconv.FormatUint(uint64(reading.UserID), 10),
+		reading.DeviceID,
+		reading.Timestamp.In(LocalTimezone()).Format(exportTimeLayout),
+		strconv.FormatFloat(float64(reading.Temperature), 'f', 2, 32),
+		strconv.FormatFloat(float64(reading.Humidity), 'f', 2, 32),
+		strconv.FormatFloat(float64(reading.SoilMoisture), 'f', 2, 32),
+		strconv.FormatBool(reading.IsAbnormal),
+		strconv.Itoa(reading.Revision),
+	}
+}
+
+// csvExporter writes exportColumns with a header row.
+type csvExporter struct{}
+
+func (csvExporter) Format() string      { return "csv" }
+func (csvExporter) ContentType() string { return "text/csv" }
+func (csvExporter) Extension() string   { return "csv" }
+
+func (csvExporter) NewWriter(w io.Writer) ReadingWriter {
+	return &csvReadingWriter{writer: csv.NewWriter(w)}
+}
+
+type csvReadingWriter struct {
+	writer  *csv.Writer
+	started bool
+}
+
+func (cw *csvReadingWriter) Write(reading models.SensorData) error {
+	if !cw.started {
+		cw.started = true
+		if err := cw.writer.Write(exportColumns); err != nil {
+			return err
+		}
+	}
+	return cw.writer.Write(exportRow(reading))
+}
+
+func (cw *csvReadingWriter) Close() error {
+	if !cw.started {
+		cw.writer.Write(exportColumns)
+	}
+	cw.writer.Flush()
+	return cw.writer.Error()
+}
+
+// jsonLinesExporter writes one JSON object per reading, as the API returns
+// them, with any extra metrics.
+type jsonLinesExporter struct{}
+
+func (jsonLinesExporter) Format() string      { return "jsonl" }
+func (jsonLinesExporter) ContentType() string { return "application/x-ndjson" }
+func (jsonLinesExporter) Extension() string   { return "jsonl" }
+
+func (jsonLinesExporter) NewWriter(w io.Writer) ReadingWriter {
+	buffered := bufio.NewWriter(w)
+	return &jsonLinesWriter{buffered: buffered, encoder: json.NewEncoder(buffered)}
+}
+
+type jsonLinesWriter struct {
+	buffered *bufio.Writer
+	encoder  *json.Encoder
+}
+
+func (jw *jsonLinesWriter) Write(reading models.SensorData) error {
+	return jw.encoder.Encode(reading)
+}
+
+func (jw *jsonLinesWriter) Close() error {
+	return jw.buffered.Flush()
+}
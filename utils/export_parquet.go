@@ -0,0 +1,357 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"fyp/models"
+)
+
+// parquetRowGroupSize is how many readings are buffered per row group.
+const parquetRowGroupSize = 50000
+
+// Parquet physical types, repetition, encodings and page types
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetFloat     = 4
+	parquetByteArray = 6
+
+	parquetRequired     = 0
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetUncompressed = 0
+	parquetDataPage     = 0
+
+	parquetConvertedUTF8            = 0
+	parquetConvertedTimestampMicros = 10
+)
+
+// parquetColumn describes one column of the Parquet export.
+type parquetColumn struct {
+	name        string
+	physical    int32
+	converted   int32               // ConvertedType for older readers, -1 for none
+	logicalType func(*thriftWriter) // Writes the member of the LogicalType union, nil for none
+	value       func(reading models.SensorData, page *bytes.Buffer)
+}
+
+var parquetColumns = []parquetColumn{
+	{name: "id", physical: parquetInt64, converted: -1, value: func(r models.SensorData, b *bytes.Buffer) { putInt64(b, int64(r.ID)) }},
+	{name: "user_id", physical: parquetInt64, converted: -1, value: func(r models.SensorData, b *bytes.Buffer) { putInt64(b, int64(r.UserID)) }},
+	{name: "device_id", physical: parquetByteArray, converted: parquetConvertedUTF8, logicalType: parquetString, value: func(r models.SensorData, b *bytes.Buffer) {
+		binary.Write(b, binary.LittleEndian, uint32(len(r.DeviceID)))
+		b.WriteString(r.DeviceID)
+	}},
+	{name: "timestamp", physical: parquetInt64, converted: parquetConvertedTimestampMicros, logicalType: parquetTimestampMicros, value: func(r models.SensorData, b *bytes.Buffer) {
+		putInt64(b, r.Timestamp.UnixMicro())
+	}},
+	{name: "temperature", physical: parquetFloat, converted: -1, value: func(r models.SensorData, b *bytes.Buffer) { putFloat(b, r.Temperature) }},
+	{name: "humidity", physical: parquetFloat, converted: -1, value: func(r models.SensorData, b *bytes.Buffer) { putFloat(b, r.Humidity) }},
+	{name: "soil_moisture", physical: parquetFloat, converted: -1, value: func(r models.SensorData, b *bytes.Buffer) { putFloat(b, r.SoilMoisture) }},
+	{name: "is_abnormal", physical: parquetBoolean, converted: -1}, // Bit-packed, see parquetWriter.flush
+	{name: "revision", physical: parquetInt32, converted: -1, value: func(r models.SensorData, b *bytes.Buffer) {
+		binary.Write(b, binary.LittleEndian, int32(r.Revision))
+	}},
+}
+
+func putInt64(b *bytes.Buffer, v int64) {
+	binary.Write(b, binary.LittleEndian, v)
+}
+
+func putFloat(b *bytes.Buffer, v float32) {
+	binary.Write(b, binary.LittleEndian, math.Float32bits(v))
+}
+
+// parquetString writes the STRING logical type.
+func parquetString(t *thriftWriter) {
+	t.fieldStruct(1)
+	t.stop()
+}
+
+// parquetTimestampMicros writes the TIMESTAMP(isAdjustedToUTC, MICROS) logical type.
+func parquetTimestampMicros(t *thriftWriter) {
+	t.fieldStruct(8)
+	t.fieldBool(1, true)
+	t.fieldStruct(2) // TimeUnit
+	t.fieldStruct(2) // MICROS
+	t.stop()
+	t.stop()
+	t.stop()
+}
+
+// parquetExporter writes an Apache Parquet file: uncompressed, plain encoded,
+// one data page per column per row group of parquetRowGroupSize readings.
+type parquetExporter struct{}
+
+func (parquetExporter) Format() string      { return "parquet" }
+func (parquetExporter) ContentType() string { return "application/vnd.apache.parquet" }
+func (parquetExporter) Extension() string   { return "parquet" }
+
+func (parquetExporter) NewWriter(w io.Writer) ReadingWriter {
+	return &parquetWriter{out: &countingWriter{w: bufio.NewWriter(w)}}
+}
+
+type parquetRowGroup struct {
+	rows    int64
+	size    int64
+	offsets []int64 // Of each column chunk
+	sizes   []int64
+}
+
+type parquetWriter struct {
+	out       *countingWriter
+	buffered  []models.SensorData
+	rowGroups []parquetRowGroup
+	rows      int64
+}
+
+func (pw *parquetWriter) Write(reading models.SensorData) error {
+	pw.buffered = append(pw.buffered, reading)
+	if len(pw.buffered) >= parquetRowGroupSize {
+		return pw.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered readings as a row group.
+func (pw *parquetWriter) flush() error {
+	if pw.out.n == 0 {
+		if _, err := pw.out.Write([]byte("PAR1")); err != nil {
+			return err
+		}
+	}
+	if len(pw.buffered) == 0 {
+		return nil
+	}
+
+	group := parquetRowGroup{rows: int64(len(pw.buffered))}
+	var page bytes.Buffer
+	for _, column := range parquetColumns {
+		page.Reset()
+		if column.physical == parquetBoolean {
+			packed := make([]byte, (len(pw.buffered)+7)/8)
+			for i, reading := range pw.buffered {
+				if reading.IsAbnormal {
+					packed[i/8] |= 1 << (i % 8)
+				}
+			}
+			page.Write(packed)
+		} else {
+			for _, reading := range pw.buffered {
+				column.value(reading, &page)
+			}
+		}
+
+		header := &thriftWriter{}
+		header.fieldI32(1, parquetDataPage)
+		header.fieldI32(2, int32(page.Len()))
+		header.fieldI32(3, int32(page.Len()))
+		header.fieldStruct(5)
+		header.fieldI32(1, int32(len(pw.buffered)))
+		header.fieldI32(2, parquetPlain)
+		header.fieldI32(3, parquetRLE)
+		header.fieldI32(4, parquetRLE)
+		header.stop()
+		header.stop()
+
+		group.offsets = append(group.offsets, pw.out.n)
+		if _, err := pw.out.Write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if _, err := pw.out.Write(page.Bytes()); err != nil {
+			return err
+		}
+		chunkSize := int64(header.buf.Len() + page.Len())
+		group.sizes = append(group.sizes, chunkSize)
+		group.size += chunkSize
+	}
+	pw.rowGroups = append(pw.rowGroups, group)
+	pw.rows += group.rows
+	pw.buffered = pw.buffered[:0]
+	return nil
+}
+
+// Close writes the last row group and the file metadata.
+func (pw *parquetWriter) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+
+	meta := &thriftWriter{}
+	meta.fieldI32(1, 1)
+	meta.fieldList(2, thriftStruct, len(parquetColumns)+1)
+	meta.element()
+	meta.fieldString(4, "schema")
+	meta.fieldI32(5, int32(len(parquetColumns)))
+	meta.stop()
+	for _, column := range parquetColumns {
+		meta.element()
+		meta.fieldI32(1, column.physical)
+		meta.fieldI32(3, parquetRequired)
+		meta.fieldString(4, column.name)
+		if column.converted >= 0 {
+			meta.fieldI32(6, column.converted)
+		}
+		if column.logicalType != nil {
+			meta.fieldStruct(10)
+			column.logicalType(meta)
+			meta.stop()
+		}
+		meta.stop()
+	}
+	meta.fieldI64(3, pw.rows)
+	meta.fieldList(4, thriftStruct, len(pw.rowGroups))
+	for _, group := range pw.rowGroups {
+		meta.element()
+		meta.fieldList(1, thriftStruct, len(parquetColumns))
+		for i, column := range parquetColumns {
+			meta.element()
+			meta.fieldI64(2, group.offsets[i])
+			meta.fieldStruct(3)
+			meta.fieldI32(1, column.physical)
+			meta.fieldList(2, thriftI32, 1)
+			meta.writeVarint(zigzag(parquetPlain))
+			meta.fieldList(3, thriftBinary, 1)
+			meta.writeString(column.name)
+			meta.fieldI32(4, parquetUncompressed)
+			meta.fieldI64(5, group.rows)
+			meta.fieldI64(6, group.sizes[i])
+			meta.fieldI64(7, group.sizes[i])
+			meta.fieldI64(9, group.offsets[i])
+			meta.stop()
+			meta.stop()
+		}
+		meta.fieldI64(2, group.size)
+		meta.fieldI64(3, group.rows)
+		meta.stop()
+	}
+	meta.fieldString(6, "fyp soil moisture monitor")
+	meta.stop()
+
+	if _, err := pw.out.Write(meta.buf.Bytes()); err != nil {
+		return err
+	}
+	footer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(footer, uint32(meta.buf.Len()))
+	copy(footer[4:], "PAR1")
+	if _, err := pw.out.Write(footer); err != nil {
+		return err
+	}
+	return pw.out.w.Flush()
+}
+
+// countingWriter tracks the file offset for the Parquet metadata.
+type countingWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Thrift compact protocol types, as used by Parquet metadata
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs in the Thrift compact protocol. Field IDs are
+// delta encoded, so it keeps the last field ID of every open struct.
+type thriftWriter struct {
+	buf     bytes.Buffer
+	lastIDs []int16
+	lastID  int16
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (t *thriftWriter) writeVarint(v uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	t.buf.Write(scratch[:binary.PutUvarint(scratch[:], v)])
+}
+
+func (t *thriftWriter) writeString(s string) {
+	t.writeVarint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) field(id int16, kind byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.buf.WriteByte(kind)
+		t.writeVarint(zigzag(int64(id)))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) fieldI32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.writeVarint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) fieldI64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.writeVarint(zigzag(v))
+}
+
+func (t *thriftWriter) fieldBool(id int16, v bool) {
+	if v {
+		t.field(id, thriftTrue)
+	} else {
+		t.field(id, thriftFalse)
+	}
+}
+
+func (t *thriftWriter) fieldString(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.writeString(s)
+}
+
+// fieldStruct opens a struct field; close it with stop.
+func (t *thriftWriter) fieldStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.element()
+}
+
+// fieldList starts a list field of size elements of kind. Write each struct
+// element between element and stop.
+func (t *thriftWriter) fieldList(id int16, kind byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | kind)
+	} else {
+		t.buf.WriteByte(0xf0 | kind)
+		t.writeVarint(uint64(size))
+	}
+}
+
+// element opens a struct whose fields follow.
+func (t *thriftWriter) element() {
+	t.lastIDs = append(t.lastIDs, t.lastID)
+	t.lastID = 0
+}
+
+// stop ends the innermost open struct.
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+	if n := len(t.lastIDs); n > 0 {
+		t.lastID = t.lastIDs[n-1]
+		t.lastIDs = t.lastIDs[:n-1]
+	}
+}
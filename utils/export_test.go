@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"fyp/models"
+)
+
+func exportReadingsFixture() []models.SensorData {
+	start := time.Date(2024, 3, 1, 6, 30, 15, 0, time.UTC)
+	return []models.SensorData{
+		{ID: 1, UserID: 7, DeviceID: "probe-1", Timestamp: start, Temperature: 21.5, Humidity: 60.25, SoilMoisture: 35.5},
+		{ID: 2, UserID: 7, DeviceID: "probe-2", Timestamp: start.Add(time.Hour), Temperature: -3.75, Humidity: 95, SoilMoisture: 2, IsAbnormal: true, Revision: 2},
+		{ID: 3, UserID: 8, DeviceID: "süd & <west>", Timestamp: start.Add(26 * time.Hour), Temperature: 30, Humidity: 41, SoilMoisture: 50.75},
+	}
+}
+
+func exportTo(t *testing.T, format string, readings []models.SensorData) []byte {
+	t.Helper()
+	exporter, err := NegotiateExporter(format, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	writer := exporter.NewWriter(&out)
+	for _, reading := range readings {
+		if err := writer.Write(reading); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// thriftReader decodes the Thrift compact protocol into maps of field ID to
+// value, enough to read back the Parquet metadata parquetWriter writes.
+type thriftReader struct {
+	r *bytes.Reader
+}
+
+func (t thriftReader) varint() int64 {
+	v, _ := binary.ReadUvarint(t.r)
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (t thriftReader) value(kind byte) interface{} {
+	switch kind {
+	case thriftTrue:
+		return true
+	case thriftFalse:
+		return false
+	case thriftI32, thriftI64:
+		return t.varint()
+	case thriftBinary:
+		size, _ := binary.ReadUvarint(t.r)
+		s := make([]byte, size)
+		io.ReadFull(t.r, s)
+		return string(s)
+	case thriftList:
+		header, _ := t.r.ReadByte()
+		size := uint64(header >> 4)
+		if size == 15 {
+			size, _ = binary.ReadUvarint(t.r)
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = t.value(header & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return t.readStruct()
+	}
+	panic("unexpected thrift type " + strconv.Itoa(int(kind)))
+}
+
+func (t thriftReader) readStruct() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var id int16
+	for {
+		header, err := t.r.ReadByte()
+		if err != nil || header == 0 {
+			return fields
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(t.varint())
+		}
+		fields[id] = t.value(header & 0x0f)
+	}
+}
+
+func TestParquetExportRoundTrip(t *testing.T) {
+	readings := exportReadingsFixture()
+	file := exportTo(t, "parquet", readings)
+
+	if string(file[:4]) != "PAR1" || string(file[len(file)-4:]) != "PAR1" {
+		t.Fatal("missing PAR1 magic")
+	}
+	metaSize := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	meta := thriftReader{bytes.NewReader(file[len(file)-8-metaSize : len(file)-8])}.readStruct()
+
+	if meta[3] != int64(len(readings)) {
+		t.Fatalf("num_rows = %v, want %d", meta[3], len(readings))
+	}
+	schema := meta[2].([]interface{})
+	if len(schema) != len(exportColumns)+1 || schema[0].(map[int16]interface{})[5] != int64(len(exportColumns)) {
+		t.Fatalf("schema has %d elements, want a root and %d columns", len(schema), len(exportColumns))
+	}
+	for i, name := range exportColumns {
+		if got := schema[i+1].(map[int16]interface{})[4]; got != name {
+			t.Fatalf("schema column %d = %v, want %s", i, got, name)
+		}
+	}
+
+	groups := meta[4].([]interface{})
+	if len(groups) != 1 {
+		t.Fatalf("%d row groups, want 1", len(groups))
+	}
+	chunks := groups[0].(map[int16]interface{})[1].([]interface{})
+	columns := make(map[string]*bytes.Reader)
+	for i, chunk := range chunks {
+		columnMeta := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+		if path := columnMeta[3].([]interface{}); path[0] != exportColumns[i] {
+			t.Fatalf("chunk %d is for %v, want %s", i, path, exportColumns[i])
+		}
+		if columnMeta[5] != int64(len(readings)) {
+			t.Fatalf("chunk %s has %v values, want %d", exportColumns[i], columnMeta[5], len(readings))
+		}
+		page := bytes.NewReader(file[columnMeta[9].(int64):])
+		header := thriftReader{page}.readStruct()
+		if header[1] != int64(parquetDataPage) || header[5].(map[int16]interface{})[1] != int64(len(readings)) {
+			t.Fatalf("chunk %s page header = %v", exportColumns[i], header)
+		}
+		values := make([]byte, header[3].(int64))
+		io.ReadFull(page, values)
+		columns[exportColumns[i]] = bytes.NewReader(values)
+	}
+
+	abnormal, _ := columns["is_abnormal"].ReadByte()
+	for i, want := range readings {
+		var id, userID, timestamp int64
+		var temperature, humidity, soilMoisture float32
+		var revision int32
+		var deviceIDSize uint32
+		binary.Read(columns["id"], binary.LittleEndian, &id)
+		binary.Read(columns["user_id"], binary.LittleEndian, &userID)
+		binary.Read(columns["device_id"], binary.LittleEndian, &deviceIDSize)
+		deviceID := make([]byte, deviceIDSize)
+		io.ReadFull(columns["device_id"], deviceID)
+		binary.Read(columns["timestamp"], binary.LittleEndian, &timestamp)
+		binary.Read(columns["temperature"], binary.LittleEndian, &temperature)
+		binary.Read(columns["humidity"], binary.LittleEndian, &humidity)
+		binary.Read(columns["soil_moisture"], binary.LittleEndian, &soilMoisture)
+		binary.Read(columns["revision"], binary.LittleEndian, &revision)
+
+		got := models.SensorData{ID: uint(id), UserID: uint(userID), DeviceID: string(deviceID),
+			Timestamp: time.UnixMicro(timestamp).UTC(), Temperature: temperature, Humidity: humidity, SoilMoisture: soilMoisture,
+			IsAbnormal: abnormal&(1<<i) != 0, Revision: int(revision)}
+		if got != want {
+			t.Fatalf("row %d read back as %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Cells []struct {
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func readXLSXPart(t *testing.T, book *zip.Reader, name string, into interface{}) {
+	t.Helper()
+	part, err := book.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer part.Close()
+	if err := xml.NewDecoder(part).Decode(into); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+}
+
+func TestXLSXExportRoundTrip(t *testing.T) {
+	readings := exportReadingsFixture()
+	file := exportTo(t, "xlsx", readings)
+	book, err := zip.NewReader(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	readXLSXPart(t, book, "xl/workbook.xml", &workbook)
+	if len(workbook.Sheets) != 2 || workbook.Sheets[0].Name != "Summary" || workbook.Sheets[1].Name != "Readings" {
+		t.Fatalf("sheets = %+v, want Summary and Readings", workbook.Sheets)
+	}
+
+	var summary xlsxSheet
+	readXLSXPart(t, book, "xl/worksheets/sheet1.xml", &summary)
+	if len(summary.Rows) != 5 || summary.Rows[4].Cells[0].Inline != "All devices" || summary.Rows[4].Cells[1].Value != "3" {
+		t.Fatalf("summary has %d rows, want a header, 3 devices and the total", len(summary.Rows))
+	}
+
+	var sheet xlsxSheet
+	readXLSXPart(t, book, "xl/worksheets/sheet2.xml", &sheet)
+	if len(sheet.Rows) != len(readings)+1 {
+		t.Fatalf("readings sheet has %d rows, want a header and %d readings", len(sheet.Rows), len(readings))
+	}
+	for i, name := range exportColumns {
+		if got := sheet.Rows[0].Cells[i].Inline; got != name {
+			t.Fatalf("header column %d = %q, want %s", i, got, name)
+		}
+	}
+	for i, want := range readings {
+		cells := sheet.Rows[i+1].Cells
+		number := func(column int) float64 {
+			value, err := strconv.ParseFloat(cells[column].Value, 64)
+			if err != nil {
+				t.Fatalf("row %d column %s: %v", i, exportColumns[column], err)
+			}
+			return value
+		}
+		// Serials are wall clock time in the local timezone, to the second
+		serial := time.Duration(math.Round(number(3) * 24 * 3600))
+		local := want.Timestamp.In(LocalTimezone())
+		wantSerial := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), 0, time.UTC).Sub(xlsxEpoch) / time.Second
+
+		got := models.SensorData{ID: uint(number(0)), UserID: uint(number(1)), DeviceID: cells[2].Inline, Timestamp: want.Timestamp,
+			Temperature: float32(number(4)), Humidity: float32(number(5)), SoilMoisture: float32(number(6)),
+			IsAbnormal: cells[7].Type == "b" && cells[7].Value == "1", Revision: int(number(8))}
+		if got != want || serial != wantSerial {
+			t.Fatalf("row %d read back as %+v at serial %v, want %+v at %v", i, got, serial, want, wantSerial)
+		}
+	}
+}
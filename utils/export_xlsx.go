@@ -0,0 +1,312 @@
+package utils
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"fyp/models"
+)
+
+// xlsxMaxRows is the most rows an Excel sheet holds; longer exports continue
+// on further sheets.
+const xlsxMaxRows = 1048576
+
+// Cell styles defined in xlsxStyles
+const (
+	xlsxStyleDefault  = 0
+	xlsxStyleDateTime = 1
+	xlsxStyleHeader   = 2
+)
+
+const xlsxMain = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="` + xlsxMain + `">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>
+<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>
+</styleSheet>`
+
+// xlsxEpoch is day zero of Excel's date serial numbers.
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// xlsxExporter writes an Excel workbook: a summary sheet of every device
+// followed by the readings.
+type xlsxExporter struct{}
+
+func (xlsxExporter) Format() string { return "xlsx" }
+func (xlsxExporter) ContentType() string {
+	return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+}
+func (xlsxExporter) Extension() string { return "xlsx" }
+
+func (xlsxExporter) NewWriter(w io.Writer) ReadingWriter {
+	return &xlsxWriter{zip: zip.NewWriter(w), devices: make(map[string]*readingSummary)}
+}
+
+type xlsxWriter struct {
+	zip     *zip.Writer
+	sheet   *bufio.Writer // Readings sheet being written
+	sheets  []string      // Names of the readings sheets
+	row     int           // Rows written to the current sheet
+	devices map[string]*readingSummary
+	total   readingSummary
+}
+
+func (xw *xlsxWriter) Write(reading models.SensorData) error {
+	if xw.sheet == nil || xw.row == xlsxMaxRows {
+		if err := xw.startSheet(); err != nil {
+			return err
+		}
+	}
+	xw.row++
+	fmt.Fprintf(xw.sheet, `<row r="%d">`, xw.row)
+	xlsxInt(xw.sheet, int64(reading.ID))
+	xlsxInt(xw.sheet, int64(reading.UserID))
+	xlsxString(xw.sheet, reading.DeviceID, xlsxStyleDefault)
+	fmt.Fprintf(xw.sheet, `<c s="%d"><v>%s</v></c>`, xlsxStyleDateTime, xlsxSerial(reading.Timestamp))
+	xlsxNumber(xw.sheet, float64(reading.Temperature))
+	xlsxNumber(xw.sheet, float64(reading.Humidity))
+	xlsxNumber(xw.sheet, float64(reading.SoilMoisture))
+	if reading.IsAbnormal {
+		xw.sheet.WriteString(`<c t="b"><v>1</v></c>`)
+	} else {
+		xw.sheet.WriteString(`<c t="b"><v>0</v></c>`)
+	}
+	xlsxInt(xw.sheet, int64(reading.Revision))
+	xw.sheet.WriteString(`</row>`)
+
+	summary, ok := xw.devices[reading.DeviceID]
+	if !ok {
+		summary = &readingSummary{}
+		xw.devices[reading.DeviceID] = summary
+	}
+	summary.add(reading)
+	xw.total.add(reading)
+	return nil
+}
+
+// startSheet ends the current readings sheet, if any, and starts the next.
+func (xw *xlsxWriter) startSheet() error {
+	if err := xw.endSheet(); err != nil {
+		return err
+	}
+	name := "Readings"
+	if len(xw.sheets) > 0 {
+		name = fmt.Sprintf("Readings %d", len(xw.sheets)+1)
+	}
+	// Sheet 1 is the summary, written last
+	entry, err := xw.zip.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(xw.sheets)+2))
+	if err != nil {
+		return err
+	}
+	xw.sheets = append(xw.sheets, name)
+	xw.sheet = bufio.NewWriter(entry)
+	xw.row = 1
+	xlsxSheetStart(xw.sheet)
+	xlsxHeader(xw.sheet, exportColumns)
+	return nil
+}
+
+func (xw *xlsxWriter) endSheet() error {
+	if xw.sheet == nil {
+		return nil
+	}
+	xw.sheet.WriteString(`</sheetData></worksheet>`)
+	return xw.sheet.Flush()
+}
+
+// Close writes the summary sheet and the workbook parts that list the sheets.
+func (xw *xlsxWriter) Close() error {
+	if xw.sheet == nil {
+		if err := xw.startSheet(); err != nil {
+			return err
+		}
+	}
+	if err := xw.endSheet(); err != nil {
+		return err
+	}
+	if err := xw.writeSummary(); err != nil {
+		return err
+	}
+
+	names := append([]string{"Summary"}, xw.sheets...)
+	parts := map[string]func(io.Writer){
+		"[Content_Types].xml": func(w io.Writer) {
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+			for i := range names {
+				fmt.Fprintf(w, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+			}
+			io.WriteString(w, `</Types>`)
+		},
+		"_rels/.rels": func(w io.Writer) {
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`)
+		},
+		"xl/workbook.xml": func(w io.Writer) {
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="`+xlsxMain+`" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+			for i, name := range names {
+				fmt.Fprintf(w, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, name, i+1, i+1)
+			}
+			io.WriteString(w, `</sheets></workbook>`)
+		},
+		"xl/_rels/workbook.xml.rels": func(w io.Writer) {
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+			for i := range names {
+				fmt.Fprintf(w, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+			}
+			fmt.Fprintf(w, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`, len(names)+1)
+		},
+		"xl/styles.xml": func(w io.Writer) {
+			io.WriteString(w, xlsxStyles)
+		},
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		entry, err := xw.zip.Create(name)
+		if err != nil {
+			return err
+		}
+		parts[name](entry)
+	}
+	return xw.zip.Close()
+}
+
+// writeSummary writes sheet 1: per device and overall counts, time span and
+// the average, minimum and maximum of every metric.
+func (xw *xlsxWriter) writeSummary() error {
+	entry, err := xw.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(entry)
+	xlsxSheetStart(w)
+	xlsxHeader(w, []string{"device_id", "readings", "abnormal", "first_reading", "last_reading",
+		"avg_temperature", "min_temperature", "max_temperature",
+		"avg_humidity", "min_humidity", "max_humidity",
+		"avg_soil_moisture", "min_soil_moisture", "max_soil_moisture"})
+
+	devices := make([]string, 0, len(xw.devices))
+	for device := range xw.devices {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	row := 1
+	for _, device := range devices {
+		row++
+		xw.devices[device].writeRow(w, row, device, xlsxStyleDefault)
+	}
+	if len(devices) != 1 {
+		row++
+		xw.total.writeRow(w, row, "All devices", xlsxStyleHeader)
+	}
+	w.WriteString(`</sheetData></worksheet>`)
+	return w.Flush()
+}
+
+// readingSummary accumulates the statistics of the summary sheet.
+type readingSummary struct {
+	count, abnormal int64
+	first, last     time.Time
+	sum, min, max   [3]float64 // Temperature, humidity, soil moisture
+}
+
+func (s *readingSummary) add(reading models.SensorData) {
+	values := [3]float64{float64(reading.Temperature), float64(reading.Humidity), float64(reading.SoilMoisture)}
+	if s.count == 0 {
+		s.first, s.last = reading.Timestamp, reading.Timestamp
+		s.min, s.max = values, values
+	}
+	s.count++
+	if reading.IsAbnormal {
+		s.abnormal++
+	}
+	if reading.Timestamp.Before(s.first) {
+		s.first = reading.Timestamp
+	}
+	if reading.Timestamp.After(s.last) {
+		s.last = reading.Timestamp
+	}
+	for i, value := range values {
+		s.sum[i] += value
+		s.min[i] = math.Min(s.min[i], value)
+		s.max[i] = math.Max(s.max[i], value)
+	}
+}
+
+func (s *readingSummary) writeRow(w *bufio.Writer, row int, label string, labelStyle int) {
+	fmt.Fprintf(w, `<row r="%d">`, row)
+	xlsxString(w, label, labelStyle)
+	xlsxInt(w, s.count)
+	xlsxInt(w, s.abnormal)
+	if s.count == 0 {
+		w.WriteString(`</row>`)
+		return
+	}
+	fmt.Fprintf(w, `<c s="%d"><v>%s</v></c>`, xlsxStyleDateTime, xlsxSerial(s.first))
+	fmt.Fprintf(w, `<c s="%d"><v>%s</v></c>`, xlsxStyleDateTime, xlsxSerial(s.last))
+	for i := range s.sum {
+		xlsxNumber(w, math.Round(s.sum[i]/float64(s.count)*100)/100)
+		xlsxNumber(w, s.min[i])
+		xlsxNumber(w, s.max[i])
+	}
+	w.WriteString(`</row>`)
+}
+
+// xlsxSheetStart opens a worksheet with its header row frozen.
+func xlsxSheetStart(w *bufio.Writer) {
+	w.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="` + xlsxMain + `"><sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews><sheetData>`)
+}
+
+func xlsxHeader(w *bufio.Writer, columns []string) {
+	w.WriteString(`<row r="1">`)
+	for _, column := range columns {
+		xlsxString(w, column, xlsxStyleHeader)
+	}
+	w.WriteString(`</row>`)
+}
+
+func xlsxString(w *bufio.Writer, value string, style int) {
+	fmt.Fprintf(w, `<c s="%d" t="inlineStr"><is><t>`, style)
+	xml.EscapeText(w, []byte(value))
+	w.WriteString(`</t></is></c>`)
+}
+
+func xlsxInt(w *bufio.Writer, value int64) {
+	w.WriteString(`<c><v>`)
+	w.WriteString(strconv.FormatInt(value, 10))
+	w.WriteString(`</v></c>`)
+}
+
+// xlsxNumber writes a metric with the precision it was recorded in.
+func xlsxNumber(w *bufio.Writer, value float64) {
+	w.WriteString(`<c><v>`)
+	w.WriteString(strconv.FormatFloat(value, 'g', -1, 32))
+	w.WriteString(`</v></c>`)
+}
+
+// xlsxSerial converts a time to an Excel date serial number in the local
+// timezone, since Excel dates carry no zone.
+func xlsxSerial(t time.Time) string {
+	local := t.In(LocalTimezone())
+	wall := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+	return strconv.FormatFloat(wall.Sub(xlsxEpoch).Hours()/24, 'f', 8, 64)
+}
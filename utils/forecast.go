@@ -0,0 +1,306 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+// MaxForecastHours is the longest horizon the forecast endpoint will produce.
+const MaxForecastHours = 48
+
+// forecastHistoryWindow is how much history the local trend model is fitted on.
+const forecastHistoryWindow = 72 * time.Hour
+
+// maxAIForecastHours is how many hours of a forecast the AI service is asked
+// for, one call per hour; later hours follow the trend from the last of them.
+const maxAIForecastHours = 24
+
+// aiForecastBudget bounds the time spent on the AI calls of one forecast,
+// after which the local trend model is used.
+const aiForecastBudget = 45 * time.Second
+
+// maxCachedForecasts bounds forecastCache. The oldest forecast is dropped to
+// make room for a new one.
+const maxCachedForecasts = 1000
+
+// ForecastPoint is the predicted soil moisture at one future hour with a 95% band.
+type ForecastPoint struct {
+	Timestamp    time.Time `json:"timestamp"`
+	SoilMoisture float64   `json:"soil_moisture"`
+	Lower        float64   `json:"lower"`
+	Upper        float64   `json:"upper"`
+}
+
+// Forecast is the result of a soil-moisture forecast for one device.
+type Forecast struct {
+	UserID       uint            `json:"user_id"`
+	DeviceID     string          `json:"device_id"`
+	Hours        int             `json:"hours"`
+	Method       string          `json:"method"`
+	BasedOn      uint            `json:"based_on_reading_id"`
+	GeneratedAt  time.Time       `json:"generated_at"`
+	Predictions  []ForecastPoint `json:"predictions"`
+	ResidualStd  float64         `json:"residual_std"`
+	TrainingSize int             `json:"training_size"`
+}
+
+var (
+	forecastMu    sync.Mutex
+	forecastCache = make(map[string]Forecast)
+)
+
+func forecastKey(userID uint, deviceID string, plant string, hours int) string {
+	return fmt.Sprintf("%d/%s/%s/%d", userID, deviceID, plant, hours)
+}
+
+// cacheForecast stores a forecast, evicting the oldest one when the cache is full.
+func cacheForecast(key string, forecast Forecast) {
+	forecastMu.Lock()
+	defer forecastMu.Unlock()
+	if _, ok := forecastCache[key]; !ok && len(forecastCache) >= maxCachedForecasts {
+		oldest := ""
+		for k, cached := range forecastCache {
+			if oldest == "" || cached.GeneratedAt.Before(forecastCache[oldest].GeneratedAt) {
+				oldest = k
+			}
+		}
+		delete(forecastCache, oldest)
+	}
+	forecastCache[key] = forecast
+}
+
+// InvalidateForecasts drops every cached forecast of a device. It is called
+// when a new reading arrives.
+func InvalidateForecasts(userID uint, deviceID string) {
+	forecastMu.Lock()
+	defer forecastMu.Unlock()
+	prefix := fmt.Sprintf("%d/%s/", userID, deviceID)
+	for key := range forecastCache {
+		if strings.HasPrefix(key, prefix) {
+			delete(forecastCache, key)
+		}
+	}
+}
+
+// trendModel is an ordinary least-squares line of soil moisture over time.
+type trendModel struct {
+	slope     float64 // per hour
+	intercept float64
+	meanX     float64
+	sxx       float64
+	sigma     float64
+	n         int
+	origin    time.Time
+}
+
+// fitTrend fits the trend model to the given readings, with x measured in hours
+// since the first reading.
+func fitTrend(records []models.SensorData) trendModel {
+	m := trendModel{n: len(records)}
+	if len(records) == 0 {
+		return m
+	}
+	m.origin = records[0].Timestamp
+
+	var sumX, sumY float64
+	for _, r := range records {
+		sumX += r.Timestamp.Sub(m.origin).Hours()
+		sumY += float64(r.SoilMoisture)
+	}
+	n := float64(len(records))
+	m.meanX = sumX / n
+	meanY := sumY / n
+
+	var sxy float64
+	for _, r := range records {
+		dx := r.Timestamp.Sub(m.origin).Hours() - m.meanX
+		m.sxx += dx * dx
+		sxy += dx * (float64(r.SoilMoisture) - meanY)
+	}
+	if m.sxx > 0 {
+		m.slope = sxy / m.sxx
+	}
+	m.intercept = meanY - m.slope*m.meanX
+
+	if len(records) > 2 {
+		var sse float64
+		for _, r := range records {
+			residual := float64(r.SoilMoisture) - m.predict(r.Timestamp)
+			sse += residual * residual
+		}
+		m.sigma = math.Sqrt(sse / (n - 2))
+	}
+	return m
+}
+
+func (m trendModel) predict(t time.Time) float64 {
+	return m.intercept + m.slope*t.Sub(m.origin).Hours()
+}
+
+// halfWidth returns the 95% prediction interval half-width at time t.
+func (m trendModel) halfWidth(t time.Time) float64 {
+	if m.n == 0 {
+		return 0
+	}
+	x := t.Sub(m.origin).Hours()
+	leverage := 1 + 1/float64(m.n)
+	if m.sxx > 0 {
+		leverage += (x - m.meanX) * (x - m.meanX) / m.sxx
+	}
+	return 1.96 * m.sigma * math.Sqrt(leverage)
+}
+
+func clampPercent(v float64) float64 {
+	return math.Max(0, math.Min(100, v))
+}
+
+// projectWeather estimates temperature and humidity at a future time by
+// repeating the reading taken closest to 24 hours earlier (diurnal persistence).
+func projectWeather(history []models.SensorData, t time.Time) (float32, float32) {
+	target := t.Add(-24 * time.Hour)
+	best := history[len(history)-1]
+	bestDiff := time.Duration(math.MaxInt64)
+	for _, r := range history {
+		diff := r.Timestamp.Sub(target)
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff < bestDiff {
+			best, bestDiff = r, diff
+		}
+	}
+	return best.Temperature, best.Humidity
+}
+
+// ForecastSoilMoisture predicts a device's soil moisture for the next hours.
+// When plant is set the AI service is queried for up to maxAIForecastHours
+// hours using projected temperature and humidity; otherwise, or when the
+// service fails, a local linear trend model is used. Bands always come from
+// the trend model residuals. Results are cached until a newer reading for the
+// device is stored. ctx ends the AI calls early, e.g. when the client leaves.
+func ForecastSoilMoisture(ctx context.Context, db *gorm.DB, userID uint, deviceID string, plant string, hours int) (Forecast, error) {
+	if hours < 1 || hours > MaxForecastHours {
+		return Forecast{}, fmt.Errorf("hours must be between 1 and %d", MaxForecastHours)
+	}
+
+	var latest models.SensorData
+	if err := db.Where("user_id = ? AND device_id = ?", userID, deviceID).
+		Order("timestamp desc").First(&latest).Error; err != nil {
+		return Forecast{}, fmt.Errorf("no readings available for device %s", deviceID)
+	}
+
+	key := forecastKey(userID, deviceID, plant, hours)
+	forecastMu.Lock()
+	cached, ok := forecastCache[key]
+	forecastMu.Unlock()
+	if ok && cached.BasedOn == latest.ID {
+		return cached, nil
+	}
+
+	var history []models.SensorData
+	if err := db.Where("user_id = ? AND device_id = ? AND timestamp >= ?",
+		userID, deviceID, latest.Timestamp.Add(-forecastHistoryWindow)).
+		Order("timestamp asc").Find(&history).Error; err != nil {
+		return Forecast{}, fmt.Errorf("failed to get historical data: %v", err)
+	}
+
+	model := fitTrend(history)
+	forecast := Forecast{
+		UserID:       userID,
+		DeviceID:     deviceID,
+		Hours:        hours,
+		Method:       "local_trend",
+		BasedOn:      latest.ID,
+		GeneratedAt:  time.Now(),
+		ResidualStd:  model.sigma,
+		TrainingSize: model.n,
+	}
+
+	var aiValues []float64
+	if plant != "" {
+		aiCtx, cancel := context.WithTimeout(ctx, aiForecastBudget)
+		aiValues = forecastWithAI(aiCtx, history, plant, latest.Timestamp, min(hours, maxAIForecastHours))
+		cancel()
+		// A forecast cut short by the caller is neither returned nor cached
+		if err := ctx.Err(); err != nil {
+			return Forecast{}, err
+		}
+		switch {
+		case aiValues == nil:
+		case len(aiValues) < hours:
+			forecast.Method = "ai_service+local_trend"
+		default:
+			forecast.Method = "ai_service"
+		}
+	}
+
+	for h := 1; h <= hours; h++ {
+		t := latest.Timestamp.Add(time.Duration(h) * time.Hour)
+		value := model.predict(t)
+		if last := len(aiValues); h <= last {
+			value = aiValues[h-1]
+		} else if last > 0 {
+			value = aiValues[last-1] + model.slope*float64(h-last)
+		}
+		band := model.halfWidth(t)
+		forecast.Predictions = append(forecast.Predictions, ForecastPoint{
+			Timestamp:    t,
+			SoilMoisture: clampPercent(value),
+			Lower:        clampPercent(value - band),
+			Upper:        clampPercent(value + band),
+		})
+	}
+
+	cacheForecast(key, forecast)
+	return forecast, nil
+}
+
+// forecastWithAI asks the AI service for each future hour, feeding back
+// projected readings so the rolling and lag features stay consistent.
+// It returns nil if any request fails or ctx is done.
+func forecastWithAI(ctx context.Context, history []models.SensorData, plant string, from time.Time, hours int) []float64 {
+	window := append([]models.SensorData(nil), history...)
+	values := make([]float64, 0, hours)
+
+	for h := 1; h <= hours; h++ {
+		t := from.Add(time.Duration(h) * time.Hour)
+		temp, humidity := projectWeather(window, t)
+
+		// Only the last 24 hours feed the features, as in GetPredictedSoilMoisture
+		var recent []models.SensorData
+		for _, r := range window {
+			if !r.Timestamp.Before(t.Add(-24 * time.Hour)) {
+				recent = append(recent, r)
+			}
+		}
+
+		features, err := calculateFeatures(recent, temp, humidity)
+		if err != nil {
+			return nil
+		}
+		features.PlantName = plant
+		features.Timestamp = t.Format("2006-01-02 15:04:05")
+
+		_, predicted, err := requestPrediction(ctx, features)
+		if err != nil {
+			fmt.Println("❌ AI forecast failed, falling back to local model:", err)
+			return nil
+		}
+		values = append(values, predicted)
+		window = append(window, models.SensorData{
+			Timestamp:    t,
+			Temperature:  temp,
+			Humidity:     humidity,
+			SoilMoisture: float32(predicted),
+		})
+	}
+	return values
+}
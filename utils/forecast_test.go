@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"fyp/models"
+)
+
+// stubAIService answers predictions with a fixed soil moisture, after delay,
+// and counts the requests.
+func stubAIService(t *testing.T, delay time.Duration) *int32 {
+	t.Helper()
+	var calls int32
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-time.After(delay):
+		case <-done:
+			return
+		}
+		fmt.Fprint(w, `{"predicted_soil_moisture": 42, "timestamp": "2024-01-01 00:00:00"}`)
+	}))
+	t.Cleanup(server.Close)
+	// Cleanups run last first, so waiting handlers return before Close
+	t.Cleanup(func() { close(done) })
+	t.Setenv("AI_URL", server.URL)
+	return &calls
+}
+
+func forecastHistory() []models.SensorData {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var history []models.SensorData
+	for h := 0; h < 24; h++ {
+		history = append(history, models.SensorData{Timestamp: start.Add(time.Duration(h) * time.Hour), Temperature: 20, Humidity: 50, SoilMoisture: 40})
+	}
+	return history
+}
+
+func TestForecastWithAI(t *testing.T) {
+	calls := stubAIService(t, 0)
+	history := forecastHistory()
+
+	values := forecastWithAI(context.Background(), history, "tomato", history[len(history)-1].Timestamp, 6)
+	if len(values) != 6 || values[0] != 42 {
+		t.Fatalf("values = %v, want 6 predictions of 42", values)
+	}
+	if *calls != 6 {
+		t.Fatalf("%d AI calls for 6 hours, want 6", *calls)
+	}
+}
+
+func TestForecastWithAIStopsWhenCancelled(t *testing.T) {
+	calls := stubAIService(t, time.Minute)
+	history := forecastHistory()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if values := forecastWithAI(ctx, history, "tomato", history[len(history)-1].Timestamp, MaxForecastHours); values != nil {
+		t.Fatalf("values = %v, want nil once cancelled", values)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("forecast ran %v after its context ended", elapsed)
+	}
+	if n := atomic.LoadInt32(calls); n > 1 {
+		t.Fatalf("%d AI calls after cancelling, want at most 1", n)
+	}
+}
+
+func TestRequestPredictionTimesOut(t *testing.T) {
+	stubAIService(t, time.Minute)
+	previous := AIHTTPClient
+	AIHTTPClient = &http.Client{Timeout: 50 * time.Millisecond}
+	t.Cleanup(func() { AIHTTPClient = previous })
+
+	if _, _, err := requestPrediction(context.Background(), AIRequestData{PlantName: "tomato"}); err == nil {
+		t.Fatal("requestPrediction waited past the client timeout")
+	}
+}
+
+func TestForecastCacheIsBounded(t *testing.T) {
+	forecastMu.Lock()
+	previous := forecastCache
+	forecastCache = make(map[string]Forecast)
+	forecastMu.Unlock()
+	t.Cleanup(func() {
+		forecastMu.Lock()
+		forecastCache = previous
+		forecastMu.Unlock()
+	})
+
+	start := time.Now()
+	for i := 0; i <= maxCachedForecasts; i++ {
+		cacheForecast(forecastKey(uint(i), "dev", "", 24), Forecast{GeneratedAt: start.Add(time.Duration(i) * time.Second)})
+	}
+	if len(forecastCache) != maxCachedForecasts {
+		t.Fatalf("cache holds %d forecasts, want %d", len(forecastCache), maxCachedForecasts)
+	}
+	if _, ok := forecastCache[forecastKey(0, "dev", "", 24)]; ok {
+		t.Fatal("the oldest forecast was kept")
+	}
+	if _, ok := forecastCache[forecastKey(maxCachedForecasts, "dev", "", 24)]; !ok {
+		t.Fatal("the newest forecast was not stored")
+	}
+}
@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// maxIntegrationGap is the longest gap between readings the integrated
+	// method interpolates across.
+	maxIntegrationGap = 3 * time.Hour
+	// minIntegratedCoverage is how much of a day the readings must cover for
+	// the integrated method to count it.
+	minIntegratedCoverage = 12 * time.Hour
+	// gddRateDays is how many recent measured days set the projected daily rate.
+	gddRateDays = 14
+)
+
+// DegreeDay is the thermal time accumulated over one local day.
+type DegreeDay struct {
+	Date       time.Time `json:"date"`
+	GDD        float64   `json:"gdd"`
+	Cumulative float64   `json:"cumulative"`
+	Readings   int       `json:"readings"`
+	Measured   bool      `json:"measured"` // False when there were too few readings to count the day
+}
+
+// StageEstimate is when a growth stage started or is expected to start and end.
+type StageEstimate struct {
+	Name      string    `json:"name"`
+	Sequence  int       `json:"sequence"`
+	GDDStart  float64   `json:"gdd_start,omitempty"`
+	GDDEnd    float64   `json:"gdd_end,omitempty"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Started   bool      `json:"started"`
+	Completed bool      `json:"completed"`
+}
+
+// GDDPrediction tracks a planting through its growth stages and projects the
+// next transition and the harvest window.
+type GDDPrediction struct {
+	Basis          string          `json:"basis"` // thermal when every stage has a GDD requirement, calendar otherwise
+	Method         string          `json:"method"`
+	AccumulatedGDD float64         `json:"accumulated_gdd"`
+	DailyRate      float64         `json:"daily_rate"` // Mean GDD per day over recent measured days
+	Stages         []StageEstimate `json:"stages"`
+	CurrentStage   *StageEstimate  `json:"current_stage"`
+	NextTransition *time.Time      `json:"next_transition"`
+	HarvestStart   *time.Time      `json:"harvest_start"`
+	HarvestEnd     *time.Time      `json:"harvest_end"`
+}
+
+// clampTemperature limits t to the crop's development range.
+func clampTemperature(t, base, ceiling float64) float64 {
+	return math.Max(base, math.Min(ceiling, t))
+}
+
+// temperatureLimits returns a crop's base and cap temperatures, with no cap
+// when none is configured.
+func temperatureLimits(crop models.Crop) (float64, float64) {
+	ceiling := crop.CapTemperature
+	if ceiling == 0 {
+		ceiling = math.Inf(1)
+	}
+	return crop.BaseTemperature, ceiling
+}
+
+// MinMaxGDD is the daily min/max method with both temperatures clamped to
+// [base, ceiling] before averaging.
+func MinMaxGDD(tMin, tMax, base, ceiling float64) float64 {
+	return (clampTemperature(tMin, base, ceiling)+clampTemperature(tMax, base, ceiling))/2 - base
+}
+
+// IntegratedGDD integrates clamped temperature above base over a day's
+// readings with the trapezoid rule, scaled up to a full day. Readings must be
+// sorted by time. It returns false when they cover less than
+// minIntegratedCoverage.
+func IntegratedGDD(readings []models.SensorData, base, ceiling float64) (float64, bool) {
+	var area float64
+	var covered time.Duration
+	for i := 1; i < len(readings); i++ {
+		gap := readings[i].Timestamp.Sub(readings[i-1].Timestamp)
+		if gap <= 0 || gap > maxIntegrationGap {
+			continue
+		}
+		t0 := clampTemperature(float64(readings[i-1].Temperature), base, ceiling) - base
+		t1 := clampTemperature(float64(readings[i].Temperature), base, ceiling) - base
+		area += (t0 + t1) / 2 * gap.Hours() / 24
+		covered += gap
+	}
+	if covered < minIntegratedCoverage {
+		return 0, false
+	}
+	return area * float64(24*time.Hour) / float64(covered), true
+}
+
+// dayGDD computes one day's degree days from its readings with method.
+func dayGDD(readings []models.SensorData, method string, base, ceiling float64) (float64, bool) {
+	if method == models.GDDIntegrated {
+		return IntegratedGDD(readings, base, ceiling)
+	}
+	if len(readings) < 2 {
+		return 0, false
+	}
+	tMin, tMax := math.Inf(1), math.Inf(-1)
+	for _, r := range readings {
+		tMin = math.Min(tMin, float64(r.Temperature))
+		tMax = math.Max(tMax, float64(r.Temperature))
+	}
+	return MinMaxGDD(tMin, tMax, base, ceiling), true
+}
+
+// GDDMethodFor returns method if set, else the crop's method, else min/max.
+func GDDMethodFor(crop models.Crop, method string) (string, error) {
+	if method == "" {
+		method = crop.GDDMethod
+	}
+	switch method {
+	case "":
+		return models.GDDMinMax, nil
+	case models.GDDMinMax, models.GDDIntegrated:
+		return method, nil
+	}
+	return "", fmt.Errorf("unknown GDD method %q", method)
+}
+
+// AccumulateGDD computes the planting's degree days for every local day from
+// its sow date until it ended or until. A field planting pools the readings of
+// every device in the field. The planting must have its crop loaded.
+func AccumulateGDD(db *gorm.DB, planting models.Planting, method string, until time.Time) ([]DegreeDay, error) {
+	if planting.EndedAt != nil && planting.EndedAt.Before(until) {
+		until = *planting.EndedAt
+	}
+	keys, err := PlantingDevices(db, planting)
+	if err != nil {
+		return nil, err
+	}
+	var readings []models.SensorData
+	if err := db.Scopes(ReadingsOf(keys)).Where("timestamp >= ? AND timestamp < ?", planting.SowDate, until).
+		Order("timestamp asc").Find(&readings).Error; err != nil {
+		return nil, err
+	}
+
+	loc := LocalTimezone()
+	base, ceiling := temperatureLimits(planting.Crop)
+	sow := planting.SowDate.In(loc)
+	day := time.Date(sow.Year(), sow.Month(), sow.Day(), 0, 0, 0, 0, loc)
+
+	var days []DegreeDay
+	var cumulative float64
+	i := 0
+	for day.Before(until) {
+		next := day.AddDate(0, 0, 1)
+		start := i
+		for i < len(readings) && readings[i].Timestamp.Before(next) {
+			i++
+		}
+		dayReadings := readings[start:i]
+		gdd, measured := dayGDD(dayReadings, method, base, ceiling)
+		cumulative += gdd
+		days = append(days, DegreeDay{Date: day, GDD: gdd, Cumulative: cumulative, Readings: len(dayReadings), Measured: measured})
+		day = next
+	}
+	return days, nil
+}
+
+// recentGDDRate is the mean GDD of the last gddRateDays measured days.
+func recentGDDRate(days []DegreeDay) float64 {
+	var total float64
+	var count int
+	for i := len(days) - 1; i >= 0 && count < gddRateDays; i-- {
+		if days[i].Measured {
+			total += days[i].GDD
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return total / float64(count)
+}
+
+// thermalDate returns when the cumulative GDD reached target, or projects it
+// forward from now at rate. ok is false when it can be neither observed nor
+// projected.
+func thermalDate(days []DegreeDay, target, rate float64, now time.Time) (at time.Time, observed bool, ok bool) {
+	for _, d := range days {
+		if d.Cumulative >= target {
+			return d.Date.AddDate(0, 0, 1), true, true
+		}
+	}
+	if rate <= 0 {
+		return time.Time{}, false, false
+	}
+	accumulated := 0.0
+	if len(days) > 0 {
+		accumulated = days[len(days)-1].Cumulative
+	}
+	remaining := (target - accumulated) / rate
+	return now.Add(time.Duration(remaining * float64(24*time.Hour))), false, true
+}
+
+// PredictStages places the planting's growth stages on the calendar. When
+// every stage has a GDD requirement they are tracked by thermal time and
+// future transitions are projected at the recent daily rate; otherwise the
+// calendar schedule from the sow date is used.
+func PredictStages(planting models.Planting, days []DegreeDay, method string, now time.Time) GDDPrediction {
+	prediction := GDDPrediction{Basis: "calendar", Method: method, DailyRate: recentGDDRate(days)}
+	if len(days) > 0 {
+		prediction.AccumulatedGDD = days[len(days)-1].Cumulative
+	}
+
+	stages := append([]models.GrowthStage(nil), planting.Crop.Stages...)
+	sort.Slice(stages, func(i, j int) bool { return stages[i].Sequence < stages[j].Sequence })
+	thermal := len(stages) > 0
+	for _, stage := range stages {
+		if stage.GDDRequired <= 0 {
+			thermal = false
+		}
+	}
+
+	if thermal {
+		prediction.Basis = "thermal"
+		gddStart := 0.0
+		start := planting.SowDate
+		for _, stage := range stages {
+			gddEnd := gddStart + stage.GDDRequired
+			end, completed, ok := thermalDate(days, gddEnd, prediction.DailyRate, now)
+			if !ok {
+				break // Nothing after this can be dated either
+			}
+			prediction.Stages = append(prediction.Stages, StageEstimate{
+				Name: stage.Name, Sequence: stage.Sequence, GDDStart: gddStart, GDDEnd: gddEnd,
+				Start: start, End: end, Started: prediction.AccumulatedGDD >= gddStart, Completed: completed,
+			})
+			gddStart, start = gddEnd, end
+		}
+	} else {
+		for _, window := range StageSchedule(stages, planting.SowDate) {
+			prediction.Stages = append(prediction.Stages, StageEstimate{
+				Name: window.Stage.Name, Sequence: window.Stage.Sequence, Start: window.Start, End: window.End,
+				Started: !now.Before(window.Start), Completed: !now.Before(window.End),
+			})
+		}
+	}
+
+	for i := range prediction.Stages {
+		stage := &prediction.Stages[i]
+		if stage.Started && !stage.Completed {
+			prediction.CurrentStage = stage
+			end := stage.End
+			prediction.NextTransition = &end
+			break
+		}
+	}
+	if len(prediction.Stages) == len(stages) && len(stages) > 0 {
+		last := prediction.Stages[len(prediction.Stages)-1]
+		prediction.HarvestStart, prediction.HarvestEnd = &last.Start, &last.End
+	}
+	return prediction
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"fyp/models"
+)
+
+func TestMinMaxGDD(t *testing.T) {
+	for _, tt := range []struct {
+		tMin, tMax, base, ceiling, want float64
+	}{
+		{12, 20, 10, math.Inf(1), 6},
+		{5, 15, 10, 30, 2.5},   // Below base counts as base
+		{25, 35, 10, 30, 17.5}, // Above the cap counts as the cap
+		{0, 8, 10, 30, 0},
+	} {
+		if got := MinMaxGDD(tt.tMin, tt.tMax, tt.base, tt.ceiling); got != tt.want {
+			t.Errorf("MinMaxGDD(%v, %v, %v, %v) = %v, want %v", tt.tMin, tt.tMax, tt.base, tt.ceiling, got, tt.want)
+		}
+	}
+}
+
+// hourlyReadings returns a reading at 20°C at each of the given hours.
+func hourlyReadings(hours ...int) []models.SensorData {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	var readings []models.SensorData
+	for _, h := range hours {
+		readings = append(readings, models.SensorData{Timestamp: start.Add(time.Duration(h) * time.Hour), Temperature: 20})
+	}
+	return readings
+}
+
+func hourRange(from, to int) []int {
+	var hours []int
+	for h := from; h <= to; h++ {
+		hours = append(hours, h)
+	}
+	return hours
+}
+
+func TestIntegratedGDDScalesCoverageToADay(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		hours    []int
+		want     float64
+		measured bool
+	}{
+		{"full day", hourRange(0, 24), 10, true},
+		{"half day", hourRange(0, 12), 10, true},
+		{"gap skipped", append(hourRange(0, 6), hourRange(10, 16)...), 10, true},
+		{"too little coverage", hourRange(0, 11), 0, false},
+		{"gap too long to count", append(hourRange(0, 6), hourRange(10, 15)...), 0, false},
+	} {
+		got, measured := IntegratedGDD(hourlyReadings(tt.hours...), 10, math.Inf(1))
+		if measured != tt.measured || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: IntegratedGDD = %v, %v; want %v, %v", tt.name, got, measured, tt.want, tt.measured)
+		}
+	}
+
+	if got, _ := IntegratedGDD(hourlyReadings(hourRange(0, 24)...), 10, 15); math.Abs(got-5) > 1e-9 {
+		t.Errorf("IntegratedGDD capped at 15 = %v, want 5", got)
+	}
+}
+
+// gddPlanting returns a planting sown at sow with a 10 day, 100 GDD stage
+// followed by a 20 day stage needing gdd2.
+func gddPlanting(sow time.Time, gdd2 float64) models.Planting {
+	return models.Planting{SowDate: sow, Crop: models.Crop{Stages: []models.GrowthStage{
+		{Name: "Fruiting", Sequence: 2, DurationDays: 20, GDDRequired: gdd2},
+		{Name: "Vegetative", Sequence: 1, DurationDays: 10, GDDRequired: 100},
+	}}}
+}
+
+// measuredDays returns n days from sow that each accumulated gdd.
+func measuredDays(sow time.Time, n int, gdd float64) []DegreeDay {
+	var days []DegreeDay
+	for i := 0; i < n; i++ {
+		days = append(days, DegreeDay{Date: sow.AddDate(0, 0, i), GDD: gdd, Cumulative: float64(i+1) * gdd, Readings: 24, Measured: true})
+	}
+	return days
+}
+
+func TestPredictStagesByThermalTime(t *testing.T) {
+	sow := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	now := sow.AddDate(0, 0, 12)
+	// 15 GDD a day: the first stage ends on day 7, not the calendar's day 10
+	prediction := PredictStages(gddPlanting(sow, 300), measuredDays(sow, 12, 15), models.GDDMinMax, now)
+
+	if prediction.Basis != "thermal" || prediction.AccumulatedGDD != 180 || prediction.DailyRate != 15 {
+		t.Fatalf("prediction = %s basis, %v GDD at %v a day; want thermal, 180 at 15", prediction.Basis, prediction.AccumulatedGDD, prediction.DailyRate)
+	}
+	if len(prediction.Stages) != 2 || prediction.Stages[0].Name != "Vegetative" {
+		t.Fatalf("stages = %+v, want Vegetative then Fruiting", prediction.Stages)
+	}
+	if first := prediction.Stages[0]; !first.End.Equal(sow.AddDate(0, 0, 7)) || !first.Completed {
+		t.Fatalf("first stage = %+v, want completed on day 7", first)
+	}
+	// 400 - 180 GDD to go at 15 a day
+	wantEnd := now.Add(time.Duration(220.0 / 15 * float64(24*time.Hour)))
+	if prediction.CurrentStage == nil || prediction.CurrentStage.Name != "Fruiting" || !prediction.NextTransition.Equal(wantEnd) {
+		t.Fatalf("current stage = %+v, want Fruiting until %v", prediction.CurrentStage, wantEnd)
+	}
+	if prediction.HarvestEnd == nil || !prediction.HarvestEnd.Equal(wantEnd) {
+		t.Fatalf("harvest end = %v, want %v", prediction.HarvestEnd, wantEnd)
+	}
+
+	// Without any measured days the unreached stages cannot be dated
+	prediction = PredictStages(gddPlanting(sow, 300), nil, models.GDDMinMax, now)
+	if len(prediction.Stages) != 0 || prediction.HarvestStart != nil {
+		t.Fatalf("prediction without readings = %+v, want no dated stages", prediction)
+	}
+}
+
+func TestPredictStagesFallsBackToCalendar(t *testing.T) {
+	sow := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	now := sow.AddDate(0, 0, 12)
+	// One stage without a GDD requirement puts the whole crop on the calendar
+	prediction := PredictStages(gddPlanting(sow, 0), measuredDays(sow, 12, 15), models.GDDMinMax, now)
+
+	if prediction.Basis != "calendar" || len(prediction.Stages) != 2 {
+		t.Fatalf("prediction = %s basis with %d stages, want calendar with 2", prediction.Basis, len(prediction.Stages))
+	}
+	if first := prediction.Stages[0]; !first.End.Equal(sow.AddDate(0, 0, 10)) || !first.Completed {
+		t.Fatalf("first stage = %+v, want completed on day 10", first)
+	}
+	if prediction.CurrentStage == nil || prediction.CurrentStage.Name != "Fruiting" || !prediction.NextTransition.Equal(sow.AddDate(0, 0, 30)) {
+		t.Fatalf("current stage = %+v, want Fruiting until day 30", prediction.CurrentStage)
+	}
+}
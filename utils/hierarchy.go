@@ -0,0 +1,297 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrNoNodeAccess is returned for hierarchy nodes the user cannot see.
+var ErrNoNodeAccess = errors.New("no access to this part of the farm")
+
+// DeviceKey identifies the readings of one device. Sensor data is keyed by the
+// device owner and the device ID.
+type DeviceKey struct {
+	UserID   uint   `json:"user_id"`
+	DeviceID string `json:"device_id"`
+}
+
+// Aggregate summarises sensor readings over part of the hierarchy.
+type Aggregate struct {
+	Readings        int64      `json:"readings"`
+	AbnormalCount   int64      `json:"abnormal_count"`
+	AvgTemperature  *float64   `json:"avg_temperature"`
+	AvgHumidity     *float64   `json:"avg_humidity"`
+	AvgSoilMoisture *float64   `json:"avg_soil_moisture"`
+	MinSoilMoisture *float64   `json:"min_soil_moisture"`
+	MaxSoilMoisture *float64   `json:"max_soil_moisture"`
+	LatestAt        *time.Time `json:"latest_at"`
+}
+
+// EnsureDevice registers a device the first time its owner reports from it.
+func EnsureDevice(db *gorm.DB, userID uint, deviceID string) (models.Device, error) {
+	device := models.Device{UserID: userID, DeviceID: deviceID}
+	err := db.Where("user_id = ? AND device_id = ?", userID, deviceID).FirstOrCreate(&device).Error
+	return device, err
+}
+
+// ZonesUnder returns the IDs of every zone at or below a node.
+func ZonesUnder(db *gorm.DB, level string, id uint) ([]uint, error) {
+	var zoneIDs []uint
+	var err error
+	switch level {
+	case models.LevelFarm:
+		err = db.Model(&models.Zone{}).Joins("JOIN fields ON fields.id = zones.field_id").
+			Where("fields.farm_id = ?", id).Pluck("zones.id", &zoneIDs).Error
+	case models.LevelField:
+		err = db.Model(&models.Zone{}).Where("field_id = ?", id).Pluck("id", &zoneIDs).Error
+	case models.LevelZone:
+		zoneIDs = []uint{id}
+	default:
+		err = fmt.Errorf("unknown hierarchy level %q", level)
+	}
+	return zoneIDs, err
+}
+
+// FarmOfNode returns the farm a node belongs to.
+func FarmOfNode(db *gorm.DB, level string, id uint) (models.Farm, error) {
+	var farm models.Farm
+	var err error
+	switch level {
+	case models.LevelFarm:
+		err = db.First(&farm, id).Error
+	case models.LevelField:
+		err = db.Joins("JOIN fields ON fields.farm_id = farms.id").Where("fields.id = ?", id).First(&farm).Error
+	case models.LevelZone:
+		err = db.Joins("JOIN fields ON fields.farm_id = farms.id").Joins("JOIN zones ON zones.field_id = fields.id").
+			Where("zones.id = ?", id).First(&farm).Error
+	default:
+		err = fmt.Errorf("unknown hierarchy level %q", level)
+	}
+	return farm, err
+}
+
+// memberZones returns the zones a farm membership grants access to.
+func memberZones(db *gorm.DB, member models.FarmMember) ([]uint, error) {
+	switch {
+	case member.ZoneID != nil:
+		return []uint{*member.ZoneID}, nil
+	case member.FieldID != nil:
+		return ZonesUnder(db, models.LevelField, *member.FieldID)
+	default:
+		return ZonesUnder(db, models.LevelFarm, member.FarmID)
+	}
+}
+
+// VisibleZones returns the zones a user can see through the farms they own
+// and their farm memberships.
+func VisibleZones(db *gorm.DB, userID uint) (map[uint]bool, error) {
+	visible := make(map[uint]bool)
+
+	var farmIDs []uint
+	if err := db.Model(&models.Farm{}).Where("owner_id = ?", userID).Pluck("id", &farmIDs).Error; err != nil {
+		return nil, err
+	}
+	for _, farmID := range farmIDs {
+		zoneIDs, err := ZonesUnder(db, models.LevelFarm, farmID)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range zoneIDs {
+			visible[id] = true
+		}
+	}
+
+	var members []models.FarmMember
+	if err := db.Where("user_id = ?", userID).Find(&members).Error; err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		zoneIDs, err := memberZones(db, member)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range zoneIDs {
+			visible[id] = true
+		}
+	}
+	return visible, nil
+}
+
+// VisibleDevices returns the devices a user can see: their own and every
+// device placed in a zone they can see. Admins see every device.
+func VisibleDevices(db *gorm.DB, user models.User) ([]models.Device, error) {
+	var devices []models.Device
+	if CanManageAny(user, models.ResourceDevices) {
+		err := db.Order("user_id asc, device_id asc").Find(&devices).Error
+		return devices, err
+	}
+
+	zones, err := VisibleZones(db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	zoneIDs := make([]uint, 0, len(zones))
+	for id := range zones {
+		zoneIDs = append(zoneIDs, id)
+	}
+	query := db.Where("user_id = ?", user.ID)
+	if len(zoneIDs) > 0 {
+		query = query.Or("zone_id IN ?", zoneIDs)
+	}
+	err = query.Order("user_id asc, device_id asc").Find(&devices).Error
+	return devices, err
+}
+
+// CanViewNode reports whether a user can see any part of a node. Farm owners
+// and admins see the whole farm, members only the part they were given.
+func CanViewNode(db *gorm.DB, user models.User, level string, id uint) (bool, error) {
+	if CanManageAny(user, models.ResourceFarms) {
+		return true, nil
+	}
+	farm, err := FarmOfNode(db, level, id)
+	if err != nil {
+		return false, err
+	}
+	if farm.OwnerID == user.ID {
+		return true, nil
+	}
+	visible, err := VisibleZones(db, user.ID)
+	if err != nil {
+		return false, err
+	}
+	zoneIDs, err := ZonesUnder(db, level, id)
+	if err != nil {
+		return false, err
+	}
+	for _, zoneID := range zoneIDs {
+		if visible[zoneID] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// NodeDevices returns the devices at or below a node that the user can see.
+// It returns ErrNoNodeAccess if the user can see no part of the node.
+func NodeDevices(db *gorm.DB, user models.User, level string, id uint) ([]DeviceKey, error) {
+	ok, err := CanViewNode(db, user, level, id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNoNodeAccess
+	}
+
+	zoneIDs, err := ZonesUnder(db, level, id)
+	if err != nil {
+		return nil, err
+	}
+	farm, err := FarmOfNode(db, level, id)
+	if err != nil {
+		return nil, err
+	}
+	if !CanManageAny(user, models.ResourceFarms) && farm.OwnerID != user.ID {
+		visible, err := VisibleZones(db, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		allowed := zoneIDs[:0]
+		for _, zoneID := range zoneIDs {
+			if visible[zoneID] {
+				allowed = append(allowed, zoneID)
+			}
+		}
+		zoneIDs = allowed
+	}
+
+	keys := []DeviceKey{}
+	if len(zoneIDs) == 0 {
+		return keys, nil
+	}
+	var devices []models.Device
+	if err := db.Where("zone_id IN ?", zoneIDs).Find(&devices).Error; err != nil {
+		return nil, err
+	}
+	for _, device := range devices {
+		keys = append(keys, DeviceKey{UserID: device.UserID, DeviceID: device.DeviceID})
+	}
+	return keys, nil
+}
+
+// DeviceAudience returns every user who can see a device's readings: its
+// owner, the owner of the farm it is placed in and the farm members whose
+// part of the farm covers its zone.
+func DeviceAudience(db *gorm.DB, userID uint, deviceID string) ([]uint, error) {
+	audience := []uint{userID}
+
+	var device models.Device
+	if err := db.Where("user_id = ? AND device_id = ?", userID, deviceID).First(&device).Error; err != nil || device.ZoneID == nil {
+		return audience, nil
+	}
+	farm, err := FarmOfNode(db, models.LevelZone, *device.ZoneID)
+	if err != nil {
+		return audience, err
+	}
+	if farm.OwnerID != userID {
+		audience = append(audience, farm.OwnerID)
+	}
+
+	var zone models.Zone
+	if err := db.First(&zone, *device.ZoneID).Error; err != nil {
+		return audience, err
+	}
+	var members []models.FarmMember
+	if err := db.Where("farm_id = ? AND (zone_id = ? OR (zone_id IS NULL AND (field_id = ? OR field_id IS NULL)))",
+		farm.ID, zone.ID, zone.FieldID).Find(&members).Error; err != nil {
+		return audience, err
+	}
+	for _, member := range members {
+		if member.UserID != userID && member.UserID != farm.OwnerID {
+			audience = append(audience, member.UserID)
+		}
+	}
+	return audience, nil
+}
+
+// ReadingsOf restricts a sensor data query to the readings of devices.
+func ReadingsOf(keys []DeviceKey) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if len(keys) == 0 {
+			return db.Where("1 = 0")
+		}
+		condition := db.Session(&gorm.Session{NewDB: true})
+		for i, key := range keys {
+			if i == 0 {
+				condition = condition.Where("user_id = ? AND device_id = ?", key.UserID, key.DeviceID)
+			} else {
+				condition = condition.Or("user_id = ? AND device_id = ?", key.UserID, key.DeviceID)
+			}
+		}
+		return db.Where(condition)
+	}
+}
+
+// AggregateReadings summarises the readings of devices between from and to.
+// Zero times leave that end of the range open.
+func AggregateReadings(db *gorm.DB, keys []DeviceKey, from, to time.Time) (Aggregate, error) {
+	var aggregate Aggregate
+	query := db.Model(&models.SensorData{}).Scopes(ReadingsOf(keys))
+	if !from.IsZero() {
+		query = query.Where("timestamp >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("timestamp < ?", to)
+	}
+	err := query.Select(`COUNT(*) AS readings,
+		COALESCE(SUM(CASE WHEN is_abnormal THEN 1 ELSE 0 END), 0) AS abnormal_count,
+		AVG(temperature) AS avg_temperature, AVG(humidity) AS avg_humidity,
+		AVG(soil_moisture) AS avg_soil_moisture, MIN(soil_moisture) AS min_soil_moisture,
+		MAX(soil_moisture) AS max_soil_moisture, MAX(timestamp) AS latest_at`).
+		Scan(&aggregate).Error
+	return aggregate, err
+}
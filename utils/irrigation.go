@@ -0,0 +1,244 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// MaxIrrigationRunTime caps every watering run regardless of actuator settings.
+	MaxIrrigationRunTime = time.Hour
+	// commandPickupTimeout is how long a pending command waits for the device to fetch it.
+	commandPickupTimeout = 10 * time.Minute
+	// commandAckTimeout is how long a fetched command waits for acknowledgement.
+	commandAckTimeout = 2 * time.Minute
+	// wateringGracePeriod is added to the planned run time before a running
+	// event is closed without a completion report.
+	wateringGracePeriod = 2 * time.Minute
+)
+
+var (
+	ErrActuatorBusy      = errors.New("actuator is already running")
+	ErrInvalidDuration   = errors.New("invalid run duration")
+	ErrInvalidTransition = errors.New("command cannot move to the requested state")
+)
+
+// MaxRunSeconds returns the longest run allowed for an actuator.
+func MaxRunSeconds(actuator models.Actuator) int {
+	limit := int(MaxIrrigationRunTime.Seconds())
+	if actuator.MaxRunSeconds > 0 && actuator.MaxRunSeconds < limit {
+		limit = actuator.MaxRunSeconds
+	}
+	return limit
+}
+
+// QueueIrrigationCommand queues a start or stop command for an actuator.
+// A stop command cancels any start command the device has not fetched yet.
+// The actuator row is locked while the command is queued, so concurrent
+// starts (manual, rule and schedule) cannot both find it idle.
+func QueueIrrigationCommand(db *gorm.DB, actuator models.Actuator, action string, durationSeconds int, source string, requestedBy uint) (models.IrrigationCommand, error) {
+	now := time.Now()
+	command := models.IrrigationCommand{
+		UserID:      actuator.UserID,
+		DeviceID:    actuator.DeviceID,
+		ActuatorID:  actuator.ID,
+		Channel:     actuator.Channel,
+		Action:      action,
+		Status:      models.CommandPending,
+		Source:      source,
+		RequestedBy: requestedBy,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(commandPickupTimeout),
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Actuator{}, actuator.ID).Error; err != nil {
+			return err
+		}
+		switch action {
+		case models.IrrigationStart:
+			if durationSeconds <= 0 || durationSeconds > MaxRunSeconds(actuator) {
+				return fmt.Errorf("%w: must be between 1 and %d seconds", ErrInvalidDuration, MaxRunSeconds(actuator))
+			}
+			var running int64
+			if err := tx.Model(&models.WateringEvent{}).
+				Where("actuator_id = ? AND status = ?", actuator.ID, models.WateringRunning).Count(&running).Error; err != nil {
+				return err
+			}
+			var queued int64
+			if err := tx.Model(&models.IrrigationCommand{}).
+				Where("actuator_id = ? AND action = ? AND status IN ?", actuator.ID, models.IrrigationStart,
+					[]string{models.CommandPending, models.CommandSent}).Count(&queued).Error; err != nil {
+				return err
+			}
+			if running > 0 || queued > 0 {
+				return ErrActuatorBusy
+			}
+			command.DurationSeconds = durationSeconds
+
+		case models.IrrigationStop:
+			if err := tx.Model(&models.IrrigationCommand{}).
+				Where("actuator_id = ? AND action = ? AND status = ?", actuator.ID, models.IrrigationStart, models.CommandPending).
+				Update("status", models.CommandCancelled).Error; err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unknown irrigation action %q", action)
+		}
+		return tx.Create(&command).Error
+	})
+	return command, err
+}
+
+// FetchPendingCommands returns the commands waiting for a device and marks
+// them as sent.
+func FetchPendingCommands(db *gorm.DB, userID uint, deviceID string) ([]models.IrrigationCommand, error) {
+	now := time.Now()
+	var commands []models.IrrigationCommand
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND device_id = ? AND status = ? AND expires_at > ?",
+			userID, deviceID, models.CommandPending, now).
+			Order("created_at asc").Find(&commands).Error; err != nil {
+			return err
+		}
+		for i := range commands {
+			commands[i].Status = models.CommandSent
+			commands[i].SentAt = &now
+			if err := tx.Save(&commands[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return commands, err
+}
+
+// AcknowledgeCommand records the device's report on a command and opens or
+// closes the matching watering event.
+func AcknowledgeCommand(db *gorm.DB, command *models.IrrigationCommand, status string, errMsg string) error {
+	now := time.Now()
+	return db.Transaction(func(tx *gorm.DB) error {
+		var actuator models.Actuator
+		if err := tx.First(&actuator, command.ActuatorID).Error; err != nil {
+			return err
+		}
+
+		open := command.Status == models.CommandPending || command.Status == models.CommandSent
+		switch {
+		case status == models.CommandAcknowledged && open:
+			if command.Action == models.IrrigationStart {
+				event := models.WateringEvent{
+					UserID:         command.UserID,
+					DeviceID:       command.DeviceID,
+					ActuatorID:     command.ActuatorID,
+					CommandID:      command.ID,
+					Source:         command.Source,
+					Status:         models.WateringRunning,
+					StartedAt:      now,
+					PlannedSeconds: command.DurationSeconds,
+				}
+				if err := tx.Create(&event).Error; err != nil {
+					return err
+				}
+			} else {
+				// A stop takes effect as soon as the device acknowledges it
+				status = models.CommandCompleted
+				if err := closeRunningEvents(tx, actuator, models.WateringCompleted, now); err != nil {
+					return err
+				}
+			}
+
+		case status == models.CommandCompleted && (open || command.Status == models.CommandAcknowledged):
+			if err := closeRunningEvents(tx, actuator, models.WateringCompleted, now); err != nil {
+				return err
+			}
+
+		case status == models.CommandFailed && (open || command.Status == models.CommandAcknowledged):
+			if err := closeRunningEvents(tx, actuator, models.WateringAborted, now); err != nil {
+				return err
+			}
+
+		default:
+			return ErrInvalidTransition
+		}
+
+		command.Status = status
+		command.AckedAt = &now
+		command.Error = errMsg
+		return tx.Save(command).Error
+	})
+}
+
+// closeRunningEvents ends every running watering event of an actuator.
+func closeRunningEvents(tx *gorm.DB, actuator models.Actuator, status string, endedAt time.Time) error {
+	var events []models.WateringEvent
+	if err := tx.Where("actuator_id = ? AND status = ?", actuator.ID, models.WateringRunning).Find(&events).Error; err != nil {
+		return err
+	}
+	for _, event := range events {
+		finishWateringEvent(&event, actuator, status, endedAt)
+		if err := tx.Save(&event).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func finishWateringEvent(event *models.WateringEvent, actuator models.Actuator, status string, endedAt time.Time) {
+	duration := int(endedAt.Sub(event.StartedAt).Seconds())
+	if event.PlannedSeconds > 0 && duration > event.PlannedSeconds {
+		duration = event.PlannedSeconds
+	}
+	event.Status = status
+	event.EndedAt = &endedAt
+	event.DurationSeconds = duration
+	event.VolumeLiters = actuator.FlowRateLPM * float64(duration) / 60
+}
+
+// ExpireIrrigationCommands times out commands the device never fetched or
+// acknowledged and closes watering events that overran without a completion
+// report. It returns the number of commands timed out.
+func ExpireIrrigationCommands(db *gorm.DB) (int64, error) {
+	now := time.Now()
+	var expired int64
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.IrrigationCommand{}).
+			Where("(status = ? AND expires_at <= ?) OR (status = ? AND sent_at <= ?)",
+				models.CommandPending, now, models.CommandSent, now.Add(-commandAckTimeout)).
+			Update("status", models.CommandTimedOut)
+		if result.Error != nil {
+			return result.Error
+		}
+		expired = result.RowsAffected
+
+		var events []models.WateringEvent
+		if err := tx.Where("status = ?", models.WateringRunning).Find(&events).Error; err != nil {
+			return err
+		}
+		for _, event := range events {
+			planned := time.Duration(event.PlannedSeconds) * time.Second
+			if planned == 0 {
+				planned = MaxIrrigationRunTime
+			}
+			if now.Before(event.StartedAt.Add(planned + wateringGracePeriod)) {
+				continue
+			}
+			var actuator models.Actuator
+			tx.First(&actuator, event.ActuatorID)
+			finishWateringEvent(&event, actuator, models.WateringCompleted, event.StartedAt.Add(planned))
+			if err := tx.Save(&event).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return expired, err
+}
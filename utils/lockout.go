@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"strings"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// LockoutThreshold is how many consecutive failures lock an account.
+	LockoutThreshold = 5
+	// lockoutBase is the first lock; each further failure doubles it.
+	lockoutBase = time.Minute
+	lockoutMax  = time.Hour
+)
+
+// LoginAccountKey is the rate limit key for login attempts on a username.
+func LoginAccountKey(username string) string {
+	return "login:account:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+// LockoutDuration returns how long an account is locked after failures
+// consecutive failed logins: nothing below the threshold, then one minute
+// doubling with every further failure up to an hour.
+func LockoutDuration(failures int) time.Duration {
+	if failures < LockoutThreshold {
+		return 0
+	}
+	lock := lockoutBase
+	for i := LockoutThreshold; i < failures && lock < lockoutMax; i++ {
+		lock *= 2
+	}
+	if lock > lockoutMax {
+		lock = lockoutMax
+	}
+	return lock
+}
+
+// LockedUntil returns when a user's lock ends, or the zero time if they are
+// not locked.
+func LockedUntil(db *gorm.DB, userID uint) time.Time {
+	var lockout models.AccountLockout
+	if err := db.First(&lockout, "user_id = ?", userID).Error; err != nil || lockout.LockedUntil == nil {
+		return time.Time{}
+	}
+	if time.Now().After(*lockout.LockedUntil) {
+		return time.Time{}
+	}
+	return *lockout.LockedUntil
+}
+
+// RecordLoginFailure counts a failed password for a user and locks the
+// account once the threshold is reached. It returns the end of the lock, or
+// the zero time.
+func RecordLoginFailure(db *gorm.DB, userID uint) (time.Time, error) {
+	var until time.Time
+	err := db.Transaction(func(tx *gorm.DB) error {
+		lockout := models.AccountLockout{UserID: userID}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).FirstOrCreate(&lockout, "user_id = ?", userID).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		lockout.FailedAttempts++
+		lockout.LastFailedAt = now
+		if lock := LockoutDuration(lockout.FailedAttempts); lock > 0 {
+			until = now.Add(lock)
+			lockout.LockedUntil = &until
+		}
+		return tx.Save(&lockout).Error
+	})
+	return until, err
+}
+
+// ClearLoginFailures resets a user's failure count and lifts any lock.
+func ClearLoginFailures(db *gorm.DB, userID uint) error {
+	return db.Where("user_id = ?", userID).Delete(&models.AccountLockout{}).Error
+}
+
+// AuditLoginFailure records a failed login attempt.
+func AuditLoginFailure(db *gorm.DB, userID *uint, username, ip, reason string) {
+	db.Create(&models.LoginAttempt{UserID: userID, Username: username, IP: ip, Reason: reason})
+}
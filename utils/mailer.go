@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Message is an email to send.
+type Message struct {
+	To          string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// Attachment is a file sent along with a Message.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Mailer delivers email.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+var (
+	mailer   Mailer = LogMailer{}
+	mailerMu sync.RWMutex
+)
+
+// SetMailer installs the mailer used for account email.
+func SetMailer(m Mailer) {
+	mailerMu.Lock()
+	defer mailerMu.Unlock()
+	mailer = m
+}
+
+// GetMailer returns the installed mailer.
+func GetMailer() Mailer {
+	mailerMu.RLock()
+	defer mailerMu.RUnlock()
+	return mailer
+}
+
+// SendMail sends a message with the installed mailer.
+func SendMail(msg Message) error {
+	return GetMailer().Send(msg)
+}
+
+// InitMailer sends through SMTP_HOST when it is set, authenticating with
+// SMTP_USER and SMTP_PASSWORD and sending from MAIL_FROM. Otherwise messages
+// are only written to the log, which is refused with GIN_MODE=release since
+// the log would hold live verification and reset links.
+func InitMailer() error {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		if os.Getenv("GIN_MODE") == "release" {
+			return fmt.Errorf("SMTP_HOST is required with GIN_MODE=release")
+		}
+		SetMailer(LogMailer{})
+		return nil
+	}
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		return fmt.Errorf("MAIL_FROM is required when SMTP_HOST is set")
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	SetMailer(&SMTPMailer{
+		Addr:     host + ":" + port,
+		Host:     host,
+		Username: os.Getenv("SMTP_USER"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	})
+	return nil
+}
+
+// SMTPMailer sends email through an SMTP server.
+type SMTPMailer struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	// Header values come from our own templates and user emails, so strip
+	// line breaks to keep them from adding headers
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	body := "From: " + m.From + "\r\n" +
+		"To: " + clean.Replace(msg.To) + "\r\n" +
+		"Subject: " + clean.Replace(msg.Subject) + "\r\n"
+	if len(msg.Attachments) == 0 {
+		body += "Content-Type: text/plain; charset=UTF-8\r\n\r\n" + msg.Body
+		return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, []byte(body))
+	}
+
+	var parts bytes.Buffer
+	writer := multipart.NewWriter(&parts)
+	text, _ := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=UTF-8"}})
+	text.Write([]byte(msg.Body))
+	for _, attachment := range msg.Attachments {
+		part, _ := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+		})
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		// Lines of encoded data may be at most 76 characters
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded))
+	}
+	writer.Close()
+	body += "MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=" + writer.Boundary() + "\r\n\r\n" + parts.String()
+	return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, []byte(body))
+}
+
+// LogMailer writes messages to the log instead of sending them, for
+// development without an SMTP server.
+type LogMailer struct{}
+
+func (LogMailer) Send(msg Message) error {
+	fmt.Printf("📧 Mail to %s: %s\n%s\n", msg.To, msg.Subject, msg.Body)
+	for _, attachment := range msg.Attachments {
+		fmt.Printf("📎 %s (%s, %d bytes)\n", attachment.Filename, attachment.ContentType, len(attachment.Data))
+	}
+	return nil
+}
+
+// CaptureMailer keeps sent messages in memory so tests can inspect them.
+type CaptureMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *CaptureMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far.
+func (m *CaptureMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
@@ -0,0 +1,360 @@
+package utils
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"fyp/models"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// oidcCacheTTL is how long discovery documents and signing keys are reused.
+const oidcCacheTTL = time.Hour
+
+// OIDCHTTPClient makes every request to identity providers. Tests can point
+// it at a local mock issuer.
+var OIDCHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// OIDCDiscovery is the part of an issuer's discovery document we use.
+type OIDCDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// IDTokenClaims are the verified claims of an ID token.
+type IDTokenClaims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+	Groups            []string
+}
+
+type oidcIssuerCache struct {
+	discovery OIDCDiscovery
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+var (
+	oidcCache   = make(map[string]*oidcIssuerCache)
+	oidcCacheMu sync.Mutex
+)
+
+// ValidateIssuerURL requires HTTPS issuers. Plain HTTP is allowed only with
+// OIDC_ALLOW_INSECURE_ISSUERS=true, for a local mock issuer.
+func ValidateIssuerURL(issuer string) error {
+	parsed, err := url.Parse(issuer)
+	if err != nil || parsed.Host == "" {
+		return errors.New("issuer_url must be an absolute URL")
+	}
+	if parsed.Scheme == "https" || (parsed.Scheme == "http" && os.Getenv("OIDC_ALLOW_INSECURE_ISSUERS") == "true") {
+		return nil
+	}
+	return errors.New("issuer_url must use https")
+}
+
+func oidcGetJSON(target string, out interface{}) error {
+	resp, err := OIDCHTTPClient.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", target, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// issuerCache returns the discovery document and keys of an issuer,
+// fetching them when missing, stale or when refresh is set.
+func issuerCache(issuer string, refresh bool) (*oidcIssuerCache, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	oidcCacheMu.Lock()
+	cached, ok := oidcCache[issuer]
+	oidcCacheMu.Unlock()
+	if ok && !refresh && time.Since(cached.fetchedAt) < oidcCacheTTL {
+		return cached, nil
+	}
+
+	var discovery OIDCDiscovery
+	if err := oidcGetJSON(issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q", discovery.Issuer)
+	}
+	keys, err := fetchJWKS(discovery.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+
+	cached = &oidcIssuerCache{discovery: discovery, keys: keys, fetchedAt: time.Now()}
+	oidcCacheMu.Lock()
+	oidcCache[issuer] = cached
+	oidcCacheMu.Unlock()
+	return cached, nil
+}
+
+// DiscoverOIDC returns an issuer's discovery document.
+func DiscoverOIDC(issuer string) (OIDCDiscovery, error) {
+	cached, err := issuerCache(issuer, false)
+	if err != nil {
+		return OIDCDiscovery{}, err
+	}
+	return cached.discovery, nil
+}
+
+// fetchJWKS loads the RSA signing keys of a JSON Web Key Set.
+func fetchJWKS(jwksURI string) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := oidcGetJSON(jwksURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no RSA signing keys")
+	}
+	return keys, nil
+}
+
+// NewPKCE returns a PKCE code verifier and its S256 challenge (RFC 7636).
+func NewPKCE() (verifier, challenge string, err error) {
+	raw, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier = raw
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// OIDCScopes returns a provider's scopes, always including openid.
+func OIDCScopes(provider models.OIDCProvider) string {
+	scopes := strings.Fields(provider.Scopes)
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	for _, scope := range scopes {
+		if scope == "openid" {
+			return strings.Join(scopes, " ")
+		}
+	}
+	return strings.Join(append([]string{"openid"}, scopes...), " ")
+}
+
+// OIDCAuthorizationURL builds the URL that sends the user to the provider.
+func OIDCAuthorizationURL(provider models.OIDCProvider, redirectURI, state, nonce, challenge string) (string, error) {
+	discovery, err := DiscoverOIDC(provider.IssuerURL)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.ClientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", OIDCScopes(provider))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", challenge)
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// ExchangeOIDCCode redeems an authorization code with its PKCE verifier and
+// returns the raw ID token.
+func ExchangeOIDCCode(provider models.OIDCProvider, code, redirectURI, verifier string) (string, error) {
+	discovery, err := DiscoverOIDC(provider.IssuerURL)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", provider.ClientID)
+	form.Set("code_verifier", verifier)
+	if provider.ClientSecret != "" {
+		form.Set("client_secret", provider.ClientSecret)
+	}
+
+	resp, err := OIDCHTTPClient.PostForm(discovery.TokenEndpoint, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return "", fmt.Errorf("token endpoint refused the code: %s %s", body.Error, body.ErrorDescription)
+	}
+	return body.IDToken, nil
+}
+
+// VerifyIDToken checks an ID token's RS256 signature against the issuer's
+// keys, its issuer, audience, expiry and nonce, and returns its claims.
+func VerifyIDToken(provider models.OIDCProvider, rawToken, nonce string) (IDTokenClaims, error) {
+	var result IDTokenClaims
+	cached, err := issuerCache(provider.IssuerURL, false)
+	if err != nil {
+		return result, err
+	}
+
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := cached.keys[kid]; ok {
+			return key, nil
+		}
+		// The provider may have rotated its keys since they were cached
+		if fresh, err := issuerCache(provider.IssuerURL, true); err == nil {
+			cached = fresh
+			if key, ok := fresh.keys[kid]; ok {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(rawToken, claims, keyFunc); err != nil {
+		return result, ErrInvalidIDToken
+	}
+	if _, ok := claims["exp"]; !ok {
+		return result, ErrInvalidIDToken
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(cached.discovery.Issuer, "/") {
+		return result, ErrInvalidIDToken
+	}
+	audiences := claimStrings(claims["aud"])
+	if !containsString(audiences, provider.ClientID) {
+		return result, ErrInvalidIDToken
+	}
+	if azp, ok := claims["azp"].(string); (len(audiences) > 1 || ok) && azp != provider.ClientID {
+		return result, ErrInvalidIDToken
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return result, ErrInvalidIDToken
+	}
+
+	result.Subject, _ = claims["sub"].(string)
+	if result.Subject == "" {
+		return result, ErrInvalidIDToken
+	}
+	result.Email, _ = claims["email"].(string)
+	result.EmailVerified = claimBool(claims["email_verified"])
+	result.PreferredUsername, _ = claims["preferred_username"].(string)
+	result.Name, _ = claims["name"].(string)
+	groupsClaim := provider.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	result.Groups = claimStrings(claims[groupsClaim])
+	return result, nil
+}
+
+// claimStrings reads a claim that may be one string or a list of them.
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// claimBool reads a boolean claim, which some providers send as a string.
+func claimBool(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+// MapGroupsToRole returns the highest organisation role the groups map to,
+// or the provider's default role when none do.
+func MapGroupsToRole(provider models.OIDCProvider, groups []string) string {
+	best := ""
+	for _, mapping := range provider.GroupMappings {
+		if containsString(groups, mapping.Group) && orgRoleRank[mapping.Role] > orgRoleRank[best] {
+			best = mapping.Role
+		}
+	}
+	if best == "" {
+		return provider.DefaultRole
+	}
+	return best
+}
@@ -0,0 +1,276 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"fyp/models"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// mockIssuer is an OpenID provider serving discovery, JWKS and token
+// endpoints. Tests authorize a code with the PKCE challenge from the
+// authorization URL, as the provider's login page would, and redeeming it
+// returns the ID token the test queued for it.
+type mockIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	idToken   string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &mockIssuer{key: key, kid: "key-1", codes: make(map[string]mockGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OIDCDiscovery{
+			Issuer:                issuer.server.URL,
+			AuthorizationEndpoint: issuer.server.URL + "/authorize",
+			TokenEndpoint:         issuer.server.URL + "/token",
+			JWKSURI:               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": issuer.kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		issuer.mu.Lock()
+		grant, ok := issuer.codes[r.PostForm.Get("code")]
+		delete(issuer.codes, r.PostForm.Get("code"))
+		issuer.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": grant.idToken, "token_type": "Bearer"})
+	})
+	issuer.server = httptest.NewTLSServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	client := OIDCHTTPClient
+	OIDCHTTPClient = issuer.server.Client()
+	t.Cleanup(func() { OIDCHTTPClient = client })
+	return issuer
+}
+
+// Authorize lets the token endpoint redeem code for idToken, once, with the
+// verifier of the challenge in authURL.
+func (m *mockIssuer) Authorize(t *testing.T, authURL, code, idToken string) {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization URL does not use S256 PKCE: %s", authURL)
+	}
+	m.mu.Lock()
+	m.codes[code] = mockGrant{challenge: parsed.Query().Get("code_challenge"), idToken: idToken}
+	m.mu.Unlock()
+}
+
+// Sign returns an ID token with the issuer's key and standard claims for the
+// provider, with overrides applied; a nil override removes the claim.
+func (m *mockIssuer) Sign(t *testing.T, provider models.OIDCProvider, nonce string, overrides map[string]interface{}) string {
+	t.Helper()
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            m.server.URL,
+		"aud":            provider.ClientID,
+		"sub":            "subject-1",
+		"email":          "grower@example.com",
+		"email_verified": true,
+		"nonce":          nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+	}
+	for name, value := range overrides {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.kid
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (m *mockIssuer) Provider() models.OIDCProvider {
+	return models.OIDCProvider{ID: 1, Slug: "mock", IssuerURL: m.server.URL, ClientID: "fyp-client", Enabled: true}
+}
+
+func TestOIDCCodeExchangeRequiresPKCEVerifier(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := issuer.Provider()
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := OIDCAuthorizationURL(provider, "https://app.test/callback", "state", "nonce", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer.Authorize(t, authURL, "code-1", "id-token")
+	if _, err := ExchangeOIDCCode(provider, "code-1", "https://app.test/callback", "wrong-verifier"); err == nil {
+		t.Fatal("code redeemed with the wrong PKCE verifier")
+	}
+
+	issuer.Authorize(t, authURL, "code-2", "id-token")
+	raw, err := ExchangeOIDCCode(provider, "code-2", "https://app.test/callback", verifier)
+	if err != nil || raw != "id-token" {
+		t.Fatalf("ExchangeOIDCCode = %q, %v; want the ID token", raw, err)
+	}
+	if _, err := ExchangeOIDCCode(provider, "code-2", "https://app.test/callback", verifier); err == nil {
+		t.Fatal("code redeemed twice")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := issuer.Provider()
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token func() string
+		ok    bool
+	}{
+		{"valid", func() string { return issuer.Sign(t, provider, "nonce-1", nil) }, true},
+		{"nonce of another login", func() string { return issuer.Sign(t, provider, "nonce-2", nil) }, false},
+		{"missing nonce", func() string { return issuer.Sign(t, provider, "", map[string]interface{}{"nonce": nil}) }, false},
+		{"other audience", func() string {
+			return issuer.Sign(t, provider, "nonce-1", map[string]interface{}{"aud": "someone-else"})
+		}, false},
+		{"extra audience without azp", func() string {
+			return issuer.Sign(t, provider, "nonce-1", map[string]interface{}{"aud": []string{provider.ClientID, "someone-else"}})
+		}, false},
+		{"other issuer", func() string {
+			return issuer.Sign(t, provider, "nonce-1", map[string]interface{}{"iss": "https://evil.test"})
+		}, false},
+		{"expired", func() string {
+			return issuer.Sign(t, provider, "nonce-1", map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()})
+		}, false},
+		{"missing exp", func() string { return issuer.Sign(t, provider, "nonce-1", map[string]interface{}{"exp": nil}) }, false},
+		{"missing subject", func() string { return issuer.Sign(t, provider, "nonce-1", map[string]interface{}{"sub": nil}) }, false},
+		{"unknown kid", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+				"iss": issuer.server.URL, "aud": provider.ClientID, "sub": "subject-1", "nonce": "nonce-1",
+				"exp": time.Now().Add(time.Minute).Unix(),
+			})
+			token.Header["kid"] = "key-2"
+			signed, _ := token.SignedString(otherKey)
+			return signed
+		}, false},
+		{"signed by another key", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+				"iss": issuer.server.URL, "aud": provider.ClientID, "sub": "subject-1", "nonce": "nonce-1",
+				"exp": time.Now().Add(time.Minute).Unix(),
+			})
+			token.Header["kid"] = issuer.kid
+			signed, _ := token.SignedString(otherKey)
+			return signed
+		}, false},
+		{"HS256", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+				"iss": issuer.server.URL, "aud": provider.ClientID, "sub": "subject-1", "nonce": "nonce-1",
+				"exp": time.Now().Add(time.Minute).Unix(),
+			})
+			token.Header["kid"] = issuer.kid
+			signed, _ := token.SignedString([]byte("secret"))
+			return signed
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := VerifyIDToken(provider, tt.token(), "nonce-1")
+			if tt.ok && (err != nil || claims.Subject != "subject-1" || !claims.EmailVerified) {
+				t.Fatalf("VerifyIDToken = %+v, %v; want the token's claims", claims, err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("VerifyIDToken accepted the token")
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenReadsGroupsClaim(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := issuer.Provider()
+	provider.GroupsClaim = "roles"
+	raw := issuer.Sign(t, provider, "nonce-1", map[string]interface{}{"roles": []string{"agronomists", "staff"}, "groups": "ignored"})
+	claims, err := VerifyIDToken(provider, raw, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claims.Groups) != 2 || claims.Groups[0] != "agronomists" || claims.Groups[1] != "staff" {
+		t.Fatalf("Groups = %v, want the roles claim", claims.Groups)
+	}
+}
+
+func TestMapGroupsToRole(t *testing.T) {
+	provider := models.OIDCProvider{GroupMappings: []models.OIDCGroupMapping{
+		{Group: "staff", Role: models.OrgRoleViewer},
+		{Group: "agronomists", Role: models.OrgRoleManager},
+		{Group: "admins", Role: models.OrgRoleOwner},
+	}}
+	tests := []struct {
+		groups      []string
+		defaultRole string
+		want        string
+	}{
+		{[]string{"staff"}, "", models.OrgRoleViewer},
+		{[]string{"staff", "agronomists"}, "", models.OrgRoleManager},
+		{[]string{"agronomists", "admins", "staff"}, "", models.OrgRoleOwner},
+		{[]string{"contractors"}, "", ""},
+		{nil, models.OrgRoleViewer, models.OrgRoleViewer},
+		{[]string{"agronomists"}, models.OrgRoleViewer, models.OrgRoleManager},
+	}
+	for _, tt := range tests {
+		provider.DefaultRole = tt.defaultRole
+		if got := MapGroupsToRole(provider, tt.groups); got != tt.want {
+			t.Errorf("MapGroupsToRole(%v) with default %q = %q, want %q", tt.groups, tt.defaultRole, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of access to another user's data
+const (
+	AccessRead  = "read"
+	AccessWrite = "write" // Edit and delete
+)
+
+// orgRoleRank orders organisation roles; unknown roles rank lowest.
+var orgRoleRank = map[string]int{
+	models.OrgRoleViewer:  1,
+	models.OrgRoleManager: 2,
+	models.OrgRoleOwner:   3,
+}
+
+// ValidOrgRole reports whether role is an organisation role.
+func ValidOrgRole(role string) bool {
+	return orgRoleRank[role] > 0
+}
+
+// OrgRoleAtLeast reports whether role grants at least the privileges of min.
+func OrgRoleAtLeast(role, min string) bool {
+	return orgRoleRank[role] >= orgRoleRank[min]
+}
+
+// OrgRole returns a user's role in an organisation, or "" if they are not a
+// member. It is the role in effect, see EffectiveOrgRole.
+func OrgRole(db *gorm.DB, orgID, userID uint) string {
+	var membership orgMembership
+	result := membershipQuery(db, userID).Where("org_members.organisation_id = ?", orgID).Limit(1).Scan(&membership)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return ""
+	}
+	return EffectiveOrgRole(db, membership.Role, membership.RequireTwoFactor, userID)
+}
+
+// orgMembership is a membership with its organisation's 2FA policy.
+type orgMembership struct {
+	OrganisationID   uint
+	Role             string
+	RequireTwoFactor bool
+}
+
+func membershipQuery(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&models.OrgMember{}).
+		Select("org_members.organisation_id, org_members.role, organisations.require_two_factor").
+		Joins("JOIN organisations ON organisations.id = org_members.organisation_id").
+		Where("org_members.user_id = ?", userID)
+}
+
+// EffectiveOrgRole returns the role a member may act with: owners and
+// managers of an organisation that requires two-factor authentication act as
+// viewers until they enable it.
+func EffectiveOrgRole(db *gorm.DB, role string, requireTwoFactor bool, userID uint) string {
+	if requireTwoFactor && OrgRoleAtLeast(role, models.OrgRoleManager) && !TwoFactorEnabled(db, userID) {
+		return models.OrgRoleViewer
+	}
+	return role
+}
+
+// OrgsRequiringTwoFactor returns the organisations in which a user holds a
+// role that requires two-factor authentication.
+func OrgsRequiringTwoFactor(db *gorm.DB, userID uint) []uint {
+	orgIDs := []uint{}
+	var memberships []orgMembership
+	membershipQuery(db, userID).Where("organisations.require_two_factor = ?", true).Scan(&memberships)
+	for _, membership := range memberships {
+		if OrgRoleAtLeast(membership.Role, models.OrgRoleManager) {
+			orgIDs = append(orgIDs, membership.OrganisationID)
+		}
+	}
+	return orgIDs
+}
+
+// OrgDataOwners returns the users whose data an organisation shares: its owners.
+func OrgDataOwners(db *gorm.DB, orgID uint) ([]uint, error) {
+	var userIDs []uint
+	err := db.Model(&models.OrgMember{}).Where("organisation_id = ? AND role = ?", orgID, models.OrgRoleOwner).
+		Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
+
+// SharedRole returns the highest role actorID holds in any organisation that
+// ownerID owns, or "" if they share none.
+func SharedRole(db *gorm.DB, actorID, ownerID uint) string {
+	var memberships []orgMembership
+	membershipQuery(db, actorID).
+		Where("org_members.organisation_id IN (?)",
+			db.Model(&models.OrgMember{}).Select("organisation_id").Where("user_id = ? AND role = ?", ownerID, models.OrgRoleOwner)).
+		Scan(&memberships)
+
+	best := ""
+	for _, membership := range memberships {
+		role := EffectiveOrgRole(db, membership.Role, membership.RequireTwoFactor, actorID)
+		if orgRoleRank[role] > orgRoleRank[best] {
+			best = role
+		}
+	}
+	return best
+}
+
+// CanAccessUserData reports whether actor may read or write the sensor data
+// of ownerID: admins and the owner always can, organisation members can read
+// and managers and owners of the organisation can also write.
+func CanAccessUserData(db *gorm.DB, actor models.User, ownerID uint, access string) bool {
+	if CanManageAny(actor, models.ResourceSensorData) || actor.ID == ownerID {
+		return true
+	}
+	role := SharedRole(db, actor.ID, ownerID)
+	if access == AccessWrite {
+		return OrgRoleAtLeast(role, models.OrgRoleManager)
+	}
+	return role != ""
+}
+
+// NewInvitationToken returns a random invitation token and its stored hash.
+func NewInvitationToken() (token string, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(buf)
+	return token, HashToken(token), nil
+}
+
+// SendInvitationEmail mails an invitation's token to the invited address as
+// a link to accept it. The token is not kept, so this is its only copy.
+func SendInvitationEmail(org models.Organisation, inviter models.User, invitation models.OrgInvitation, token string) error {
+	return SendMail(Message{
+		To:      invitation.Email,
+		Subject: "You are invited to join " + org.Name,
+		Body: "Hi,\n\n" + inviter.Username + " invited you to join " + org.Name + " as a " + invitation.Role +
+			". Sign in or sign up with this address, then accept by opening this link before " +
+			invitation.ExpiresAt.Format("2 January 2006") + ":\n" +
+			AppLink("/accept-invitation", token) + "\n\nIf you do not know " + org.Name + ", you can ignore this email.\n",
+	})
+}
+
+// HashToken hashes a secret token for storage.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
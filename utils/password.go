@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+const (
+	MinPasswordLength = 10
+	// MaxPasswordLength is bcrypt's limit; longer passwords would be truncated.
+	MaxPasswordLength = 72
+)
+
+// commonPasswords are refused whatever their length.
+var commonPasswords = map[string]bool{
+	"password123": true, "password1234": true, "qwerty12345": true, "1234567890": true,
+	"iloveyou123": true, "letmein1234": true, "welcome123": true, "admin12345": true,
+	"abc1234567": true, "password12": true, "qwertyuiop": true, "1q2w3e4r5t": true,
+}
+
+// ValidatePassword checks that a password is long enough, mixes letters with
+// digits or symbols, is not a well-known password and does not contain the
+// username or the local part of the email.
+func ValidatePassword(password, username, email string) error {
+	if len(password) < MinPasswordLength {
+		return errors.New("password must be at least 10 characters")
+	}
+	if len(password) > MaxPasswordLength {
+		return errors.New("password must be at most 72 bytes")
+	}
+
+	var letters, others bool
+	for _, r := range password {
+		if unicode.IsLetter(r) {
+			letters = true
+		} else if !unicode.IsSpace(r) {
+			others = true
+		}
+	}
+	if !letters || !others {
+		return errors.New("password must contain letters and digits or symbols")
+	}
+
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return errors.New("password is too common")
+	}
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	for _, personal := range []string{strings.ToLower(username), local} {
+		if len(personal) >= 3 && strings.Contains(lower, personal) {
+			return errors.New("password must not contain your username or email")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package utils
+
+import "fyp/models"
+
+// grant lists the actions a role may take on a resource.
+func grant(resource string, actions ...string) []models.Permission {
+	permissions := make([]models.Permission, 0, len(actions))
+	for _, action := range actions {
+		permissions = append(permissions, models.Permission{Resource: resource, Action: action})
+	}
+	return permissions
+}
+
+// concat joins permission lists.
+func concat(lists ...[]models.Permission) []models.Permission {
+	var all []models.Permission
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+	return all
+}
+
+// RolePermissions is what each platform role may do. Handlers still check
+// ownership of the individual records; ActionManageAny lifts that limit.
+var RolePermissions = map[string][]models.Permission{
+	models.RoleAdmin: {
+		{Resource: models.PermissionWildcard, Action: models.PermissionWildcard},
+	},
+	models.RoleUser: concat(
+		grant(models.ResourceSensorData, models.ActionRead, models.ActionCreate, models.ActionUpdate, models.ActionDelete),
+		grant(models.ResourceProfile, models.ActionRead, models.ActionUpdate),
+		grant(models.ResourceSessions, models.ActionDelete),
+		grant(models.ResourceDeviceConfig, models.ActionRead),
+		grant(models.ResourceAIConfig, models.ActionRead, models.ActionUpdate),
+		grant(models.ResourceAIModels, models.ActionRead, models.ActionCreate),
+		grant(models.ResourceCrops, models.ActionRead),
+		grant(models.ResourcePlantings, models.ActionRead, models.ActionCreate),
+		grant(models.ResourceIrrigation, models.ActionRead, models.ActionCreate, models.ActionUpdate, models.ActionDelete),
+		grant(models.ResourceWeather, models.ActionRead),
+		grant(models.ResourceWaterBalance, models.ActionRead, models.ActionUpdate),
+		grant(models.ResourceLocation, models.ActionRead, models.ActionCreate),
+		grant(models.ResourceFarms, models.ActionRead, models.ActionCreate, models.ActionUpdate, models.ActionDelete),
+		grant(models.ResourceDevices, models.ActionRead, models.ActionUpdate),
+		grant(models.ResourceDeviceKeys, models.ActionRead, models.ActionCreate, models.ActionUpdate, models.ActionDelete),
+		grant(models.ResourceDeviceCommand, models.ActionRead, models.ActionUpdate),
+		grant(models.ResourceOrganisations, models.ActionRead, models.ActionCreate, models.ActionUpdate, models.ActionDelete),
+		grant(models.ResourceNotifications, models.ActionRead),
+		grant(models.ResourceReports, models.ActionRead, models.ActionCreate, models.ActionUpdate, models.ActionDelete),
+	),
+	// Devices may only report readings and location and fetch their config
+	// and commands.
+	models.RoleDevice: concat(
+		grant(models.ResourceSensorData, models.ActionCreate),
+		grant(models.ResourceLocation, models.ActionCreate),
+		grant(models.ResourceDeviceConfig, models.ActionRead),
+		grant(models.ResourceDeviceCommand, models.ActionRead, models.ActionUpdate),
+	),
+}
+
+// HasPermission reports whether a platform role grants a permission. Unknown
+// roles grant nothing.
+func HasPermission(role string, required models.Permission) bool {
+	for _, p := range RolePermissions[role] {
+		if (p.Resource == models.PermissionWildcard || p.Resource == required.Resource) &&
+			(p.Action == models.PermissionWildcard || p.Action == required.Action) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanManageAny reports whether a user may act on every user's resources of a
+// kind rather than only their own.
+func CanManageAny(user models.User, resource string) bool {
+	return HasPermission(user.Role, models.Permission{Resource: resource, Action: models.ActionManageAny})
+}
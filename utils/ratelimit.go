@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket: Burst requests at once, refilled at Rate per
+// second.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute returns a limit of n requests a minute with a burst of burst.
+func PerMinute(n float64, burst int) RateLimit {
+	return RateLimit{Rate: n / 60, Burst: burst}
+}
+
+// LimiterStore keeps token buckets. Take spends a token from the bucket for
+// key and reports whether one was available, and if not how long until one
+// will be. Stores shared between instances let them enforce one limit.
+type LimiterStore interface {
+	Take(key string, limit RateLimit, now time.Time) (bool, time.Duration)
+	Reset(key string)
+}
+
+var (
+	limiterStore   LimiterStore = NewMemoryLimiterStore()
+	limiterStoreMu sync.RWMutex
+)
+
+// SetLimiterStore installs the store rate limits are kept in.
+func SetLimiterStore(store LimiterStore) {
+	limiterStoreMu.Lock()
+	defer limiterStoreMu.Unlock()
+	limiterStore = store
+}
+
+// GetLimiterStore returns the installed limiter store.
+func GetLimiterStore() LimiterStore {
+	limiterStoreMu.RLock()
+	defer limiterStoreMu.RUnlock()
+	return limiterStore
+}
+
+// Allow spends a token for key from the installed store.
+func Allow(key string, limit RateLimit) (bool, time.Duration) {
+	return GetLimiterStore().Take(key, limit, time.Now())
+}
+
+// TrustedProxies returns the reverse proxies in TRUSTED_PROXIES, a comma
+// separated list of IPs and CIDRs. Only requests arriving from them may name
+// the client IP in X-Forwarded-For; everyone else is limited and audited
+// under the address they connect from, so a client cannot pick its own.
+func TrustedProxies() ([]string, error) {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an IP or CIDR", proxy)
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryLimiterStore keeps buckets in process memory.
+type MemoryLimiterStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func NewMemoryLimiterStore() *MemoryLimiterStore {
+	return &MemoryLimiterStore{buckets: make(map[string]*tokenBucket)}
+}
+
+func (s *MemoryLimiterStore) Take(key string, limit RateLimit, now time.Time) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = bucket
+	}
+	if elapsed := now.Sub(bucket.last).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+elapsed*limit.Rate)
+		bucket.last = now
+	}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	if limit.Rate <= 0 {
+		return false, time.Hour
+	}
+	wait := time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+func (s *MemoryLimiterStore) Reset(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.buckets, key)
+}
+
+// Sweep drops buckets untouched for longer than idle, which have refilled
+// anyway, so the map does not grow with every client ever seen.
+func (s *MemoryLimiterStore) Sweep(idle time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := time.Now().Add(-idle)
+	for key, bucket := range s.buckets {
+		if bucket.last.Before(cutoff) {
+			delete(s.buckets, key)
+		}
+	}
+}
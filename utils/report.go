@@ -0,0 +1,454 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+// Defaults of report templates
+const (
+	DefaultReportRRule      = "FREQ=WEEKLY;BYDAY=MO;BYHOUR=7;BYMINUTE=0"
+	DefaultReportPeriodDays = 7
+	maxReportPeriodDays     = 366
+	maxReportRecipients     = 20
+)
+
+// maxReportAbnormalRows caps the abnormal reading table; the report still
+// counts every abnormal reading.
+const maxReportAbnormalRows = 200
+
+var reportSections = []string{
+	models.ReportSectionSummary,
+	models.ReportSectionCharts,
+	models.ReportSectionAbnormal,
+	models.ReportSectionAccuracy,
+}
+
+// Series colours of report charts
+const (
+	chartSoilMoisture = "#2b7bb9"
+	chartHumidity     = "#35a272"
+	chartTemperature  = "#d9572b"
+)
+
+// ReportData is everything a report shows, gathered before it is rendered.
+type ReportData struct {
+	Title       string
+	Farm        string
+	Field       string // Set when the template covers one field
+	From        time.Time
+	To          time.Time
+	GeneratedAt time.Time
+	Location    *time.Location
+	Sections    map[string]bool
+
+	Fields []FieldReport
+	Total  Aggregate
+
+	Abnormal      []AbnormalEvent
+	AbnormalTotal int64 // Abnormal may be cut short at maxReportAbnormalRows
+
+	Accuracy []ModelAccuracy
+}
+
+// FieldReport summarises the readings of one field.
+type FieldReport struct {
+	Name    string
+	Devices int
+	Summary Aggregate
+	Chart   Chart
+}
+
+// AbnormalEvent is one abnormal reading in a report.
+type AbnormalEvent struct {
+	Time         time.Time
+	Field        string
+	DeviceID     string
+	Type         string
+	Temperature  float32
+	Humidity     float32
+	SoilMoisture float32
+}
+
+// ModelAccuracy is the latest training of the model a device predicts with.
+type ModelAccuracy struct {
+	Field     string
+	DeviceID  string
+	PlantName string
+	BestModel string
+	R2Score   float64
+	RMSE      float64
+	MAE       float64
+	Rows      int
+	TrainedAt time.Time
+}
+
+// ReportRecurrence parses a report template's RRULE in its time zone.
+func ReportRecurrence(template models.ReportTemplate) (Recurrence, error) {
+	start := template.StartsAt
+	if start.IsZero() {
+		start = template.CreatedAt
+	}
+	return ParseRecurrence(template.RRule, start, LoadTimezone(template.Timezone))
+}
+
+// ReportSections returns the sections a template includes.
+func ReportSections(template models.ReportTemplate) map[string]bool {
+	sections := make(map[string]bool, len(reportSections))
+	for _, section := range strings.Split(template.Sections, ",") {
+		if section = strings.TrimSpace(section); section != "" {
+			sections[section] = true
+		}
+	}
+	if len(sections) == 0 {
+		for _, section := range reportSections {
+			sections[section] = true
+		}
+	}
+	return sections
+}
+
+// ReportRecipients returns the email addresses a template's reports go to.
+func ReportRecipients(template models.ReportTemplate) []string {
+	recipients := []string{}
+	for _, recipient := range strings.Split(template.Recipients, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients
+}
+
+// ValidateReportTemplate checks a report template's settings before it is saved.
+func ValidateReportTemplate(template models.ReportTemplate) error {
+	if template.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if template.Format != models.ReportFormatHTML && template.Format != models.ReportFormatPDF {
+		return fmt.Errorf("format must be %s or %s", models.ReportFormatHTML, models.ReportFormatPDF)
+	}
+	for section := range ReportSections(template) {
+		if !containsString(reportSections, section) {
+			return fmt.Errorf("unknown section %q, use %s", section, strings.Join(reportSections, ", "))
+		}
+	}
+	if template.PeriodDays < 1 || template.PeriodDays > maxReportPeriodDays {
+		return fmt.Errorf("period_days must be between 1 and %d", maxReportPeriodDays)
+	}
+	if template.Timezone != "" {
+		if _, err := time.LoadLocation(template.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", template.Timezone)
+		}
+	}
+	if _, err := ReportRecurrence(template); err != nil {
+		return fmt.Errorf("invalid rrule: %v", err)
+	}
+	recipients := ReportRecipients(template)
+	if len(recipients) > maxReportRecipients {
+		return fmt.Errorf("at most %d recipients are allowed", maxReportRecipients)
+	}
+	for _, recipient := range recipients {
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			return fmt.Errorf("invalid recipient %q", recipient)
+		}
+	}
+	return nil
+}
+
+// reportField is a field covered by a report and the devices in it the
+// report's owner can see.
+type reportField struct {
+	name string
+	keys []DeviceKey
+}
+
+// BuildReport gathers a template's report on the readings from from to to as
+// user sees them. It returns ErrNoNodeAccess if the user can no longer see
+// the farm or field.
+func BuildReport(db *gorm.DB, user models.User, template models.ReportTemplate, from, to time.Time) (ReportData, error) {
+	data := ReportData{
+		Title:       template.Name,
+		From:        from,
+		To:          to,
+		GeneratedAt: time.Now(),
+		Location:    LoadTimezone(template.Timezone),
+		Sections:    ReportSections(template),
+		Abnormal:    []AbnormalEvent{},
+		Accuracy:    []ModelAccuracy{},
+	}
+
+	level, nodeID := models.LevelFarm, template.FarmID
+	if template.FieldID != nil {
+		level, nodeID = models.LevelField, *template.FieldID
+	}
+	ok, err := CanViewNode(db, user, level, nodeID)
+	if err != nil {
+		return data, err
+	}
+	if !ok {
+		return data, ErrNoNodeAccess
+	}
+	farm, err := FarmOfNode(db, level, nodeID)
+	if err != nil {
+		return data, err
+	}
+	if farm.ID != template.FarmID {
+		return data, fmt.Errorf("field %d is not part of farm %d", nodeID, template.FarmID)
+	}
+	data.Farm = farm.Name
+
+	var fields []models.Field
+	query := db.Where("farm_id = ?", farm.ID)
+	if template.FieldID != nil {
+		query = query.Where("id = ?", *template.FieldID)
+	}
+	if err := query.Order("name asc").Find(&fields).Error; err != nil {
+		return data, err
+	}
+	if template.FieldID != nil && len(fields) == 1 {
+		data.Field = fields[0].Name
+	}
+
+	var covered []reportField
+	var allKeys []DeviceKey
+	for _, field := range fields {
+		keys, err := NodeDevices(db, user, models.LevelField, field.ID)
+		if errors.Is(err, ErrNoNodeAccess) {
+			// Members only see the parts of the farm they were given
+			continue
+		}
+		if err != nil {
+			return data, err
+		}
+		covered = append(covered, reportField{name: field.Name, keys: keys})
+		allKeys = append(allKeys, keys...)
+
+		fieldReport := FieldReport{Name: field.Name, Devices: len(keys)}
+		if fieldReport.Summary, err = AggregateReadings(db, keys, from, to); err != nil {
+			return data, err
+		}
+		if data.Sections[models.ReportSectionCharts] {
+			if fieldReport.Chart, err = dailyAverageChart(db, keys, from, to, data.Location); err != nil {
+				return data, err
+			}
+			fieldReport.Chart.Title = field.Name
+		}
+		data.Fields = append(data.Fields, fieldReport)
+	}
+	if data.Total, err = AggregateReadings(db, allKeys, from, to); err != nil {
+		return data, err
+	}
+
+	if data.Sections[models.ReportSectionAbnormal] {
+		if err := reportAbnormal(db, &data, covered, allKeys); err != nil {
+			return data, err
+		}
+	}
+	if data.Sections[models.ReportSectionAccuracy] {
+		if err := reportAccuracy(db, &data, covered); err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+// fieldOf returns the name of the field a device is in.
+func fieldOf(fields []reportField, key DeviceKey) string {
+	for _, field := range fields {
+		for _, candidate := range field.keys {
+			if candidate == key {
+				return field.name
+			}
+		}
+	}
+	return ""
+}
+
+// dailyAverageChart charts the daily average of every metric, days starting
+// at midnight in loc.
+func dailyAverageChart(db *gorm.DB, keys []DeviceKey, from, to time.Time, loc *time.Location) (Chart, error) {
+	var days []struct {
+		Day          time.Time
+		Temperature  float64
+		Humidity     float64
+		SoilMoisture float64
+	}
+	err := db.Model(&models.SensorData{}).Scopes(ReadingsOf(keys)).
+		Where("timestamp >= ? AND timestamp < ?", from, to).
+		Select(`date_trunc('day', timestamp AT TIME ZONE ?) AS day, AVG(temperature) AS temperature,
+			AVG(humidity) AS humidity, AVG(soil_moisture) AS soil_moisture`, loc.String()).
+		Group("day").Order("day asc").Scan(&days).Error
+	if err != nil {
+		return Chart{}, err
+	}
+
+	chart := Chart{Series: []ChartSeries{
+		{Name: "Soil moisture (%)", Color: chartSoilMoisture},
+		{Name: "Humidity (%)", Color: chartHumidity},
+		{Name: "Temperature (°C)", Color: chartTemperature},
+	}}
+	for _, day := range days {
+		// The database returns the local date as a UTC wall time
+		at := time.Date(day.Day.Year(), day.Day.Month(), day.Day.Day(), 0, 0, 0, 0, loc)
+		chart.Series[0].Points = append(chart.Series[0].Points, ChartPoint{Time: at, Value: day.SoilMoisture})
+		chart.Series[1].Points = append(chart.Series[1].Points, ChartPoint{Time: at, Value: day.Humidity})
+		chart.Series[2].Points = append(chart.Series[2].Points, ChartPoint{Time: at, Value: day.Temperature})
+	}
+	return chart, nil
+}
+
+// reportAbnormal lists the latest abnormal readings with what was abnormal
+// about them under the thresholds of the time.
+func reportAbnormal(db *gorm.DB, data *ReportData, fields []reportField, keys []DeviceKey) error {
+	query := db.Model(&models.SensorData{}).Scopes(ReadingsOf(keys)).
+		Where("is_abnormal = ? AND timestamp >= ? AND timestamp < ?", true, data.From, data.To)
+	if err := query.Count(&data.AbnormalTotal).Error; err != nil {
+		return err
+	}
+	var readings []models.SensorData
+	if err := query.Order("timestamp desc").Limit(maxReportAbnormalRows).Find(&readings).Error; err != nil {
+		return err
+	}
+
+	// Thresholds only change with the growth stage, so look them up once a day
+	thresholds := make(map[string]Thresholds)
+	for _, reading := range readings {
+		cacheKey := fmt.Sprintf("%d/%s/%s", reading.UserID, reading.DeviceID, reading.Timestamp.In(data.Location).Format("2006-01-02"))
+		limits, ok := thresholds[cacheKey]
+		if !ok {
+			limits, _ = PlantingThresholds(db, reading.UserID, reading.DeviceID, reading.Timestamp)
+			thresholds[cacheKey] = limits
+		}
+		abnormalType := GetAbnormalTypeWith(reading, limits)
+		if abnormalType == "" {
+			abnormalType = "Unknown"
+		}
+		data.Abnormal = append(data.Abnormal, AbnormalEvent{
+			Time:         reading.Timestamp,
+			Field:        fieldOf(fields, DeviceKey{UserID: reading.UserID, DeviceID: reading.DeviceID}),
+			DeviceID:     reading.DeviceID,
+			Type:         abnormalType,
+			Temperature:  reading.Temperature,
+			Humidity:     reading.Humidity,
+			SoilMoisture: reading.SoilMoisture,
+		})
+	}
+	return nil
+}
+
+// reportAccuracy lists the latest successful training of the model each
+// device with AI predictions enabled uses.
+func reportAccuracy(db *gorm.DB, data *ReportData, fields []reportField) error {
+	for _, field := range fields {
+		for _, key := range field.keys {
+			var aiConfig models.AIConfig
+			err := db.Where("user_id = ? AND device_id = ? AND enabled = ?", key.UserID, key.DeviceID, true).First(&aiConfig).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && aiConfig.PlantName == "") {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			var run models.TrainingRun
+			err = db.Where("plant_name = ? AND success = ?", aiConfig.PlantName, true).Order("created_at desc").First(&run).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			data.Accuracy = append(data.Accuracy, ModelAccuracy{
+				Field:     field.name,
+				DeviceID:  key.DeviceID,
+				PlantName: run.PlantName,
+				BestModel: run.BestModel,
+				R2Score:   run.R2Score,
+				RMSE:      run.RMSE,
+				MAE:       run.MAE,
+				Rows:      run.RowCount,
+				TrainedAt: run.CreatedAt,
+			})
+		}
+	}
+	sort.SliceStable(data.Accuracy, func(i, j int) bool { return data.Accuracy[i].Field < data.Accuracy[j].Field })
+	return nil
+}
+
+// ReportContentType returns the MIME type of a report format.
+func ReportContentType(format string) string {
+	if format == models.ReportFormatPDF {
+		return "application/pdf"
+	}
+	return "text/html; charset=utf-8"
+}
+
+// RenderReport renders a report in a format.
+func RenderReport(data ReportData, format string) ([]byte, error) {
+	switch format {
+	case models.ReportFormatHTML:
+		return RenderReportHTML(data)
+	case models.ReportFormatPDF:
+		return RenderReportPDF(data), nil
+	default:
+		return nil, fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+// ReportFilename names the download of a report.
+func ReportFilename(report models.Report) string {
+	return fmt.Sprintf("report_%d_%s.%s", report.TemplateID, report.PeriodTo.Format("2006-01-02"), report.Format)
+}
+
+// EmailReport sends a report to the template's recipients as an attachment.
+// It returns the recipients it was sent to.
+func EmailReport(template models.ReportTemplate, report models.Report, data ReportData) ([]string, error) {
+	body := fmt.Sprintf("Your report \"%s\" for %s to %s is attached.\n\n%s\n",
+		data.Title, data.From.In(data.Location).Format("2 Jan 2006"),
+		data.To.Add(-time.Second).In(data.Location).Format("2 Jan 2006"), reportSummaryText(data))
+	var sent []string
+	for _, recipient := range ReportRecipients(template) {
+		err := SendMail(Message{
+			To:      recipient,
+			Subject: "Report: " + data.Title,
+			Body:    body,
+			Attachments: []Attachment{{
+				Filename:    ReportFilename(report),
+				ContentType: ReportContentType(report.Format),
+				Data:        report.Content,
+			}},
+		})
+		if err != nil {
+			return sent, fmt.Errorf("sending to %s: %w", recipient, err)
+		}
+		sent = append(sent, recipient)
+	}
+	return sent, nil
+}
+
+// reportSummaryText is the plain text summary in report emails.
+func reportSummaryText(data ReportData) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d readings, %d abnormal\n", data.Farm, data.Total.Readings, data.Total.AbnormalCount)
+	for _, field := range data.Fields {
+		fmt.Fprintf(&b, "- %s: %d readings, %d abnormal, average soil moisture %s\n",
+			field.Name, field.Summary.Readings, field.Summary.AbnormalCount, formatOptional(field.Summary.AvgSoilMoisture, "%"))
+	}
+	return b.String()
+}
+
+// formatOptional formats an average that is nil without readings.
+func formatOptional(value *float64, unit string) string {
+	if value == nil {
+		return "–"
+	}
+	return fmt.Sprintf("%.1f%s", *value, unit)
+}
@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"strings"
+	"time"
+)
+
+// Chart is a line chart of values over time, rendered to SVG for HTML
+// reports and drawn directly into PDF reports.
+type Chart struct {
+	Title  string
+	Series []ChartSeries
+}
+
+// ChartSeries is one line of a chart.
+type ChartSeries struct {
+	Name   string
+	Color  string // #rrggbb
+	Points []ChartPoint
+}
+
+// ChartPoint is a value at a time.
+type ChartPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// Margins of the plot area within a chart, leaving room for axis labels and
+// the legend.
+const (
+	chartMarginLeft   = 40
+	chartMarginRight  = 12
+	chartMarginTop    = 24
+	chartMarginBottom = 40
+)
+
+// chartLayout maps times and values onto a chart of a given size, with the
+// origin at the top left.
+type chartLayout struct {
+	width, height float64
+	from, to      time.Time
+	min, max      float64
+	yTicks        []float64
+	xTicks        []time.Time
+}
+
+func (c Chart) empty() bool {
+	for _, series := range c.Series {
+		if len(series.Points) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func newChartLayout(chart Chart, width, height float64) chartLayout {
+	layout := chartLayout{width: width, height: height, min: math.Inf(1), max: math.Inf(-1)}
+	for _, series := range chart.Series {
+		for _, point := range series.Points {
+			if layout.from.IsZero() || point.Time.Before(layout.from) {
+				layout.from = point.Time
+			}
+			if point.Time.After(layout.to) {
+				layout.to = point.Time
+			}
+			layout.min = math.Min(layout.min, point.Value)
+			layout.max = math.Max(layout.max, point.Value)
+		}
+	}
+	if math.IsInf(layout.min, 0) {
+		layout.min, layout.max = 0, 100
+	}
+	layout.yTicks = niceTicks(math.Min(layout.min, 0), layout.max, 5)
+	layout.min, layout.max = layout.yTicks[0], layout.yTicks[len(layout.yTicks)-1]
+
+	// Label at most eight days along the time axis
+	days := int(layout.to.Sub(layout.from).Hours()/24) + 1
+	step := (days + 7) / 8
+	for day := layout.from; !day.After(layout.to); day = day.AddDate(0, 0, step) {
+		layout.xTicks = append(layout.xTicks, day)
+	}
+	return layout
+}
+
+// x returns the horizontal position of a time; a single day sits in the middle.
+func (l chartLayout) x(t time.Time) float64 {
+	left, right := float64(chartMarginLeft), l.width-chartMarginRight
+	span := l.to.Sub(l.from)
+	if span <= 0 {
+		return (left + right) / 2
+	}
+	return left + (right-left)*float64(t.Sub(l.from))/float64(span)
+}
+
+func (l chartLayout) y(value float64) float64 {
+	top, bottom := float64(chartMarginTop), l.height-chartMarginBottom
+	if l.max == l.min {
+		return bottom
+	}
+	return bottom - (bottom-top)*(value-l.min)/(l.max-l.min)
+}
+
+// niceTicks returns about count evenly spaced round values covering min to max.
+func niceTicks(min, max float64, count int) []float64 {
+	if max <= min {
+		max = min + 1
+	}
+	raw := (max - min) / float64(count)
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := magnitude
+	for _, factor := range []float64{1, 2, 5, 10} {
+		if step = factor * magnitude; step >= raw {
+			break
+		}
+	}
+	ticks := []float64{}
+	for tick := math.Floor(min/step) * step; tick < max+step/2; tick += step {
+		ticks = append(ticks, math.Round(tick/step)*step)
+	}
+	if len(ticks) < 2 {
+		ticks = append(ticks, ticks[0]+step)
+	}
+	return ticks
+}
+
+func formatTick(value float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", value), "0"), ".")
+}
+
+// RenderChartSVG renders a chart as a standalone SVG image.
+func RenderChartSVG(chart Chart, width, height float64) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" viewBox="0 0 %g %g" font-family="Helvetica, Arial, sans-serif" font-size="10">`,
+		width, height, width, height)
+	fmt.Fprintf(&b, `<rect width="%g" height="%g" fill="#ffffff"/>`, width, height)
+	fmt.Fprintf(&b, `<text x="%d" y="14" font-size="12" font-weight="bold">%s</text>`, chartMarginLeft, html.EscapeString(chart.Title))
+	if chart.empty() {
+		fmt.Fprintf(&b, `<text x="%g" y="%g" text-anchor="middle" fill="#888888">No readings</text></svg>`, width/2, height/2)
+		return b.String()
+	}
+
+	layout := newChartLayout(chart, width, height)
+	for _, tick := range layout.yTicks {
+		y := layout.y(tick)
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%g" y2="%.1f" stroke="#e0e0e0"/>`, chartMarginLeft, y, width-chartMarginRight, y)
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end">%s</text>`, chartMarginLeft-4, y+3, formatTick(tick))
+	}
+	for _, tick := range layout.xTicks {
+		fmt.Fprintf(&b, `<text x="%.1f" y="%g" text-anchor="middle">%s</text>`, layout.x(tick), height-chartMarginBottom+14, tick.Format("2 Jan"))
+	}
+	fmt.Fprintf(&b, `<line x1="%d" y1="%g" x2="%g" y2="%g" stroke="#888888"/>`,
+		chartMarginLeft, height-chartMarginBottom, width-chartMarginRight, height-chartMarginBottom)
+
+	legendX := float64(chartMarginLeft)
+	for _, series := range chart.Series {
+		points := make([]string, 0, len(series.Points))
+		for _, point := range series.Points {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", layout.x(point.Time), layout.y(point.Value)))
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`, strings.Join(points, " "), series.Color)
+		for _, point := range series.Points {
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="2.5" fill="%s"/>`, layout.x(point.Time), layout.y(point.Value), series.Color)
+		}
+
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%g" width="10" height="10" fill="%s"/>`, legendX, height-14, series.Color)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%g">%s</text>`, legendX+14, height-5, html.EscapeString(series.Name))
+		legendX += 14 + float64(len(series.Name))*5.5 + 16
+	}
+	b.WriteString(`</svg>`)
+	return b.String()
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"time"
+)
+
+// Size of the charts in HTML reports
+const (
+	htmlChartWidth  = 640
+	htmlChartHeight = 240
+)
+
+var reportHTMLTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"date": func(t time.Time, loc *time.Location) string {
+		return t.In(loc).Format("2 Jan 2006")
+	},
+	"datetime": func(t time.Time, loc *time.Location) string {
+		return t.In(loc).Format("2006-01-02 15:04")
+	},
+	"lastDay": func(t time.Time, loc *time.Location) string {
+		return t.Add(-time.Second).In(loc).Format("2 Jan 2006")
+	},
+	"optional": formatOptional,
+	"number": func(value float64) string {
+		return fmt.Sprintf("%.2f", value)
+	},
+	"reading": func(value float32) string {
+		return fmt.Sprintf("%.1f", value)
+	},
+	"chart": func(chart Chart) template.HTML {
+		// RenderChartSVG escapes the text it draws
+		return template.HTML(RenderChartSVG(chart, htmlChartWidth, htmlChartHeight))
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; color: #222; max-width: 960px; margin: 24px auto; padding: 0 16px; }
+h1 { margin-bottom: 4px; }
+.period { color: #666; margin-top: 0; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 24px; font-size: 14px; }
+th, td { border-bottom: 1px solid #ddd; padding: 6px 8px; text-align: left; }
+th { background: #f4f6f4; }
+td.number { text-align: right; }
+.chart { margin-bottom: 16px; }
+.note { color: #666; font-size: 13px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="period">{{.Farm}}{{if .Field}} · {{.Field}}{{end}} · {{date .From .Location}} to {{lastDay .To .Location}}</p>
+{{if .Sections.summary}}
+<h2>Summary</h2>
+<table>
+<tr><th>Field</th><th>Devices</th><th>Readings</th><th>Abnormal</th><th>Avg temperature</th><th>Avg humidity</th><th>Avg soil moisture</th><th>Min soil moisture</th><th>Max soil moisture</th></tr>
+{{range .Fields}}<tr><td>{{.Name}}</td><td class="number">{{.Devices}}</td><td class="number">{{.Summary.Readings}}</td><td class="number">{{.Summary.AbnormalCount}}</td><td class="number">{{optional .Summary.AvgTemperature " °C"}}</td><td class="number">{{optional .Summary.AvgHumidity "%"}}</td><td class="number">{{optional .Summary.AvgSoilMoisture "%"}}</td><td class="number">{{optional .Summary.MinSoilMoisture "%"}}</td><td class="number">{{optional .Summary.MaxSoilMoisture "%"}}</td></tr>
+{{end}}<tr><th>Total</th><th></th><th>{{.Total.Readings}}</th><th>{{.Total.AbnormalCount}}</th><th>{{optional .Total.AvgTemperature " °C"}}</th><th>{{optional .Total.AvgHumidity "%"}}</th><th>{{optional .Total.AvgSoilMoisture "%"}}</th><th>{{optional .Total.MinSoilMoisture "%"}}</th><th>{{optional .Total.MaxSoilMoisture "%"}}</th></tr>
+</table>
+{{end}}
+{{if .Sections.charts}}
+<h2>Daily averages</h2>
+{{range .Fields}}<div class="chart">{{chart .Chart}}</div>
+{{end}}
+{{end}}
+{{if .Sections.abnormal}}
+<h2>Abnormal readings</h2>
+{{if .Abnormal}}
+<table>
+<tr><th>Time</th><th>Field</th><th>Device</th><th>Abnormal</th><th>Temperature</th><th>Humidity</th><th>Soil moisture</th></tr>
+{{$loc := .Location}}{{range .Abnormal}}<tr><td>{{datetime .Time $loc}}</td><td>{{.Field}}</td><td>{{.DeviceID}}</td><td>{{.Type}}</td><td class="number">{{reading .Temperature}} °C</td><td class="number">{{reading .Humidity}}%</td><td class="number">{{reading .SoilMoisture}}%</td></tr>
+{{end}}</table>
+{{if lt (len .Abnormal) .AbnormalTotal}}<p class="note">Showing the latest {{len .Abnormal}} of {{.AbnormalTotal}} abnormal readings.</p>{{end}}
+{{else}}<p class="note">No abnormal readings.</p>{{end}}
+{{end}}
+{{if .Sections.accuracy}}
+<h2>Model accuracy</h2>
+{{if .Accuracy}}
+<table>
+<tr><th>Field</th><th>Device</th><th>Plant</th><th>Model</th><th>R²</th><th>RMSE</th><th>MAE</th><th>Training rows</th><th>Trained</th></tr>
+{{$loc := .Location}}{{range .Accuracy}}<tr><td>{{.Field}}</td><td>{{.DeviceID}}</td><td>{{.PlantName}}</td><td>{{.BestModel}}</td><td class="number">{{number .R2Score}}</td><td class="number">{{number .RMSE}}</td><td class="number">{{number .MAE}}</td><td class="number">{{.Rows}}</td><td>{{date .TrainedAt $loc}}</td></tr>
+{{end}}</table>
+{{else}}<p class="note">No device in this report predicts with a trained model.</p>{{end}}
+{{end}}
+<p class="note">Generated {{datetime .GeneratedAt .Location}}</p>
+</body>
+</html>
+`))
+
+// RenderReportHTML renders a report as a standalone HTML page with inline SVG charts.
+func RenderReportHTML(data ReportData) ([]byte, error) {
+	var b bytes.Buffer
+	if err := reportHTMLTemplate.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
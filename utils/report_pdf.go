@@ -0,0 +1,403 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strconv"
+	"time"
+
+	"fyp/models"
+)
+
+// A4 in points, the PDF unit
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+	pdfMargin     = 48
+	pdfTextWidth  = pdfPageWidth - 2*pdfMargin
+)
+
+// Average glyph width of Helvetica as a fraction of the font size, used to
+// fit and align text; digits are exactly this wide.
+const pdfGlyphWidth = 0.556
+
+// pdfColumn is a column of a table in a PDF report.
+type pdfColumn struct {
+	title  string
+	width  float64
+	number bool // Right aligned
+}
+
+// pdfWriter lays out a PDF report from the top of the first page down,
+// starting new pages as they fill up. Text uses the standard Helvetica fonts,
+// which PDF readers provide, so nothing is embedded.
+type pdfWriter struct {
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	y     float64 // Distance of the cursor from the top of the page
+}
+
+// RenderReportPDF renders a report as a PDF document with vector charts.
+func RenderReportPDF(data ReportData) []byte {
+	p := &pdfWriter{}
+	p.newPage()
+	p.text(pdfMargin, p.y+18, 18, true, "", data.Title)
+	p.y += 26
+	period := data.Farm
+	if data.Field != "" {
+		period += " · " + data.Field
+	}
+	period += " · " + data.From.In(data.Location).Format("2 Jan 2006") + " to " + data.To.Add(-time.Second).In(data.Location).Format("2 Jan 2006")
+	p.text(pdfMargin, p.y+10, 10, false, "#666666", period)
+	p.y += 24
+
+	if data.Sections[models.ReportSectionSummary] {
+		p.heading("Summary")
+		rows := [][]string{}
+		for _, field := range data.Fields {
+			rows = append(rows, pdfAggregateRow(field.Name, strconv.Itoa(field.Devices), field.Summary))
+		}
+		rows = append(rows, pdfAggregateRow("Total", "", data.Total))
+		p.table([]pdfColumn{
+			{title: "Field", width: 105},
+			{title: "Devices", width: 45, number: true},
+			{title: "Readings", width: 50, number: true},
+			{title: "Abnormal", width: 50, number: true},
+			{title: "Avg °C", width: 49, number: true},
+			{title: "Avg RH", width: 49, number: true},
+			{title: "Avg soil", width: 50, number: true},
+			{title: "Min soil", width: 50, number: true},
+			{title: "Max soil", width: 50, number: true},
+		}, rows, true)
+	}
+
+	if data.Sections[models.ReportSectionCharts] {
+		p.heading("Daily averages")
+		for _, field := range data.Fields {
+			p.need(210)
+			p.chart(field.Chart, pdfMargin, p.y, pdfTextWidth, 200)
+			p.y += 210
+		}
+	}
+
+	if data.Sections[models.ReportSectionAbnormal] {
+		p.heading("Abnormal readings")
+		if len(data.Abnormal) == 0 {
+			p.note("No abnormal readings.")
+		} else {
+			rows := [][]string{}
+			for _, event := range data.Abnormal {
+				rows = append(rows, []string{
+					event.Time.In(data.Location).Format("2006-01-02 15:04"),
+					event.Field,
+					event.DeviceID,
+					event.Type,
+					fmt.Sprintf("%.1f °C", event.Temperature),
+					fmt.Sprintf("%.1f%%", event.Humidity),
+					fmt.Sprintf("%.1f%%", event.SoilMoisture),
+				})
+			}
+			p.table([]pdfColumn{
+				{title: "Time", width: 85},
+				{title: "Field", width: 85},
+				{title: "Device", width: 85},
+				{title: "Abnormal", width: 80},
+				{title: "Temp", width: 55, number: true},
+				{title: "Humidity", width: 54, number: true},
+				{title: "Soil", width: 55, number: true},
+			}, rows, false)
+			if int64(len(data.Abnormal)) < data.AbnormalTotal {
+				p.note(fmt.Sprintf("Showing the latest %d of %d abnormal readings.", len(data.Abnormal), data.AbnormalTotal))
+			}
+		}
+	}
+
+	if data.Sections[models.ReportSectionAccuracy] {
+		p.heading("Model accuracy")
+		if len(data.Accuracy) == 0 {
+			p.note("No device in this report predicts with a trained model.")
+		} else {
+			rows := [][]string{}
+			for _, accuracy := range data.Accuracy {
+				rows = append(rows, []string{
+					accuracy.Field,
+					accuracy.DeviceID,
+					accuracy.PlantName,
+					accuracy.BestModel,
+					fmt.Sprintf("%.2f", accuracy.R2Score),
+					fmt.Sprintf("%.2f", accuracy.RMSE),
+					fmt.Sprintf("%.2f", accuracy.MAE),
+					strconv.Itoa(accuracy.Rows),
+					accuracy.TrainedAt.In(data.Location).Format("2 Jan 2006"),
+				})
+			}
+			p.table([]pdfColumn{
+				{title: "Field", width: 70},
+				{title: "Device", width: 70},
+				{title: "Plant", width: 60},
+				{title: "Model", width: 70},
+				{title: "R²", width: 40, number: true},
+				{title: "RMSE", width: 40, number: true},
+				{title: "MAE", width: 40, number: true},
+				{title: "Rows", width: 45, number: true},
+				{title: "Trained", width: 64},
+			}, rows, false)
+		}
+	}
+
+	return p.bytes("Generated " + data.GeneratedAt.In(data.Location).Format("2006-01-02 15:04"))
+}
+
+func pdfAggregateRow(name, devices string, aggregate Aggregate) []string {
+	return []string{
+		name,
+		devices,
+		strconv.FormatInt(aggregate.Readings, 10),
+		strconv.FormatInt(aggregate.AbnormalCount, 10),
+		formatOptional(aggregate.AvgTemperature, ""),
+		formatOptional(aggregate.AvgHumidity, "%"),
+		formatOptional(aggregate.AvgSoilMoisture, "%"),
+		formatOptional(aggregate.MinSoilMoisture, "%"),
+		formatOptional(aggregate.MaxSoilMoisture, "%"),
+	}
+}
+
+func (p *pdfWriter) newPage() {
+	p.page = &bytes.Buffer{}
+	p.pages = append(p.pages, p.page)
+	p.y = pdfMargin
+}
+
+// need starts a new page unless height fits below the cursor.
+func (p *pdfWriter) need(height float64) {
+	if p.y+height > pdfPageHeight-pdfMargin {
+		p.newPage()
+	}
+}
+
+func (p *pdfWriter) heading(title string) {
+	p.need(60)
+	p.y += 8
+	p.text(pdfMargin, p.y+14, 14, true, "", title)
+	p.y += 22
+}
+
+func (p *pdfWriter) note(text string) {
+	p.need(16)
+	p.text(pdfMargin, p.y+9, 9, false, "#666666", text)
+	p.y += 16
+}
+
+// text draws s with its baseline y from the top of the page, in color or
+// black when color is empty.
+func (p *pdfWriter) text(x, y, size float64, bold bool, color, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	if color != "" {
+		fmt.Fprintf(p.page, "%s rg ", pdfColor(color))
+	}
+	fmt.Fprintf(p.page, "BT /%s %g Tf %.2f %.2f Td (%s) Tj ET", font, size, x, pdfPageHeight-y, pdfEscape(s))
+	if color != "" {
+		p.page.WriteString(" 0 g")
+	}
+	p.page.WriteString("\n")
+}
+
+// fitText shortens s to fit width at a font size.
+func fitText(s string, width, size float64) string {
+	runes := []rune(s)
+	fits := int(width / (size * pdfGlyphWidth))
+	if len(runes) <= fits {
+		return s
+	}
+	if fits < 1 {
+		return ""
+	}
+	return string(runes[:fits-1]) + "…"
+}
+
+func pdfTextWidthOf(s string, size float64) float64 {
+	return float64(len([]rune(s))) * size * pdfGlyphWidth
+}
+
+func (p *pdfWriter) line(x1, y1, x2, y2, width float64, color string) {
+	fmt.Fprintf(p.page, "%s RG %g w %.2f %.2f m %.2f %.2f l S\n",
+		pdfColor(color), width, x1, pdfPageHeight-y1, x2, pdfPageHeight-y2)
+}
+
+func (p *pdfWriter) fillRect(x, y, width, height float64, color string) {
+	fmt.Fprintf(p.page, "%s rg %.2f %.2f %.2f %.2f re f 0 g\n", pdfColor(color), x, pdfPageHeight-y-height, width, height)
+}
+
+// table draws rows under a header, repeating the header on every page the
+// table continues on. A total row can be set in bold as the last row.
+func (p *pdfWriter) table(columns []pdfColumn, rows [][]string, boldLast bool) {
+	const size, rowHeight = 8, 15
+	header := func() {
+		p.fillRect(pdfMargin, p.y, pdfTextWidth, rowHeight, "#f0f2f0")
+		p.row(columns, nil, size, rowHeight, true)
+	}
+	p.need(2 * rowHeight)
+	header()
+	for i, row := range rows {
+		if p.y+rowHeight > pdfPageHeight-pdfMargin {
+			p.newPage()
+			header()
+		}
+		p.row(columns, row, size, rowHeight, boldLast && i == len(rows)-1)
+	}
+	p.y += 10
+}
+
+// row draws one table row, the column titles when cells is nil.
+func (p *pdfWriter) row(columns []pdfColumn, cells []string, size, height float64, bold bool) {
+	x := float64(pdfMargin)
+	for i, column := range columns {
+		cell := column.title
+		if cells != nil {
+			cell = cells[i]
+		}
+		cell = fitText(cell, column.width-6, size)
+		cellX := x + 3
+		if column.number {
+			cellX = x + column.width - 3 - pdfTextWidthOf(cell, size)
+		}
+		p.text(cellX, p.y+height-4, size, bold || cells == nil, "", cell)
+		x += column.width
+	}
+	p.y += height
+	p.line(pdfMargin, p.y, pdfMargin+pdfTextWidth, p.y, 0.5, "#dddddd")
+}
+
+// chart draws a chart with its top left corner at x, y; the same layout as
+// RenderChartSVG.
+func (p *pdfWriter) chart(chart Chart, x, y, width, height float64) {
+	p.text(x+chartMarginLeft, y+14, 11, true, "", chart.Title)
+	if chart.empty() {
+		message := "No readings"
+		p.text(x+(width-pdfTextWidthOf(message, 10))/2, y+height/2, 10, false, "#888888", message)
+		return
+	}
+
+	layout := newChartLayout(chart, width, height)
+	for _, tick := range layout.yTicks {
+		tickY := y + layout.y(tick)
+		p.line(x+chartMarginLeft, tickY, x+width-chartMarginRight, tickY, 0.5, "#e0e0e0")
+		label := formatTick(tick)
+		p.text(x+chartMarginLeft-4-pdfTextWidthOf(label, 8), tickY+3, 8, false, "", label)
+	}
+	for _, tick := range layout.xTicks {
+		label := tick.Format("2 Jan")
+		p.text(x+layout.x(tick)-pdfTextWidthOf(label, 8)/2, y+height-chartMarginBottom+14, 8, false, "", label)
+	}
+	p.line(x+chartMarginLeft, y+height-chartMarginBottom, x+width-chartMarginRight, y+height-chartMarginBottom, 0.75, "#888888")
+
+	legendX := x + chartMarginLeft
+	for _, series := range chart.Series {
+		for i, point := range series.Points {
+			px, py := x+layout.x(point.Time), y+layout.y(point.Value)
+			if i == 0 {
+				fmt.Fprintf(p.page, "%s RG 1.5 w %.2f %.2f m", pdfColor(series.Color), px, pdfPageHeight-py)
+			} else {
+				fmt.Fprintf(p.page, " %.2f %.2f l", px, pdfPageHeight-py)
+			}
+		}
+		if len(series.Points) > 0 {
+			p.page.WriteString(" S\n")
+		}
+		for _, point := range series.Points {
+			p.fillRect(x+layout.x(point.Time)-2, y+layout.y(point.Value)-2, 4, 4, series.Color)
+		}
+
+		p.fillRect(legendX, y+height-14, 8, 8, series.Color)
+		p.text(legendX+12, y+height-7, 8, false, "", series.Name)
+		legendX += 12 + pdfTextWidthOf(series.Name, 8) + 16
+	}
+}
+
+// bytes assembles the document, with footer and page numbers on every page.
+func (p *pdfWriter) bytes(footer string) []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// Objects 1 to 4 are the catalog, page tree and fonts; each page is then
+	// a page object followed by its content stream
+	kids := ""
+	for i := range p.pages {
+		kids += fmt.Sprintf("%d 0 R ", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids, len(p.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range p.pages {
+		p.page = page
+		p.text(pdfMargin, pdfPageHeight-pdfMargin/2, 8, false, "#888888", footer)
+		number := fmt.Sprintf("Page %d of %d", i+1, len(p.pages))
+		p.text(pdfPageWidth-pdfMargin-pdfTextWidthOf(number, 8), pdfPageHeight-pdfMargin/2, 8, false, "#888888", number)
+
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(page.Bytes())
+		zw.Close()
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %g %g] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), compressed.Bytes()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// pdfColor converts #rrggbb to PDF colour components.
+func pdfColor(hex string) string {
+	if len(hex) != 7 || hex[0] != '#' {
+		return "0 0 0"
+	}
+	value, err := strconv.ParseUint(hex[1:], 16, 32)
+	if err != nil {
+		return "0 0 0"
+	}
+	return fmt.Sprintf("%.3f %.3f %.3f", float64(value>>16&0xff)/255, float64(value>>8&0xff)/255, float64(value&0xff)/255)
+}
+
+// pdfWinAnsi maps the characters outside Latin-1 that reports use to their
+// WinAnsiEncoding codes.
+var pdfWinAnsi = map[rune]byte{'–': 0x96, '—': 0x97, '…': 0x85, '•': 0x95, '€': 0x80}
+
+// pdfEscape encodes s as the contents of a PDF string in WinAnsiEncoding,
+// replacing characters the standard fonts lack with '?'.
+func pdfEscape(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20:
+			b.WriteByte(' ')
+		case r < 0x80:
+			b.WriteRune(r)
+		case pdfWinAnsi[r] != 0:
+			fmt.Fprintf(&b, "\\%03o", pdfWinAnsi[r])
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"fmt"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CorrectReading applies a manual correction to a reading within tx: it
+// stores the change as a new revision and recomputes the abnormality flag
+// against the thresholds in force when the reading was taken.
+func CorrectReading(tx *gorm.DB, id uint, req models.CorrectReadingRequest, editedBy uint) (models.SensorData, models.ReadingRevision, error) {
+	var record models.SensorData
+	var revision models.ReadingRevision
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&record, id).Error; err != nil {
+		return record, revision, err
+	}
+
+	revision = models.ReadingRevision{
+		SensorDataID:         record.ID,
+		Revision:             record.Revision + 1,
+		PreviousTemperature:  record.Temperature,
+		PreviousHumidity:     record.Humidity,
+		PreviousSoilMoisture: record.SoilMoisture,
+		PreviousIsAbnormal:   record.IsAbnormal,
+		Reason:               req.Reason,
+		EditedBy:             editedBy,
+	}
+	if req.Temperature != nil {
+		record.Temperature = *req.Temperature
+	}
+	if req.Humidity != nil {
+		record.Humidity = *req.Humidity
+	}
+	if req.SoilMoisture != nil {
+		record.SoilMoisture = *req.SoilMoisture
+	}
+	thresholds, _ := PlantingThresholds(tx, record.UserID, record.DeviceID, record.Timestamp)
+	record.IsAbnormal = CheckAbnormalityWith(record, thresholds)
+	record.Revision = revision.Revision
+
+	revision.Temperature = record.Temperature
+	revision.Humidity = record.Humidity
+	revision.SoilMoisture = record.SoilMoisture
+	revision.IsAbnormal = record.IsAbnormal
+
+	if err := tx.Save(&record).Error; err != nil {
+		return record, revision, err
+	}
+	return record, revision, tx.Create(&revision).Error
+}
+
+// RawReadings reads sensor data with the values the devices reported,
+// undoing any manual corrections.
+func RawReadings(db *gorm.DB) *gorm.DB {
+	return db.Select(`sensor_data.id, sensor_data.user_id, sensor_data.device_id, sensor_data.timestamp,
+		COALESCE(original.previous_temperature, sensor_data.temperature) AS temperature,
+		COALESCE(original.previous_humidity, sensor_data.humidity) AS humidity,
+		COALESCE(original.previous_soil_moisture, sensor_data.soil_moisture) AS soil_moisture,
+		COALESCE(original.previous_is_abnormal, sensor_data.is_abnormal) AS is_abnormal,
+		sensor_data.extra, 0 AS revision`).
+		Joins("LEFT JOIN reading_revisions original ON original.sensor_data_id = sensor_data.id AND original.revision = 1")
+}
+
+// ReadingValues returns the scope reading sensor data with raw or corrected
+// values. Corrected values are what the table holds, so that scope does nothing.
+func ReadingValues(values string) (func(*gorm.DB) *gorm.DB, error) {
+	switch values {
+	case "", models.ReadingValuesCorrected:
+		return func(db *gorm.DB) *gorm.DB { return db }, nil
+	case models.ReadingValuesRaw:
+		return RawReadings, nil
+	}
+	return nil, fmt.Errorf("values must be %s or %s", models.ReadingValuesCorrected, models.ReadingValuesRaw)
+}
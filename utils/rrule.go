@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRecurrenceScan bounds how many days a recurrence is scanned for occurrences.
+const maxRecurrenceScan = 5 * 366
+
+var rruleWeekdays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// Recurrence is a parsed subset of an RFC 5545 RRULE: FREQ (DAILY, WEEKLY or
+// MONTHLY), INTERVAL, BYDAY, BYMONTHDAY, BYHOUR, BYMINUTE, COUNT and UNTIL.
+// Occurrences are generated as wall-clock times in Location so they keep
+// their local time across daylight-saving changes.
+type Recurrence struct {
+	Freq       string
+	Interval   int
+	ByDay      []time.Weekday
+	ByMonthDay []int
+	ByHour     []int
+	ByMinute   []int
+	Count      int
+	Until      time.Time
+	Start      time.Time
+	Location   *time.Location
+}
+
+// ParseRecurrence parses rule, e.g. "FREQ=WEEKLY;BYDAY=MO,WE,FR;BYHOUR=6;BYMINUTE=0",
+// anchored at start in loc. Missing BYHOUR/BYMINUTE default to start's clock time.
+func ParseRecurrence(rule string, start time.Time, loc *time.Location) (Recurrence, error) {
+	r := Recurrence{Interval: 1, Start: start.In(loc), Location: loc}
+
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:"), ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return r, fmt.Errorf("invalid rrule part %q", part)
+		}
+		key, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+
+		switch key {
+		case "FREQ":
+			if value != "DAILY" && value != "WEEKLY" && value != "MONTHLY" {
+				return r, fmt.Errorf("unsupported FREQ %q", value)
+			}
+			r.Freq = value
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return r, fmt.Errorf("invalid INTERVAL %q", value)
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return r, fmt.Errorf("invalid COUNT %q", value)
+			}
+			r.Count = n
+		case "UNTIL":
+			t, err := parseRRuleTime(value, loc)
+			if err != nil {
+				return r, err
+			}
+			r.Until = t
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday, ok := rruleWeekdays[day]
+				if !ok {
+					return r, fmt.Errorf("invalid BYDAY %q", day)
+				}
+				r.ByDay = append(r.ByDay, weekday)
+			}
+		case "BYMONTHDAY":
+			days, err := parseIntList(value, 1, 31)
+			if err != nil {
+				return r, fmt.Errorf("invalid BYMONTHDAY: %v", err)
+			}
+			r.ByMonthDay = days
+		case "BYHOUR":
+			hours, err := parseIntList(value, 0, 23)
+			if err != nil {
+				return r, fmt.Errorf("invalid BYHOUR: %v", err)
+			}
+			r.ByHour = hours
+		case "BYMINUTE":
+			minutes, err := parseIntList(value, 0, 59)
+			if err != nil {
+				return r, fmt.Errorf("invalid BYMINUTE: %v", err)
+			}
+			r.ByMinute = minutes
+		default:
+			return r, fmt.Errorf("unsupported rrule part %q", key)
+		}
+	}
+
+	if r.Freq == "" {
+		return r, fmt.Errorf("FREQ is required")
+	}
+	if len(r.ByHour) == 0 {
+		r.ByHour = []int{r.Start.Hour()}
+	}
+	if len(r.ByMinute) == 0 {
+		r.ByMinute = []int{r.Start.Minute()}
+	}
+	if r.Freq == "WEEKLY" && len(r.ByDay) == 0 {
+		r.ByDay = []time.Weekday{r.Start.Weekday()}
+	}
+	if r.Freq == "MONTHLY" && len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 {
+		r.ByMonthDay = []int{r.Start.Day()}
+	}
+	return r, nil
+}
+
+func parseRRuleTime(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("20060102T150405", value, loc); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("20060102", value, loc); err == nil {
+		return t.Add(24*time.Hour - time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid UNTIL %q", value)
+}
+
+func parseIntList(value string, min, max int) ([]int, error) {
+	var values []int
+	for _, item := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(item)
+		if err != nil || n < min || n > max {
+			return nil, fmt.Errorf("%q out of range %d-%d", item, min, max)
+		}
+		values = append(values, n)
+	}
+	sort.Ints(values)
+	return values, nil
+}
+
+// matchesDay reports whether the local date day is part of the recurrence.
+func (r Recurrence) matchesDay(day time.Time) bool {
+	startDay := time.Date(r.Start.Year(), r.Start.Month(), r.Start.Day(), 0, 0, 0, 0, r.Location)
+
+	switch r.Freq {
+	case "DAILY":
+		days := int(day.Sub(startDay).Hours()/24 + 0.5)
+		if days%r.Interval != 0 {
+			return false
+		}
+	case "WEEKLY":
+		// Weeks start on Monday as in the RRULE default WKST=MO
+		startWeek := startDay.AddDate(0, 0, -((int(startDay.Weekday()) + 6) % 7))
+		weeks := int(day.Sub(startWeek).Hours()/24+0.5) / 7
+		if weeks%r.Interval != 0 {
+			return false
+		}
+	case "MONTHLY":
+		months := (day.Year()-startDay.Year())*12 + int(day.Month()) - int(startDay.Month())
+		if months%r.Interval != 0 {
+			return false
+		}
+	}
+
+	if len(r.ByDay) > 0 && !containsWeekday(r.ByDay, day.Weekday()) {
+		return false
+	}
+	if len(r.ByMonthDay) > 0 && !containsInt(r.ByMonthDay, day.Day()) {
+		return false
+	}
+	return true
+}
+
+func containsWeekday(days []time.Weekday, day time.Weekday) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Between returns up to limit occurrences in (after, before], in order.
+// COUNT is honoured by counting occurrences from Start.
+func (r Recurrence) Between(after, before time.Time, limit int) []time.Time {
+	var occurrences []time.Time
+	seen := 0
+
+	day := time.Date(r.Start.Year(), r.Start.Month(), r.Start.Day(), 0, 0, 0, 0, r.Location)
+	if r.Count == 0 && after.After(r.Start) {
+		// Without COUNT there is nothing to tally, so skip straight to after
+		local := after.In(r.Location)
+		day = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, r.Location)
+	}
+	for i := 0; i < maxRecurrenceScan; i, day = i+1, day.AddDate(0, 0, 1) {
+		if !before.IsZero() && day.After(before) {
+			break
+		}
+		if !r.matchesDay(day) {
+			continue
+		}
+		for _, hour := range r.ByHour {
+			for _, minute := range r.ByMinute {
+				t := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, r.Location)
+				if t.Before(r.Start) {
+					continue
+				}
+				if !r.Until.IsZero() && t.After(r.Until) {
+					return occurrences
+				}
+				seen++
+				if r.Count > 0 && seen > r.Count {
+					return occurrences
+				}
+				if t.After(after) && (before.IsZero() || !t.After(before)) {
+					occurrences = append(occurrences, t)
+					if limit > 0 && len(occurrences) >= limit {
+						return occurrences
+					}
+				}
+			}
+		}
+	}
+	return occurrences
+}
+
+// Next returns the next count occurrences after t.
+func (r Recurrence) Next(t time.Time, count int) []time.Time {
+	return r.Between(t, time.Time{}, count)
+}
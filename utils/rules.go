@@ -0,0 +1,244 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+// ruleHistoryWindow bounds how far back readings are loaded to find how long
+// the trigger condition has held.
+const ruleHistoryWindow = 24 * time.Hour
+
+// RuleInputs is everything a rule decision is based on. It is stored with
+// every decision so the outcome can be explained later.
+type RuleInputs struct {
+	EvaluatedAt        time.Time  `json:"evaluated_at"`
+	LocalTime          string     `json:"local_time"`
+	LatestReadingAt    *time.Time `json:"latest_reading_at"`
+	LatestSoilMoisture *float32   `json:"latest_soil_moisture"`
+	ConditionSince     *time.Time `json:"condition_since"` // Start of the current unbroken run of readings below threshold
+	ForecastMinimum    *float64   `json:"forecast_minimum,omitempty"`
+	ActuatorBusy       bool       `json:"actuator_busy"`
+	LastRunAt          *time.Time `json:"last_run_at"`
+	RunsToday          int        `json:"runs_today"`
+	VolumeTodayLiters  float64    `json:"volume_today_liters"`
+	PlannedVolume      float64    `json:"planned_volume_liters"`
+	RainForecastMM     *float64   `json:"rain_forecast_mm,omitempty"`
+}
+
+// RuleLocation returns the time zone a rule's quiet hours and daily limits use.
+func RuleLocation(rule models.IrrigationRule) *time.Location {
+	return LoadTimezone(rule.Timezone)
+}
+
+// parseClock parses "HH:MM" into minutes since midnight.
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ValidateIrrigationRule checks a rule's settings before it is saved.
+func ValidateIrrigationRule(rule models.IrrigationRule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if rule.Threshold <= 0 || rule.Threshold >= 100 {
+		return fmt.Errorf("threshold must be between 0 and 100")
+	}
+	if rule.DurationSeconds <= 0 {
+		return fmt.Errorf("duration_seconds must be positive")
+	}
+	if rule.UseForecast && (rule.ForecastHours < 1 || rule.ForecastHours > MaxForecastHours) {
+		return fmt.Errorf("forecast_hours must be between 1 and %d", MaxForecastHours)
+	}
+	if (rule.QuietStart == "") != (rule.QuietEnd == "") {
+		return fmt.Errorf("quiet_start and quiet_end must be set together")
+	}
+	if rule.QuietStart != "" {
+		if _, err := parseClock(rule.QuietStart); err != nil {
+			return err
+		}
+		if _, err := parseClock(rule.QuietEnd); err != nil {
+			return err
+		}
+	}
+	if rule.Timezone != "" {
+		if _, err := time.LoadLocation(rule.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", rule.Timezone)
+		}
+	}
+	return nil
+}
+
+// inQuietHours reports whether local falls in the rule's quiet window, which
+// may wrap past midnight.
+func inQuietHours(rule models.IrrigationRule, local time.Time) bool {
+	if rule.QuietStart == "" {
+		return false
+	}
+	start, err1 := parseClock(rule.QuietStart)
+	end, err2 := parseClock(rule.QuietEnd)
+	if err1 != nil || err2 != nil || start == end {
+		return false
+	}
+	minute := local.Hour()*60 + local.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// GatherRuleInputs loads the readings, forecast and watering history a rule
+// decision needs.
+func GatherRuleInputs(db *gorm.DB, rule models.IrrigationRule, actuator models.Actuator, now time.Time) (RuleInputs, error) {
+	loc := RuleLocation(rule)
+	local := now.In(loc)
+	inputs := RuleInputs{
+		EvaluatedAt:   now,
+		LocalTime:     local.Format("2006-01-02 15:04 MST"),
+		PlannedVolume: actuator.FlowRateLPM * float64(rule.DurationSeconds) / 60,
+	}
+
+	var readings []models.SensorData
+	if err := db.Where("user_id = ? AND device_id = ? AND timestamp >= ?", rule.UserID, rule.DeviceID, now.Add(-ruleHistoryWindow)).
+		Order("timestamp desc").Find(&readings).Error; err != nil {
+		return inputs, err
+	}
+	if len(readings) > 0 {
+		latest := readings[0]
+		inputs.LatestReadingAt = &latest.Timestamp
+		inputs.LatestSoilMoisture = &latest.SoilMoisture
+
+		// Walk back while readings stay below threshold
+		for i := range readings {
+			if readings[i].SoilMoisture >= rule.Threshold {
+				break
+			}
+			inputs.ConditionSince = &readings[i].Timestamp
+		}
+	}
+
+	if rule.UseForecast && len(readings) > 0 {
+		forecast, err := ForecastSoilMoisture(context.Background(), db, rule.UserID, rule.DeviceID, "", rule.ForecastHours)
+		if err == nil && len(forecast.Predictions) > 0 {
+			minimum := forecast.Predictions[0].SoilMoisture
+			for _, p := range forecast.Predictions {
+				if p.SoilMoisture < minimum {
+					minimum = p.SoilMoisture
+				}
+			}
+			inputs.ForecastMinimum = &minimum
+		}
+	}
+
+	if rule.SkipIfRainMM > 0 && RainForecast != nil {
+		hours := rule.RainLookaheadHours
+		if hours <= 0 {
+			hours = defaultRainLookahead
+		}
+		if rain, err := RainForecast(db, rule.UserID, rule.DeviceID, hours); err == nil {
+			inputs.RainForecastMM = &rain
+		}
+	}
+
+	var running int64
+	db.Model(&models.WateringEvent{}).Where("actuator_id = ? AND status = ?", actuator.ID, models.WateringRunning).Count(&running)
+	var queued int64
+	db.Model(&models.IrrigationCommand{}).Where("actuator_id = ? AND action = ? AND status IN ?",
+		actuator.ID, models.IrrigationStart, []string{models.CommandPending, models.CommandSent}).Count(&queued)
+	inputs.ActuatorBusy = running > 0 || queued > 0
+
+	var last models.WateringEvent
+	if err := db.Where("actuator_id = ?", actuator.ID).Order("started_at desc").First(&last).Error; err == nil {
+		inputs.LastRunAt = &last.StartedAt
+	}
+
+	startOfDay := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	var today []models.WateringEvent
+	if err := db.Where("actuator_id = ? AND started_at >= ?", actuator.ID, startOfDay).Find(&today).Error; err != nil {
+		return inputs, err
+	}
+	inputs.RunsToday = len(today)
+	for _, event := range today {
+		if event.Status == models.WateringRunning {
+			inputs.VolumeTodayLiters += actuator.FlowRateLPM * float64(event.PlannedSeconds) / 60
+		} else {
+			inputs.VolumeTodayLiters += event.VolumeLiters
+		}
+	}
+	return inputs, nil
+}
+
+// EvaluateRule decides whether a rule should water now and explains why.
+// Safety interlocks are checked before the trigger so a lockout is always
+// reported even when the soil is dry.
+func EvaluateRule(rule models.IrrigationRule, inputs RuleInputs) (string, string) {
+	now := inputs.EvaluatedAt
+
+	if !rule.Enabled {
+		return models.DecisionSkip, "rule is disabled"
+	}
+	if inputs.LatestReadingAt == nil {
+		return models.DecisionSkip, "sensor stale: no readings in the last 24 hours"
+	}
+	if rule.StaleAfterMinutes > 0 {
+		age := now.Sub(*inputs.LatestReadingAt)
+		if age > time.Duration(rule.StaleAfterMinutes)*time.Minute {
+			return models.DecisionSkip, fmt.Sprintf("sensor stale: latest reading is %.0f minutes old (limit %d)", age.Minutes(), rule.StaleAfterMinutes)
+		}
+	}
+	if rule.MaxDailyVolumeLiters > 0 && inputs.VolumeTodayLiters+inputs.PlannedVolume > rule.MaxDailyVolumeLiters {
+		return models.DecisionSkip, fmt.Sprintf("daily volume limit: %.1f L used + %.1f L planned exceeds %.1f L",
+			inputs.VolumeTodayLiters, inputs.PlannedVolume, rule.MaxDailyVolumeLiters)
+	}
+
+	sustain := time.Duration(rule.SustainMinutes) * time.Minute
+	sustained := inputs.ConditionSince != nil && now.Sub(*inputs.ConditionSince) >= sustain
+	predicted := rule.UseForecast && inputs.ForecastMinimum != nil && *inputs.ForecastMinimum < float64(rule.Threshold)
+
+	var trigger string
+	switch {
+	case sustained:
+		trigger = fmt.Sprintf("soil moisture below %.1f%% since %s", rule.Threshold, inputs.ConditionSince.Format(time.RFC3339))
+	case predicted:
+		trigger = fmt.Sprintf("soil moisture forecast to reach %.1f%% within %d hours (threshold %.1f%%)",
+			*inputs.ForecastMinimum, rule.ForecastHours, rule.Threshold)
+	case inputs.ConditionSince != nil:
+		return models.DecisionSkip, fmt.Sprintf("soil moisture below %.1f%% for %.0f of %d minutes",
+			rule.Threshold, now.Sub(*inputs.ConditionSince).Minutes(), rule.SustainMinutes)
+	default:
+		return models.DecisionSkip, fmt.Sprintf("soil moisture %.1f%% is not below %.1f%%", *inputs.LatestSoilMoisture, rule.Threshold)
+	}
+
+	if inQuietHours(rule, now.In(RuleLocation(rule))) {
+		return models.DecisionSkip, fmt.Sprintf("%s, but inside quiet hours %s-%s", trigger, rule.QuietStart, rule.QuietEnd)
+	}
+	if rule.SkipIfRainMM > 0 && inputs.RainForecastMM != nil && *inputs.RainForecastMM >= rule.SkipIfRainMM {
+		return models.DecisionSkip, fmt.Sprintf("%s, but %.1f mm of rain is forecast", trigger, *inputs.RainForecastMM)
+	}
+	if inputs.ActuatorBusy {
+		return models.DecisionSkip, fmt.Sprintf("%s, but the actuator is already running", trigger)
+	}
+	if rule.CooldownMinutes > 0 && inputs.LastRunAt != nil {
+		if elapsed := now.Sub(*inputs.LastRunAt); elapsed < time.Duration(rule.CooldownMinutes)*time.Minute {
+			return models.DecisionSkip, fmt.Sprintf("%s, but in cooldown (%.0f of %d minutes since last run)",
+				trigger, elapsed.Minutes(), rule.CooldownMinutes)
+		}
+	}
+	if rule.MaxRunsPerDay > 0 && inputs.RunsToday >= rule.MaxRunsPerDay {
+		return models.DecisionSkip, fmt.Sprintf("%s, but already watered %d of %d times today", trigger, inputs.RunsToday, rule.MaxRunsPerDay)
+	}
+
+	if rule.DryRun {
+		return models.DecisionDryRun, fmt.Sprintf("%s; would water for %d seconds", trigger, rule.DurationSeconds)
+	}
+	return models.DecisionIrrigate, fmt.Sprintf("%s; watering for %d seconds", trigger, rule.DurationSeconds)
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+// defaultRainLookahead applies when a schedule skips on rain without its own lookahead.
+const defaultRainLookahead = 12
+
+// RainForecast returns the rain expected at a device over the next hours in
+// millimetres. It stays nil until a weather provider is configured, in which
+// case rain skip conditions are not applied.
+var RainForecast func(db *gorm.DB, userID uint, deviceID string, hours int) (float64, error)
+
+// ScheduleRecurrence parses a schedule's RRULE in its time zone.
+func ScheduleRecurrence(schedule models.IrrigationSchedule) (Recurrence, error) {
+	start := schedule.StartsAt
+	if start.IsZero() {
+		start = schedule.CreatedAt
+	}
+	return ParseRecurrence(schedule.RRule, start, LoadTimezone(schedule.Timezone))
+}
+
+// ValidateIrrigationSchedule checks a schedule's settings before it is saved.
+func ValidateIrrigationSchedule(schedule models.IrrigationSchedule) error {
+	if schedule.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if schedule.DurationSeconds <= 0 {
+		return fmt.Errorf("duration_seconds must be positive")
+	}
+	if schedule.Timezone != "" {
+		if _, err := time.LoadLocation(schedule.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", schedule.Timezone)
+		}
+	}
+	if _, err := ScheduleRecurrence(schedule); err != nil {
+		return fmt.Errorf("invalid rrule: %v", err)
+	}
+	return nil
+}
+
+// ScheduleSkipReason checks a schedule's skip conditions and returns why the
+// occurrence should be skipped, or "" to water. Notes about conditions that
+// could not be checked are returned separately.
+func ScheduleSkipReason(db *gorm.DB, schedule models.IrrigationSchedule) (string, string) {
+	note := ""
+
+	if schedule.SkipIfMoistureAbove > 0 {
+		var latest models.SensorData
+		if err := db.Where("user_id = ? AND device_id = ?", schedule.UserID, schedule.DeviceID).
+			Order("timestamp desc").First(&latest).Error; err == nil {
+			if latest.SoilMoisture > schedule.SkipIfMoistureAbove {
+				return fmt.Sprintf("already wet: soil moisture %.1f%% is above %.1f%%", latest.SoilMoisture, schedule.SkipIfMoistureAbove), ""
+			}
+		} else {
+			note = "no soil moisture reading to check"
+		}
+	}
+
+	if schedule.SkipIfRainMM > 0 {
+		hours := schedule.RainLookaheadHours
+		if hours <= 0 {
+			hours = defaultRainLookahead
+		}
+		if RainForecast == nil {
+			note = joinNote(note, "no weather provider to check rain")
+		} else if rain, err := RainForecast(db, schedule.UserID, schedule.DeviceID, hours); err != nil {
+			note = joinNote(note, fmt.Sprintf("rain forecast unavailable: %v", err))
+		} else if rain >= schedule.SkipIfRainMM {
+			return fmt.Sprintf("rain forecast: %.1f mm expected in the next %d hours", rain, hours), ""
+		}
+	}
+	return "", note
+}
+
+func joinNote(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "; " + b
+}
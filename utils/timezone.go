@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"os"
+	"time"
+)
+
+// defaultTimezone is used when TIMEZONE is unset or invalid.
+const defaultTimezone = "Asia/Kuala_Lumpur"
+
+// LocalTimezone returns the server's configured time zone from the TIMEZONE
+// environment variable. Readings are timestamped in it and it is the default
+// for rules and schedules without their own time zone.
+func LocalTimezone() *time.Location {
+	name := os.Getenv("TIMEZONE")
+	if name == "" {
+		name = defaultTimezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc, err = time.LoadLocation(defaultTimezone)
+		if err != nil {
+			return time.UTC
+		}
+	}
+	return loc
+}
+
+// LoadTimezone returns the named time zone, or LocalTimezone when name is empty
+// or unknown.
+func LoadTimezone(name string) *time.Location {
+	if name == "" {
+		return LocalTimezone()
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return LocalTimezone()
+	}
+	return loc
+}
@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"fyp/config"
+	"fyp/models"
+
+	"github.com/dgrijalva/jwt-go"
+	"gorm.io/gorm"
+)
+
+// Token types, so one kind of signed token cannot be used in place of another
+const (
+	TokenTypeAccess             = "access"
+	TokenTypeTwoFactorChallenge = "2fa_challenge" // Password checked, second factor pending
+)
+
+// twoFactorChallengeTTL is how long a user has to enter their second factor.
+const twoFactorChallengeTTL = 5 * time.Minute
+
+var (
+	ErrInvalidToken       = errors.New("invalid or expired token")
+	ErrTokenRevoked       = errors.New("token has been revoked")
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
+// AccessClaims are the claims of an access token.
+type AccessClaims struct {
+	UserID uint   `json:"user_id"`
+	Type   string `json:"typ"`
+	jwt.StandardClaims
+}
+
+// IssueAccessToken signs a short-lived access token for a user with the
+// active signing key.
+func IssueAccessToken(userID uint) (string, AccessClaims, error) {
+	return issueToken(userID, TokenTypeAccess, config.GetAuthSettings().AccessTokenTTL)
+}
+
+// IssueTwoFactorChallenge signs the token that carries a login from the
+// password step to the second factor.
+func IssueTwoFactorChallenge(userID uint) (string, AccessClaims, error) {
+	return issueToken(userID, TokenTypeTwoFactorChallenge, twoFactorChallengeTTL)
+}
+
+func issueToken(userID uint, tokenType string, ttl time.Duration) (string, AccessClaims, error) {
+	settings := config.GetAuthSettings()
+	key, ok := settings.Keys[settings.ActiveKeyID]
+	if !ok {
+		return "", AccessClaims{}, errors.New("no active signing key")
+	}
+	jti, err := randomHex(16)
+	if err != nil {
+		return "", AccessClaims{}, err
+	}
+
+	now := time.Now()
+	claims := AccessClaims{
+		UserID: userID,
+		Type:   tokenType,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.Secret)
+	return signed, claims, err
+}
+
+// ParseAccessToken verifies an access token. Only HS256 is accepted, whatever
+// the token's header claims, and the key is chosen by its kid.
+func ParseAccessToken(tokenString string) (AccessClaims, error) {
+	return parseToken(tokenString, TokenTypeAccess)
+}
+
+// ParseTwoFactorChallenge verifies a token from IssueTwoFactorChallenge.
+func ParseTwoFactorChallenge(tokenString string) (AccessClaims, error) {
+	return parseToken(tokenString, TokenTypeTwoFactorChallenge)
+}
+
+func parseToken(tokenString, tokenType string) (AccessClaims, error) {
+	var claims AccessClaims
+	settings := config.GetAuthSettings()
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, ok := settings.Keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return key.Secret, nil
+	})
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+	// StandardClaims.Valid skips missing claims, so require them here
+	if claims.Type != tokenType || claims.Id == "" || claims.ExpiresAt == 0 || claims.UserID == 0 {
+		return claims, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// IsTokenRevoked reports whether an access token is on the revocation list.
+// When the list cannot be read it reports the token revoked along with the
+// error, so a database outage never lets a revoked token through.
+func IsTokenRevoked(db *gorm.DB, jti string) (bool, error) {
+	var count int64
+	if err := db.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return true, err
+	}
+	return count > 0, nil
+}
+
+// RevokeAccessToken puts an access token on the revocation list until it expires.
+func RevokeAccessToken(db *gorm.DB, claims AccessClaims) error {
+	revoked := models.RevokedToken{
+		JTI:       claims.Id,
+		UserID:    claims.UserID,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+		RevokedAt: time.Now(),
+	}
+	return db.Where(models.RevokedToken{JTI: claims.Id}).FirstOrCreate(&revoked).Error
+}
+
+// IssueRefreshToken creates a refresh token for a user. An empty familyID
+// starts a new session.
+func IssueRefreshToken(db *gorm.DB, userID uint, familyID string) (string, error) {
+	if familyID == "" {
+		id, err := randomHex(16)
+		if err != nil {
+			return "", err
+		}
+		familyID = id
+	}
+	token, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	record := models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: HashToken(token),
+		ExpiresAt: time.Now().Add(config.GetAuthSettings().RefreshTokenTTL),
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family and returns the user it belongs to. Reusing a token that was already
+// exchanged revokes every token in its family, since either the user or an
+// attacker holds a stolen copy.
+func RotateRefreshToken(db *gorm.DB, token string) (uint, string, error) {
+	var record models.RefreshToken
+	if err := db.Where("token_hash = ?", HashToken(token)).First(&record).Error; err != nil {
+		return 0, "", ErrInvalidToken
+	}
+	if record.RevokedAt != nil || time.Now().After(record.ExpiresAt) {
+		return 0, "", ErrInvalidToken
+	}
+
+	var next string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Only one exchange may win if the token is presented twice at once
+		result := tx.Model(&models.RefreshToken{}).Where("id = ? AND used_at IS NULL", record.ID).Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+		var err error
+		next, err = IssueRefreshToken(tx, record.UserID, record.FamilyID)
+		return err
+	})
+	if errors.Is(err, ErrRefreshTokenReused) {
+		if revokeErr := RevokeRefreshFamily(db, record.FamilyID); revokeErr != nil {
+			return 0, "", revokeErr
+		}
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	return record.UserID, next, nil
+}
+
+// RevokeRefreshFamily revokes every token of a session.
+func RevokeRefreshFamily(db *gorm.DB, familyID string) error {
+	return db.Model(&models.RefreshToken{}).Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeUserSessions revokes every refresh token of a user.
+func RevokeUserSessions(db *gorm.DB, userID uint) error {
+	return db.Model(&models.RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// PurgeExpiredTokens deletes revocation entries, refresh tokens, mailed
+// account tokens and OIDC login state that have expired and returns how many were removed.
+func PurgeExpiredTokens(db *gorm.DB) (int64, error) {
+	now := time.Now()
+	revoked := db.Where("expires_at < ?", now).Delete(&models.RevokedToken{})
+	if revoked.Error != nil {
+		return 0, revoked.Error
+	}
+	refresh := db.Where("expires_at < ?", now).Delete(&models.RefreshToken{})
+	if refresh.Error != nil {
+		return 0, refresh.Error
+	}
+	removed := revoked.RowsAffected + refresh.RowsAffected
+	for _, model := range []interface{}{&models.UserToken{}, &models.OIDCLoginState{}, &models.OIDCLoginCode{}} {
+		result := db.Where("expires_at < ?", now).Delete(model)
+		if result.Error != nil {
+			return removed, result.Error
+		}
+		removed += result.RowsAffected
+	}
+	return removed, nil
+}
@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"fyp/config"
+
+	"github.com/dgrijalva/jwt-go"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var testSigningKey = config.SigningKey{ID: "current", Secret: []byte("current-signing-secret-of-32-bytes-or-more")}
+
+// useTestSigningKeys signs tokens with testSigningKey for the test.
+func useTestSigningKeys(t *testing.T) {
+	t.Helper()
+	previous := config.GetAuthSettings()
+	config.SetAuthSettings(config.AuthSettings{
+		Keys:            map[string]config.SigningKey{testSigningKey.ID: testSigningKey},
+		ActiveKeyID:     testSigningKey.ID,
+		AccessTokenTTL:  time.Minute,
+		RefreshTokenTTL: time.Hour,
+	})
+	t.Cleanup(func() { config.SetAuthSettings(previous) })
+}
+
+// signedToken builds a JWT from a header and claims, signed with HS256 and
+// secret whatever the header says.
+func signedToken(header, claims map[string]interface{}, secret []byte) string {
+	encode := func(part map[string]interface{}) string {
+		raw, _ := json.Marshal(part)
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	unsigned := encode(header) + "." + encode(claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func accessClaims(overrides map[string]interface{}) map[string]interface{} {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"user_id": 7,
+		"typ":     TokenTypeAccess,
+		"jti":     "token-id",
+		"iat":     now.Unix(),
+		"exp":     now.Add(time.Minute).Unix(),
+	}
+	for name, value := range overrides {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+	return claims
+}
+
+var hs256Header = map[string]interface{}{"alg": "HS256", "typ": "JWT", "kid": testSigningKey.ID}
+
+func TestIssuedAccessTokenParses(t *testing.T) {
+	useTestSigningKeys(t)
+	token, issued, err := IssueAccessToken(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseAccessToken(token)
+	if err != nil {
+		t.Fatalf("ParseAccessToken: %v", err)
+	}
+	if claims.UserID != 7 || claims.Id != issued.Id || claims.Type != TokenTypeAccess {
+		t.Fatalf("claims = %+v, want those issued %+v", claims, issued)
+	}
+	if _, err := ParseAccessToken(signedToken(hs256Header, accessClaims(nil), testSigningKey.Secret)); err != nil {
+		t.Fatalf("hand-built token rejected: %v", err)
+	}
+}
+
+func TestParseAccessTokenRejects(t *testing.T) {
+	useTestSigningKeys(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := signedToken(hs256Header, accessClaims(nil), testSigningKey.Secret)
+
+	tests := []struct {
+		name  string
+		token func() string
+	}{
+		{"alg none", func() string {
+			parts := strings.Split(signedToken(map[string]interface{}{"alg": "none", "typ": "JWT", "kid": testSigningKey.ID}, accessClaims(nil), nil), ".")
+			return parts[0] + "." + parts[1] + "."
+		}},
+		{"alg none with signature", func() string {
+			return signedToken(map[string]interface{}{"alg": "none", "kid": testSigningKey.ID}, accessClaims(nil), testSigningKey.Secret)
+		}},
+		{"RS256 header", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims(accessClaims(nil)))
+			token.Header["kid"] = testSigningKey.ID
+			signed, _ := token.SignedString(rsaKey)
+			return signed
+		}},
+		{"RS256 header signed with the HMAC secret", func() string {
+			return signedToken(map[string]interface{}{"alg": "RS256", "kid": testSigningKey.ID}, accessClaims(nil), testSigningKey.Secret)
+		}},
+		{"HS512", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims(accessClaims(nil)))
+			token.Header["kid"] = testSigningKey.ID
+			signed, _ := token.SignedString(testSigningKey.Secret)
+			return signed
+		}},
+		{"unknown kid", func() string {
+			return signedToken(map[string]interface{}{"alg": "HS256", "kid": "retired"}, accessClaims(nil), testSigningKey.Secret)
+		}},
+		{"missing kid", func() string {
+			return signedToken(map[string]interface{}{"alg": "HS256"}, accessClaims(nil), testSigningKey.Secret)
+		}},
+		{"other secret", func() string {
+			return signedToken(hs256Header, accessClaims(nil), []byte("another-signing-secret-of-32-bytes-or-more"))
+		}},
+		{"flipped signature byte", func() string {
+			parts := strings.Split(valid, ".")
+			signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			signature[0] ^= 0x01
+			return parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(signature)
+		}},
+		{"edited claims", func() string {
+			parts := strings.Split(valid, ".")
+			raw, _ := json.Marshal(accessClaims(map[string]interface{}{"user_id": 1}))
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(raw) + "." + parts[2]
+		}},
+		{"expired", func() string {
+			return signedToken(hs256Header, accessClaims(map[string]interface{}{"exp": time.Now().Add(-time.Second).Unix()}), testSigningKey.Secret)
+		}},
+		{"missing exp", func() string {
+			return signedToken(hs256Header, accessClaims(map[string]interface{}{"exp": nil}), testSigningKey.Secret)
+		}},
+		{"missing jti", func() string {
+			return signedToken(hs256Header, accessClaims(map[string]interface{}{"jti": nil}), testSigningKey.Secret)
+		}},
+		{"missing user", func() string {
+			return signedToken(hs256Header, accessClaims(map[string]interface{}{"user_id": nil}), testSigningKey.Secret)
+		}},
+		{"missing type", func() string {
+			return signedToken(hs256Header, accessClaims(map[string]interface{}{"typ": nil}), testSigningKey.Secret)
+		}},
+		{"2FA challenge", func() string {
+			token, _, _ := IssueTwoFactorChallenge(7)
+			return token
+		}},
+		{"refresh token", func() string {
+			token, _ := randomHex(32)
+			return token
+		}},
+		{"empty", func() string { return "" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if claims, err := ParseAccessToken(tt.token()); err == nil {
+				t.Fatalf("ParseAccessToken accepted the token: %+v", claims)
+			}
+		})
+	}
+}
+
+func TestParseTwoFactorChallengeRejectsAccessToken(t *testing.T) {
+	useTestSigningKeys(t)
+	challenge, _, err := IssueTwoFactorChallenge(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := ParseTwoFactorChallenge(challenge); err != nil || claims.UserID != 7 {
+		t.Fatalf("ParseTwoFactorChallenge = %+v, %v; want the challenge's claims", claims, err)
+	}
+
+	access, _, err := IssueAccessToken(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseTwoFactorChallenge(access); err == nil {
+		t.Fatal("an access token passed as a 2FA challenge")
+	}
+	forged := signedToken(hs256Header, accessClaims(map[string]interface{}{"typ": "refresh"}), testSigningKey.Secret)
+	if _, err := ParseTwoFactorChallenge(forged); err == nil {
+		t.Fatal("a token of another type passed as a 2FA challenge")
+	}
+}
+
+func TestRotatedOutKeyStopsVerifying(t *testing.T) {
+	useTestSigningKeys(t)
+	token, _, err := IssueAccessToken(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := config.SigningKey{ID: "next", Secret: []byte("next-signing-secret-of-at-least-32-bytes")}
+	config.SetAuthSettings(config.AuthSettings{
+		Keys:           map[string]config.SigningKey{testSigningKey.ID: testSigningKey, next.ID: next},
+		ActiveKeyID:    next.ID,
+		AccessTokenTTL: time.Minute,
+	})
+	if _, err := ParseAccessToken(token); err != nil {
+		t.Fatalf("token signed with a listed key rejected: %v", err)
+	}
+	config.SetAuthSettings(config.AuthSettings{
+		Keys:           map[string]config.SigningKey{next.ID: next},
+		ActiveKeyID:    next.ID,
+		AccessTokenTTL: time.Minute,
+	})
+	if _, err := ParseAccessToken(token); err == nil {
+		t.Fatal("token signed with a removed key accepted")
+	}
+}
+
+func TestIsTokenRevokedFailsClosed(t *testing.T) {
+	// Nothing listens on port 1, so every query fails
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1 port=1 connect_timeout=1 sslmode=disable"}),
+		&gorm.Config{DisableAutomaticPing: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := IsTokenRevoked(db, "token-id")
+	if err == nil || !revoked {
+		t.Fatalf("IsTokenRevoked without a database = %v, %v; want revoked with the error", revoked, err)
+	}
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of RFC 6238 Appendix B, "12345678901234567890".
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// rfc6238Vectors are the SHA-1 test vectors of RFC 6238 Appendix B. The RFC
+// lists 8 digit codes; a 6 digit code is their last 6 digits.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		step := TOTPStep(time.Unix(v.unix, 0))
+		code, err := TOTPCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := v.code[2:]; code != want {
+			t.Errorf("TOTPCode at %d = %s, want %s", v.unix, code, want)
+		}
+	}
+	// Secrets are accepted whatever their case
+	if code, _ := TOTPCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1); code != "287082" {
+		t.Errorf("lower-case secret gave %s", code)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		now := time.Unix(v.unix, 0)
+		code := v.code[2:]
+		step, ok := VerifyTOTP(rfc6238Secret, code, now, 0)
+		if !ok || step != TOTPStep(now) {
+			t.Fatalf("VerifyTOTP(%s) at %d = %d, %v; want step %d", code, v.unix, step, ok, TOTPStep(now))
+		}
+		if _, ok := VerifyTOTP(rfc6238Secret, code[:3]+" "+code[3:], now, 0); !ok {
+			t.Errorf("code with a space at %d refused", v.unix)
+		}
+		// One step of clock drift either way is allowed, two are not
+		for drift, want := range map[time.Duration]bool{
+			-totpPeriod * time.Second: true, totpPeriod * time.Second: true,
+			-2 * totpPeriod * time.Second: false, 2 * totpPeriod * time.Second: false,
+		} {
+			if v.unix < 2*totpPeriod {
+				break // No earlier steps to drift back to
+			}
+			if _, ok := VerifyTOTP(rfc6238Secret, code, now.Add(drift), 0); ok != want {
+				t.Errorf("code from %d checked %v later: accepted = %v, want %v", v.unix, drift, ok, want)
+			}
+		}
+		// A code cannot be used again once its step is spent
+		if _, ok := VerifyTOTP(rfc6238Secret, code, now, step); ok {
+			t.Errorf("code at %d replayed", v.unix)
+		}
+	}
+	for _, code := range []string{"", "28708", "2870820", "94287082", "abcdef"} {
+		if _, ok := VerifyTOTP(rfc6238Secret, code, time.Unix(59, 0), 0); ok {
+			t.Errorf("VerifyTOTP accepted %q", code)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+// PlantAudits loads the AI configuration changes FilterByPlant needs for
+// records: those of the users and devices the records come from, up to the
+// latest record.
+func PlantAudits(db *gorm.DB, records []models.SensorData) ([]models.AIConfigAudit, error) {
+	audits := []models.AIConfigAudit{}
+	if len(records) == 0 {
+		return audits, nil
+	}
+	users := make(map[uint]bool)
+	devices := make(map[string]bool)
+	latest := records[0].Timestamp
+	for _, record := range records {
+		users[record.UserID] = true
+		devices[record.DeviceID] = true
+		if record.Timestamp.After(latest) {
+			latest = record.Timestamp
+		}
+	}
+	userIDs := make([]uint, 0, len(users))
+	for id := range users {
+		userIDs = append(userIDs, id)
+	}
+	deviceIDs := make([]string, 0, len(devices))
+	for id := range devices {
+		deviceIDs = append(deviceIDs, id)
+	}
+
+	err := db.Where("user_id IN ? AND device_id IN ? AND changed_at <= ?", userIDs, deviceIDs, latest).
+		Order("changed_at asc").Find(&audits).Error
+	return audits, err
+}
+
+// FilterByPlant keeps the records taken while their device was configured for
+// plant, based on the AI configuration audit trail. Records from a device that
+// had no plant configured at the time are kept only when includeUnassigned is set.
+func FilterByPlant(records []models.SensorData, audits []models.AIConfigAudit, plant string, includeUnassigned bool) []models.SensorData {
+	timelines := make(map[string][]models.AIConfigAudit)
+	for _, audit := range audits {
+		key := fmt.Sprintf("%d/%s", audit.UserID, audit.DeviceID)
+		timelines[key] = append(timelines[key], audit)
+	}
+	for _, timeline := range timelines {
+		sort.Slice(timeline, func(i, j int) bool { return timeline[i].ChangedAt.Before(timeline[j].ChangedAt) })
+	}
+
+	var filtered []models.SensorData
+	for _, record := range records {
+		timeline := timelines[fmt.Sprintf("%d/%s", record.UserID, record.DeviceID)]
+
+		// The plant in effect is the one from the last change before the reading
+		current := ""
+		for _, audit := range timeline {
+			if audit.ChangedAt.After(record.Timestamp) {
+				break
+			}
+			current = audit.PlantName
+		}
+
+		if current == plant || (current == "" && includeUnassigned) {
+			filtered = append(filtered, record)
+		}
+	}
+	return filtered
+}
+
+// ExcludeOutliers drops records where any metric lies more than zScore
+// standard deviations from the mean of the set.
+func ExcludeOutliers(records []models.SensorData, zScore float64) []models.SensorData {
+	if len(records) < 3 || zScore <= 0 {
+		return records
+	}
+
+	metrics := []func(models.SensorData) float64{
+		func(r models.SensorData) float64 { return float64(r.Temperature) },
+		func(r models.SensorData) float64 { return float64(r.Humidity) },
+		func(r models.SensorData) float64 { return float64(r.SoilMoisture) },
+	}
+
+	means := make([]float64, len(metrics))
+	stds := make([]float64, len(metrics))
+	for i, metric := range metrics {
+		var sum float64
+		for _, r := range records {
+			sum += metric(r)
+		}
+		means[i] = sum / float64(len(records))
+
+		var sq float64
+		for _, r := range records {
+			d := metric(r) - means[i]
+			sq += d * d
+		}
+		stds[i] = math.Sqrt(sq / float64(len(records)))
+	}
+
+	var kept []models.SensorData
+	for _, r := range records {
+		outlier := false
+		for i, metric := range metrics {
+			if stds[i] > 0 && math.Abs(metric(r)-means[i])/stds[i] > zScore {
+				outlier = true
+				break
+			}
+		}
+		if !outlier {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// SplitTrainingData divides records into training and validation sets.
+// The "chronological" policy holds out the most recent fraction of readings;
+// "random" shuffles with the given seed so the split is reproducible.
+func SplitTrainingData(records []models.SensorData, fraction float64, policy string, seed int64) ([]models.SensorData, []models.SensorData, error) {
+	if fraction < 0 || fraction >= 1 {
+		return nil, nil, fmt.Errorf("validation split must be between 0 and 1")
+	}
+
+	ordered := append([]models.SensorData(nil), records...)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].Timestamp.Before(ordered[j].Timestamp) })
+
+	switch policy {
+	case "", "chronological":
+	case "random":
+		rng := rand.New(rand.NewSource(seed))
+		rng.Shuffle(len(ordered), func(i, j int) { ordered[i], ordered[j] = ordered[j], ordered[i] })
+	default:
+		return nil, nil, fmt.Errorf("unknown split policy %q", policy)
+	}
+
+	validationSize := int(math.Round(float64(len(ordered)) * fraction))
+	cut := len(ordered) - validationSize
+	return ordered[:cut], ordered[cut:], nil
+}
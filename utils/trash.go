@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+// NewTrashBatch returns an ID naming the rows one deletion moves to the trash.
+func NewTrashBatch() (string, error) {
+	return randomHex(8)
+}
+
+// TrashSensorData moves the readings matching scopes to the trash under
+// batch and returns how many were moved. With no scopes every reading is.
+func TrashSensorData(tx *gorm.DB, batch string, scopes ...func(*gorm.DB) *gorm.DB) (int64, error) {
+	query := tx.Model(&models.SensorData{}).Scopes(scopes...)
+	if len(scopes) == 0 {
+		query = query.Session(&gorm.Session{AllowGlobalUpdate: true})
+	}
+	result := query.Updates(map[string]interface{}{"deleted_at": time.Now(), "trash_batch": batch})
+	return result.RowsAffected, result.Error
+}
+
+// TrashUser moves an account to the trash under batch.
+func TrashUser(tx *gorm.DB, userID uint, batch string) error {
+	result := tx.Model(&models.User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"deleted_at": time.Now(), "trash_batch": batch})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+// RestoreTrash brings back every reading and account trashed under batch and
+// returns how many of each were restored.
+func RestoreTrash(tx *gorm.DB, batch string) (records int64, users int64, err error) {
+	restore := map[string]interface{}{"deleted_at": nil, "trash_batch": ""}
+	result := tx.Unscoped().Model(&models.SensorData{}).Where("trash_batch = ?", batch).Updates(restore)
+	if result.Error != nil {
+		return 0, 0, result.Error
+	}
+	records = result.RowsAffected
+	result = tx.Unscoped().Model(&models.User{}).Where("trash_batch = ?", batch).Updates(restore)
+	return records, result.RowsAffected, result.Error
+}
+
+// TrashBatchUsers returns the owners of the readings and the accounts trashed
+// under batch.
+func TrashBatchUsers(db *gorm.DB, batch string) ([]uint, error) {
+	var owners, accounts []uint
+	if err := db.Unscoped().Model(&models.SensorData{}).Where("trash_batch = ?", batch).
+		Distinct("user_id").Pluck("user_id", &owners).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Unscoped().Model(&models.User{}).Where("trash_batch = ?", batch).
+		Pluck("id", &accounts).Error; err != nil {
+		return nil, err
+	}
+	return append(owners, accounts...), nil
+}
+
+// ListTrash returns the trashed readings grouped by deletion and owner,
+// newest first. A nil userIDs lists the trash of every user.
+func ListTrash(db *gorm.DB, userIDs []uint, retention time.Duration) ([]models.Trash, error) {
+	query := db.Unscoped().Model(&models.SensorData{}).Where("deleted_at IS NOT NULL")
+	if userIDs != nil {
+		query = query.Where("user_id IN ?", userIDs)
+	}
+	trash := []models.Trash{}
+	err := query.Select("trash_batch AS batch, user_id, COUNT(*) AS records, MAX(deleted_at) AS deleted_at").
+		Group("trash_batch, user_id").Order("deleted_at desc").Scan(&trash).Error
+	for i := range trash {
+		trash[i].PurgeAfter = trash[i].DeletedAt.Add(retention)
+	}
+	return trash, err
+}
+
+// ListTrashedUsers returns the accounts in the trash, newest first.
+func ListTrashedUsers(db *gorm.DB, retention time.Duration) ([]models.TrashedUser, error) {
+	users := []models.TrashedUser{}
+	err := db.Unscoped().Model(&models.User{}).Where("deleted_at IS NOT NULL").
+		Select("id, username, email, role, trash_batch AS batch, deleted_at").
+		Order("deleted_at desc").Scan(&users).Error
+	for i := range users {
+		users[i].PurgeAfter = users[i].DeletedAt.Add(retention)
+	}
+	return users, err
+}
+
+// PurgeTrash permanently deletes readings and accounts trashed before cutoff
+// and returns how many of each were deleted.
+func PurgeTrash(db *gorm.DB, cutoff time.Time) (records int64, users int64, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		trashed := tx.Unscoped().Model(&models.SensorData{}).Select("id").Where("deleted_at < ?", cutoff)
+		if err := tx.Where("sensor_data_id IN (?)", trashed).Delete(&models.ReadingRevision{}).Error; err != nil {
+			return err
+		}
+		result := tx.Unscoped().Where("deleted_at < ?", cutoff).Delete(&models.SensorData{})
+		if result.Error != nil {
+			return result.Error
+		}
+		records = result.RowsAffected
+		result = tx.Unscoped().Where("deleted_at < ?", cutoff).Delete(&models.User{})
+		if result.Error != nil {
+			return result.Error
+		}
+		users = result.RowsAffected
+		if records == 0 && users == 0 {
+			return nil
+		}
+		return RecordAudit(tx, AuditEntry{
+			Action:     models.AuditTrashPurge,
+			TargetType: models.AuditTargetTrash,
+			Before:     map[string]interface{}{"trashed_before": cutoff, "records": records, "users": users},
+		})
+	})
+	return records, users, err
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCodeCount is how many recovery codes a user is given at a time.
+const RecoveryCodeCount = 10
+
+var (
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	ErrInvalidTwoFactor    = errors.New("invalid two-factor code")
+)
+
+// TOTPIssuer names the service in authenticator apps, from TOTP_ISSUER.
+func TOTPIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Soil Moisture Monitor"
+}
+
+// TwoFactorEnabled reports whether a user has confirmed TOTP enrolment.
+func TwoFactorEnabled(db *gorm.DB, userID uint) bool {
+	var count int64
+	db.Model(&models.TwoFactor{}).Where("user_id = ? AND enabled = ?", userID, true).Count(&count)
+	return count > 0
+}
+
+// BeginTwoFactorSetup stores a new, unconfirmed secret for a user, replacing
+// any earlier unconfirmed one.
+func BeginTwoFactorSetup(db *gorm.DB, userID uint) (string, error) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	enrolment := models.TwoFactor{UserID: userID, Secret: secret}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.TwoFactor{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&enrolment).Error
+	})
+	return secret, err
+}
+
+// ConfirmTwoFactor enables a pending enrolment with a code from the app and
+// returns the user's recovery codes.
+func ConfirmTwoFactor(db *gorm.DB, userID uint, code string) ([]string, error) {
+	var enrolment models.TwoFactor
+	if err := db.First(&enrolment, "user_id = ?", userID).Error; err != nil {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	step, ok := VerifyTOTP(enrolment.Secret, code, time.Now(), enrolment.LastUsedStep)
+	if !ok {
+		return nil, ErrInvalidTwoFactor
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&enrolment).Updates(map[string]interface{}{
+			"enabled": true, "confirmed_at": now, "last_used_step": step,
+		}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = ReplaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// ReplaceRecoveryCodes discards a user's recovery codes and returns new ones.
+func ReplaceRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for i := 0; i < RecoveryCodeCount; i++ {
+			raw, err := randomHex(5)
+			if err != nil {
+				return err
+			}
+			code := raw[:5] + "-" + raw[5:]
+			if err := tx.Create(&models.RecoveryCode{UserID: userID, CodeHash: HashToken(code)}).Error; err != nil {
+				return err
+			}
+			codes = append(codes, code)
+		}
+		return nil
+	})
+	return codes, err
+}
+
+// RemainingRecoveryCodes counts a user's unused recovery codes.
+func RemainingRecoveryCodes(db *gorm.DB, userID uint) int64 {
+	var count int64
+	db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count)
+	return count
+}
+
+// VerifyTwoFactor checks a TOTP code, or a recovery code if code is empty,
+// for a user with 2FA enabled. Either works only once.
+func VerifyTwoFactor(db *gorm.DB, userID uint, code, recoveryCode string) error {
+	var enrolment models.TwoFactor
+	if err := db.First(&enrolment, "user_id = ? AND enabled = ?", userID, true).Error; err != nil {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if code != "" {
+		step, ok := VerifyTOTP(enrolment.Secret, code, time.Now(), enrolment.LastUsedStep)
+		if !ok {
+			return ErrInvalidTwoFactor
+		}
+		// Another request may have used the same step in the meantime
+		result := db.Model(&models.TwoFactor{}).Where("user_id = ? AND last_used_step < ?", userID, step).
+			Update("last_used_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidTwoFactor
+		}
+		return nil
+	}
+
+	normalised := strings.ToLower(strings.TrimSpace(recoveryCode))
+	if normalised == "" {
+		return ErrInvalidTwoFactor
+	}
+	result := db.Model(&models.RecoveryCode{}).Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, HashToken(normalised)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTwoFactor
+	}
+	return nil
+}
+
+// DisableTwoFactor removes a user's enrolment and recovery codes.
+func DisableTwoFactor(db *gorm.DB, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.TwoFactor{}).Error
+	})
+}
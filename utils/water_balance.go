@@ -0,0 +1,336 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+// MaxWaterBalanceDays bounds how far back the water balance is recomputed.
+const MaxWaterBalanceDays = 90
+
+// DefaultSoilProfile is used for devices without a stored profile: a loam with
+// a shallow root zone.
+var DefaultSoilProfile = models.SoilProfile{SoilParameters: models.SoilParameters{
+	FieldCapacity:     35,
+	WiltingPoint:      15,
+	RootDepthM:        0.3,
+	DepletionFraction: 0.5,
+	AreaM2:            1,
+}}
+
+// ValidateSoilProfile checks that a profile describes a usable root zone.
+func ValidateSoilProfile(profile models.SoilParameters) error {
+	switch {
+	case profile.FieldCapacity <= 0 || profile.FieldCapacity > 100:
+		return fmt.Errorf("field_capacity must be between 0 and 100")
+	case profile.WiltingPoint < 0 || profile.WiltingPoint >= profile.FieldCapacity:
+		return fmt.Errorf("wilting_point must be below field_capacity")
+	case profile.RootDepthM <= 0 || profile.RootDepthM > 3:
+		return fmt.Errorf("root_depth_m must be between 0 and 3")
+	case profile.DepletionFraction <= 0 || profile.DepletionFraction >= 1:
+		return fmt.Errorf("depletion_fraction must be between 0 and 1")
+	case profile.AreaM2 < 0:
+		return fmt.Errorf("area_m2 must not be negative")
+	}
+	return nil
+}
+
+// SoilProfileFor returns the stored soil profile of a device, or
+// DefaultSoilProfile when none is stored.
+func SoilProfileFor(db *gorm.DB, userID uint, deviceID string) models.SoilProfile {
+	var profile models.SoilProfile
+	if err := db.Where("user_id = ? AND device_id = ?", userID, deviceID).First(&profile).Error; err != nil {
+		profile = DefaultSoilProfile
+		profile.UserID, profile.DeviceID = userID, deviceID
+	}
+	return profile
+}
+
+// FieldSoilProfileFor returns the stored soil profile of a field, or
+// DefaultSoilProfile over the field's area when none is stored.
+func FieldSoilProfileFor(db *gorm.DB, field models.Field) models.FieldSoilProfile {
+	var profile models.FieldSoilProfile
+	if err := db.Where("field_id = ?", field.ID).First(&profile).Error; err != nil {
+		profile = models.FieldSoilProfile{FieldID: field.ID, SoilParameters: DefaultSoilProfile.SoilParameters}
+		if field.AreaM2 > 0 {
+			profile.AreaM2 = field.AreaM2
+		}
+	}
+	return profile
+}
+
+// TotalAvailableWater is FAO-56 eq. 82 with soil moisture in volumetric %, in mm.
+func TotalAvailableWater(profile models.SoilParameters) float64 {
+	return (profile.FieldCapacity - profile.WiltingPoint) / 100 * 1000 * profile.RootDepthM
+}
+
+// depletionFromMoisture converts a soil moisture reading to root-zone depletion in mm.
+func depletionFromMoisture(profile models.SoilParameters, moisture float64) float64 {
+	return (profile.FieldCapacity - moisture) / 100 * 1000 * profile.RootDepthM
+}
+
+// NextDepletion is the daily root-zone water balance (FAO-56 eq. 85) without
+// runoff, capillary rise or deep percolation beyond field capacity.
+func NextDepletion(previous, rainMM, irrigationMM, etc, taw float64) float64 {
+	return math.Max(0, math.Min(taw, previous-rainMM-irrigationMM+etc))
+}
+
+// dayObservations is what was measured at one or more devices over one local day.
+type dayObservations struct {
+	weather      DailyWeather
+	hasTemp      bool
+	soilMoisture *float64
+	rainMM       float64
+}
+
+// observeDay aggregates the readings of devices and the cached weather at
+// their location for the day starting at dayStart. Device readings take
+// precedence for temperature and humidity; weather fills wind, solar
+// radiation and rain.
+func observeDay(db *gorm.DB, keys []DeviceKey, lat, lon float64, dayStart time.Time) (dayObservations, error) {
+	dayEnd := dayStart.AddDate(0, 0, 1)
+	obs := dayObservations{weather: DailyWeather{Date: dayStart}}
+
+	var readings []models.SensorData
+	if err := db.Scopes(ReadingsOf(keys)).Where("timestamp >= ? AND timestamp < ?", dayStart, dayEnd).
+		Find(&readings).Error; err != nil {
+		return obs, err
+	}
+	if len(readings) > 0 {
+		obs.hasTemp = true
+		obs.weather.TMax, obs.weather.TMin = math.Inf(-1), math.Inf(1)
+		var humidity, moisture float64
+		for _, r := range readings {
+			obs.weather.TMax = math.Max(obs.weather.TMax, float64(r.Temperature))
+			obs.weather.TMin = math.Min(obs.weather.TMin, float64(r.Temperature))
+			humidity += float64(r.Humidity)
+			moisture += float64(r.SoilMoisture)
+		}
+		rhMean := humidity / float64(len(readings))
+		soilMean := moisture / float64(len(readings))
+		obs.weather.RHMean, obs.soilMoisture = &rhMean, &soilMean
+	}
+
+	provider := GetWeatherProvider()
+	if provider == nil {
+		return obs, nil
+	}
+	var records []models.WeatherRecord
+	if err := db.Where("provider = ? AND latitude = ? AND longitude = ? AND kind = ? AND valid_at >= ? AND valid_at < ?",
+		provider.Name(), roundCoordinate(lat), roundCoordinate(lon), models.WeatherForecast, dayStart, dayEnd).
+		Order("fetched_at asc").Find(&records).Error; err != nil {
+		return obs, err
+	}
+
+	// Keep the latest fetch of each hour
+	hourly := make(map[time.Time]models.WeatherRecord)
+	for _, record := range records {
+		hourly[record.ValidAt] = record
+	}
+	if len(hourly) == 0 {
+		return obs, nil
+	}
+
+	wind, solar, tMax, tMin := 0.0, 0.0, math.Inf(-1), math.Inf(1)
+	for _, record := range hourly {
+		wind += record.WindSpeed
+		solar += record.SolarRadiation
+		obs.rainMM += record.PrecipitationMM
+		tMax, tMin = math.Max(tMax, record.Temperature), math.Min(tMin, record.Temperature)
+	}
+	meanWind := wind / float64(len(hourly))
+	obs.weather.WindSpeed10m = &meanWind
+	// Solar radiation needs most of the day to integrate, W/m² mean × 0.0864 = MJ/m²/day
+	if len(hourly) >= 18 {
+		dailySolar := solar / float64(len(hourly)) * 0.0864
+		obs.weather.SolarRadiation = &dailySolar
+	}
+	if !obs.hasTemp {
+		obs.hasTemp = true
+		obs.weather.TMax, obs.weather.TMin = tMax, tMin
+	}
+	return obs, nil
+}
+
+// irrigationDepth sums the water applied through devices over a day, in mm
+// over the profile's area.
+func irrigationDepth(db *gorm.DB, keys []DeviceKey, profile models.SoilParameters, dayStart time.Time) (float64, error) {
+	if profile.AreaM2 <= 0 {
+		return 0, nil
+	}
+	var liters float64
+	err := db.Model(&models.WateringEvent{}).Scopes(ReadingsOf(keys)).
+		Where("started_at >= ? AND started_at < ?", dayStart, dayStart.AddDate(0, 0, 1)).
+		Select("COALESCE(SUM(volume_liters), 0)").Scan(&liters).Error
+	return liters / profile.AreaM2, err
+}
+
+// cropCoefficient returns the Kc of the planting's growth stage on a day, or 1
+// when the device is not planted or the stage has no coefficient.
+func cropCoefficient(planting *models.Planting, at time.Time) float64 {
+	if planting == nil {
+		return 1
+	}
+	window, ok := CurrentStage(planting.Crop.Stages, planting.SowDate, at)
+	if !ok || window.Stage.CropCoefficient <= 0 {
+		return 1
+	}
+	return window.Stage.CropCoefficient
+}
+
+// balanceSite is what a water balance is computed over: one device, or the
+// devices of a field pooled under the field's soil profile.
+type balanceSite struct {
+	keys     []DeviceKey
+	lat, lon float64
+	soil     models.SoilParameters
+	planting *models.Planting
+}
+
+// runWaterBalance steps a site's balance through each local day from first
+// to last that has observations, starting from depletion, or from the first
+// day's mean soil moisture when depletion is negative. record turns each day
+// into the entry returned for it.
+func runWaterBalance(db *gorm.DB, site balanceSite, depletion float64, first, last time.Time,
+	record func(models.WaterBalanceDay) (models.WaterBalanceDay, error)) ([]models.WaterBalanceDay, error) {
+	taw := TotalAvailableWater(site.soil)
+	raw := site.soil.DepletionFraction * taw
+
+	results := []models.WaterBalanceDay{}
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		obs, err := observeDay(db, site.keys, site.lat, site.lon, day)
+		if err != nil {
+			return nil, err
+		}
+		if !obs.hasTemp {
+			continue // Nothing measured or cached for this day
+		}
+		if depletion < 0 {
+			depletion = 0
+			if obs.soilMoisture != nil {
+				depletion = math.Max(0, math.Min(taw, depletionFromMoisture(site.soil, *obs.soilMoisture)))
+			}
+		}
+
+		irrigation, err := irrigationDepth(db, site.keys, site.soil, day)
+		if err != nil {
+			return nil, err
+		}
+		et0, method := ReferenceET0(obs.weather, site.lat, site.soil.ElevationM)
+		kc := cropCoefficient(site.planting, day)
+		etc := kc * et0
+		depletion = NextDepletion(depletion, obs.rainMM, irrigation, etc, taw)
+
+		entry, err := record(models.WaterBalanceDay{
+			Date:             day,
+			TMax:             obs.weather.TMax,
+			TMin:             obs.weather.TMin,
+			ET0:              et0,
+			ET0Method:        method,
+			CropCoefficient:  kc,
+			ETc:              etc,
+			RainMM:           obs.rainMM,
+			IrrigationMM:     irrigation,
+			Depletion:        depletion,
+			TAW:              taw,
+			RAW:              raw,
+			SoilMoistureMean: obs.soilMoisture,
+			IrrigationNeeded: depletion > raw,
+			ComputedAt:       time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, entry)
+	}
+	return results, nil
+}
+
+// balanceWindow returns the first and last local day of a days long window
+// ending today.
+func balanceWindow(days int) (time.Time, time.Time) {
+	now := time.Now().In(LocalTimezone())
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return today.AddDate(0, 0, -(days - 1)), today
+}
+
+// ComputeWaterBalance recomputes a device's daily water balance for the last
+// days local days, today included, and stores the results. The balance
+// carries on from the stored day before the window, or starts from the first
+// day's mean soil moisture.
+func ComputeWaterBalance(db *gorm.DB, userID uint, deviceID string, days int) ([]models.WaterBalanceDay, error) {
+	lat, lon, err := DeviceCoordinates(db, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := SoilProfileFor(db, userID, deviceID)
+	site := balanceSite{keys: []DeviceKey{{UserID: userID, DeviceID: deviceID}}, lat: lat, lon: lon, soil: profile.SoilParameters}
+	if p, err := ActivePlanting(db, userID, deviceID); err == nil {
+		site.planting = &p
+	}
+
+	first, today := balanceWindow(days)
+	depletion := -1.0
+	var previous models.WaterBalanceDay
+	if err := db.Where("user_id = ? AND device_id = ? AND date = ?", userID, deviceID, first.AddDate(0, 0, -1).Format("2006-01-02")).
+		First(&previous).Error; err == nil {
+		depletion = math.Min(previous.Depletion, TotalAvailableWater(profile.SoilParameters))
+	}
+
+	return runWaterBalance(db, site, depletion, first, today, func(entry models.WaterBalanceDay) (models.WaterBalanceDay, error) {
+		entry.UserID, entry.DeviceID = userID, deviceID
+		var stored models.WaterBalanceDay
+		err := db.Where("user_id = ? AND device_id = ? AND date = ?", userID, deviceID, entry.Date.Format("2006-01-02")).
+			Assign(entry).FirstOrCreate(&stored).Error
+		return stored, err
+	})
+}
+
+// FieldWaterBalance is a field's water balance, pooled over its devices.
+type FieldWaterBalance struct {
+	FieldID uint                     `json:"field_id"`
+	Devices []DeviceKey              `json:"devices"`
+	Profile models.FieldSoilProfile  `json:"profile"`
+	Days    []models.WaterBalanceDay `json:"days"`
+}
+
+// ComputeFieldWaterBalance computes a field's daily water balance for the
+// last days local days from the readings and watering of the given devices in
+// it, under the field's soil profile and planting. Field balances are not
+// stored, so each starts from the first day's mean soil moisture.
+func ComputeFieldWaterBalance(db *gorm.DB, field models.Field, keys []DeviceKey, days int) (FieldWaterBalance, error) {
+	balance := FieldWaterBalance{FieldID: field.ID, Devices: keys, Profile: FieldSoilProfileFor(db, field)}
+	if len(keys) == 0 {
+		return balance, fmt.Errorf("no devices are placed in field %d", field.ID)
+	}
+
+	// The farm's location, or else the first device that has one
+	lat, lon, err := 0.0, 0.0, fmt.Errorf("no location stored for field %d", field.ID)
+	if farm, farmErr := FarmOfNode(db, models.LevelField, field.ID); farmErr == nil && farm.Latitude != nil && farm.Longitude != nil {
+		lat, lon, err = *farm.Latitude, *farm.Longitude, nil
+	}
+	for i := 0; err != nil && i < len(keys); i++ {
+		if deviceLat, deviceLon, deviceErr := DeviceCoordinates(db, keys[i].UserID, keys[i].DeviceID); deviceErr == nil {
+			lat, lon, err = deviceLat, deviceLon, nil
+		}
+	}
+	if err != nil {
+		return balance, err
+	}
+
+	site := balanceSite{keys: keys, lat: lat, lon: lon, soil: balance.Profile.SoilParameters}
+	if p, err := ActiveFieldPlanting(db, field.ID); err == nil {
+		site.planting = &p
+	}
+	first, today := balanceWindow(days)
+	balance.Days, err = runWaterBalance(db, site, -1, first, today, func(entry models.WaterBalanceDay) (models.WaterBalanceDay, error) {
+		return entry, nil
+	})
+	return balance, err
+}
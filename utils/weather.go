@@ -0,0 +1,370 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"fyp/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	currentWeatherTTL  = 30 * time.Minute
+	forecastWeatherTTL = 3 * time.Hour
+)
+
+// WeatherProvider fetches weather for a coordinate. Implementations return
+// records with every measurement filled in; the Kind, ValidAt and location
+// fields are set by the provider.
+type WeatherProvider interface {
+	Name() string
+	Current(lat, lon float64) (models.WeatherRecord, error)
+	Forecast(lat, lon float64, hours int) ([]models.WeatherRecord, error)
+}
+
+var (
+	weatherMu       sync.RWMutex
+	weatherProvider WeatherProvider
+)
+
+// SetWeatherProvider installs the provider used by the weather helpers.
+// Passing nil disables weather integration.
+func SetWeatherProvider(provider WeatherProvider) {
+	weatherMu.Lock()
+	defer weatherMu.Unlock()
+	weatherProvider = provider
+}
+
+// GetWeatherProvider returns the installed provider, or nil.
+func GetWeatherProvider() WeatherProvider {
+	weatherMu.RLock()
+	defer weatherMu.RUnlock()
+	return weatherProvider
+}
+
+// InitWeatherProvider installs a provider from WEATHER_PROVIDER: "open-meteo"
+// (HTTP, base URL overridable with WEATHER_API_URL) or "file" (fixture JSON at
+// WEATHER_FIXTURE_FILE). Weather stays disabled when it is unset.
+func InitWeatherProvider() error {
+	switch os.Getenv("WEATHER_PROVIDER") {
+	case "":
+		SetWeatherProvider(nil)
+		return nil
+	case "open-meteo":
+		SetWeatherProvider(NewOpenMeteoProvider(os.Getenv("WEATHER_API_URL")))
+	case "file":
+		provider, err := NewFileWeatherProvider(os.Getenv("WEATHER_FIXTURE_FILE"))
+		if err != nil {
+			return err
+		}
+		SetWeatherProvider(provider)
+	default:
+		return fmt.Errorf("unknown weather provider %q", os.Getenv("WEATHER_PROVIDER"))
+	}
+
+	// Schedules skip on rain once a provider is available
+	RainForecast = ForecastRainForDevice
+	return nil
+}
+
+// OpenMeteoProvider fetches weather from the Open-Meteo forecast API.
+type OpenMeteoProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewOpenMeteoProvider returns a provider for baseURL, defaulting to the public API.
+func NewOpenMeteoProvider(baseURL string) *OpenMeteoProvider {
+	if baseURL == "" {
+		baseURL = "https://api.open-meteo.com/v1/forecast"
+	}
+	return &OpenMeteoProvider{BaseURL: baseURL, Client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+func (p *OpenMeteoProvider) Name() string { return "open-meteo" }
+
+const openMeteoVariables = "temperature_2m,relative_humidity_2m,precipitation,wind_speed_10m,shortwave_radiation,cloud_cover"
+
+type openMeteoResponse struct {
+	Current struct {
+		Time               string  `json:"time"`
+		Temperature        float64 `json:"temperature_2m"`
+		Humidity           float64 `json:"relative_humidity_2m"`
+		Precipitation      float64 `json:"precipitation"`
+		WindSpeed          float64 `json:"wind_speed_10m"`
+		ShortwaveRadiation float64 `json:"shortwave_radiation"`
+		CloudCover         float64 `json:"cloud_cover"`
+	} `json:"current"`
+	Hourly struct {
+		Time               []string  `json:"time"`
+		Temperature        []float64 `json:"temperature_2m"`
+		Humidity           []float64 `json:"relative_humidity_2m"`
+		Precipitation      []float64 `json:"precipitation"`
+		WindSpeed          []float64 `json:"wind_speed_10m"`
+		ShortwaveRadiation []float64 `json:"shortwave_radiation"`
+		CloudCover         []float64 `json:"cloud_cover"`
+	} `json:"hourly"`
+}
+
+func (p *OpenMeteoProvider) fetch(lat, lon float64, params url.Values) (openMeteoResponse, error) {
+	var parsed openMeteoResponse
+	params.Set("latitude", fmt.Sprintf("%.4f", lat))
+	params.Set("longitude", fmt.Sprintf("%.4f", lon))
+	params.Set("timezone", "UTC")
+	params.Set("wind_speed_unit", "ms")
+
+	resp, err := p.Client.Get(p.BaseURL + "?" + params.Encode())
+	if err != nil {
+		return parsed, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return parsed, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return parsed, fmt.Errorf("weather API returned %d: %s", resp.StatusCode, string(body))
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return parsed, fmt.Errorf("failed to parse weather response: %v", err)
+	}
+	return parsed, nil
+}
+
+func (p *OpenMeteoProvider) Current(lat, lon float64) (models.WeatherRecord, error) {
+	parsed, err := p.fetch(lat, lon, url.Values{"current": {openMeteoVariables}})
+	if err != nil {
+		return models.WeatherRecord{}, err
+	}
+	validAt, err := time.Parse("2006-01-02T15:04", parsed.Current.Time)
+	if err != nil {
+		return models.WeatherRecord{}, fmt.Errorf("invalid weather time %q", parsed.Current.Time)
+	}
+	return models.WeatherRecord{
+		Kind:            models.WeatherCurrent,
+		ValidAt:         validAt,
+		Temperature:     parsed.Current.Temperature,
+		Humidity:        parsed.Current.Humidity,
+		PrecipitationMM: parsed.Current.Precipitation,
+		WindSpeed:       parsed.Current.WindSpeed,
+		SolarRadiation:  parsed.Current.ShortwaveRadiation,
+		CloudCover:      parsed.Current.CloudCover,
+	}, nil
+}
+
+func (p *OpenMeteoProvider) Forecast(lat, lon float64, hours int) ([]models.WeatherRecord, error) {
+	parsed, err := p.fetch(lat, lon, url.Values{
+		"hourly":         {openMeteoVariables},
+		"forecast_hours": {fmt.Sprintf("%d", hours)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h := parsed.Hourly
+	var records []models.WeatherRecord
+	for i, ts := range h.Time {
+		validAt, err := time.Parse("2006-01-02T15:04", ts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weather time %q", ts)
+		}
+		records = append(records, models.WeatherRecord{
+			Kind:            models.WeatherForecast,
+			ValidAt:         validAt,
+			Temperature:     valueAt(h.Temperature, i),
+			Humidity:        valueAt(h.Humidity, i),
+			PrecipitationMM: valueAt(h.Precipitation, i),
+			WindSpeed:       valueAt(h.WindSpeed, i),
+			SolarRadiation:  valueAt(h.ShortwaveRadiation, i),
+			CloudCover:      valueAt(h.CloudCover, i),
+		})
+	}
+	return records, nil
+}
+
+func valueAt(values []float64, i int) float64 {
+	if i < len(values) {
+		return values[i]
+	}
+	return 0
+}
+
+// FileWeatherProvider serves weather from a JSON fixture for offline use and
+// testing. The file holds {"current": WeatherRecord, "forecast": [WeatherRecord]};
+// forecast hours are shifted so the first one starts at the next hour.
+type FileWeatherProvider struct {
+	Path          string                 `json:"-"`
+	CurrentRecord models.WeatherRecord   `json:"current"`
+	ForecastHours []models.WeatherRecord `json:"forecast"`
+}
+
+// NewFileWeatherProvider loads a fixture file.
+func NewFileWeatherProvider(path string) (*FileWeatherProvider, error) {
+	if path == "" {
+		return nil, fmt.Errorf("WEATHER_FIXTURE_FILE is required for the file weather provider")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read weather fixture: %v", err)
+	}
+	provider := &FileWeatherProvider{Path: path}
+	if err := json.Unmarshal(data, provider); err != nil {
+		return nil, fmt.Errorf("failed to parse weather fixture: %v", err)
+	}
+	return provider, nil
+}
+
+func (p *FileWeatherProvider) Name() string { return "file" }
+
+func (p *FileWeatherProvider) Current(lat, lon float64) (models.WeatherRecord, error) {
+	record := p.CurrentRecord
+	record.Kind = models.WeatherCurrent
+	record.ValidAt = time.Now().UTC().Truncate(time.Hour)
+	return record, nil
+}
+
+func (p *FileWeatherProvider) Forecast(lat, lon float64, hours int) ([]models.WeatherRecord, error) {
+	if len(p.ForecastHours) == 0 {
+		return nil, fmt.Errorf("weather fixture has no forecast")
+	}
+	start := time.Now().UTC().Truncate(time.Hour).Add(time.Hour)
+	records := make([]models.WeatherRecord, 0, hours)
+	for i := 0; i < hours; i++ {
+		record := p.ForecastHours[i%len(p.ForecastHours)]
+		record.Kind = models.WeatherForecast
+		record.ValidAt = start.Add(time.Duration(i) * time.Hour)
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func roundCoordinate(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// DeviceCoordinates returns the latest stored location of a user's device,
+// or of any of their devices when deviceID is empty. Devices that never
+// reported a location fall back to the coordinates of their farm.
+func DeviceCoordinates(db *gorm.DB, userID uint, deviceID string) (float64, float64, error) {
+	var location models.DeviceLocation
+	query := db.Where("user_id = ?", userID)
+	if deviceID != "" {
+		query = query.Where("device_id = ?", deviceID)
+	}
+	if err := query.Order("timestamp desc").First(&location).Error; err == nil {
+		return location.Latitude, location.Longitude, nil
+	}
+
+	var device models.Device
+	if deviceID != "" && db.Where("user_id = ? AND device_id = ?", userID, deviceID).First(&device).Error == nil && device.ZoneID != nil {
+		farm, err := FarmOfNode(db, models.LevelZone, *device.ZoneID)
+		if err == nil && farm.Latitude != nil && farm.Longitude != nil {
+			return *farm.Latitude, *farm.Longitude, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("no location stored for device %s", deviceID)
+}
+
+// CurrentWeather returns current conditions at a coordinate, served from the
+// database cache while it is fresh.
+func CurrentWeather(db *gorm.DB, lat, lon float64) (models.WeatherRecord, error) {
+	provider := GetWeatherProvider()
+	if provider == nil {
+		return models.WeatherRecord{}, fmt.Errorf("no weather provider configured")
+	}
+	lat, lon = roundCoordinate(lat), roundCoordinate(lon)
+
+	var cached models.WeatherRecord
+	err := db.Where("provider = ? AND latitude = ? AND longitude = ? AND kind = ? AND fetched_at > ?",
+		provider.Name(), lat, lon, models.WeatherCurrent, time.Now().Add(-currentWeatherTTL)).
+		Order("fetched_at desc").First(&cached).Error
+	if err == nil {
+		return cached, nil
+	}
+
+	record, err := provider.Current(lat, lon)
+	if err != nil {
+		return record, err
+	}
+	record.Provider = provider.Name()
+	record.Latitude, record.Longitude = lat, lon
+	record.FetchedAt = time.Now()
+	if err := db.Create(&record).Error; err != nil {
+		return record, err
+	}
+	return record, nil
+}
+
+// ForecastWeather returns the hourly forecast at a coordinate for the next
+// hours, served from the database cache while it is fresh.
+func ForecastWeather(db *gorm.DB, lat, lon float64, hours int) ([]models.WeatherRecord, error) {
+	provider := GetWeatherProvider()
+	if provider == nil {
+		return nil, fmt.Errorf("no weather provider configured")
+	}
+	lat, lon = roundCoordinate(lat), roundCoordinate(lon)
+	now := time.Now()
+
+	var latestFetch models.WeatherRecord
+	err := db.Where("provider = ? AND latitude = ? AND longitude = ? AND kind = ?",
+		provider.Name(), lat, lon, models.WeatherForecast).
+		Order("fetched_at desc").First(&latestFetch).Error
+
+	if err != nil || now.Sub(latestFetch.FetchedAt) > forecastWeatherTTL {
+		fetched, err := provider.Forecast(lat, lon, MaxForecastHours)
+		if err != nil {
+			return nil, err
+		}
+		for i := range fetched {
+			fetched[i].Provider = provider.Name()
+			fetched[i].Latitude, fetched[i].Longitude = lat, lon
+			fetched[i].FetchedAt = now
+		}
+		// Replace the hours still ahead; past hours stay as history for the water balance
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("provider = ? AND latitude = ? AND longitude = ? AND kind = ? AND valid_at > ?",
+				provider.Name(), lat, lon, models.WeatherForecast, now).Delete(&models.WeatherRecord{}).Error; err != nil {
+				return err
+			}
+			if len(fetched) == 0 {
+				return nil
+			}
+			return tx.Create(&fetched).Error
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	var records []models.WeatherRecord
+	err = db.Where("provider = ? AND latitude = ? AND longitude = ? AND kind = ? AND valid_at > ? AND valid_at <= ?",
+		provider.Name(), lat, lon, models.WeatherForecast, now, now.Add(time.Duration(hours)*time.Hour)).
+		Order("valid_at asc").Find(&records).Error
+	return records, err
+}
+
+// ForecastRainForDevice sums forecast precipitation at a device's location
+// over the next hours.
+func ForecastRainForDevice(db *gorm.DB, userID uint, deviceID string, hours int) (float64, error) {
+	lat, lon, err := DeviceCoordinates(db, userID, deviceID)
+	if err != nil {
+		return 0, err
+	}
+	records, err := ForecastWeather(db, lat, lon, hours)
+	if err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, record := range records {
+		total += record.PrecipitationMM
+	}
+	return total, nil
+}